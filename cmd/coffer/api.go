@@ -18,10 +18,14 @@ var apiCmd = &args.Command{
 	Help:    "call HTTP API resources",
 	Options: envs.APIOptions,
 	Subcommands: []*args.Command{
+		disputesCmd,
+		goalsCmd,
 		metricsCmd,
 		ledgerCmd,
 		patronsCmd,
+		reconcileCmd,
 		settingsCmd,
+		stripeCmd,
 	},
 }
 
@@ -43,6 +47,24 @@ func addParams(
 	}
 }
 
+func newClient(
+	i *args.Input,
+) (
+	*wire.Client,
+	error,
+) {
+	cfg, err := envs.BuildConfig(DEFAULT_CFG, i)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to build config: %w", err)
+	}
+	baseURL := cfg.GetBaseUrl() + API_BASE_URL
+	client := &wire.Client{
+		BaseURL: baseURL,
+		APIKey:  cfg.GetApiKey(),
+	}
+	return client, nil
+}
+
 func request[T any](
 	i *args.Input,
 	method string,
@@ -50,14 +72,9 @@ func request[T any](
 	body []byte,
 	response *T,
 ) error {
-	cfg, err := envs.BuildConfig(DEFAULT_CFG, i)
+	client, err := newClient(i)
 	if err != nil {
-		return fmt.Errorf("Failed to build config: %w", err)
-	}
-	baseURL := cfg.GetBaseUrl() + API_BASE_URL
-	client := wire.Client{
-		BaseURL: baseURL,
-		APIKey:  cfg.GetApiKey(),
+		return err
 	}
 
 	if response == nil {
@@ -66,6 +83,71 @@ func request[T any](
 	return client.Do(method, path, body, response)
 }
 
+// requestList fetches a cursor paginated list. With the 'all' flag every
+// page is fetched; otherwise the page at the 'cursor' parameter is fetched
+// and the next cursor, if any, is reported on stderr.
+func requestList[T any](
+	i *args.Input,
+	path string,
+) (
+	[]T,
+	error,
+) {
+	client, err := newClient(i)
+	if err != nil {
+		return nil, err
+	}
+
+	if i.GetFlag("all") {
+		var all []T
+		for page, err := range wire.Pages[[]T](*client, path) {
+			if err != nil {
+				return nil, err
+			}
+			all = append(all, page...)
+		}
+		return all, nil
+	}
+
+	cursor := ""
+	if c := i.GetParameter("cursor"); c != nil {
+		cursor = *c
+	}
+	var page []T
+	next, err := client.GetPage(path, cursor, &page)
+	if err != nil {
+		return nil, err
+	}
+	if next != "" {
+		fmt.Fprintf(os.Stderr, "next cursor: %s\n", next)
+	}
+	return page, nil
+}
+
+// listOptions are the pagination options accepted by requestList
+var listOptions = []args.Option{
+	{
+		Long: "limit",
+		Type: args.OptionTypeParameter,
+		Help: "page size",
+	},
+	{
+		Long: "offset",
+		Type: args.OptionTypeParameter,
+		Help: "offset",
+	},
+	{
+		Long: "cursor",
+		Type: args.OptionTypeParameter,
+		Help: "cursor of the page to fetch",
+	},
+	{
+		Long: "all",
+		Type: args.OptionTypeFlag,
+		Help: "fetch every page",
+	},
+}
+
 func writeJSON(
 	data any,
 ) error {
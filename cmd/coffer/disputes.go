@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http"
+
+	"git.sr.ht/~jakintosh/coffer/internal/service"
+	"git.sr.ht/~jakintosh/command-go/pkg/args"
+)
+
+var disputesCmd = &args.Command{
+	Name: "disputes",
+	Help: "manage dispute resources",
+	Subcommands: []*args.Command{
+		disputesListCmd,
+	},
+}
+
+var disputesListCmd = &args.Command{
+	Name: "list",
+	Help: "list open disputes",
+	Options: []args.Option{
+		{
+			Long: "all",
+			Type: args.OptionTypeFlag,
+			Help: "include closed disputes",
+		},
+	},
+	Handler: func(i *args.Input) error {
+
+		path := "/disputes"
+		if i.GetFlag("all") {
+			path += "?all=true"
+		}
+		response := &[]service.Dispute{}
+		if err := request(i, http.MethodGet, path, nil, response); err != nil {
+			return err
+		}
+		return writeJSON(response)
+	},
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"git.sr.ht/~jakintosh/coffer/internal/service"
+	"git.sr.ht/~jakintosh/command-go/pkg/args"
+)
+
+var goalsCmd = &args.Command{
+	Name: "goals",
+	Help: "manage funding goal resources",
+	Subcommands: []*args.Command{
+		goalsProgressCmd,
+	},
+}
+
+var goalsProgressCmd = &args.Command{
+	Name: "progress",
+	Help: "get public progress of a funding goal",
+	Operands: []args.Operand{
+		{
+			Name: "id",
+			Help: "goal id",
+		},
+	},
+	Handler: func(i *args.Input) error {
+
+		path := fmt.Sprintf("/goals/%s", i.GetOperand("id"))
+		response := &service.GoalProgress{}
+		if err := request(i, http.MethodGet, path, nil, response); err != nil {
+			return err
+		}
+		return writeJSON(response)
+	},
+}
+
+var settingsGoalsCmd = &args.Command{
+	Name: "goals",
+	Help: "manage funding goals",
+	Subcommands: []*args.Command{
+		settingsGoalsListCmd,
+		settingsGoalsGetCmd,
+		settingsGoalsCreateCmd,
+		settingsGoalsUpdateCmd,
+		settingsGoalsDeleteCmd,
+	},
+}
+
+var goalOptions = []args.Option{
+	{
+		Long: "ledger",
+		Type: args.OptionTypeParameter,
+		Help: "ledger the goal is raised on",
+	},
+	{
+		Long: "title",
+		Type: args.OptionTypeParameter,
+		Help: "goal title",
+	},
+	{
+		Long: "description",
+		Type: args.OptionTypeParameter,
+		Help: "goal description",
+	},
+	{
+		Long: "target",
+		Type: args.OptionTypeParameter,
+		Help: "target amount",
+	},
+	{
+		Long: "start",
+		Type: args.OptionTypeParameter,
+		Help: "RFC3339 date",
+	},
+	{
+		Long: "deadline",
+		Type: args.OptionTypeParameter,
+		Help: "RFC3339 date",
+	},
+}
+
+var settingsGoalsListCmd = &args.Command{
+	Name: "list",
+	Help: "list funding goals",
+	Handler: func(i *args.Input) error {
+
+		response := &[]service.Goal{}
+		if err := request(i, http.MethodGet, "/settings/goals", nil, response); err != nil {
+			return err
+		}
+		return writeJSON(response)
+	},
+}
+
+var settingsGoalsGetCmd = &args.Command{
+	Name: "get",
+	Help: "get a funding goal",
+	Operands: []args.Operand{
+		{
+			Name: "id",
+			Help: "goal id",
+		},
+	},
+	Handler: func(i *args.Input) error {
+
+		path := fmt.Sprintf("/settings/goals/%s", i.GetOperand("id"))
+		response := &service.Goal{}
+		if err := request(i, http.MethodGet, path, nil, response); err != nil {
+			return err
+		}
+		return writeJSON(response)
+	},
+}
+
+var settingsGoalsCreateCmd = &args.Command{
+	Name: "create",
+	Help: "create a funding goal",
+	Options: append([]args.Option{
+		{
+			Long: "id",
+			Type: args.OptionTypeParameter,
+			Help: "goal id (optional)",
+		},
+	}, goalOptions...),
+	Handler: func(i *args.Input) error {
+
+		ledger := i.GetParameter("ledger")
+		target := i.GetIntParameter("target")
+		deadline := i.GetParameter("deadline")
+
+		// validate options present
+		if ledger == nil {
+			return fmt.Errorf("'ledger' missing")
+		}
+		if target == nil {
+			return fmt.Errorf("'target' missing")
+		}
+		if deadline == nil {
+			return fmt.Errorf("'deadline' missing")
+		}
+
+		req := service.CreateGoalRequest{
+			Ledger:   *ledger,
+			Target:   *target,
+			Deadline: *deadline,
+		}
+		if v := i.GetParameter("id"); v != nil {
+			req.ID = *v
+		}
+		if v := i.GetParameter("title"); v != nil {
+			req.Title = *v
+		}
+		if v := i.GetParameter("description"); v != nil {
+			req.Description = *v
+		}
+		if v := i.GetParameter("start"); v != nil {
+			req.Start = *v
+		}
+
+		// validate dates
+		for _, d := range []string{req.Start, req.Deadline} {
+			if d == "" {
+				continue
+			}
+			if _, err := time.Parse(time.RFC3339, d); err != nil {
+				return fmt.Errorf("invalid date format: expected YYYY-MM-DDTHH-mm-ssZ")
+			}
+		}
+
+		body, err := json.Marshal(req)
+		if err != nil {
+			return err
+		}
+
+		response := &service.Goal{}
+		if err := request(i, http.MethodPost, "/settings/goals", body, response); err != nil {
+			return err
+		}
+		return writeJSON(response)
+	},
+}
+
+var settingsGoalsUpdateCmd = &args.Command{
+	Name: "update",
+	Help: "update a funding goal",
+	Operands: []args.Operand{
+		{
+			Name: "id",
+			Help: "goal id",
+		},
+	},
+	Options: goalOptions,
+	Handler: func(i *args.Input) error {
+
+		req := service.UpdateGoalRequest{
+			Ledger:      i.GetParameter("ledger"),
+			Title:       i.GetParameter("title"),
+			Description: i.GetParameter("description"),
+			Target:      i.GetIntParameter("target"),
+			Start:       i.GetParameter("start"),
+			Deadline:    i.GetParameter("deadline"),
+		}
+
+		body, err := json.Marshal(req)
+		if err != nil {
+			return err
+		}
+
+		path := fmt.Sprintf("/settings/goals/%s", i.GetOperand("id"))
+		response := &service.Goal{}
+		if err := request(i, http.MethodPut, path, body, response); err != nil {
+			return err
+		}
+		return writeJSON(response)
+	},
+}
+
+var settingsGoalsDeleteCmd = &args.Command{
+	Name: "delete",
+	Help: "delete a funding goal",
+	Operands: []args.Operand{
+		{
+			Name: "id",
+			Help: "goal id",
+		},
+	},
+	Handler: func(i *args.Input) error {
+
+		path := fmt.Sprintf("/settings/goals/%s", i.GetOperand("id"))
+		return request[struct{}](i, http.MethodDelete, path, nil, nil)
+	},
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"text/tabwriter"
 	"time"
 
 	"git.sr.ht/~jakintosh/coffer/internal/service"
@@ -15,11 +16,32 @@ var ledgerCmd = &args.Command{
 	Name: "ledger",
 	Help: "manage ledger resources",
 	Subcommands: []*args.Command{
+		ledgerExportCmd,
+		ledgerHistoryCmd,
+		ledgerImportCmd,
+		ledgerListCmd,
+		ledgerReallocateCmd,
+		ledgerReverseTransferCmd,
 		ledgerSnapshotCmd,
+		ledgerTransferCmd,
 		ledgerTxCmd,
 	},
 }
 
+var ledgerListCmd = &args.Command{
+	Name: "list",
+	Help: "list all ledgers with balances",
+	Handler: func(i *args.Input) error {
+
+		response := []service.LedgerSummary{}
+		if err := request(i, http.MethodGet, "/ledgers", nil, &response); err != nil {
+			return err
+		}
+
+		return writeJSON(response)
+	},
+}
+
 var ledgerSnapshotCmd = &args.Command{
 	Name: "snapshot",
 	Help: "get snapshot of ledger over date range",
@@ -56,12 +78,383 @@ var ledgerSnapshotCmd = &args.Command{
 	},
 }
 
+var ledgerExportCmd = &args.Command{
+	Name: "export",
+	Help: "export ledger transactions to a file",
+	Operands: []args.Operand{
+		{
+			Name: "ledger",
+			Help: "ledger name",
+		},
+	},
+	Options: []args.Option{
+		{
+			Long: "format",
+			Type: args.OptionTypeParameter,
+			Help: "'csv', 'ofx' or 'json', defaults to 'csv'",
+		},
+		{
+			Long: "since",
+			Type: args.OptionTypeParameter,
+			Help: "YYYY-MM-DD, defaults to '0'",
+		},
+		{
+			Long: "until",
+			Type: args.OptionTypeParameter,
+			Help: "YYYY-MM-DD, defaults to 'now'",
+		},
+		{
+			Long: "output",
+			Type: args.OptionTypeParameter,
+			Help: "file to write, defaults to '<ledger>.<format>'",
+		},
+	},
+	Handler: func(i *args.Input) error {
+
+		ledger := i.GetOperand("ledger")
+		path := fmt.Sprintf("/ledger/%s/export", ledger)
+		path = addParams(i, path, "format", "since", "until")
+
+		format := "csv"
+		if f := i.GetParameter("format"); f != nil {
+			format = *f
+		}
+		output := fmt.Sprintf("%s.%s", ledger, format)
+		if o := i.GetParameter("output"); o != nil {
+			output = *o
+		}
+
+		client, err := newClient(i)
+		if err != nil {
+			return err
+		}
+
+		file, err := os.Create(output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		if err := client.Download(path, file); err != nil {
+			os.Remove(output)
+			return err
+		}
+		return file.Close()
+	},
+}
+
+var ledgerHistoryCmd = &args.Command{
+	Name: "history",
+	Help: "print ledger balance history as a table",
+	Operands: []args.Operand{
+		{
+			Name: "ledger",
+			Help: "ledger name",
+		},
+	},
+	Options: []args.Option{
+		{
+			Long: "interval",
+			Type: args.OptionTypeParameter,
+			Help: "'day', 'week' or 'month', defaults to 'month'",
+		},
+		{
+			Long: "since",
+			Type: args.OptionTypeParameter,
+			Help: "YYYY-MM-DD, defaults to 11 intervals before 'until'",
+		},
+		{
+			Long: "until",
+			Type: args.OptionTypeParameter,
+			Help: "YYYY-MM-DD, defaults to 'now'",
+		},
+	},
+	Handler: func(i *args.Input) error {
+
+		ledger := i.GetOperand("ledger")
+		path := fmt.Sprintf("/ledger/%s/history", ledger)
+		path = addParams(i, path, "interval", "since", "until")
+
+		response := []service.HistoryBucket{}
+		if err := request(i, http.MethodGet, path, nil, &response); err != nil {
+			return err
+		}
+
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+		fmt.Fprintln(tw, "start\topening\tincoming\toutgoing\tclosing\t")
+		for _, b := range response {
+			fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t\n",
+				b.Start.Format("2006-01-02"),
+				b.OpeningBalance,
+				b.IncomingFunds,
+				b.OutgoingFunds,
+				b.ClosingBalance,
+			)
+		}
+		return tw.Flush()
+	},
+}
+
+var ledgerImportCmd = &args.Command{
+	Name: "import",
+	Help: "import transactions from a csv file",
+	Operands: []args.Operand{
+		{
+			Name: "ledger",
+			Help: "target ledger",
+		},
+		{
+			Name: "file",
+			Help: "csv file with a header row",
+		},
+	},
+	Options: []args.Option{
+		{
+			Long: "id-column",
+			Type: args.OptionTypeParameter,
+			Help: "header of the id column (optional)",
+		},
+		{
+			Long: "date-column",
+			Type: args.OptionTypeParameter,
+			Help: "header of the date column, defaults to 'date'",
+		},
+		{
+			Long: "amount-column",
+			Type: args.OptionTypeParameter,
+			Help: "header of the amount column, defaults to 'amount'",
+		},
+		{
+			Long: "label-column",
+			Type: args.OptionTypeParameter,
+			Help: "header of the label column, defaults to 'label'",
+		},
+		{
+			Long: "date-format",
+			Type: args.OptionTypeParameter,
+			Help: "Go time layout, defaults to RFC3339 or YYYY-MM-DD",
+		},
+		{
+			Long: "major-units",
+			Type: args.OptionTypeFlag,
+			Help: "amounts are decimal major units (e.g. 12.50)",
+		},
+		{
+			Long: "dry-run",
+			Type: args.OptionTypeFlag,
+			Help: "preview the import without committing",
+		},
+	},
+	Handler: func(i *args.Input) error {
+
+		ledger := i.GetOperand("ledger")
+		path := fmt.Sprintf("/ledger/%s/import", ledger)
+
+		data, err := os.ReadFile(i.GetOperand("file"))
+		if err != nil {
+			return err
+		}
+
+		req := service.ImportTransactionsRequest{
+			CSV:        string(data),
+			MajorUnits: i.GetFlag("major-units"),
+			DryRun:     i.GetFlag("dry-run"),
+		}
+		if v := i.GetParameter("id-column"); v != nil {
+			req.Columns.ID = *v
+		}
+		if v := i.GetParameter("date-column"); v != nil {
+			req.Columns.Date = *v
+		}
+		if v := i.GetParameter("amount-column"); v != nil {
+			req.Columns.Amount = *v
+		}
+		if v := i.GetParameter("label-column"); v != nil {
+			req.Columns.Label = *v
+		}
+		if v := i.GetParameter("date-format"); v != nil {
+			req.DateFormat = *v
+		}
+
+		body, err := json.Marshal(req)
+		if err != nil {
+			return err
+		}
+
+		response := &service.ImportResult{}
+		if err := request(i, http.MethodPost, path, body, response); err != nil {
+			return err
+		}
+
+		if err := writeJSON(response); err != nil {
+			return err
+		}
+		if len(response.Errors) > 0 {
+			return fmt.Errorf("%d invalid rows, nothing imported", len(response.Errors))
+		}
+		return nil
+	},
+}
+
+var ledgerTransferCmd = &args.Command{
+	Name: "transfer",
+	Help: "move funds between ledgers",
+	Options: []args.Option{
+		{
+			Long: "from",
+			Type: args.OptionTypeParameter,
+			Help: "source ledger",
+		},
+		{
+			Long: "to",
+			Type: args.OptionTypeParameter,
+			Help: "destination ledger",
+		},
+		{
+			Long: "amount",
+			Type: args.OptionTypeParameter,
+			Help: "amount",
+		},
+		{
+			Long: "date",
+			Type: args.OptionTypeParameter,
+			Help: "RFC3339 date",
+		},
+		{
+			Long: "label",
+			Type: args.OptionTypeParameter,
+			Help: "transfer label",
+		},
+		{
+			Long: "id",
+			Type: args.OptionTypeParameter,
+			Help: "transfer id (optional)",
+		},
+	},
+	Handler: func(i *args.Input) error {
+
+		from := i.GetParameter("from")
+		to := i.GetParameter("to")
+		amount := i.GetIntParameter("amount")
+		dateStr := i.GetParameter("date")
+		label := i.GetParameter("label")
+		idOpt := i.GetParameter("id")
+
+		// validate options present
+		if from == nil {
+			return fmt.Errorf("'from' missing")
+		}
+		if to == nil {
+			return fmt.Errorf("'to' missing")
+		}
+		if amount == nil {
+			return fmt.Errorf("'amount' missing")
+		}
+		if dateStr == nil {
+			return fmt.Errorf("'date' missing")
+		}
+		if label == nil {
+			return fmt.Errorf("'label' missing")
+		}
+		id := ""
+		if idOpt != nil {
+			id = *idOpt
+		}
+
+		// validate date
+		if _, err := time.Parse(time.RFC3339, *dateStr); err != nil {
+			return fmt.Errorf("invalid date format: expected YYYY-MM-DDTHH-mm-ssZ")
+		}
+
+		// marshal body json
+		body, err := json.Marshal(
+			service.CreateTransferRequest{
+				ID:     id,
+				From:   *from,
+				To:     *to,
+				Date:   *dateStr,
+				Amount: *amount,
+				Label:  *label,
+			},
+		)
+		if err != nil {
+			return err
+		}
+
+		response := &service.Transfer{}
+		if err := request(i, http.MethodPost, "/ledger/transfers", body, response); err != nil {
+			return err
+		}
+
+		return writeJSON(response)
+	},
+}
+
+var ledgerReverseTransferCmd = &args.Command{
+	Name: "reverse-transfer",
+	Help: "reverse both legs of a transfer",
+	Operands: []args.Operand{
+		{
+			Name: "id",
+			Help: "transfer id",
+		},
+	},
+	Options: []args.Option{
+		{
+			Long: "date",
+			Type: args.OptionTypeParameter,
+			Help: "RFC3339 date, defaults to 'now'",
+		},
+		{
+			Long: "label",
+			Type: args.OptionTypeParameter,
+			Help: "reversal label, defaults to 'reversal'",
+		},
+		{
+			Long: "void",
+			Type: args.OptionTypeFlag,
+			Help: "reverse on the original transfer date",
+		},
+	},
+	Handler: func(i *args.Input) error {
+
+		id := i.GetOperand("id")
+		path := fmt.Sprintf("/ledger/transfers/%s/reverse", id)
+
+		req := service.ReverseTransactionRequest{
+			Void: i.GetFlag("void"),
+		}
+		if dateStr := i.GetParameter("date"); dateStr != nil {
+			if _, err := time.Parse(time.RFC3339, *dateStr); err != nil {
+				return fmt.Errorf("invalid date format: expected YYYY-MM-DDTHH-mm-ssZ")
+			}
+			req.Date = *dateStr
+		}
+		if label := i.GetParameter("label"); label != nil {
+			req.Label = *label
+		}
+
+		body, err := json.Marshal(req)
+		if err != nil {
+			return err
+		}
+
+		response := &service.Transfer{}
+		if err := request(i, http.MethodPost, path, body, response); err != nil {
+			return err
+		}
+
+		return writeJSON(response)
+	},
+}
+
 var ledgerTxCmd = &args.Command{
 	Name: "tx",
 	Help: "manage transaction resources",
 	Subcommands: []*args.Command{
 		ledgerTxListCmd,
 		ledgerTxCreateCmd,
+		ledgerTxReverseCmd,
 	},
 }
 
@@ -74,26 +467,62 @@ var ledgerTxListCmd = &args.Command{
 			Help: "ledger name",
 		},
 	},
-	Options: []args.Option{
+	Options: append([]args.Option{
 		{
-			Long: "limit",
+			Long: "since",
 			Type: args.OptionTypeParameter,
-			Help: "limit",
+			Help: "YYYY-MM-DD, earliest transaction date",
 		},
 		{
-			Long: "offset",
+			Long: "until",
 			Type: args.OptionTypeParameter,
-			Help: "offset",
+			Help: "YYYY-MM-DD, latest transaction date",
 		},
-	},
+		{
+			Long: "label",
+			Type: args.OptionTypeParameter,
+			Help: "exact transaction label",
+		},
+		{
+			Long: "search",
+			Type: args.OptionTypeParameter,
+			Help: "text to search for in labels",
+		},
+		{
+			Long: "sign",
+			Type: args.OptionTypeParameter,
+			Help: "'income' or 'spending'",
+		},
+		{
+			Long: "min",
+			Type: args.OptionTypeParameter,
+			Help: "minimum amount",
+		},
+		{
+			Long: "max",
+			Type: args.OptionTypeParameter,
+			Help: "maximum amount",
+		},
+		{
+			Long: "sort",
+			Type: args.OptionTypeParameter,
+			Help: "'asc' or 'desc' by date, defaults to 'desc'",
+		},
+	}, listOptions...),
 	Handler: func(i *args.Input) error {
 
 		ledger := i.GetOperand("ledger")
 		path := fmt.Sprintf("/ledger/%s/transactions", ledger)
-		path = addParams(i, path, "limit", "offset")
+		path = addParams(i, path,
+			"limit", "offset",
+			"since", "until",
+			"label", "search",
+			"sign", "min", "max",
+			"sort",
+		)
 
-		response := &[]service.Transaction{}
-		if err := request(i, http.MethodGet, path, nil, response); err != nil {
+		response, err := requestList[service.Transaction](i, path)
+		if err != nil {
 			return err
 		}
 
@@ -195,3 +624,131 @@ var ledgerTxCreateCmd = &args.Command{
 		return request[struct{}](i, http.MethodPost, path, body, nil)
 	},
 }
+
+var ledgerTxReverseCmd = &args.Command{
+	Name: "reverse",
+	Help: "reverse a transaction with a compensating entry",
+	Operands: []args.Operand{
+		{
+			Name: "ledger",
+			Help: "ledger name",
+		},
+		{
+			Name: "id",
+			Help: "transaction id",
+		},
+	},
+	Options: []args.Option{
+		{
+			Long: "date",
+			Type: args.OptionTypeParameter,
+			Help: "RFC3339 date, defaults to 'now'",
+		},
+		{
+			Long: "label",
+			Type: args.OptionTypeParameter,
+			Help: "reversal label, defaults to 'reversal'",
+		},
+		{
+			Long: "void",
+			Type: args.OptionTypeFlag,
+			Help: "reverse on the original transaction date",
+		},
+	},
+	Handler: func(i *args.Input) error {
+
+		ledger := i.GetOperand("ledger")
+		id := i.GetOperand("id")
+		path := fmt.Sprintf("/ledger/%s/transactions/%s/reverse", ledger, id)
+
+		req := service.ReverseTransactionRequest{
+			Void: i.GetFlag("void"),
+		}
+		if dateStr := i.GetParameter("date"); dateStr != nil {
+			if _, err := time.Parse(time.RFC3339, *dateStr); err != nil {
+				return fmt.Errorf("invalid date format: expected YYYY-MM-DDTHH-mm-ssZ")
+			}
+			req.Date = *dateStr
+		}
+		if label := i.GetParameter("label"); label != nil {
+			req.Label = *label
+		}
+
+		body, err := json.Marshal(req)
+		if err != nil {
+			return err
+		}
+
+		response := &service.Transaction{}
+		if err := request(i, http.MethodPost, path, body, response); err != nil {
+			return err
+		}
+
+		return writeJSON(response)
+	},
+}
+
+var ledgerReallocateCmd = &args.Command{
+	Name: "reallocate",
+	Help: "re-split past payments with an allocation set",
+	Options: []args.Option{
+		{
+			Long: "set",
+			Type: args.OptionTypeParameter,
+			Help: "target allocation set id, see 'settings allocations history'",
+		},
+		{
+			Long: "since",
+			Type: args.OptionTypeParameter,
+			Help: "RFC3339 start of payments to reallocate",
+		},
+		{
+			Long: "until",
+			Type: args.OptionTypeParameter,
+			Help: "RFC3339 end of payments to reallocate, defaults to 'now'",
+		},
+		{
+			Long: "dry-run",
+			Type: args.OptionTypeFlag,
+			Help: "show the changes without posting them",
+		},
+	},
+	Handler: func(i *args.Input) error {
+
+		set := i.GetIntParameter("set")
+		since := i.GetParameter("since")
+		if set == nil {
+			return fmt.Errorf("'set' missing")
+		}
+		if since == nil {
+			return fmt.Errorf("'since' missing")
+		}
+
+		req := service.ReallocateRequest{
+			AllocationSet: int64(*set),
+			DryRun:        i.GetFlag("dry-run"),
+		}
+		if _, err := time.Parse(time.RFC3339, *since); err != nil {
+			return fmt.Errorf("invalid date format: expected YYYY-MM-DDTHH-mm-ssZ")
+		}
+		req.Since = *since
+		if until := i.GetParameter("until"); until != nil {
+			if _, err := time.Parse(time.RFC3339, *until); err != nil {
+				return fmt.Errorf("invalid date format: expected YYYY-MM-DDTHH-mm-ssZ")
+			}
+			req.Until = *until
+		}
+
+		body, err := json.Marshal(req)
+		if err != nil {
+			return err
+		}
+
+		response := &service.Reallocation{}
+		if err := request(i, http.MethodPost, "/ledger/reallocate", body, response); err != nil {
+			return err
+		}
+
+		return writeJSON(response)
+	},
+}
@@ -37,6 +37,7 @@ var root = &args.Command{
 		envs.Command(DEFAULT_CFG),
 		serveCmd,
 		statusCmd,
+		syncCmd,
 		version.Command(VersionInfo),
 	},
 	Options: envs.ConfigOptionsAnd(args.Option{
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"net/http"
 
 	"git.sr.ht/~jakintosh/coffer/internal/service"
@@ -12,29 +13,41 @@ var patronsCmd = &args.Command{
 	Help: "manage patron resources",
 	Subcommands: []*args.Command{
 		patronsListCmd,
+		patronsSubscriptionsCmd,
 	},
 }
 
 var patronsListCmd = &args.Command{
-	Name: "list",
-	Help: "list patrons",
-	Options: []args.Option{
-		{
-			Long: "limit",
-			Type: args.OptionTypeParameter,
-			Help: "result limit",
-		},
+	Name:     "list",
+	Help:     "list patrons",
+	Options:  listOptions,
+	Operands: []args.Operand{},
+	Handler: func(i *args.Input) error {
+		path := addParams(i, "/patrons", "limit", "offset")
+
+		response, err := requestList[service.Patron](i, path)
+		if err != nil {
+			return err
+		}
+		return writeJSON(response)
+	},
+}
+
+var patronsSubscriptionsCmd = &args.Command{
+	Name: "subscriptions",
+	Help: "show the subscription history of a patron",
+	Operands: []args.Operand{
 		{
-			Long: "offset",
-			Type: args.OptionTypeParameter,
-			Help: "result offset",
+			Name: "id",
+			Help: "patron id",
 		},
 	},
-	Operands: []args.Operand{},
 	Handler: func(i *args.Input) error {
-		path := addParams(i, "/patrons", "limit", "offset")
 
-		response := &[]service.Patron{}
+		id := i.GetOperand("id")
+		path := fmt.Sprintf("/patrons/%s/subscriptions", id)
+
+		response := &[]service.SubscriptionTimeline{}
 		if err := request(i, http.MethodGet, path, nil, response); err != nil {
 			return err
 		}
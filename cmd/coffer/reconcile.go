@@ -0,0 +1,35 @@
+package main
+
+import (
+	"net/http"
+
+	"git.sr.ht/~jakintosh/coffer/internal/service"
+	"git.sr.ht/~jakintosh/command-go/pkg/args"
+)
+
+var reconcileCmd = &args.Command{
+	Name: "reconcile",
+	Help: "reconcile payouts with ledger payments",
+	Options: []args.Option{
+		{
+			Long: "since",
+			Type: args.OptionTypeParameter,
+			Help: "YYYY-MM-DD, defaults to the start of the month",
+		},
+		{
+			Long: "until",
+			Type: args.OptionTypeParameter,
+			Help: "YYYY-MM-DD, exclusive, defaults to 'now'",
+		},
+	},
+	Handler: func(i *args.Input) error {
+
+		path := addParams(i, "/reconciliation", "since", "until")
+
+		response := &service.Reconciliation{}
+		if err := request(i, http.MethodGet, path, nil, response); err != nil {
+			return err
+		}
+		return writeJSON(response)
+	},
+}
@@ -17,6 +17,7 @@ const (
 	PORT                  = "8080"
 	CORS_ALLOWED_ORIGINS  = "http://localhost:80"
 	CREDENTIALS_DIRECTORY = "/etc/coffer"
+	CURRENCY              = "usd"
 )
 
 var serveCmd = &args.Command{
@@ -43,12 +44,24 @@ var serveCmd = &args.Command{
 			Type: args.OptionTypeParameter,
 			Help: "credentials directory",
 		},
+		{
+			Long: "currency",
+			Type: args.OptionTypeParameter,
+			Help: "currency code of ledger amounts",
+		},
+		{
+			Long: "stripe-api-url",
+			Type: args.OptionTypeParameter,
+			Help: "base URL of the Stripe API, for a local stand-in",
+		},
 	},
 	Handler: func(i *args.Input) error {
 		dbPath := resolveOption(i, "db-file-path", "DB_FILE_PATH", DB_FILE_PATH)
 		port := ":" + resolveOption(i, "port", "PORT", PORT)
 		originsStr := resolveOption(i, "cors-allowed-origins", "CORS_ALLOWED_ORIGINS", CORS_ALLOWED_ORIGINS)
 		origins := strings.Split(originsStr, ",")
+		currency := resolveOption(i, "currency", "CURRENCY", CURRENCY)
+		stripeURL := resolveOption(i, "stripe-api-url", "STRIPE_API_URL", "")
 
 		credsDir := resolveOption(i, "credentials-directory", "CREDENTIALS_DIRECTORY", CREDENTIALS_DIRECTORY)
 		stripeKey := loadCredential("stripe_key", credsDir)
@@ -69,9 +82,11 @@ var serveCmd = &args.Command{
 		// setup service
 		opts := service.Options{
 			Store:       db,
+			Currency:    currency,
 			HealthCheck: db.HealthCheck,
 			StripeProcessorOptions: &service.StripeProcessorOptions{
 				Key:            stripeKey,
+				BaseURL:        stripeURL,
 				EndpointSecret: endpointSecret,
 				TestMode:       false,
 				DebounceWindow: 500 * time.Millisecond,
@@ -4,7 +4,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
 	"os"
+	"strconv"
+	"time"
 
 	"git.sr.ht/~jakintosh/coffer/internal/service"
 	cors "git.sr.ht/~jakintosh/coffer/pkg/cors/cmd"
@@ -17,6 +20,9 @@ var settingsCmd = &args.Command{
 	Help: "manage settings",
 	Subcommands: []*args.Command{
 		allocationsCmd,
+		feesCmd,
+		settingsGoalsCmd,
+		ledgersCmd,
 		cors.Command(DEFAULT_CFG, API_BASE_URL+"/settings"),
 		keys.Command(DEFAULT_CFG, API_BASE_URL+"/settings"),
 	},
@@ -27,6 +33,8 @@ var allocationsCmd = &args.Command{
 	Help: "manage allocation rules",
 	Subcommands: []*args.Command{
 		allocGetCmd,
+		allocHistoryCmd,
+		allocPreviewCmd,
 		allocSetCmd,
 	},
 }
@@ -34,83 +42,432 @@ var allocationsCmd = &args.Command{
 var allocGetCmd = &args.Command{
 	Name: "get",
 	Help: "get allocation rules",
+	Options: []args.Option{
+		{
+			Long: "selector",
+			Type: args.OptionTypeParameter,
+			Help: "rule set selector, e.g. 'amount:5000' or 'product:prod_123', defaults to the default set",
+		},
+	},
 	Handler: func(i *args.Input) error {
 
+		path := addParams(i, "/settings/allocations", "selector")
 		response := &[]service.AllocationRule{}
-		if err := request(i, http.MethodGet, "/settings/allocations", nil, response); err != nil {
+		if err := request(i, http.MethodGet, path, nil, response); err != nil {
+			return err
+		}
+		return writeJSON(response)
+	},
+}
+
+var allocHistoryCmd = &args.Command{
+	Name: "history",
+	Help: "list past, current and scheduled allocation sets",
+	Handler: func(i *args.Input) error {
+
+		response := &[]service.AllocationSet{}
+		if err := request(i, http.MethodGet, "/settings/allocations/history", nil, response); err != nil {
 			return err
 		}
 		return writeJSON(response)
 	},
 }
 
+// allocationRuleOptions are the options read by readAllocationRules
+var allocationRuleOptions = []args.Option{
+	{
+		Long: "id",
+		Type: args.OptionTypeArray,
+		Help: "allocation rule id",
+	},
+	{
+		Long: "ledger",
+		Type: args.OptionTypeArray,
+		Help: "ledger name for allocation rule",
+	},
+	{
+		Long: "percentage",
+		Type: args.OptionTypeArray,
+		Help: "percentage of allocation rule",
+	},
+	{
+		Long: "file",
+		Type: args.OptionTypeParameter,
+		Help: "json file to read allocation rules from (supports basis_points and fixed)",
+	},
+}
+
+// readAllocationRules returns the JSON allocation rules from --file, or
+// built from the --id, --ledger and --percentage options.
+func readAllocationRules(
+	i *args.Input,
+) (
+	json.RawMessage,
+	error,
+) {
+	// file-based path
+	if f := i.GetParameter("file"); f != nil {
+		return os.ReadFile(*f)
+	}
+
+	// option-based path
+	id := i.GetArray("id")
+	ledger := i.GetArray("ledger")
+	percentage := i.GetIntArray("percentage")
+
+	if !(len(id) == len(ledger) && len(id) == len(percentage)) {
+		return nil, fmt.Errorf("id, ledger and percentage must have same count")
+	}
+	if len(id) == 0 {
+		return nil, fmt.Errorf("id, ledger and percentage required")
+	}
+
+	var rule []service.AllocationRule
+	for i := range len(id) {
+		rule = append(rule, service.AllocationRule{
+			ID:         id[i],
+			LedgerName: ledger[i],
+			Percentage: percentage[i],
+		})
+	}
+
+	return json.Marshal(rule)
+}
+
 var allocSetCmd = &args.Command{
 	Name: "set",
 	Help: "set allocation rules",
-	Options: []args.Option{
+	Options: append([]args.Option{
 		{
-			Long: "id",
-			Type: args.OptionTypeArray,
-			Help: "allocation rule id",
+			Long: "effective-from",
+			Type: args.OptionTypeParameter,
+			Help: "RFC3339 date the rules take effect, defaults to 'now'",
 		},
 		{
-			Long: "ledger",
-			Type: args.OptionTypeArray,
-			Help: "ledger name for allocation rule",
+			Long: "selector",
+			Type: args.OptionTypeParameter,
+			Help: "rule set selector: 'amount:<cents>', 'price:<id>', 'product:<id>' or 'metadata:<key>=<value>'",
 		},
+	}, allocationRuleOptions...),
+	Handler: func(i *args.Input) error {
+
+		body, err := readAllocationRules(i)
+		if err != nil {
+			return err
+		}
+
+		params := url.Values{}
+		if e := i.GetParameter("effective-from"); e != nil {
+			if _, err := time.Parse(time.RFC3339, *e); err != nil {
+				return fmt.Errorf("invalid date format: expected YYYY-MM-DDTHH-mm-ssZ")
+			}
+			params.Set("effective_from", *e)
+		}
+		if sel := i.GetParameter("selector"); sel != nil {
+			params.Set("selector", *sel)
+		}
+		path := "/settings/allocations"
+		if q := params.Encode(); q != "" {
+			path += "?" + q
+		}
+		response := &[]service.AllocationRule{}
+		if err := request(i, http.MethodPut, path, body, response); err != nil {
+			return nil
+		}
+		return writeJSON(response)
+	},
+}
+
+var allocPreviewCmd = &args.Command{
+	Name: "preview",
+	Help: "preview allocation rules on an amount, or replay past payments",
+	Options: append([]args.Option{
 		{
-			Long: "percentage",
-			Type: args.OptionTypeArray,
-			Help: "percentage of allocation rule",
+			Long: "amount",
+			Type: args.OptionTypeParameter,
+			Help: "amount to split, in minor units",
 		},
 		{
-			Long: "file",
+			Long: "since",
 			Type: args.OptionTypeParameter,
-			Help: "json file to read allocation rules from",
+			Help: "RFC3339 start of payments to replay",
 		},
-	},
+		{
+			Long: "until",
+			Type: args.OptionTypeParameter,
+			Help: "RFC3339 end of payments to replay, defaults to 'now'",
+		},
+	}, allocationRuleOptions...),
 	Handler: func(i *args.Input) error {
 
-		var body []byte
-		var err error
+		rules, err := readAllocationRules(i)
+		if err != nil {
+			return err
+		}
 
-		// file-based path
-		if f := i.GetParameter("file"); f != nil {
-			body, err = os.ReadFile(*f)
-			if err != nil {
-				return err
+		req := struct {
+			Rules  json.RawMessage `json:"rules"`
+			Amount int             `json:"amount,omitempty"`
+			Since  string          `json:"since,omitempty"`
+			Until  string          `json:"until,omitempty"`
+		}{Rules: rules}
+
+		if amount := i.GetIntParameter("amount"); amount != nil {
+			req.Amount = *amount
+		} else if since := i.GetParameter("since"); since != nil {
+			req.Since = *since
+			if until := i.GetParameter("until"); until != nil {
+				req.Until = *until
 			}
 		} else {
-			// option-based path
-			id := i.GetArray("id")
-			ledger := i.GetArray("ledger")
-			percentage := i.GetIntArray("percentage")
+			return fmt.Errorf("'amount' or 'since' required")
+		}
 
-			if !(len(id) == len(ledger) && len(id) == len(percentage)) {
-				return fmt.Errorf("id, ledger and percentage must have same count")
-			}
-			if len(id) == 0 {
-				return fmt.Errorf("id, ledger and percentage required")
-			}
+		body, err := json.Marshal(req)
+		if err != nil {
+			return err
+		}
+		response := &service.AllocationPreview{}
+		if err := request(i, http.MethodPost, "/settings/allocations/preview", body, response); err != nil {
+			return err
+		}
+		return writeJSON(response)
+	},
+}
 
-			var rule []service.AllocationRule
-			for i := range len(id) {
-				rule = append(rule, service.AllocationRule{
-					ID:         id[i],
-					LedgerName: ledger[i],
-					Percentage: percentage[i],
-				})
-			}
+var ledgersCmd = &args.Command{
+	Name: "ledgers",
+	Help: "manage ledger metadata",
+	Subcommands: []*args.Command{
+		ledgersListCmd,
+		ledgersGetCmd,
+		ledgersCreateCmd,
+		ledgersUpdateCmd,
+		ledgersDeleteCmd,
+	},
+}
+
+var ledgersListCmd = &args.Command{
+	Name: "list",
+	Help: "list registered ledgers",
+	Handler: func(i *args.Input) error {
+
+		response := &[]service.Ledger{}
+		if err := request(i, http.MethodGet, "/settings/ledgers", nil, response); err != nil {
+			return err
+		}
+		return writeJSON(response)
+	},
+}
+
+var ledgersGetCmd = &args.Command{
+	Name: "get",
+	Help: "get ledger metadata",
+	Operands: []args.Operand{
+		{
+			Name: "name",
+			Help: "ledger name",
+		},
+	},
+	Handler: func(i *args.Input) error {
+
+		path := fmt.Sprintf("/settings/ledgers/%s", i.GetOperand("name"))
+		response := &service.Ledger{}
+		if err := request(i, http.MethodGet, path, nil, response); err != nil {
+			return err
+		}
+		return writeJSON(response)
+	},
+}
+
+var ledgersCreateCmd = &args.Command{
+	Name: "create",
+	Help: "register a new ledger",
+	Operands: []args.Operand{
+		{
+			Name: "name",
+			Help: "ledger name (lowercase letters, digits, '-' and '_')",
+		},
+	},
+	Options: []args.Option{
+		{
+			Long: "display-name",
+			Type: args.OptionTypeParameter,
+			Help: "human-readable title",
+		},
+		{
+			Long: "description",
+			Type: args.OptionTypeParameter,
+			Help: "ledger description",
+		},
+		{
+			Long: "private",
+			Type: args.OptionTypeFlag,
+			Help: "hide the ledger from the public catalog",
+		},
+	},
+	Handler: func(i *args.Input) error {
+
+		public := !i.GetFlag("private")
+		req := service.CreateLedgerRequest{
+			Name:   i.GetOperand("name"),
+			Public: &public,
+		}
+		if v := i.GetParameter("display-name"); v != nil {
+			req.DisplayName = *v
+		}
+		if v := i.GetParameter("description"); v != nil {
+			req.Description = *v
+		}
+
+		body, err := json.Marshal(req)
+		if err != nil {
+			return err
+		}
+
+		response := &service.Ledger{}
+		if err := request(i, http.MethodPost, "/settings/ledgers", body, response); err != nil {
+			return err
+		}
+		return writeJSON(response)
+	},
+}
 
-			body, err = json.Marshal(rule)
+var ledgersUpdateCmd = &args.Command{
+	Name: "update",
+	Help: "update ledger metadata",
+	Operands: []args.Operand{
+		{
+			Name: "name",
+			Help: "ledger name",
+		},
+	},
+	Options: []args.Option{
+		{
+			Long: "display-name",
+			Type: args.OptionTypeParameter,
+			Help: "human-readable title",
+		},
+		{
+			Long: "description",
+			Type: args.OptionTypeParameter,
+			Help: "ledger description",
+		},
+		{
+			Long: "public",
+			Type: args.OptionTypeParameter,
+			Help: "'true' or 'false'",
+		},
+		{
+			Long: "archived",
+			Type: args.OptionTypeParameter,
+			Help: "'true' or 'false'",
+		},
+	},
+	Handler: func(i *args.Input) error {
+
+		req := service.UpdateLedgerRequest{
+			DisplayName: i.GetParameter("display-name"),
+			Description: i.GetParameter("description"),
+		}
+		if v := i.GetParameter("public"); v != nil {
+			public, err := strconv.ParseBool(*v)
 			if err != nil {
-				return err
+				return fmt.Errorf("invalid 'public': expected 'true' or 'false'")
 			}
+			req.Public = &public
 		}
-		response := &[]service.AllocationRule{}
-		if err := request(i, http.MethodPut, "/settings/allocations", body, response); err != nil {
-			return nil
+		if v := i.GetParameter("archived"); v != nil {
+			archived, err := strconv.ParseBool(*v)
+			if err != nil {
+				return fmt.Errorf("invalid 'archived': expected 'true' or 'false'")
+			}
+			req.Archived = &archived
+		}
+
+		body, err := json.Marshal(req)
+		if err != nil {
+			return err
+		}
+
+		path := fmt.Sprintf("/settings/ledgers/%s", i.GetOperand("name"))
+		response := &service.Ledger{}
+		if err := request(i, http.MethodPut, path, body, response); err != nil {
+			return err
 		}
 		return writeJSON(response)
 	},
 }
+
+var ledgersDeleteCmd = &args.Command{
+	Name: "delete",
+	Help: "delete an unused ledger",
+	Operands: []args.Operand{
+		{
+			Name: "name",
+			Help: "ledger name",
+		},
+	},
+	Handler: func(i *args.Input) error {
+
+		path := fmt.Sprintf("/settings/ledgers/%s", i.GetOperand("name"))
+		return request[struct{}](i, http.MethodDelete, path, nil, nil)
+	},
+}
+
+var feesCmd = &args.Command{
+	Name: "fees",
+	Help: "manage how stripe fees are accounted for",
+	Subcommands: []*args.Command{
+		feesGetCmd,
+		feesSetCmd,
+	},
+}
+
+var feesGetCmd = &args.Command{
+	Name: "get",
+	Help: "get fee settings",
+	Handler: func(i *args.Input) error {
+
+		response := &service.FeeSettings{}
+		if err := request(i, http.MethodGet, "/settings/fees", nil, response); err != nil {
+			return err
+		}
+		return writeJSON(response)
+	},
+}
+
+var feesSetCmd = &args.Command{
+	Name: "set",
+	Help: "set fee settings",
+	Options: []args.Option{
+		{
+			Long: "mode",
+			Type: args.OptionTypeParameter,
+			Help: "'gross', 'net' or 'ledger'",
+		},
+		{
+			Long: "ledger",
+			Type: args.OptionTypeParameter,
+			Help: "ledger fees are posted to in 'ledger' mode, defaults to 'fees'",
+		},
+	},
+	Handler: func(i *args.Input) error {
+
+		mode := i.GetParameter("mode")
+		if mode == nil {
+			return fmt.Errorf("missing required '--mode'")
+		}
+		req := service.FeeSettings{Mode: *mode}
+		if ledger := i.GetParameter("ledger"); ledger != nil {
+			req.Ledger = *ledger
+		}
+
+		body, err := json.Marshal(req)
+		if err != nil {
+			return err
+		}
+
+		return request[struct{}](i, http.MethodPut, "/settings/fees", body, nil)
+	},
+}
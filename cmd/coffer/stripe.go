@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"git.sr.ht/~jakintosh/coffer/internal/service"
+	"git.sr.ht/~jakintosh/command-go/pkg/args"
+)
+
+var stripeCmd = &args.Command{
+	Name: "stripe",
+	Help: "inspect stripe webhook processing",
+	Subcommands: []*args.Command{
+		stripeEventsCmd,
+		stripeRetryCmd,
+	},
+}
+
+var stripeEventsCmd = &args.Command{
+	Name: "events",
+	Help: "list received stripe events, newest first",
+	Options: append([]args.Option{
+		{
+			Long: "status",
+			Type: args.OptionTypeParameter,
+			Help: "only events with status: pending, processed, failed or ignored",
+		},
+	}, listOptions...),
+	Handler: func(i *args.Input) error {
+
+		path := addParams(i, "/stripe/events", "status", "limit", "offset")
+
+		response, err := requestList[service.StripeEvent](i, path)
+		if err != nil {
+			return err
+		}
+		return writeJSON(response)
+	},
+}
+
+var stripeRetryCmd = &args.Command{
+	Name: "retry",
+	Help: "queue the resource of a stripe event to be processed again",
+	Operands: []args.Operand{
+		{
+			Name: "id",
+			Help: "stripe event id",
+		},
+	},
+	Handler: func(i *args.Input) error {
+
+		path := fmt.Sprintf("/stripe/events/%s/retry", i.GetOperand("id"))
+		response := &service.StripeEvent{}
+		if err := request(i, http.MethodPost, path, nil, response); err != nil {
+			return err
+		}
+		return writeJSON(response)
+	},
+}
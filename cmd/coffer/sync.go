@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"git.sr.ht/~jakintosh/coffer/internal/service"
+	"git.sr.ht/~jakintosh/command-go/pkg/args"
+	"git.sr.ht/~jakintosh/command-go/pkg/envs"
+)
+
+var syncCmd = &args.Command{
+	Name:    "sync",
+	Help:    "backfill records from external services",
+	Options: envs.APIOptions,
+	Subcommands: []*args.Command{
+		syncStripeCmd,
+	},
+}
+
+var syncStripeCmd = &args.Command{
+	Name: "stripe",
+	Help: "record customers, subscriptions, payments and payouts missed by webhooks",
+	Options: []args.Option{
+		{
+			Long: "since",
+			Type: args.OptionTypeParameter,
+			Help: "RFC3339 start of objects to sync, defaults to the start of the last completed sync",
+		},
+	},
+	Handler: func(i *args.Input) error {
+
+		req := service.StripeSyncRequest{}
+		if since := i.GetParameter("since"); since != nil {
+			if _, err := time.Parse(time.RFC3339, *since); err != nil {
+				return fmt.Errorf("invalid date format: expected YYYY-MM-DDTHH-mm-ssZ")
+			}
+			req.Since = *since
+		}
+
+		body, err := json.Marshal(req)
+		if err != nil {
+			return err
+		}
+
+		client, err := newClient(i)
+		if err != nil {
+			return err
+		}
+		// a sync runs until every object is recorded, which can take
+		// much longer than the default client timeout
+		client.HTTPClient = &http.Client{}
+
+		response := &service.StripeSyncResult{}
+		if err := client.Post("/stripe/sync", body, response); err != nil {
+			return err
+		}
+
+		return writeJSON(response)
+	},
+}
@@ -2,28 +2,33 @@ package database
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
+	"time"
 
 	"git.sr.ht/~jakintosh/coffer/internal/service"
 )
 
 func ensureDefaultAllocations(conn *sql.DB) error {
 
-	// get current allocation count
+	// get default allocation set count
 	var count int
 	row := conn.QueryRow(`
 		SELECT COUNT(*)
-		FROM allocation;
+		FROM allocation_set
+		WHERE selector='';
 	`)
 	if err := row.Scan(&count); err != nil {
 		return fmt.Errorf("failed to check allocation table: %w", err)
 	}
 
-	// if zero, insert default
+	// if zero, insert default effective since the epoch
 	if count == 0 {
 		if _, err := conn.Exec(`
-			INSERT INTO allocation (id, ledger, percentage)
-			VALUES ('general', 'general', 100);
+			INSERT INTO allocation_set (created, effective_from, selector)
+			VALUES (unixepoch(), 0, '');
+			INSERT INTO allocation_rule (set_id, position, id, ledger, basis_points)
+			VALUES (last_insert_rowid(), 0, 'general', 'general', 10000);
 		`); err != nil {
 			return fmt.Errorf("failed to insert default allocation: %w", err)
 		}
@@ -31,76 +36,227 @@ func ensureDefaultAllocations(conn *sql.DB) error {
 	return nil
 }
 
-func (db *DB) GetAllocations() (
+// GetAllocations returns the rules of the default allocation set in effect
+// at the given time: the latest default set effective at or before it.
+func (db *DB) GetAllocations(
+	at int64,
+) (
 	[]service.AllocationRule,
 	error,
+) {
+	set, err := db.GetAllocationSet("", at)
+	if errors.Is(err, service.ErrAllocNotFound) {
+		return []service.AllocationRule{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return set.Rules, nil
+}
+
+// GetAllocationSet returns the latest set for selector effective at or
+// before the given time.
+func (db *DB) GetAllocationSet(
+	selector string,
+	at int64,
+) (
+	*service.AllocationSet,
+	error,
+) {
+	row := db.Conn.QueryRow(`
+		SELECT id, selector, created, effective_from
+		FROM allocation_set
+		WHERE selector=?1
+			AND effective_from<=?2
+		ORDER BY effective_from DESC, id DESC
+		LIMIT 1;
+		`,
+		selector,
+		at,
+	)
+	return db.scanAllocationSet(row)
+}
+
+func (db *DB) GetAllocationSetByID(
+	id int64,
+) (
+	*service.AllocationSet,
+	error,
+) {
+	row := db.Conn.QueryRow(`
+		SELECT id, selector, created, effective_from
+		FROM allocation_set
+		WHERE id=?1;
+		`,
+		id,
+	)
+	return db.scanAllocationSet(row)
+}
+
+// scanAllocationSet scans a single allocation set row and loads its rules
+func (db *DB) scanAllocationSet(
+	row *sql.Row,
+) (
+	*service.AllocationSet,
+	error,
+) {
+	var (
+		set       service.AllocationSet
+		created   int64
+		effective int64
+	)
+	err := row.Scan(
+		&set.ID,
+		&set.Selector,
+		&created,
+		&effective,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, service.ErrAllocNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	set.CreatedAt = time.Unix(created, 0)
+	set.EffectiveFrom = time.Unix(effective, 0)
+
+	if set.Rules, err = getAllocationRules(db.Conn, set.ID); err != nil {
+		return nil, err
+	}
+
+	return &set, nil
+}
+
+func (db *DB) GetAllocationHistory() (
+	[]service.AllocationSet,
+	error,
 ) {
 	rows, err := db.Conn.Query(`
-		SELECT id, ledger, percentage
-		FROM allocation
-		ORDER BY rowid;
+		SELECT id, selector, created, effective_from
+		FROM allocation_set
+		ORDER BY selector, effective_from DESC, id DESC;
 	`)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var allocations []service.AllocationRule
+	sets := []service.AllocationSet{}
 	for rows.Next() {
-		a := service.AllocationRule{}
+		var (
+			set       service.AllocationSet
+			created   int64
+			effective int64
+		)
 		if err := rows.Scan(
-			&a.ID,
-			&a.LedgerName,
-			&a.Percentage,
+			&set.ID,
+			&set.Selector,
+			&created,
+			&effective,
 		); err != nil {
 			return nil, err
 		}
-		allocations = append(allocations, a)
+		set.CreatedAt = time.Unix(created, 0)
+		set.EffectiveFrom = time.Unix(effective, 0)
+		sets = append(sets, set)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
 
-	return allocations, nil
+	for i := range sets {
+		if sets[i].Rules, err = getAllocationRules(db.Conn, sets[i].ID); err != nil {
+			return nil, err
+		}
+	}
+
+	return sets, nil
 }
 
+// SetAllocations appends a new allocation set for selector, created at
+// created, that takes effect at effectiveFrom. Earlier sets are kept as
+// history.
 func (db *DB) SetAllocations(
+	selector string,
 	rules []service.AllocationRule,
+	effectiveFrom int64,
+	created int64,
 ) error {
 	tx, err := db.Conn.Begin()
 	if err != nil {
 		return err
 	}
-	defer func() {
-		if err != nil {
-			tx.Rollback()
-		}
-	}()
+	defer tx.Rollback()
 
-	// delete existing allocations
-	_, err = tx.Exec(`DELETE FROM allocation;`)
+	res, err := tx.Exec(`
+		INSERT INTO allocation_set (created, effective_from, selector)
+		VALUES (?1, ?2, ?3);
+		`,
+		created,
+		effectiveFrom,
+		selector,
+	)
+	if err != nil {
+		return err
+	}
+	setID, err := res.LastInsertId()
 	if err != nil {
-		tx.Rollback()
 		return err
 	}
 
 	// prepare new allocation batch
 	stmt, err := tx.Prepare(`
-		INSERT INTO allocation (id, ledger, percentage)
-		VALUES (?1, ?2, ?3);
+		INSERT INTO allocation_rule (set_id, position, id, ledger, basis_points, fixed)
+		VALUES (?1, ?2, ?3, ?4, ?5, ?6);
 	`)
 	if err != nil {
-		tx.Rollback()
 		return err
 	}
-
-	// run batch of allocation inserts
 	defer stmt.Close()
 
-	for _, r := range rules {
-		_, err = stmt.Exec(r.ID, r.LedgerName, r.Percentage)
-		if err != nil {
-			tx.Rollback()
+	// run batch of allocation inserts
+	for i, r := range rules {
+		if _, err := stmt.Exec(setID, i, r.ID, r.LedgerName, r.BasisPoints, r.Fixed); err != nil {
 			return err
 		}
 	}
 
 	return tx.Commit()
 }
+
+func getAllocationRules(
+	conn *sql.DB,
+	setID int64,
+) (
+	[]service.AllocationRule,
+	error,
+) {
+	rows, err := conn.Query(`
+		SELECT id, ledger, basis_points, fixed
+		FROM allocation_rule
+		WHERE set_id=?1
+		ORDER BY position;
+		`,
+		setID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	allocations := []service.AllocationRule{}
+	for rows.Next() {
+		a := service.AllocationRule{}
+		if err := rows.Scan(
+			&a.ID,
+			&a.LedgerName,
+			&a.BasisPoints,
+			&a.Fixed,
+		); err != nil {
+			return nil, err
+		}
+		allocations = append(allocations, a)
+	}
+
+	return allocations, rows.Err()
+}
@@ -1,7 +1,9 @@
 package database_test
 
 import (
+	"errors"
 	"testing"
+	"time"
 
 	"git.sr.ht/~jakintosh/coffer/internal/database"
 	"git.sr.ht/~jakintosh/coffer/internal/service"
@@ -14,33 +16,34 @@ func TestAllocationsStore(t *testing.T) {
 	}
 	t.Cleanup(func() { _ = db.Close() })
 
-	rules, err := db.GetAllocations()
+	now := time.Now().Unix()
+	rules, err := db.GetAllocations(now)
 	if err != nil {
 		t.Fatal(err)
 	}
-	if len(rules) != 1 || rules[0].LedgerName != "general" || rules[0].Percentage != 100 {
+	if len(rules) != 1 || rules[0].LedgerName != "general" || rules[0].BasisPoints != 10000 {
 		t.Fatalf("unexpected default rules %+v", rules)
 	}
 
 	// insert new rules
 	newRules := []service.AllocationRule{
 		{
-			ID:         "g",
-			LedgerName: "general",
-			Percentage: 80,
+			ID:          "g",
+			LedgerName:  "general",
+			BasisPoints: 8000,
 		},
 		{
-			ID:         "c",
-			LedgerName: "community",
-			Percentage: 20,
+			ID:          "c",
+			LedgerName:  "community",
+			BasisPoints: 2000,
 		},
 	}
-	if err := db.SetAllocations(newRules); err != nil {
+	if err := db.SetAllocations("", newRules, now, now); err != nil {
 		t.Fatalf("failed to set allocations: %v", err)
 	}
 
 	// retrieve and check allocation rules
-	allocations, err := db.GetAllocations()
+	allocations, err := db.GetAllocations(now)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -51,3 +54,86 @@ func TestAllocationsStore(t *testing.T) {
 		t.Errorf("unexpected rules %+v", allocations)
 	}
 }
+
+func TestAllocationsStoreEffectiveDates(t *testing.T) {
+	db, err := database.Open(database.Options{Path: ":memory:"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+
+	march := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC).Unix()
+	rules := []service.AllocationRule{
+		{ID: "c", LedgerName: "community", BasisPoints: 10000},
+	}
+	if err := db.SetAllocations("", rules, march, march); err != nil {
+		t.Fatalf("failed to set allocations: %v", err)
+	}
+
+	// the default set stays in effect until march
+	before, err := db.GetAllocations(march - 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(before) != 1 || before[0].LedgerName != "general" {
+		t.Errorf("unexpected rules before march %+v", before)
+	}
+	after, err := db.GetAllocations(march)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(after) != 1 || after[0].LedgerName != "community" {
+		t.Errorf("unexpected rules from march %+v", after)
+	}
+
+	history, err := db.GetAllocationHistory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2 {
+		t.Fatalf("expected 2 sets, got %d", len(history))
+	}
+	if history[0].EffectiveFrom.Unix() != march || history[0].Rules[0].ID != "c" {
+		t.Errorf("unexpected latest set %+v", history[0])
+	}
+	if history[1].EffectiveFrom.Unix() != 0 || history[1].Rules[0].ID != "general" {
+		t.Errorf("unexpected default set %+v", history[1])
+	}
+}
+
+func TestAllocationsStoreSelectors(t *testing.T) {
+	db, err := database.Open(database.Options{Path: ":memory:"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+
+	now := time.Now().Unix()
+	rules := []service.AllocationRule{
+		{ID: "d", LedgerName: "dev", BasisPoints: 10000},
+	}
+	if err := db.SetAllocations("amount:5000", rules, now, now); err != nil {
+		t.Fatalf("failed to set allocations: %v", err)
+	}
+
+	// keyed sets do not replace the default set
+	def, err := db.GetAllocations(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(def) != 1 || def[0].LedgerName != "general" {
+		t.Errorf("unexpected default rules %+v", def)
+	}
+
+	set, err := db.GetAllocationSet("amount:5000", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if set.Selector != "amount:5000" || len(set.Rules) != 1 || set.Rules[0].LedgerName != "dev" {
+		t.Errorf("unexpected keyed set %+v", set)
+	}
+
+	if _, err := db.GetAllocationSet("amount:500", now); !errors.Is(err, service.ErrAllocNotFound) {
+		t.Errorf("expected ErrAllocNotFound, got %v", err)
+	}
+}
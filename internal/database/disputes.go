@@ -0,0 +1,245 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"git.sr.ht/~jakintosh/coffer/internal/service"
+)
+
+func (db *DB) GetDispute(
+	id string,
+) (
+	*service.Dispute,
+	error,
+) {
+	row := db.Conn.QueryRow(`
+		SELECT id, created, payment, status, reason, amount, fee, currency, held, stage
+		FROM dispute
+		WHERE id=?1;
+		`,
+		id,
+	)
+	d, err := scanDispute(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, service.ErrDisputeNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to query dispute: %w", err)
+	}
+
+	return d, nil
+}
+
+// GetDisputes lists disputes, newest first. Closed disputes are only
+// included when all is set.
+func (db *DB) GetDisputes(
+	all bool,
+) (
+	[]service.Dispute,
+	error,
+) {
+	rows, err := db.Conn.Query(`
+		SELECT id, created, payment, status, reason, amount, fee, currency, held, stage
+		FROM dispute
+		WHERE ?1 OR status NOT IN ('won', 'lost', 'warning_closed')
+		ORDER BY created DESC, id;
+		`,
+		all,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query disputes: %w", err)
+	}
+	defer rows.Close()
+
+	disputes := []service.Dispute{}
+	for rows.Next() {
+		d, err := scanDispute(rows)
+		if err != nil {
+			return nil, err
+		}
+		disputes = append(disputes, *d)
+	}
+
+	return disputes, rows.Err()
+}
+
+func (db *DB) GetDisputeSummary() (
+	*service.DisputeSummary,
+	error,
+) {
+	summary := &service.DisputeSummary{}
+	row := db.Conn.QueryRow(`
+		SELECT COUNT(*), COALESCE(SUM(amount), 0)
+		FROM dispute
+		WHERE status NOT IN ('won', 'lost', 'warning_closed');
+	`)
+	if err := row.Scan(&summary.Count, &summary.Total); err != nil {
+		return nil, fmt.Errorf("failed to query dispute summary: %w", err)
+	}
+
+	return summary, nil
+}
+
+// InsertDispute records a dispute, or updates a known one, and posts
+// entries in the same transaction. The entries are worked out by post
+// from the dispute as last recorded, its payment and the number of its fee
+// entries, read in that transaction, and are only posted when the stage or
+// the fee of the dispute changes. Every entry is recorded for the payment. When a dispute is released, every live entry of its
+// hold is reversed, dated at date.
+func (db *DB) InsertDispute(
+	d service.Dispute,
+	post service.DisputePosting,
+	date int64,
+) error {
+	tx, err := db.Conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	existing, err := scanDispute(tx.QueryRow(`
+		SELECT id, created, payment, status, reason, amount, fee, currency, held, stage
+		FROM dispute
+		WHERE id=?1;
+		`,
+		d.ID,
+	))
+	if errors.Is(err, sql.ErrNoRows) {
+		existing = nil
+	} else if err != nil {
+		return fmt.Errorf("failed to query dispute: %w", err)
+	}
+
+	payment, err := getPaymentAllocation(tx, d.Payment)
+	if errors.Is(err, service.ErrPaymentNotFound) {
+		payment = nil
+	} else if err != nil {
+		return fmt.Errorf("failed to query payment %s: %w", d.Payment, err)
+	}
+
+	// fee entries are numbered, since a fee can return to an earlier amount
+	var feeEntries int
+	feeID := service.DisputeFeeID(d.ID, 0)
+	if err := tx.QueryRow(`
+		SELECT COUNT(*)
+		FROM tx
+		WHERE label='dispute fee'
+			AND id>=?1
+			AND id<?1 || ';';
+		`,
+		feeID,
+	).Scan(&feeEntries); err != nil {
+		return fmt.Errorf("failed to query dispute fees %s: %w", d.ID, err)
+	}
+
+	entries, err := post(&d, existing, payment, feeEntries)
+	if err != nil {
+		return err
+	}
+
+	var (
+		stage string
+		fee   int
+	)
+	if existing != nil {
+		stage, fee = existing.Stage, existing.Fee
+	}
+
+	if _, err := tx.Exec(`
+		INSERT INTO dispute (id, created, updated, payment, status, reason, amount, fee, currency, held, stage)
+		VALUES (?1, ?2, unixepoch(), ?3, ?4, ?5, ?6, ?7, ?8, ?9, ?10)
+		ON CONFLICT(id) DO UPDATE
+			SET updated=unixepoch(),
+				status=excluded.status,
+				reason=excluded.reason,
+				amount=excluded.amount,
+				fee=excluded.fee,
+				held=excluded.held,
+				stage=excluded.stage;`,
+		d.ID,
+		d.Created.Unix(),
+		d.Payment,
+		d.Status,
+		d.Reason,
+		d.Amount,
+		d.Fee,
+		d.Currency,
+		d.Held,
+		d.Stage,
+	); err != nil {
+		return fmt.Errorf("failed to insert dispute: %w", err)
+	}
+
+	if stage == d.Stage && fee == d.Fee {
+		return tx.Commit()
+	}
+
+	for _, t := range entries {
+		row := txRow{
+			id:      t.ID,
+			ledger:  t.Ledger,
+			amount:  t.Amount,
+			date:    t.Date.Unix(),
+			label:   t.Label,
+			payment: sql.NullString{String: d.Payment, Valid: true},
+		}
+		if err := insertTransaction(tx, row); err != nil {
+			return err
+		}
+	}
+
+	if d.Stage == service.DisputeReleased && stage != d.Stage {
+		hold := service.DisputeHoldID(d.ID)
+		live, err := getLiveEntries(tx, hold)
+		if err != nil {
+			return fmt.Errorf("failed to query dispute hold %s: %w", d.ID, err)
+		}
+		for _, orig := range live {
+			row := txRow{
+				id:       orig.id + ":reversal",
+				ledger:   orig.ledger,
+				amount:   -orig.amount,
+				date:     date,
+				label:    "dispute won",
+				reverses: sql.NullString{String: orig.id, Valid: true},
+				payment:  orig.payment,
+			}
+			if err := insertTransaction(tx, row); err != nil {
+				return err
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+func scanDispute(
+	row rowScanner,
+) (
+	*service.Dispute,
+	error,
+) {
+	var (
+		d       service.Dispute
+		created int64
+	)
+	if err := row.Scan(
+		&d.ID,
+		&created,
+		&d.Payment,
+		&d.Status,
+		&d.Reason,
+		&d.Amount,
+		&d.Fee,
+		&d.Currency,
+		&d.Held,
+		&d.Stage,
+	); err != nil {
+		return nil, err
+	}
+	d.Created = time.Unix(created, 0)
+
+	return &d, nil
+}
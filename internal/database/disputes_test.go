@@ -0,0 +1,91 @@
+package database_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"git.sr.ht/~jakintosh/coffer/internal/service"
+	"git.sr.ht/~jakintosh/coffer/internal/testutil"
+)
+
+func TestInsertDispute(t *testing.T) {
+	env := testutil.SetupTestEnv(t)
+	testutil.SeedLedgers(t, env.Service, "disputes")
+
+	shares := []service.Allocation{{Ledger: "general", Amount: 1000}}
+	if err := env.DB.InsertPayment(makePayment("pi_1", 100, "succeeded", 1000, 1), shares, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	dispute := service.Dispute{
+		ID:       "dp_1",
+		Payment:  "pi_1",
+		Created:  time.Unix(100, 0),
+		Status:   "needs_response",
+		Amount:   1000,
+		Currency: "usd",
+	}
+	hold := service.DisputeHoldID("dp_1")
+	post := func(d *service.Dispute, existing *service.Dispute, p *service.PaymentAllocation, fees int) ([]service.Transaction, error) {
+		if existing != nil || p == nil || len(p.Posted) != 1 || p.Posted[0].Amount != 1000 {
+			t.Errorf("unexpected dispute %+v or payment %+v", existing, p)
+		}
+		d.Held = 1000
+		d.Stage = service.DisputeHeld
+		return []service.Transaction{
+			{ID: hold + ":general", Ledger: "general", Amount: -1000, Date: time.Unix(100, 0), Label: "dispute hold"},
+			{ID: hold, Ledger: "disputes", Amount: 1000, Date: time.Unix(100, 0), Label: "dispute hold"},
+		}, nil
+	}
+	if err := env.DB.InsertDispute(dispute, post, 100); err != nil {
+		t.Fatalf("InsertDispute failed: %v", err)
+	}
+
+	// an unchanged stage posts nothing
+	dispute.Status = "under_review"
+	post = func(d *service.Dispute, existing *service.Dispute, p *service.PaymentAllocation, fees int) ([]service.Transaction, error) {
+		d.Held, d.Stage = existing.Held, existing.Stage
+		return []service.Transaction{{ID: "bogus", Ledger: "general", Amount: 1, Date: time.Unix(100, 0)}}, nil
+	}
+	if err := env.DB.InsertDispute(dispute, post, 100); err != nil {
+		t.Fatalf("InsertDispute failed: %v", err)
+	}
+	if _, err := env.DB.GetTransaction("bogus"); !errors.Is(err, service.ErrTxNotFound) {
+		t.Errorf("expected no entry for unchanged stage, got %v", err)
+	}
+
+	// releasing reverses the hold
+	dispute.Status = "won"
+	post = func(d *service.Dispute, existing *service.Dispute, p *service.PaymentAllocation, fees int) ([]service.Transaction, error) {
+		d.Held, d.Stage = existing.Held, service.DisputeReleased
+		return nil, nil
+	}
+	if err := env.DB.InsertDispute(dispute, post, 200); err != nil {
+		t.Fatalf("InsertDispute failed: %v", err)
+	}
+	for _, id := range []string{hold, hold + ":general"} {
+		tx, err := env.DB.GetTransaction(id + ":reversal")
+		if err != nil {
+			t.Fatalf("GetTransaction %s failed: %v", id, err)
+		}
+		if tx.Reverses != id || tx.Date.Unix() != 200 {
+			t.Errorf("unexpected reversal %+v", tx)
+		}
+	}
+
+	got, err := env.DB.GetDispute("dp_1")
+	if err != nil {
+		t.Fatalf("GetDispute failed: %v", err)
+	}
+	if got.Status != "won" || got.Stage != service.DisputeReleased || got.Held != 1000 {
+		t.Errorf("unexpected dispute %+v", got)
+	}
+	summary, err := env.DB.GetDisputeSummary()
+	if err != nil {
+		t.Fatalf("GetDisputeSummary failed: %v", err)
+	}
+	if summary.Count != 0 || summary.Total != 0 {
+		t.Errorf("unexpected summary %+v", summary)
+	}
+}
@@ -0,0 +1,258 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"git.sr.ht/~jakintosh/coffer/internal/service"
+	"git.sr.ht/~jakintosh/coffer/pkg/wire"
+)
+
+func (db *DB) GetStripeEvent(
+	id string,
+) (
+	*service.StripeEvent,
+	error,
+) {
+	row := db.Conn.QueryRow(`
+		SELECT id, type, resource_type, resource_id, created, received, status, attempts, next_attempt, last_error
+		FROM stripe_event
+		WHERE id=?1;
+		`,
+		id,
+	)
+	e, err := scanStripeEvent(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, service.ErrEventNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to query stripe event: %w", err)
+	}
+
+	return e, nil
+}
+
+// GetStripeEvents lists events, newest first, optionally filtered by status
+func (db *DB) GetStripeEvents(
+	status string,
+	after *wire.Cursor,
+	limit int,
+	offset int,
+) (
+	[]service.StripeEvent,
+	error,
+) {
+	// keyset pagination on (received, id), unbounded without a cursor
+	var (
+		afterKey sql.NullInt64
+		afterID  string
+	)
+	if after != nil {
+		afterKey = sql.NullInt64{Int64: after.Key, Valid: true}
+		afterID = after.ID
+	}
+
+	rows, err := db.Conn.Query(`
+		SELECT id, type, resource_type, resource_id, created, received, status, attempts, next_attempt, last_error
+		FROM stripe_event
+		WHERE (?1 = '' OR status=?1)
+			AND (?2 IS NULL
+				OR received<?2
+				OR (received=?2 AND id<?3))
+		ORDER BY received DESC, id DESC
+		LIMIT ?4 OFFSET ?5;`,
+		status,
+		afterKey,
+		afterID,
+		limit,
+		offset,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query stripe events: %w", err)
+	}
+	defer rows.Close()
+
+	return scanStripeEvents(rows)
+}
+
+// InsertStripeEvent queues a received event. Events that were already
+// received are left as they are.
+func (db *DB) InsertStripeEvent(
+	e service.StripeEvent,
+) error {
+	_, err := db.Conn.Exec(`
+		INSERT INTO stripe_event (id, type, resource_type, resource_id, created, received, status, attempts, next_attempt, last_error)
+		VALUES (?1, ?2, ?3, ?4, ?5, ?6, ?7, ?8, ?9, ?10)
+		ON CONFLICT(id) DO NOTHING;`,
+		e.ID,
+		e.Type,
+		e.ResourceType,
+		e.ResourceID,
+		e.Created.Unix(),
+		e.Received.Unix(),
+		e.Status,
+		e.Attempts,
+		e.NextAttempt.Unix(),
+		e.LastError,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to insert stripe event: %w", err)
+	}
+
+	return nil
+}
+
+// UpdateStripeEvent records the outcome of an attempt to process an event
+func (db *DB) UpdateStripeEvent(
+	e service.StripeEvent,
+) error {
+	_, err := db.Conn.Exec(`
+		UPDATE stripe_event
+		SET status=?2,
+			attempts=?3,
+			next_attempt=?4,
+			last_error=?5
+		WHERE id=?1;
+		`,
+		e.ID,
+		e.Status,
+		e.Attempts,
+		e.NextAttempt.Unix(),
+		e.LastError,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update stripe event: %w", err)
+	}
+
+	return nil
+}
+
+// GetPendingStripeEvents lists the pending events of a resource, oldest
+// first.
+func (db *DB) GetPendingStripeEvents(
+	resourceType string,
+	resourceID string,
+) (
+	[]service.StripeEvent,
+	error,
+) {
+	rows, err := db.Conn.Query(`
+		SELECT id, type, resource_type, resource_id, created, received, status, attempts, next_attempt, last_error
+		FROM stripe_event
+		WHERE resource_type=?1
+			AND resource_id=?2
+			AND status=?3
+		ORDER BY received, id;
+		`,
+		resourceType,
+		resourceID,
+		service.EventPending,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query stripe events: %w", err)
+	}
+	defer rows.Close()
+
+	return scanStripeEvents(rows)
+}
+
+// ClaimStripeEvents returns the pending events due by due, oldest first,
+// and pushes their next attempt back to until so that they are not claimed
+// again while they are processed.
+func (db *DB) ClaimStripeEvents(
+	due int64,
+	until int64,
+) (
+	[]service.StripeEvent,
+	error,
+) {
+	tx, err := db.Conn.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`
+		SELECT id, type, resource_type, resource_id, created, received, status, attempts, next_attempt, last_error
+		FROM stripe_event
+		WHERE status=?1
+			AND next_attempt<=?2
+		ORDER BY received, id;
+		`,
+		service.EventPending,
+		due,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query stripe events: %w", err)
+	}
+	events, err := scanStripeEvents(rows)
+	rows.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.Exec(`
+		UPDATE stripe_event
+		SET next_attempt=?3
+		WHERE status=?1
+			AND next_attempt<=?2;
+		`,
+		service.EventPending,
+		due,
+		until,
+	); err != nil {
+		return nil, fmt.Errorf("failed to claim stripe events: %w", err)
+	}
+
+	return events, tx.Commit()
+}
+
+func scanStripeEvents(
+	rows *sql.Rows,
+) (
+	[]service.StripeEvent,
+	error,
+) {
+	events := []service.StripeEvent{}
+	for rows.Next() {
+		e, err := scanStripeEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, *e)
+	}
+
+	return events, rows.Err()
+}
+
+func scanStripeEvent(
+	row rowScanner,
+) (
+	*service.StripeEvent,
+	error,
+) {
+	var (
+		e                              service.StripeEvent
+		created, received, nextAttempt int64
+	)
+	if err := row.Scan(
+		&e.ID,
+		&e.Type,
+		&e.ResourceType,
+		&e.ResourceID,
+		&created,
+		&received,
+		&e.Status,
+		&e.Attempts,
+		&nextAttempt,
+		&e.LastError,
+	); err != nil {
+		return nil, err
+	}
+	e.Created = time.Unix(created, 0)
+	e.Received = time.Unix(received, 0)
+	e.NextAttempt = time.Unix(nextAttempt, 0)
+
+	return &e, nil
+}
@@ -0,0 +1,171 @@
+package database_test
+
+import (
+	"testing"
+	"time"
+
+	"git.sr.ht/~jakintosh/coffer/internal/database"
+	"git.sr.ht/~jakintosh/coffer/internal/service"
+	"git.sr.ht/~jakintosh/coffer/internal/testutil"
+	"git.sr.ht/~jakintosh/coffer/pkg/wire"
+)
+
+// openEventsDB opens a database without a running service, whose retry
+// loop would claim the events under test.
+func openEventsDB(t *testing.T) *database.DB {
+	t.Helper()
+
+	db, err := database.Open(database.Options{Path: ":memory:"})
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func makeStripeEvent(
+	id string,
+	resource string,
+	received time.Time,
+) service.StripeEvent {
+	return service.StripeEvent{
+		ID:           id,
+		Type:         "payment_intent.succeeded",
+		ResourceType: "payment",
+		ResourceID:   resource,
+		Created:      received,
+		Received:     received,
+		Status:       service.EventPending,
+		NextAttempt:  received,
+	}
+}
+
+func TestInsertStripeEvent(t *testing.T) {
+	db := openEventsDB(t)
+
+	received := testutil.MakeDate(2025, 1, 1)
+	if err := db.InsertStripeEvent(makeStripeEvent("evt_1", "pi_1", received)); err != nil {
+		t.Fatalf("InsertStripeEvent failed: %v", err)
+	}
+
+	// redelivery does not reset the event
+	e := makeStripeEvent("evt_1", "pi_1", received)
+	e.Status = service.EventProcessed
+	e.Attempts = 1
+	if err := db.UpdateStripeEvent(e); err != nil {
+		t.Fatalf("UpdateStripeEvent failed: %v", err)
+	}
+	if err := db.InsertStripeEvent(makeStripeEvent("evt_1", "pi_1", received)); err != nil {
+		t.Fatalf("InsertStripeEvent failed: %v", err)
+	}
+
+	got, err := db.GetStripeEvent("evt_1")
+	if err != nil {
+		t.Fatalf("GetStripeEvent failed: %v", err)
+	}
+	if got.Status != service.EventProcessed || got.Attempts != 1 || !got.Received.Equal(received) {
+		t.Errorf("unexpected event %+v", got)
+	}
+
+	if _, err := db.GetStripeEvent("evt_2"); err != service.ErrEventNotFound {
+		t.Errorf("want ErrEventNotFound got %v", err)
+	}
+}
+
+func TestGetPendingStripeEvents(t *testing.T) {
+	db := openEventsDB(t)
+
+	received := testutil.MakeDate(2025, 1, 1)
+	for _, e := range []service.StripeEvent{
+		makeStripeEvent("evt_2", "pi_1", received.Add(time.Second)),
+		makeStripeEvent("evt_1", "pi_1", received),
+		makeStripeEvent("evt_3", "pi_2", received),
+	} {
+		if err := db.InsertStripeEvent(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	events, err := db.GetPendingStripeEvents("payment", "pi_1")
+	if err != nil {
+		t.Fatalf("GetPendingStripeEvents failed: %v", err)
+	}
+	if len(events) != 2 || events[0].ID != "evt_1" || events[1].ID != "evt_2" {
+		t.Errorf("unexpected events %+v", events)
+	}
+}
+
+func TestClaimStripeEvents(t *testing.T) {
+	db := openEventsDB(t)
+
+	received := testutil.MakeDate(2025, 1, 1)
+	due := makeStripeEvent("evt_1", "pi_1", received)
+	later := makeStripeEvent("evt_2", "pi_2", received)
+	later.NextAttempt = received.Add(time.Hour)
+	done := makeStripeEvent("evt_3", "pi_3", received)
+	done.Status = service.EventProcessed
+	for _, e := range []service.StripeEvent{due, later, done} {
+		if err := db.InsertStripeEvent(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	until := received.Add(time.Minute).Unix()
+	events, err := db.ClaimStripeEvents(received.Unix(), until)
+	if err != nil {
+		t.Fatalf("ClaimStripeEvents failed: %v", err)
+	}
+	if len(events) != 1 || events[0].ID != "evt_1" {
+		t.Fatalf("unexpected events %+v", events)
+	}
+
+	// claimed events are not claimed again until the lease ends
+	events, err = db.ClaimStripeEvents(received.Unix(), until)
+	if err != nil {
+		t.Fatalf("ClaimStripeEvents failed: %v", err)
+	}
+	if len(events) != 0 {
+		t.Errorf("unexpected events %+v", events)
+	}
+
+	events, err = db.ClaimStripeEvents(received.Add(time.Hour).Unix(), until)
+	if err != nil {
+		t.Fatalf("ClaimStripeEvents failed: %v", err)
+	}
+	if len(events) != 2 {
+		t.Errorf("unexpected events %+v", events)
+	}
+}
+
+func TestGetStripeEvents(t *testing.T) {
+	db := openEventsDB(t)
+
+	received := testutil.MakeDate(2025, 1, 1)
+	failed := makeStripeEvent("evt_2", "pi_2", received)
+	failed.Status = service.EventFailed
+	for _, e := range []service.StripeEvent{
+		makeStripeEvent("evt_1", "pi_1", received),
+		failed,
+		makeStripeEvent("evt_3", "pi_3", received.Add(time.Second)),
+	} {
+		if err := db.InsertStripeEvent(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	events, err := db.GetStripeEvents("", nil, 10, 0)
+	if err != nil {
+		t.Fatalf("GetStripeEvents failed: %v", err)
+	}
+	if len(events) != 3 || events[0].ID != "evt_3" || events[1].ID != "evt_2" || events[2].ID != "evt_1" {
+		t.Errorf("unexpected events %+v", events)
+	}
+
+	events, err = db.GetStripeEvents(service.EventPending, &wire.Cursor{Key: received.Add(time.Second).Unix(), ID: "evt_3"}, 10, 0)
+	if err != nil {
+		t.Fatalf("GetStripeEvents failed: %v", err)
+	}
+	if len(events) != 1 || events[0].ID != "evt_1" {
+		t.Errorf("unexpected events %+v", events)
+	}
+}
@@ -0,0 +1,185 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"git.sr.ht/~jakintosh/coffer/internal/service"
+)
+
+func (db *DB) GetGoal(
+	id string,
+) (
+	*service.Goal,
+	error,
+) {
+	row := db.Conn.QueryRow(`
+		SELECT id, ledger, title, description, target, start, deadline, created, updated
+		FROM goal
+		WHERE id=?1;
+		`,
+		id,
+	)
+	g, err := scanGoal(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, service.ErrGoalNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to query goal: %w", err)
+	}
+
+	return g, nil
+}
+
+func (db *DB) GetGoals() (
+	[]service.Goal,
+	error,
+) {
+	rows, err := db.Conn.Query(`
+		SELECT id, ledger, title, description, target, start, deadline, created, updated
+		FROM goal
+		ORDER BY deadline, id;
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query goals: %w", err)
+	}
+	defer rows.Close()
+
+	goals := []service.Goal{}
+	for rows.Next() {
+		g, err := scanGoal(rows)
+		if err != nil {
+			return nil, err
+		}
+		goals = append(goals, *g)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return goals, nil
+}
+
+func (db *DB) InsertGoal(
+	g service.Goal,
+) error {
+	res, err := db.Conn.Exec(`
+		INSERT INTO goal (id, created, ledger, title, description, target, start, deadline)
+		VALUES (?1, ?2, ?3, ?4, ?5, ?6, ?7, ?8)
+		ON CONFLICT(id) DO NOTHING;
+		`,
+		g.ID,
+		time.Now().Unix(),
+		g.Ledger,
+		g.Title,
+		g.Description,
+		g.Target,
+		g.Start.Unix(),
+		g.Deadline.Unix(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to insert goal: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return service.ErrGoalExists
+	}
+
+	return nil
+}
+
+func (db *DB) UpdateGoal(
+	g service.Goal,
+) error {
+	res, err := db.Conn.Exec(`
+		UPDATE goal
+		SET ledger=?2,
+			title=?3,
+			description=?4,
+			target=?5,
+			start=?6,
+			deadline=?7,
+			updated=?8
+		WHERE id=?1;
+		`,
+		g.ID,
+		g.Ledger,
+		g.Title,
+		g.Description,
+		g.Target,
+		g.Start.Unix(),
+		g.Deadline.Unix(),
+		time.Now().Unix(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update goal: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return service.ErrGoalNotFound
+	}
+
+	return nil
+}
+
+func (db *DB) DeleteGoal(
+	id string,
+) error {
+	res, err := db.Conn.Exec(`
+		DELETE FROM goal
+		WHERE id=?1;
+		`,
+		id,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to delete goal: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return service.ErrGoalNotFound
+	}
+
+	return nil
+}
+
+func scanGoal(
+	row rowScanner,
+) (
+	*service.Goal,
+	error,
+) {
+	var (
+		g        service.Goal
+		start    int64
+		deadline int64
+		created  int64
+		updated  sql.NullInt64
+	)
+	if err := row.Scan(
+		&g.ID,
+		&g.Ledger,
+		&g.Title,
+		&g.Description,
+		&g.Target,
+		&start,
+		&deadline,
+		&created,
+		&updated,
+	); err != nil {
+		return nil, err
+	}
+	g.Start = time.Unix(start, 0)
+	g.Deadline = time.Unix(deadline, 0)
+	g.CreatedAt = time.Unix(created, 0)
+	if updated.Valid {
+		g.UpdatedAt = time.Unix(updated.Int64, 0)
+	} else {
+		g.UpdatedAt = g.CreatedAt
+	}
+
+	return &g, nil
+}
@@ -0,0 +1,67 @@
+package database_test
+
+import (
+	"errors"
+	"testing"
+
+	"git.sr.ht/~jakintosh/coffer/internal/service"
+	"git.sr.ht/~jakintosh/coffer/internal/testutil"
+)
+
+func TestGoalsStore(t *testing.T) {
+	env := testutil.SetupTestEnv(t)
+
+	g := service.Goal{
+		ID:       "servers",
+		Ledger:   "general",
+		Title:    "server costs",
+		Target:   300000,
+		Start:    testutil.MakeDate(2027, 1, 1),
+		Deadline: testutil.MakeDate(2027, 12, 31),
+	}
+	if err := env.DB.InsertGoal(g); err != nil {
+		t.Fatalf("InsertGoal: %v", err)
+	}
+	if err := env.DB.InsertGoal(g); !errors.Is(err, service.ErrGoalExists) {
+		t.Fatalf("expected ErrGoalExists, got %v", err)
+	}
+
+	got, err := env.DB.GetGoal("servers")
+	if err != nil {
+		t.Fatalf("GetGoal: %v", err)
+	}
+	if got.Target != g.Target || !got.Start.Equal(g.Start) || !got.Deadline.Equal(g.Deadline) {
+		t.Errorf("unexpected goal %+v", got)
+	}
+
+	g.Target = 100
+	if err := env.DB.UpdateGoal(g); err != nil {
+		t.Fatalf("UpdateGoal: %v", err)
+	}
+	goals, err := env.DB.GetGoals()
+	if err != nil {
+		t.Fatalf("GetGoals: %v", err)
+	}
+	if len(goals) != 1 || goals[0].Target != 100 {
+		t.Fatalf("unexpected goals %+v", goals)
+	}
+
+	// ledgers with goals are in use
+	if err := env.DB.InsertLedger(service.Ledger{Name: "servers"}); err != nil {
+		t.Fatal(err)
+	}
+	g.Ledger = "servers"
+	if err := env.DB.UpdateGoal(g); err != nil {
+		t.Fatal(err)
+	}
+	if err := env.DB.DeleteLedger("servers"); !errors.Is(err, service.ErrLedgerInUse) {
+		t.Fatalf("expected ErrLedgerInUse, got %v", err)
+	}
+
+	if err := env.DB.DeleteGoal("servers"); err != nil {
+		t.Fatalf("DeleteGoal: %v", err)
+	}
+	if _, err := env.DB.GetGoal("servers"); !errors.Is(err, service.ErrGoalNotFound) {
+		t.Fatalf("expected ErrGoalNotFound, got %v", err)
+	}
+}
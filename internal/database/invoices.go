@@ -0,0 +1,135 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"git.sr.ht/~jakintosh/coffer/internal/service"
+)
+
+const invoiceColumns = `id, customer, subscription, payment, created, status, billing_reason, amount_due, amount_paid, currency, period_start, period_end, price, product, attempts, next_attempt, failed`
+
+func (db *DB) GetInvoice(
+	id string,
+) (
+	*service.Invoice,
+	error,
+) {
+	row := db.Conn.QueryRow(`
+		SELECT `+invoiceColumns+`
+		FROM invoice
+		WHERE id=?1;
+		`,
+		id,
+	)
+	inv, err := scanInvoice(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, service.ErrInvoiceNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to query invoice: %w", err)
+	}
+
+	return inv, nil
+}
+
+// InsertInvoice records an invoice, or updates the state of a known one. A
+// known payment is kept if the update has none.
+func (db *DB) InsertInvoice(
+	inv service.Invoice,
+) error {
+	var subscriptionNullStr, paymentNullStr sql.NullString
+	if inv.Subscription != "" {
+		subscriptionNullStr = sql.NullString{String: inv.Subscription, Valid: true}
+	}
+	if inv.Payment != "" {
+		paymentNullStr = sql.NullString{String: inv.Payment, Valid: true}
+	}
+
+	_, err := db.Conn.Exec(`
+		INSERT INTO invoice (id, customer, subscription, payment, created, status, billing_reason, amount_due, amount_paid, currency, period_start, period_end, price, product, attempts, next_attempt, failed)
+		VALUES (?1, ?2, ?3, ?4, ?5, ?6, ?7, ?8, ?9, ?10, ?11, ?12, ?13, ?14, ?15, ?16, ?17)
+		ON CONFLICT(id) DO UPDATE
+			SET updated=unixepoch(),
+				subscription=COALESCE(excluded.subscription, invoice.subscription),
+				payment=COALESCE(excluded.payment, invoice.payment),
+				status=excluded.status,
+				amount_due=excluded.amount_due,
+				amount_paid=excluded.amount_paid,
+				period_start=excluded.period_start,
+				period_end=excluded.period_end,
+				price=excluded.price,
+				product=excluded.product,
+				attempts=excluded.attempts,
+				next_attempt=excluded.next_attempt,
+				failed=excluded.failed;`,
+		inv.ID,
+		inv.Customer,
+		subscriptionNullStr,
+		paymentNullStr,
+		inv.Created.Unix(),
+		inv.Status,
+		inv.BillingReason,
+		inv.AmountDue,
+		inv.AmountPaid,
+		inv.Currency,
+		nullUnix(inv.PeriodStart),
+		nullUnix(inv.PeriodEnd),
+		inv.PriceID,
+		inv.ProductID,
+		inv.Attempts,
+		nullUnix(inv.NextAttempt),
+		inv.Failed,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to insert invoice: %w", err)
+	}
+	return nil
+}
+
+func scanInvoice(
+	row rowScanner,
+) (
+	*service.Invoice,
+	error,
+) {
+	var (
+		inv          service.Invoice
+		subscription sql.NullString
+		payment      sql.NullString
+		created      int64
+		periodStart  sql.NullInt64
+		periodEnd    sql.NullInt64
+		nextAttempt  sql.NullInt64
+	)
+	if err := row.Scan(
+		&inv.ID,
+		&inv.Customer,
+		&subscription,
+		&payment,
+		&created,
+		&inv.Status,
+		&inv.BillingReason,
+		&inv.AmountDue,
+		&inv.AmountPaid,
+		&inv.Currency,
+		&periodStart,
+		&periodEnd,
+		&inv.PriceID,
+		&inv.ProductID,
+		&inv.Attempts,
+		&nextAttempt,
+		&inv.Failed,
+	); err != nil {
+		return nil, err
+	}
+	inv.Subscription = subscription.String
+	inv.Payment = payment.String
+	inv.Created = time.Unix(created, 0)
+	inv.PeriodStart = timeFromNull(periodStart)
+	inv.PeriodEnd = timeFromNull(periodEnd)
+	inv.NextAttempt = timeFromNull(nextAttempt)
+
+	return &inv, nil
+}
@@ -0,0 +1,90 @@
+package database_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"git.sr.ht/~jakintosh/coffer/internal/service"
+	"git.sr.ht/~jakintosh/coffer/internal/testutil"
+)
+
+func TestInsertInvoice(t *testing.T) {
+	env := testutil.SetupTestEnv(t)
+
+	if _, err := env.DB.GetInvoice("in_1"); !errors.Is(err, service.ErrInvoiceNotFound) {
+		t.Fatalf("expected ErrInvoiceNotFound, got %v", err)
+	}
+
+	start, end, next := time.Unix(1000, 0), time.Unix(2000, 0), time.Unix(1100, 0)
+	inv := service.Invoice{
+		ID:           "in_1",
+		Customer:     "cus_1",
+		Subscription: "sub_1",
+		Payment:      "pi_1",
+		Created:      time.Unix(1000, 0),
+		Status:       "open",
+		AmountDue:    500,
+		Currency:     "usd",
+		PeriodStart:  &start,
+		PeriodEnd:    &end,
+		ProductID:    "prod_1",
+		Attempts:     1,
+		NextAttempt:  &next,
+		Failed:       true,
+	}
+	if err := env.DB.InsertInvoice(inv); err != nil {
+		t.Fatalf("InsertInvoice failed: %v", err)
+	}
+
+	// an update without a payment keeps the known one
+	inv.Payment = ""
+	inv.Status = "paid"
+	inv.AmountPaid = 500
+	inv.NextAttempt = nil
+	inv.Failed = false
+	if err := env.DB.InsertInvoice(inv); err != nil {
+		t.Fatalf("InsertInvoice update failed: %v", err)
+	}
+
+	got, err := env.DB.GetInvoice("in_1")
+	if err != nil {
+		t.Fatalf("GetInvoice failed: %v", err)
+	}
+	if got.Payment != "pi_1" || got.Subscription != "sub_1" || got.Status != "paid" || got.Failed {
+		t.Errorf("unexpected invoice %+v", got)
+	}
+	if got.PeriodStart.Unix() != 1000 || got.PeriodEnd.Unix() != 2000 || got.NextAttempt != nil {
+		t.Errorf("unexpected dates %+v", got)
+	}
+}
+
+func TestSubscriptionTimelineInvoices(t *testing.T) {
+	env := testutil.SetupTestEnv(t)
+
+	sub := makeSubscription("sub_1", 1000, "cus_1", "active", 500, "usd")
+	if err := env.DB.InsertSubscription(sub, 0, nil); err != nil {
+		t.Fatalf("InsertSubscription failed: %v", err)
+	}
+	for _, inv := range []service.Invoice{
+		{ID: "in_2", Customer: "cus_1", Subscription: "sub_1", Created: time.Unix(2000, 0), Status: "open", Currency: "usd"},
+		{ID: "in_1", Customer: "cus_1", Subscription: "sub_1", Created: time.Unix(1000, 0), Status: "paid", Currency: "usd"},
+		{ID: "in_3", Customer: "cus_1", Created: time.Unix(1500, 0), Status: "paid", Currency: "usd"},
+	} {
+		if err := env.DB.InsertInvoice(inv); err != nil {
+			t.Fatalf("InsertInvoice failed: %v", err)
+		}
+	}
+
+	timelines, err := env.DB.GetSubscriptionTimelines("cus_1")
+	if err != nil {
+		t.Fatalf("GetSubscriptionTimelines failed: %v", err)
+	}
+	if len(timelines) != 1 {
+		t.Fatalf("expected 1 timeline, got %+v", timelines)
+	}
+	invoices := timelines[0].Invoices
+	if len(invoices) != 2 || invoices[0].ID != "in_1" || invoices[1].ID != "in_2" {
+		t.Errorf("unexpected invoices %+v", invoices)
+	}
+}
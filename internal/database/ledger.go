@@ -1,12 +1,47 @@
 package database
 
 import (
+	"database/sql"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"git.sr.ht/~jakintosh/coffer/internal/service"
+	"git.sr.ht/~jakintosh/coffer/pkg/wire"
 )
 
+// txRow mirrors a single row of the tx table
+type txRow struct {
+	id       string
+	ledger   string
+	amount   int
+	date     int64
+	label    string
+	transfer sql.NullString
+	reverses sql.NullString
+	payment  sql.NullString
+}
+
+func (r txRow) toTransaction() service.Transaction {
+	return service.Transaction{
+		ID:         r.id,
+		Ledger:     r.ledger,
+		Amount:     r.amount,
+		Date:       time.Unix(r.date, 0),
+		Label:      r.label,
+		TransferID: r.transfer.String,
+		Reverses:   r.reverses.String,
+	}
+}
+
+// queryExecer is satisfied by both *sql.DB and *sql.Tx
+type queryExecer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
 func (db *DB) InsertTransaction(
 	id string,
 	ledger string,
@@ -14,22 +49,464 @@ func (db *DB) InsertTransaction(
 	date int64,
 	label string,
 ) error {
-	_, err := db.Conn.Exec(`
-		INSERT INTO tx (id, created, date, amount, ledger, label)
-		VALUES(?1, unixepoch(), ?2, ?3, ?4, ?5)
-		ON CONFLICT(id) DO UPDATE
-			SET updated=unixepoch(),
-			amount=excluded.amount,
-			date=excluded.date,
-			ledger=excluded.ledger,
-			label=excluded.label;`,
+	tx, err := db.Conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	row := txRow{
+		id:     id,
+		ledger: ledger,
+		amount: amount,
+		date:   date,
+		label:  label,
+	}
+	if err := insertTransaction(tx, row); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (db *DB) InsertTransactions(
+	txs []service.Transaction,
+) error {
+	tx, err := db.Conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, t := range txs {
+		row := txRow{
+			id:     t.ID,
+			ledger: t.Ledger,
+			amount: t.Amount,
+			date:   t.Date.Unix(),
+			label:  t.Label,
+		}
+		if err := insertTransaction(tx, row); err != nil {
+			return fmt.Errorf("failed to insert transaction %s: %w", t.ID, err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (db *DB) InsertTransfer(
+	id string,
+	from string,
+	to string,
+	amount int,
+	date int64,
+	label string,
+) error {
+	tx, err := db.Conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	transfer := sql.NullString{String: id, Valid: true}
+
+	// debit leg
+	debit := txRow{
+		id:       transferLegID(id, from),
+		ledger:   from,
+		amount:   -amount,
+		date:     date,
+		label:    label,
+		transfer: transfer,
+	}
+	if err := insertTransaction(tx, debit); err != nil {
+		return fmt.Errorf("failed to insert debit leg: %w", err)
+	}
+
+	// credit leg
+	credit := txRow{
+		id:       transferLegID(id, to),
+		ledger:   to,
+		amount:   amount,
+		date:     date,
+		label:    label,
+		transfer: transfer,
+	}
+	if err := insertTransaction(tx, credit); err != nil {
+		return fmt.Errorf("failed to insert credit leg: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+// transferLegID is the ID of the leg of a transfer on a ledger, kept apart
+// from the "<payment id>:<ledger>" shares of payments
+func transferLegID(
+	transfer string,
+	ledger string,
+) string {
+	return fmt.Sprintf("transfer:%s:%s", transfer, ledger)
+}
+
+// GetTransfer rebuilds a transfer from its two legs
+func (db *DB) GetTransfer(
+	id string,
+) (
+	*service.Transfer,
+	error,
+) {
+	rows, err := db.Conn.Query(`
+		SELECT id, ledger, amount, date, label, transfer, reverses
+		FROM tx
+		WHERE transfer=?1;`,
+		id,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	transfer := &service.Transfer{ID: id}
+	legs := 0
+	for rows.Next() {
+		var r txRow
+		if err := rows.Scan(
+			&r.id,
+			&r.ledger,
+			&r.amount,
+			&r.date,
+			&r.label,
+			&r.transfer,
+			&r.reverses,
+		); err != nil {
+			return nil, err
+		}
+		if r.amount < 0 {
+			transfer.From = r.ledger
+		} else {
+			transfer.To = r.ledger
+			transfer.Amount = r.amount
+		}
+		transfer.Date = time.Unix(r.date, 0)
+		transfer.Label = r.label
+		legs++
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if legs == 0 {
+		return nil, service.ErrTxNotFound
+	}
+
+	return transfer, nil
+}
+
+// InsertTransferReversal appends a compensating entry for both legs of a
+// transfer in a single transaction. Each reversal id is the leg id with a
+// ":reversal" suffix.
+func (db *DB) InsertTransferReversal(
+	id string,
+	date int64,
+	label string,
+) error {
+	tx, err := db.Conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`
+		SELECT id, ledger, amount
+		FROM tx
+		WHERE transfer=?1
+		ORDER BY rowid;`,
+		id,
+	)
+	if err != nil {
+		return err
+	}
+	var legs []txRow
+	for rows.Next() {
+		var r txRow
+		if err := rows.Scan(&r.id, &r.ledger, &r.amount); err != nil {
+			rows.Close()
+			return err
+		}
+		legs = append(legs, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(legs) == 0 {
+		return service.ErrTxNotFound
+	}
+
+	for _, leg := range legs {
+		row := txRow{
+			id:       leg.id + ":reversal",
+			ledger:   leg.ledger,
+			amount:   -leg.amount,
+			date:     date,
+			label:    label,
+			reverses: sql.NullString{String: leg.id, Valid: true},
+		}
+		if err := insertTransaction(tx, row); err != nil {
+			return fmt.Errorf("failed to reverse leg %s: %w", leg.id, err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (db *DB) InsertReversal(
+	id string,
+	original string,
+	date int64,
+	label string,
+) error {
+	tx, err := db.Conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	orig, err := getTransactionRow(tx, original)
+	if err != nil {
+		return err
+	}
+
+	// compensating entry on the same ledger
+	row := txRow{
+		id:       id,
+		ledger:   orig.ledger,
+		amount:   -orig.amount,
+		date:     date,
+		label:    label,
+		reverses: sql.NullString{String: original, Valid: true},
+		payment:  orig.payment,
+	}
+	if err := insertTransaction(tx, row); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (db *DB) GetTransaction(
+	id string,
+) (
+	*service.Transaction,
+	error,
+) {
+	row, err := getTransactionRow(db.Conn, id)
+	if err != nil {
+		return nil, err
+	}
+	tx := row.toTransaction()
+	return &tx, nil
+}
+
+// insertTransaction appends a row to the ledger. Existing rows are never
+// modified; re-inserting an id succeeds only if the row is identical.
+func insertTransaction(
+	conn queryExecer,
+	row txRow,
+) error {
+	result, err := conn.Exec(`
+		INSERT INTO tx (id, created, date, amount, ledger, label, transfer, reverses, payment)
+		VALUES(?1, unixepoch(), ?2, ?3, ?4, ?5, ?6, ?7, ?8)
+		ON CONFLICT(id) DO NOTHING;`,
+		row.id,
+		row.date,
+		row.amount,
+		row.ledger,
+		row.label,
+		row.transfer,
+		row.reverses,
+		row.payment,
+	)
+	if err != nil {
+		return err
+	}
+
+	inserted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if inserted > 0 {
+		return nil
+	}
+
+	// id already exists, only an exact retry is accepted
+	existing, err := getTransactionRow(conn, row.id)
+	if err != nil {
+		return err
+	}
+	if *existing != row {
+		return service.ErrTxConflict
+	}
+	return nil
+}
+
+func getTransactionRow(
+	conn queryExecer,
+	id string,
+) (
+	*txRow,
+	error,
+) {
+	row := conn.QueryRow(`
+		SELECT id, ledger, amount, date, label, transfer, reverses, payment
+		FROM tx
+		WHERE id=?1;`,
 		id,
-		date,
-		amount,
+	)
+
+	var r txRow
+	if err := row.Scan(
+		&r.id,
+		&r.ledger,
+		&r.amount,
+		&r.date,
+		&r.label,
+		&r.transfer,
+		&r.reverses,
+		&r.payment,
+	); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, service.ErrTxNotFound
+		}
+		return nil, err
+	}
+	return &r, nil
+}
+
+func (db *DB) GetLedgers() (
+	[]service.LedgerSummary,
+	error,
+) {
+	rows, err := db.Conn.Query(`
+		SELECT l.name,
+			l.display_name,
+			l.description,
+			l.archived,
+			COALESCE(SUM(t.amount),0),
+			COUNT(t.id),
+			MIN(t.date),
+			MAX(t.date)
+		FROM ledger l
+		LEFT JOIN tx t ON t.ledger=l.name
+		WHERE l.public=1
+		GROUP BY l.name
+		ORDER BY l.name;
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query ledgers: %w", err)
+	}
+	defer rows.Close()
+
+	ledgers := []service.LedgerSummary{}
+	for rows.Next() {
+		var (
+			l     service.LedgerSummary
+			first sql.NullInt64
+			last  sql.NullInt64
+		)
+		if err := rows.Scan(
+			&l.Name,
+			&l.DisplayName,
+			&l.Description,
+			&l.Archived,
+			&l.Balance,
+			&l.TransactionCount,
+			&first,
+			&last,
+		); err != nil {
+			return nil, err
+		}
+		if first.Valid {
+			t := time.Unix(first.Int64, 0)
+			l.FirstActivity = &t
+		}
+		if last.Valid {
+			t := time.Unix(last.Int64, 0)
+			l.LastActivity = &t
+		}
+		ledgers = append(ledgers, l)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return ledgers, nil
+}
+
+// historyBuckets maps history intervals to SQLite expressions for the UTC
+// start date of the bucket containing a transaction. Weeks start on Monday.
+var historyBuckets = map[string]string{
+	service.IntervalDay:   `date(date, 'unixepoch')`,
+	service.IntervalWeek:  `date(date, 'unixepoch', '-6 days', 'weekday 1')`,
+	service.IntervalMonth: `date(date, 'unixepoch', 'start of month')`,
+}
+
+func (db *DB) GetLedgerFlows(
+	ledger string,
+	interval string,
+	since int64,
+	until int64,
+) (
+	[]service.HistoryBucket,
+	error,
+) {
+	bucket, ok := historyBuckets[interval]
+	if !ok {
+		return nil, service.ErrInvalidInterval
+	}
+
+	rows, err := db.Conn.Query(`
+		SELECT `+bucket+` AS bucket,
+			COALESCE(SUM(CASE WHEN amount>0 THEN amount END),0),
+			COALESCE(SUM(CASE WHEN amount<0 THEN amount END),0)
+		FROM tx
+		WHERE ledger=?1
+			AND date>=?2
+			AND date<=?3
+		GROUP BY bucket
+		ORDER BY bucket;
+		`,
 		ledger,
-		label,
+		since,
+		until,
 	)
-	return err
+	if err != nil {
+		return nil, fmt.Errorf("failed to query ledger flows: %w", err)
+	}
+	defer rows.Close()
+
+	var buckets []service.HistoryBucket
+	for rows.Next() {
+		var (
+			b     service.HistoryBucket
+			start string
+		)
+		if err := rows.Scan(
+			&start,
+			&b.IncomingFunds,
+			&b.OutgoingFunds,
+		); err != nil {
+			return nil, err
+		}
+		if b.Start, err = time.Parse("2006-01-02", start); err != nil {
+			return nil, fmt.Errorf("failed to parse bucket %q: %w", start, err)
+		}
+		buckets = append(buckets, b)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return buckets, nil
 }
 
 func (db *DB) GetLedgerSnapshot(
@@ -102,23 +579,45 @@ func (db *DB) GetLedgerSnapshot(
 
 func (db *DB) GetTransactions(
 	ledger string,
+	filter service.TransactionFilter,
+	after *wire.Cursor,
 	limit int,
 	offset int,
 ) (
 	[]service.Transaction,
 	error,
 ) {
-	rows, err := db.Conn.Query(`
-		SELECT id, amount, date, label
+	where, args := transactionFilterClause(ledger, filter)
+	order, cmp := "DESC", "<"
+	if filter.Ascending {
+		order, cmp = "ASC", ">"
+	}
+
+	// keyset pagination on (date, id)
+	if after != nil {
+		args = append(args, after.Key, after.ID)
+		where += fmt.Sprintf(
+			" AND (date%s?%d OR (date=?%d AND id%s?%d))",
+			cmp, len(args)-1, len(args)-1, cmp, len(args),
+		)
+	}
+
+	query := fmt.Sprintf(`
+		SELECT id, ledger, amount, date, label, transfer, reverses
 		FROM tx
-		WHERE ledger=?1
-		ORDER BY date DESC
-		LIMIT ?2 OFFSET ?3;
+		WHERE %s
+		ORDER BY date %s, id %s
+		LIMIT ?%d OFFSET ?%d;
 		`,
-		ledger,
-		limit,
-		offset,
+		where,
+		order,
+		order,
+		len(args)+1,
+		len(args)+2,
 	)
+	args = append(args, limit, offset)
+
+	rows, err := db.Conn.Query(query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query transactions: %w", err)
 	}
@@ -126,19 +625,153 @@ func (db *DB) GetTransactions(
 
 	var txs []service.Transaction
 	for rows.Next() {
-		var id, label string
-		var amount int
-		var date int64
-		if err := rows.Scan(&id, &amount, &date, &label); err != nil {
+		var r txRow
+		if err := rows.Scan(
+			&r.id,
+			&r.ledger,
+			&r.amount,
+			&r.date,
+			&r.label,
+			&r.transfer,
+			&r.reverses,
+		); err != nil {
 			return nil, err
 		}
-		txs = append(txs, service.Transaction{
-			ID:     id,
-			Ledger: ledger,
-			Amount: amount,
-			Date:   time.Unix(date, 0),
-			Label:  label,
-		})
+		txs = append(txs, r.toTransaction())
 	}
 	return txs, nil
 }
+
+// ExportTransactions returns the transactions of a ledger dated from since
+// to until, oldest first, with the ledger's balances over them. Every row
+// is read before returning so the connection isn't held while an export is
+// written out.
+func (db *DB) ExportTransactions(
+	ledger string,
+	since int64,
+	until int64,
+) (
+	[]service.Transaction,
+	*service.LedgerSnapshot,
+	error,
+) {
+	// one read transaction keeps the balances consistent with the rows
+	tx, err := db.Conn.Begin()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	var opening int
+	row := tx.QueryRow(`
+		SELECT COALESCE(SUM(amount),0)
+		FROM tx
+		WHERE ledger=?1
+			AND date<?2;
+		`,
+		ledger,
+		since,
+	)
+	if err := row.Scan(&opening); err != nil {
+		return nil, nil, fmt.Errorf("failed to query opening balance: %w", err)
+	}
+
+	rows, err := tx.Query(`
+		SELECT id, ledger, amount, date, label, transfer, reverses
+		FROM tx
+		WHERE ledger=?1
+			AND date>=?2
+			AND date<=?3
+		ORDER BY date ASC, id ASC;
+		`,
+		ledger,
+		since,
+		until,
+	)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to query transactions: %w", err)
+	}
+	defer rows.Close()
+
+	txs := []service.Transaction{}
+	snapshot := &service.LedgerSnapshot{OpeningBalance: opening}
+	for rows.Next() {
+		var r txRow
+		if err := rows.Scan(
+			&r.id,
+			&r.ledger,
+			&r.amount,
+			&r.date,
+			&r.label,
+			&r.transfer,
+			&r.reverses,
+		); err != nil {
+			return nil, nil, err
+		}
+		if r.amount > 0 {
+			snapshot.IncomingFunds += r.amount
+		} else {
+			snapshot.OutgoingFunds += r.amount
+		}
+		txs = append(txs, r.toTransaction())
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+	snapshot.ClosingBalance = opening + snapshot.IncomingFunds + snapshot.OutgoingFunds
+
+	if err := tx.Commit(); err != nil {
+		return nil, nil, err
+	}
+	return txs, snapshot, nil
+}
+
+// transactionFilterClause builds a WHERE clause with numbered parameters
+// for the given ledger and filter.
+func transactionFilterClause(
+	ledger string,
+	filter service.TransactionFilter,
+) (
+	string,
+	[]any,
+) {
+	conditions := []string{"ledger=?1"}
+	args := []any{ledger}
+	add := func(condition string, arg any) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if !filter.Since.IsZero() {
+		add("date>=?%d", filter.Since.Unix())
+	}
+	if !filter.Until.IsZero() {
+		add("date<=?%d", filter.Until.Unix())
+	}
+	if filter.Label != "" {
+		add("label=?%d", filter.Label)
+	}
+	if filter.Search != "" {
+		add(`label LIKE ?%d ESCAPE '\'`, "%"+escapeLike(filter.Search)+"%")
+	}
+	switch filter.Sign {
+	case service.SignIncome:
+		conditions = append(conditions, "amount>0")
+	case service.SignSpending:
+		conditions = append(conditions, "amount<0")
+	}
+	if filter.MinAmount != nil {
+		add("amount>=?%d", *filter.MinAmount)
+	}
+	if filter.MaxAmount != nil {
+		add("amount<=?%d", *filter.MaxAmount)
+	}
+
+	return strings.Join(conditions, " AND "), args
+}
+
+// escapeLike escapes LIKE wildcards so search terms match literally
+func escapeLike(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+	return r.Replace(s)
+}
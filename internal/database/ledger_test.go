@@ -1,10 +1,14 @@
 package database_test
 
 import (
+	"errors"
+	"strings"
 	"testing"
 	"time"
 
+	"git.sr.ht/~jakintosh/coffer/internal/service"
 	"git.sr.ht/~jakintosh/coffer/internal/testutil"
+	"git.sr.ht/~jakintosh/coffer/pkg/wire"
 )
 
 func TestLedgerSnapshotAndTransactions(t *testing.T) {
@@ -29,7 +33,7 @@ func TestLedgerSnapshotAndTransactions(t *testing.T) {
 	}
 
 	// list transactions
-	rows, err := env.DB.GetTransactions("general", 10, 0)
+	rows, err := env.DB.GetTransactions("general", service.TransactionFilter{}, nil, 10, 0)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -42,7 +46,7 @@ func TestLedgerTransactionsLimitOffset(t *testing.T) {
 	env := testutil.SetupTestEnv(t)
 	testutil.SeedTransactionData(t, env.Service)
 
-	txs, err := env.DB.GetTransactions("general", 1, 1)
+	txs, err := env.DB.GetTransactions("general", service.TransactionFilter{}, nil, 1, 1)
 	if err != nil {
 		t.Fatalf("GetTransactions: %v", err)
 	}
@@ -52,7 +56,7 @@ func TestLedgerTransactionsLimitOffset(t *testing.T) {
 		t.Fatalf("unexpected txs %+v", txs)
 	}
 
-	empty, err := env.DB.GetTransactions("general", 10, 5)
+	empty, err := env.DB.GetTransactions("general", service.TransactionFilter{}, nil, 10, 5)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -60,3 +64,285 @@ func TestLedgerTransactionsLimitOffset(t *testing.T) {
 		t.Fatalf("expected empty slice")
 	}
 }
+
+func TestInsertTransfer(t *testing.T) {
+	env := testutil.SetupTestEnv(t)
+
+	date := testutil.MakeDateUnix(2025, 1, 1)
+	if err := env.DB.InsertTransfer("tr1", "general", "community", 75, date, "move"); err != nil {
+		t.Fatalf("InsertTransfer: %v", err)
+	}
+
+	general, err := env.DB.GetTransactions("general", service.TransactionFilter{}, nil, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	community, err := env.DB.GetTransactions("community", service.TransactionFilter{}, nil, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(general) != 1 || len(community) != 1 {
+		t.Fatalf("expected one leg per ledger, got %d and %d", len(general), len(community))
+	}
+	if general[0].Amount != -75 || community[0].Amount != 75 {
+		t.Errorf("unexpected leg amounts %d and %d", general[0].Amount, community[0].Amount)
+	}
+	if general[0].TransferID != "tr1" || community[0].TransferID != "tr1" {
+		t.Errorf("legs not linked to transfer: %+v %+v", general[0], community[0])
+	}
+}
+
+func TestInsertTransactionAppendOnly(t *testing.T) {
+	env := testutil.SetupTestEnv(t)
+
+	date := testutil.MakeDateUnix(2025, 1, 1)
+	if err := env.DB.InsertTransaction("t1", "general", 100, date, "in"); err != nil {
+		t.Fatalf("InsertTransaction: %v", err)
+	}
+
+	// identical retry is accepted
+	if err := env.DB.InsertTransaction("t1", "general", 100, date, "in"); err != nil {
+		t.Fatalf("identical retry: %v", err)
+	}
+
+	// differing overwrite is refused
+	err := env.DB.InsertTransaction("t1", "general", 500, date, "in")
+	if !errors.Is(err, service.ErrTxConflict) {
+		t.Fatalf("expected ErrTxConflict, got %v", err)
+	}
+
+	tx, err := env.DB.GetTransaction("t1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tx.Amount != 100 {
+		t.Errorf("transaction was overwritten: %+v", tx)
+	}
+}
+
+func TestInsertReversal(t *testing.T) {
+	env := testutil.SetupTestEnv(t)
+	testutil.SeedTransactionData(t, env.Service)
+
+	date := testutil.MakeDateUnix(2025, 4, 1)
+	if err := env.DB.InsertReversal("t2:reversal", "t2", date, "reversal"); err != nil {
+		t.Fatalf("InsertReversal: %v", err)
+	}
+
+	reversal, err := env.DB.GetTransaction("t2:reversal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reversal.Amount != -200 || reversal.Ledger != "general" || reversal.Reverses != "t2" {
+		t.Errorf("unexpected reversal %+v", reversal)
+	}
+
+	// missing original
+	err = env.DB.InsertReversal("nope:reversal", "nope", date, "reversal")
+	if !errors.Is(err, service.ErrTxNotFound) {
+		t.Fatalf("expected ErrTxNotFound, got %v", err)
+	}
+}
+
+func TestGetTransactionsFiltered(t *testing.T) {
+	env := testutil.SetupTestEnv(t)
+	testutil.SeedTransactionData(t, env.Service)
+	if err := env.DB.InsertTransaction("t4", "general", 30, testutil.MakeDateUnix(2025, 4, 1), "coffee_beans"); err != nil {
+		t.Fatal(err)
+	}
+
+	minAmount := 50
+	cases := []struct {
+		name   string
+		filter service.TransactionFilter
+		want   []string
+	}{
+		{"none", service.TransactionFilter{}, []string{"t4", "t3", "t2", "t1"}},
+		{"ascending", service.TransactionFilter{Ascending: true}, []string{"t1", "t2", "t3", "t4"}},
+		{"since", service.TransactionFilter{Since: testutil.MakeDate(2025, 2, 1)}, []string{"t4", "t3", "t2"}},
+		{"until", service.TransactionFilter{Until: testutil.MakeDate(2025, 2, 1)}, []string{"t2", "t1"}},
+		{"label", service.TransactionFilter{Label: "in"}, []string{"t2", "t1"}},
+		{"search", service.TransactionFilter{Search: "BEANS"}, []string{"t4"}},
+		{"search wildcard", service.TransactionFilter{Search: "_"}, []string{"t4"}},
+		{"income", service.TransactionFilter{Sign: service.SignIncome}, []string{"t4", "t2", "t1"}},
+		{"spending", service.TransactionFilter{Sign: service.SignSpending}, []string{"t3"}},
+		{"min", service.TransactionFilter{MinAmount: &minAmount}, []string{"t2", "t1"}},
+		{"max", service.TransactionFilter{MaxAmount: &minAmount}, []string{"t4", "t3"}},
+	}
+	for _, c := range cases {
+		txs, err := env.DB.GetTransactions("general", c.filter, nil, 10, 0)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		var got []string
+		for _, tx := range txs {
+			got = append(got, tx.ID)
+		}
+		if strings.Join(got, ",") != strings.Join(c.want, ",") {
+			t.Errorf("%s: want %v, got %v", c.name, c.want, got)
+		}
+	}
+}
+
+func TestGetTransactionsAfterCursor(t *testing.T) {
+	env := testutil.SetupTestEnv(t)
+	testutil.SeedTransactionData(t, env.Service)
+
+	// two transactions sharing a date are ordered by id
+	date := testutil.MakeDateUnix(2025, 2, 1)
+	if err := env.DB.InsertTransaction("t2b", "general", 20, date, "in"); err != nil {
+		t.Fatal(err)
+	}
+
+	filter := service.TransactionFilter{}
+	after := &wire.Cursor{Key: date, ID: "t2b"}
+	txs, err := env.DB.GetTransactions("general", filter, after, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(txs) != 2 || txs[0].ID != "t2" || txs[1].ID != "t1" {
+		t.Fatalf("unexpected descending page %+v", txs)
+	}
+
+	filter.Ascending = true
+	after = &wire.Cursor{Key: date, ID: "t2"}
+	txs, err = env.DB.GetTransactions("general", filter, after, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(txs) != 2 || txs[0].ID != "t2b" || txs[1].ID != "t3" {
+		t.Fatalf("unexpected ascending page %+v", txs)
+	}
+}
+
+func TestExportTransactions(t *testing.T) {
+	env := testutil.SetupTestEnv(t)
+	testutil.SeedTransactionData(t, env.Service)
+
+	since := testutil.MakeDateUnix(2025, 2, 1)
+	until := testutil.MakeDateUnix(2025, 12, 31)
+	txs, snapshot, err := env.DB.ExportTransactions("general", since, until)
+	if err != nil {
+		t.Fatalf("ExportTransactions: %v", err)
+	}
+
+	var ids []string
+	for _, tx := range txs {
+		ids = append(ids, tx.ID)
+	}
+
+	if strings.Join(ids, ",") != "t2,t3" {
+		t.Errorf("unexpected export order %v", ids)
+	}
+	if snapshot.OpeningBalance != 100 || snapshot.ClosingBalance != 250 {
+		t.Errorf("unexpected snapshot %+v", snapshot)
+	}
+}
+
+func TestInsertTransactionsAtomic(t *testing.T) {
+	env := testutil.SetupTestEnv(t)
+
+	date := time.Unix(testutil.MakeDateUnix(2025, 1, 1), 0)
+	if err := env.DB.InsertTransaction("t2", "general", 100, date.Unix(), "existing"); err != nil {
+		t.Fatalf("InsertTransaction: %v", err)
+	}
+
+	// a conflicting row rolls back the whole batch
+	txs := []service.Transaction{
+		{ID: "t1", Ledger: "general", Amount: 50, Date: date, Label: "new"},
+		{ID: "t2", Ledger: "general", Amount: 999, Date: date, Label: "existing"},
+	}
+	err := env.DB.InsertTransactions(txs)
+	if !errors.Is(err, service.ErrTxConflict) {
+		t.Fatalf("expected ErrTxConflict, got %v", err)
+	}
+	if _, err := env.DB.GetTransaction("t1"); !errors.Is(err, service.ErrTxNotFound) {
+		t.Fatalf("expected t1 rolled back, got %v", err)
+	}
+
+	txs[1].Amount = 100
+	if err := env.DB.InsertTransactions(txs); err != nil {
+		t.Fatalf("InsertTransactions: %v", err)
+	}
+	if _, err := env.DB.GetTransaction("t1"); err != nil {
+		t.Fatalf("expected t1 inserted: %v", err)
+	}
+}
+
+func TestGetLedgers(t *testing.T) {
+	env := testutil.SetupTestEnv(t)
+
+	// the default ledger has no transactions
+	ledgers, err := env.DB.GetLedgers()
+	if err != nil {
+		t.Fatalf("GetLedgers: %v", err)
+	}
+	if len(ledgers) != 1 || ledgers[0].Name != "general" || ledgers[0].TransactionCount != 0 {
+		t.Fatalf("unexpected ledgers %+v", ledgers)
+	}
+	if ledgers[0].FirstActivity != nil || ledgers[0].LastActivity != nil {
+		t.Errorf("expected no activity dates")
+	}
+
+	if err := env.DB.InsertLedger(service.Ledger{Name: "savings", Public: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := env.DB.InsertLedger(service.Ledger{Name: "private"}); err != nil {
+		t.Fatal(err)
+	}
+	date := testutil.MakeDateUnix(2025, 1, 1)
+	if err := env.DB.InsertTransaction("t1", "savings", 100, date, "in"); err != nil {
+		t.Fatal(err)
+	}
+
+	// private ledgers are not listed
+	ledgers, err = env.DB.GetLedgers()
+	if err != nil {
+		t.Fatalf("GetLedgers: %v", err)
+	}
+	if len(ledgers) != 2 || ledgers[1].Name != "savings" || ledgers[1].Balance != 100 {
+		t.Fatalf("unexpected ledgers %+v", ledgers)
+	}
+	if ledgers[1].LastActivity == nil || ledgers[1].LastActivity.Unix() != date {
+		t.Errorf("unexpected last activity %v", ledgers[1].LastActivity)
+	}
+}
+
+func TestGetLedgerFlows(t *testing.T) {
+	env := testutil.SetupTestEnv(t)
+
+	for _, tx := range []struct {
+		id     string
+		amount int
+		date   int64
+	}{
+		{"t1", 100, testutil.MakeDateUnix(2025, 1, 6)},
+		{"t2", -30, testutil.MakeDateUnix(2025, 1, 12)},
+		{"t3", 50, testutil.MakeDateUnix(2025, 1, 13)},
+	} {
+		if err := env.DB.InsertTransaction(tx.id, "general", tx.amount, tx.date, "x"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 2025-01-06 and 2025-01-13 are Mondays
+	since := testutil.MakeDateUnix(2025, 1, 1)
+	until := testutil.MakeDateUnix(2025, 2, 1)
+	flows, err := env.DB.GetLedgerFlows("general", service.IntervalWeek, since, until)
+	if err != nil {
+		t.Fatalf("GetLedgerFlows: %v", err)
+	}
+	if len(flows) != 2 {
+		t.Fatalf("expected 2 weeks, got %+v", flows)
+	}
+	if !flows[0].Start.Equal(testutil.MakeDate(2025, 1, 6)) || flows[0].IncomingFunds != 100 || flows[0].OutgoingFunds != -30 {
+		t.Errorf("unexpected first week %+v", flows[0])
+	}
+	if !flows[1].Start.Equal(testutil.MakeDate(2025, 1, 13)) || flows[1].IncomingFunds != 50 {
+		t.Errorf("unexpected second week %+v", flows[1])
+	}
+
+	if _, err := env.DB.GetLedgerFlows("general", "year", since, until); !errors.Is(err, service.ErrInvalidInterval) {
+		t.Errorf("expected ErrInvalidInterval, got %v", err)
+	}
+}
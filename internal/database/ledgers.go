@@ -0,0 +1,237 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"git.sr.ht/~jakintosh/coffer/internal/service"
+)
+
+func (db *DB) GetLedgerInfo(
+	name string,
+) (
+	*service.Ledger,
+	error,
+) {
+	row := db.Conn.QueryRow(`
+		SELECT name, display_name, description, public, archived, created, updated
+		FROM ledger
+		WHERE name=?1;
+		`,
+		name,
+	)
+	l, err := scanLedger(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, service.ErrLedgerNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to query ledger: %w", err)
+	}
+
+	return l, nil
+}
+
+func (db *DB) GetLedgerInfos() (
+	[]service.Ledger,
+	error,
+) {
+	rows, err := db.Conn.Query(`
+		SELECT name, display_name, description, public, archived, created, updated
+		FROM ledger
+		ORDER BY name;
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query ledgers: %w", err)
+	}
+	defer rows.Close()
+
+	ledgers := []service.Ledger{}
+	for rows.Next() {
+		l, err := scanLedger(rows)
+		if err != nil {
+			return nil, err
+		}
+		ledgers = append(ledgers, *l)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return ledgers, nil
+}
+
+func (db *DB) InsertLedger(
+	l service.Ledger,
+) error {
+	res, err := db.Conn.Exec(`
+		INSERT INTO ledger (name, created, display_name, description, public, archived)
+		VALUES (?1, ?2, ?3, ?4, ?5, ?6)
+		ON CONFLICT(name) DO NOTHING;
+		`,
+		l.Name,
+		time.Now().Unix(),
+		l.DisplayName,
+		l.Description,
+		l.Public,
+		l.Archived,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to insert ledger: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return service.ErrLedgerExists
+	}
+
+	return nil
+}
+
+func (db *DB) UpdateLedger(
+	l service.Ledger,
+) error {
+	tx, err := db.Conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// ledgers in a current or scheduled allocation set cannot be archived
+	if l.Archived {
+		var allocated int
+		row := tx.QueryRow(`
+			SELECT COUNT(*)
+			FROM allocation_rule
+			WHERE ledger=?1
+				AND set_id IN (
+					SELECT id FROM allocation_set WHERE effective_from>?2
+					UNION
+					SELECT id FROM (
+						SELECT id, ROW_NUMBER() OVER (
+							PARTITION BY selector
+							ORDER BY effective_from DESC, id DESC
+						) AS n
+						FROM allocation_set
+						WHERE effective_from<=?2
+					)
+					WHERE n=1
+				);
+			`,
+			l.Name,
+			time.Now().Unix(),
+		)
+		if err := row.Scan(&allocated); err != nil {
+			return fmt.Errorf("failed to query allocations: %w", err)
+		}
+		if allocated > 0 {
+			return service.ErrLedgerInUse
+		}
+	}
+
+	res, err := tx.Exec(`
+		UPDATE ledger
+		SET display_name=?2,
+			description=?3,
+			public=?4,
+			archived=?5,
+			updated=?6
+		WHERE name=?1;
+		`,
+		l.Name,
+		l.DisplayName,
+		l.Description,
+		l.Public,
+		l.Archived,
+		time.Now().Unix(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update ledger: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return service.ErrLedgerNotFound
+	}
+
+	return tx.Commit()
+}
+
+func (db *DB) DeleteLedger(
+	name string,
+) error {
+	tx, err := db.Conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// only ledgers without transactions, allocations or goals can be deleted
+	var used int
+	row := tx.QueryRow(`
+		SELECT (SELECT COUNT(*) FROM tx WHERE ledger=?1)
+			+ (SELECT COUNT(*) FROM allocation_rule WHERE ledger=?1)
+			+ (SELECT COUNT(*) FROM goal WHERE ledger=?1);
+		`,
+		name,
+	)
+	if err := row.Scan(&used); err != nil {
+		return fmt.Errorf("failed to query ledger usage: %w", err)
+	}
+	if used > 0 {
+		return service.ErrLedgerInUse
+	}
+
+	res, err := tx.Exec(`
+		DELETE FROM ledger
+		WHERE name=?1;
+		`,
+		name,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to delete ledger: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return service.ErrLedgerNotFound
+	}
+
+	return tx.Commit()
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanLedger(
+	row rowScanner,
+) (
+	*service.Ledger,
+	error,
+) {
+	var (
+		l       service.Ledger
+		created int64
+		updated sql.NullInt64
+	)
+	if err := row.Scan(
+		&l.Name,
+		&l.DisplayName,
+		&l.Description,
+		&l.Public,
+		&l.Archived,
+		&created,
+		&updated,
+	); err != nil {
+		return nil, err
+	}
+	l.CreatedAt = time.Unix(created, 0)
+	if updated.Valid {
+		l.UpdatedAt = time.Unix(updated.Int64, 0)
+	} else {
+		l.UpdatedAt = l.CreatedAt
+	}
+
+	return &l, nil
+}
@@ -0,0 +1,74 @@
+package database_test
+
+import (
+	"errors"
+	"testing"
+
+	"git.sr.ht/~jakintosh/coffer/internal/service"
+	"git.sr.ht/~jakintosh/coffer/internal/testutil"
+)
+
+func TestLedgersStore(t *testing.T) {
+	env := testutil.SetupTestEnv(t)
+
+	l := service.Ledger{
+		Name:        "community",
+		DisplayName: "Community Fund",
+		Description: "grants for community projects",
+		Public:      true,
+	}
+	if err := env.DB.InsertLedger(l); err != nil {
+		t.Fatalf("InsertLedger: %v", err)
+	}
+	if err := env.DB.InsertLedger(l); !errors.Is(err, service.ErrLedgerExists) {
+		t.Fatalf("expected ErrLedgerExists, got %v", err)
+	}
+
+	got, err := env.DB.GetLedgerInfo("community")
+	if err != nil {
+		t.Fatalf("GetLedgerInfo: %v", err)
+	}
+	if got.DisplayName != l.DisplayName || got.Description != l.Description || !got.Public {
+		t.Errorf("unexpected ledger %+v", got)
+	}
+
+	l.Archived = true
+	if err := env.DB.UpdateLedger(l); err != nil {
+		t.Fatalf("UpdateLedger: %v", err)
+	}
+	if got, _ := env.DB.GetLedgerInfo("community"); !got.Archived {
+		t.Errorf("expected archived ledger")
+	}
+
+	ledgers, err := env.DB.GetLedgerInfos()
+	if err != nil {
+		t.Fatalf("GetLedgerInfos: %v", err)
+	}
+	if len(ledgers) != 2 || ledgers[0].Name != "community" || ledgers[1].Name != "general" {
+		t.Fatalf("unexpected ledgers %+v", ledgers)
+	}
+
+	if err := env.DB.DeleteLedger("community"); err != nil {
+		t.Fatalf("DeleteLedger: %v", err)
+	}
+	if _, err := env.DB.GetLedgerInfo("community"); !errors.Is(err, service.ErrLedgerNotFound) {
+		t.Fatalf("expected ErrLedgerNotFound, got %v", err)
+	}
+}
+
+func TestLedgersStoreInUse(t *testing.T) {
+	env := testutil.SetupTestEnv(t)
+
+	// the default allocation targets general
+	err := env.DB.UpdateLedger(service.Ledger{Name: "general", Archived: true})
+	if !errors.Is(err, service.ErrLedgerInUse) {
+		t.Fatalf("expected ErrLedgerInUse archiving, got %v", err)
+	}
+	if err := env.DB.DeleteLedger("general"); !errors.Is(err, service.ErrLedgerInUse) {
+		t.Fatalf("expected ErrLedgerInUse deleting, got %v", err)
+	}
+
+	if err := env.DB.UpdateLedger(service.Ledger{Name: "missing"}); !errors.Is(err, service.ErrLedgerNotFound) {
+		t.Fatalf("expected ErrLedgerNotFound, got %v", err)
+	}
+}
@@ -25,7 +25,7 @@ func TestSimpleSubscriptionSummary(t *testing.T) {
 	env := testutil.SetupTestEnv(t)
 
 	// insert one active USD subscription @ $5.00
-	if err := env.DB.InsertSubscription("s1", time.Now().Unix(), "c1", "active", 500, "usd"); err != nil {
+	if err := env.DB.InsertSubscription(makeSubscription("s1", time.Now().Unix(), "c1", "active", 500, "usd"), 0, nil); err != nil {
 		t.Fatal(err)
 	}
 
@@ -45,15 +45,15 @@ func TestSubscriptionSummaryFilters(t *testing.T) {
 	now := time.Now().Unix()
 
 	// active USD subscription
-	if err := env.DB.InsertSubscription("s1", now, "c1", "active", 500, "usd"); err != nil {
+	if err := env.DB.InsertSubscription(makeSubscription("s1", now, "c1", "active", 500, "usd"), 0, nil); err != nil {
 		t.Fatal(err)
 	}
 	// cancelled subscription should be ignored
-	if err := env.DB.InsertSubscription("s2", now, "c2", "canceled", 800, "usd"); err != nil {
+	if err := env.DB.InsertSubscription(makeSubscription("s2", now, "c2", "canceled", 800, "usd"), 0, nil); err != nil {
 		t.Fatal(err)
 	}
 	// non-USD currency should be ignored
-	if err := env.DB.InsertSubscription("s3", now, "c3", "active", 700, "eur"); err != nil {
+	if err := env.DB.InsertSubscription(makeSubscription("s3", now, "c3", "active", 700, "eur"), 0, nil); err != nil {
 		t.Fatal(err)
 	}
 
@@ -72,6 +72,325 @@ var migrations = []migration{
 			);
 		`,
 	},
+	{
+		version: 2,
+		sql: `
+			ALTER TABLE tx ADD COLUMN transfer TEXT;
+			CREATE INDEX IF NOT EXISTS tx_transfer ON tx(transfer);
+		`,
+	},
+	{
+		version: 3,
+		sql: `
+			ALTER TABLE tx ADD COLUMN reverses TEXT;
+		`,
+	},
+	{
+		version: 4,
+		sql: `
+			CREATE TABLE IF NOT EXISTS ledger (
+				name TEXT NOT NULL PRIMARY KEY,
+				created INTEGER NOT NULL,
+				updated INTEGER,
+				display_name TEXT NOT NULL DEFAULT '',
+				description TEXT NOT NULL DEFAULT '',
+				public INTEGER NOT NULL DEFAULT 1,
+				archived INTEGER NOT NULL DEFAULT 0
+			);
+			INSERT OR IGNORE INTO ledger (name, created)
+				SELECT ledger, unixepoch() FROM tx
+				UNION
+				SELECT ledger, unixepoch() FROM allocation
+				UNION
+				SELECT 'general', unixepoch();
+		`,
+	},
+	{
+		version: 5,
+		sql: `
+			CREATE TABLE IF NOT EXISTS goal (
+				id TEXT NOT NULL PRIMARY KEY,
+				created INTEGER NOT NULL,
+				updated INTEGER,
+				ledger TEXT NOT NULL,
+				title TEXT NOT NULL DEFAULT '',
+				description TEXT NOT NULL DEFAULT '',
+				target INTEGER NOT NULL,
+				start INTEGER NOT NULL,
+				deadline INTEGER NOT NULL
+			);
+			CREATE INDEX IF NOT EXISTS goal_ledger ON goal(ledger);
+		`,
+	},
+	{
+		version: 6,
+		sql: `
+			CREATE TABLE IF NOT EXISTS allocation_set (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				created INTEGER NOT NULL,
+				effective_from INTEGER NOT NULL
+			);
+			CREATE INDEX IF NOT EXISTS allocation_set_effective ON allocation_set(effective_from);
+			CREATE TABLE IF NOT EXISTS allocation_rule (
+				set_id INTEGER NOT NULL,
+				position INTEGER NOT NULL,
+				id TEXT NOT NULL,
+				ledger TEXT NOT NULL,
+				percentage INTEGER NOT NULL,
+				PRIMARY KEY (set_id, position)
+			);
+			INSERT INTO allocation_set (id, created, effective_from)
+				SELECT 1, unixepoch(), 0
+				WHERE EXISTS (SELECT 1 FROM allocation);
+			INSERT INTO allocation_rule (set_id, position, id, ledger, percentage)
+				SELECT 1, rowid, id, ledger, percentage
+				FROM allocation;
+			DROP TABLE allocation;
+		`,
+	},
+	{
+		version: 7,
+		sql: `
+			ALTER TABLE allocation_rule ADD COLUMN basis_points INTEGER NOT NULL DEFAULT 0;
+			ALTER TABLE allocation_rule ADD COLUMN fixed INTEGER NOT NULL DEFAULT 0;
+			UPDATE allocation_rule SET basis_points=percentage*100;
+			ALTER TABLE allocation_rule DROP COLUMN percentage;
+		`,
+	},
+	{
+		version: 8,
+		sql: `
+			ALTER TABLE allocation_set ADD COLUMN selector TEXT NOT NULL DEFAULT '';
+			DROP INDEX IF EXISTS allocation_set_effective;
+			CREATE INDEX IF NOT EXISTS allocation_set_selector ON allocation_set(selector, effective_from);
+			ALTER TABLE payment ADD COLUMN allocation_set INTEGER;
+		`,
+	},
+	{
+		version: 9,
+		sql: `
+			CREATE TABLE IF NOT EXISTS reallocation (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				created INTEGER NOT NULL,
+				allocation_set INTEGER NOT NULL,
+				since INTEGER NOT NULL,
+				until INTEGER NOT NULL,
+				payments INTEGER NOT NULL
+			);
+			CREATE INDEX IF NOT EXISTS tx_reverses ON tx(reverses);
+		`,
+	},
+	{
+		version: 10,
+		sql: `
+			ALTER TABLE payment ADD COLUMN fee INTEGER NOT NULL DEFAULT 0;
+			ALTER TABLE payment ADD COLUMN balance_transaction TEXT;
+			ALTER TABLE payment ADD COLUMN allocated INTEGER;
+			UPDATE payment SET allocated=amount;
+			CREATE TABLE IF NOT EXISTS setting (
+				key TEXT NOT NULL PRIMARY KEY,
+				value TEXT NOT NULL
+			);
+		`,
+	},
+	{
+		version: 11,
+		sql: `
+			ALTER TABLE payment ADD COLUMN refunded INTEGER NOT NULL DEFAULT 0;
+			CREATE TABLE IF NOT EXISTS refund (
+				id TEXT NOT NULL PRIMARY KEY,
+				created INTEGER NOT NULL,
+				updated INTEGER,
+				payment TEXT NOT NULL,
+				status TEXT NOT NULL,
+				amount INTEGER NOT NULL,
+				currency TEXT NOT NULL,
+				allocated INTEGER NOT NULL DEFAULT 0,
+				posted INTEGER NOT NULL DEFAULT 0
+			);
+			CREATE INDEX IF NOT EXISTS refund_payment ON refund(payment);
+		`,
+	},
+	{
+		version: 12,
+		sql: `
+			CREATE TABLE IF NOT EXISTS dispute (
+				id TEXT NOT NULL PRIMARY KEY,
+				created INTEGER NOT NULL,
+				updated INTEGER,
+				payment TEXT NOT NULL,
+				status TEXT NOT NULL,
+				reason TEXT NOT NULL DEFAULT '',
+				amount INTEGER NOT NULL,
+				fee INTEGER NOT NULL DEFAULT 0,
+				currency TEXT NOT NULL,
+				held INTEGER NOT NULL DEFAULT 0,
+				stage TEXT NOT NULL DEFAULT ''
+			);
+			CREATE INDEX IF NOT EXISTS dispute_status ON dispute(status);
+		`,
+	},
+	{
+		version: 13,
+		sql: `
+			CREATE TABLE IF NOT EXISTS stripe_event (
+				id TEXT NOT NULL PRIMARY KEY,
+				type TEXT NOT NULL,
+				resource_type TEXT NOT NULL,
+				resource_id TEXT NOT NULL,
+				created INTEGER NOT NULL,
+				received INTEGER NOT NULL,
+				status TEXT NOT NULL,
+				attempts INTEGER NOT NULL DEFAULT 0,
+				next_attempt INTEGER NOT NULL,
+				last_error TEXT NOT NULL DEFAULT ''
+			);
+			CREATE INDEX IF NOT EXISTS stripe_event_pending ON stripe_event(status, next_attempt);
+			CREATE INDEX IF NOT EXISTS stripe_event_resource ON stripe_event(resource_type, resource_id);
+		`,
+	},
+	{
+		version: 14,
+		sql: `
+			CREATE TABLE IF NOT EXISTS balance_transaction (
+				id TEXT NOT NULL PRIMARY KEY,
+				payout TEXT NOT NULL,
+				created INTEGER NOT NULL,
+				type TEXT NOT NULL,
+				source TEXT NOT NULL DEFAULT '',
+				amount INTEGER NOT NULL,
+				fee INTEGER NOT NULL,
+				net INTEGER NOT NULL,
+				currency TEXT NOT NULL
+			);
+			CREATE INDEX IF NOT EXISTS balance_transaction_payout ON balance_transaction(payout);
+			CREATE INDEX IF NOT EXISTS payment_balance_transaction ON payment(balance_transaction);
+		`,
+	},
+	{
+		version: 15,
+		sql: `
+			ALTER TABLE subscription ADD COLUMN current_period_end INTEGER;
+			ALTER TABLE subscription ADD COLUMN cancel_at_period_end INTEGER NOT NULL DEFAULT 0;
+			ALTER TABLE subscription ADD COLUMN cancel_at INTEGER;
+			ALTER TABLE subscription ADD COLUMN canceled_at INTEGER;
+			ALTER TABLE subscription ADD COLUMN paused INTEGER NOT NULL DEFAULT 0;
+			ALTER TABLE subscription ADD COLUMN resumes_at INTEGER;
+			CREATE TABLE IF NOT EXISTS subscription_change (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				subscription TEXT NOT NULL,
+				date INTEGER NOT NULL,
+				status TEXT NOT NULL,
+				amount INTEGER NOT NULL,
+				currency TEXT NOT NULL,
+				paused INTEGER NOT NULL DEFAULT 0,
+				cancel_at INTEGER
+			);
+			CREATE INDEX IF NOT EXISTS subscription_change_subscription ON subscription_change(subscription, date);
+			CREATE INDEX IF NOT EXISTS subscription_customer ON subscription(customer);
+			INSERT INTO subscription_change (subscription, date, status, amount, currency)
+				SELECT id, COALESCE(updated, created), COALESCE(status, ''), COALESCE(amount, 0), COALESCE(currency, '')
+				FROM subscription;
+		`,
+	},
+	{
+		version: 16,
+		sql: `
+			CREATE TABLE IF NOT EXISTS invoice (
+				id TEXT NOT NULL PRIMARY KEY,
+				created INTEGER NOT NULL,
+				updated INTEGER,
+				customer TEXT NOT NULL,
+				subscription TEXT,
+				payment TEXT,
+				status TEXT NOT NULL,
+				billing_reason TEXT NOT NULL DEFAULT '',
+				amount_due INTEGER NOT NULL,
+				amount_paid INTEGER NOT NULL,
+				currency TEXT NOT NULL,
+				period_start INTEGER,
+				period_end INTEGER,
+				price TEXT NOT NULL DEFAULT '',
+				product TEXT NOT NULL DEFAULT '',
+				attempts INTEGER NOT NULL DEFAULT 0,
+				next_attempt INTEGER,
+				failed INTEGER NOT NULL DEFAULT 0
+			);
+			CREATE INDEX IF NOT EXISTS invoice_subscription ON invoice(subscription, created);
+			CREATE INDEX IF NOT EXISTS invoice_payment ON invoice(payment);
+		`,
+	},
+	{
+		version: 17,
+		sql: `
+			ALTER TABLE tx ADD COLUMN payment TEXT;
+			UPDATE tx
+			SET payment=(
+				SELECT p.id
+				FROM payment p
+				WHERE tx.id>=p.id || ':'
+					AND tx.id<p.id || ';'
+			)
+			WHERE transfer IS NULL
+				AND reverses IS NULL
+				AND label IN ('patron', 'refund');
+			UPDATE tx
+			SET payment=(SELECT r.payment FROM tx r WHERE r.id=tx.reverses)
+			WHERE reverses IS NOT NULL;
+			CREATE INDEX IF NOT EXISTS tx_payment ON tx(payment);
+		`,
+	},
+	{
+		version: 18,
+		sql: `
+			UPDATE tx
+			SET payment=substr(id, 5)
+			WHERE label='stripe fee'
+				AND id LIKE 'fee:%'
+				AND payment IS NULL
+				AND EXISTS (SELECT 1 FROM payment p WHERE p.id=substr(tx.id, 5));
+		`,
+	},
+	{
+		version: 19,
+		sql: `
+			UPDATE tx
+			SET payment=(
+				SELECT d.payment
+				FROM dispute d
+				JOIN payment p ON p.id=d.payment
+				WHERE tx.id>='dispute:' || d.id || ':'
+					AND tx.id<'dispute:' || d.id || ';'
+			)
+			WHERE label IN ('dispute hold', 'dispute fee', 'dispute lost', 'dispute won')
+				AND payment IS NULL;
+		`,
+	},
+	{
+		version: 20,
+		sql: `
+			ALTER TABLE subscription ADD COLUMN changed INTEGER;
+		`,
+	},
+	{
+		version: 21,
+		sql: `
+			ALTER TABLE payment ADD COLUMN charge TEXT;
+			CREATE INDEX IF NOT EXISTS payment_charge ON payment(charge);
+		`,
+	},
+	{
+		version: 22,
+		sql: `
+			UPDATE tx
+			SET id='transfer:' || id,
+				reverses='transfer:' || reverses
+			WHERE reverses IN (SELECT id FROM tx WHERE transfer IS NOT NULL);
+			UPDATE tx
+			SET id='transfer:' || id
+			WHERE transfer IS NOT NULL;
+		`,
+	},
 }
 
 func getSchemaVersion(
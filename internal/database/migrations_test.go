@@ -7,7 +7,7 @@ import (
 	_ "modernc.org/sqlite"
 )
 
-func TestMigrateToVersion1(t *testing.T) {
+func TestMigrateToLatest(t *testing.T) {
 
 	db, err := sql.Open("sqlite", ":memory:")
 	if err != nil {
@@ -24,8 +24,9 @@ func TestMigrateToVersion1(t *testing.T) {
 	if err := db.QueryRow(`PRAGMA user_version;`).Scan(&version); err != nil {
 		t.Fatal(err)
 	}
-	if version != 1 {
-		t.Fatalf("expected version 1, got %d", version)
+	latest := migrations[len(migrations)-1].version
+	if version != latest {
+		t.Fatalf("expected version %d, got %d", latest, version)
 	}
 
 	// ensure proper tables
@@ -35,9 +36,12 @@ func TestMigrateToVersion1(t *testing.T) {
 		"payment",
 		"payout",
 		"tx",
-		"allocation",
+		"allocation_set",
+		"allocation_rule",
 		"api_key",
 		"allowed_origin",
+		"ledger",
+		"goal",
 	}
 	for _, table := range want {
 		var name string
@@ -53,3 +57,447 @@ func TestMigrateToVersion1(t *testing.T) {
 		}
 	}
 }
+
+func TestMigrateAddsTxColumns(t *testing.T) {
+
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if err := migrate(db); err != nil {
+		t.Fatalf("migrate failed: %v", err)
+	}
+
+	// ensure columns added after version 1
+	want := []string{
+		"transfer",
+		"reverses",
+	}
+	for _, column := range want {
+		var count int
+		row := db.QueryRow(`
+			SELECT COUNT(*)
+			FROM pragma_table_info('tx')
+			WHERE name=?1;`,
+			column,
+		)
+		if err := row.Scan(&count); err != nil {
+			t.Fatal(err)
+		}
+		if count != 1 {
+			t.Fatalf("column tx.%s missing", column)
+		}
+	}
+}
+
+func TestMigrateBackfillsLedgers(t *testing.T) {
+
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	// apply migrations before the ledger table existed
+	for _, m := range migrations[:3] {
+		if _, err := db.Exec(m.sql); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := setSchemaVersion(db, 3); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`
+		INSERT INTO tx (id, created, date, ledger, amount)
+		VALUES ('t1', 0, 0, 'savings', 100);
+		INSERT INTO allocation (id, ledger, percentage)
+		VALUES ('c', 'community', 100);
+	`); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := migrate(db); err != nil {
+		t.Fatalf("migrate failed: %v", err)
+	}
+
+	// ensure every referenced ledger is registered
+	for _, ledger := range []string{"community", "general", "savings"} {
+		var public, archived bool
+		row := db.QueryRow(`
+			SELECT public, archived
+			FROM ledger
+			WHERE name=?1;`,
+			ledger,
+		)
+		if err := row.Scan(&public, &archived); err != nil {
+			t.Fatalf("ledger %s missing: %v", ledger, err)
+		}
+		if !public || archived {
+			t.Errorf("ledger %s should be public and open", ledger)
+		}
+	}
+}
+
+func TestMigrateVersionsAllocations(t *testing.T) {
+
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	// apply migrations before allocation sets existed
+	for _, m := range migrations[:5] {
+		if _, err := db.Exec(m.sql); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := setSchemaVersion(db, 5); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`
+		INSERT INTO allocation (id, ledger, percentage)
+		VALUES ('g', 'general', 60), ('c', 'community', 40);
+	`); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := migrate(db); err != nil {
+		t.Fatalf("migrate failed: %v", err)
+	}
+
+	// ensure existing rules became the first set, in order
+	rows, err := db.Query(`
+		SELECT r.id, s.effective_from
+		FROM allocation_rule r
+		JOIN allocation_set s ON s.id=r.set_id
+		ORDER BY r.position;
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var (
+			id        string
+			effective int64
+		)
+		if err := rows.Scan(&id, &effective); err != nil {
+			t.Fatal(err)
+		}
+		if effective != 0 {
+			t.Errorf("expected migrated set effective from 0, got %d", effective)
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) != 2 || ids[0] != "g" || ids[1] != "c" {
+		t.Fatalf("unexpected migrated rules %v", ids)
+	}
+}
+
+func TestMigrateAllocationBasisPoints(t *testing.T) {
+
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	// apply migrations before basis points existed
+	for _, m := range migrations[:6] {
+		if _, err := db.Exec(m.sql); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := setSchemaVersion(db, 6); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`
+		INSERT INTO allocation_set (id, created, effective_from)
+		VALUES (1, 0, 0);
+		INSERT INTO allocation_rule (set_id, position, id, ledger, percentage)
+		VALUES (1, 0, 'g', 'general', 60), (1, 1, 'c', 'community', 40);
+	`); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := migrate(db); err != nil {
+		t.Fatalf("migrate failed: %v", err)
+	}
+
+	// ensure percentages were converted to basis points
+	rows, err := db.Query(`
+		SELECT basis_points, fixed
+		FROM allocation_rule
+		ORDER BY position;
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	var got []int
+	for rows.Next() {
+		var bp, fixed int
+		if err := rows.Scan(&bp, &fixed); err != nil {
+			t.Fatal(err)
+		}
+		if fixed != 0 {
+			t.Errorf("expected no fixed amount, got %d", fixed)
+		}
+		got = append(got, bp)
+	}
+	if len(got) != 2 || got[0] != 6000 || got[1] != 4000 {
+		t.Fatalf("unexpected basis points %v", got)
+	}
+}
+
+func TestMigratePaymentFees(t *testing.T) {
+
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	// apply migrations before fees were recorded
+	for _, m := range migrations[:9] {
+		if _, err := db.Exec(m.sql); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := setSchemaVersion(db, 9); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`
+		INSERT INTO payment (id, created, status, customer, amount, currency)
+		VALUES ('pi_1', 0, 'succeeded', 'cus_1', 1000, 'usd');
+	`); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := migrate(db); err != nil {
+		t.Fatalf("migrate failed: %v", err)
+	}
+
+	// ensure existing payments were allocated in full with no fee
+	var fee, allocated int
+	if err := db.QueryRow(`
+		SELECT fee, allocated
+		FROM payment
+		WHERE id='pi_1';
+	`).Scan(&fee, &allocated); err != nil {
+		t.Fatal(err)
+	}
+	if fee != 0 || allocated != 1000 {
+		t.Errorf("unexpected fee %d and allocated %d", fee, allocated)
+	}
+}
+
+func TestMigrateTxPayments(t *testing.T) {
+
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	// apply migrations before entries recorded their payment
+	for _, m := range migrations[:16] {
+		if _, err := db.Exec(m.sql); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := setSchemaVersion(db, 16); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`
+		INSERT INTO payment (id, created, status, customer, amount, currency)
+		VALUES ('pi_1', 0, 'succeeded', 'cus_1', 1000, 'usd');
+		INSERT INTO tx (id, created, date, amount, ledger, label, reverses)
+		VALUES ('pi_1:general', 0, 0, 1000, 'general', 'patron', NULL),
+			('pi_1:general:re_1', 0, 0, -100, 'general', 'refund', NULL),
+			('pi_1:general:reversal', 0, 0, -1000, 'general', 'reallocation', 'pi_1:general'),
+			('pi_1:general:manual', 0, 0, 50, 'general', 'adjustment', NULL),
+			('pi_10:general', 0, 0, 50, 'general', 'patron', NULL);
+	`); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := migrate(db); err != nil {
+		t.Fatalf("migrate failed: %v", err)
+	}
+
+	// ensure only the payment's own entries were attributed to it
+	want := map[string]string{
+		"pi_1:general":          "pi_1",
+		"pi_1:general:re_1":     "pi_1",
+		"pi_1:general:reversal": "pi_1",
+		"pi_1:general:manual":   "",
+		"pi_10:general":         "",
+	}
+	for id, payment := range want {
+		var got sql.NullString
+		if err := db.QueryRow(`SELECT payment FROM tx WHERE id=?1;`, id).Scan(&got); err != nil {
+			t.Fatal(err)
+		}
+		if got.String != payment {
+			t.Errorf("%s: want payment %q got %q", id, payment, got.String)
+		}
+	}
+}
+
+func TestMigrateFeePayments(t *testing.T) {
+
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	// apply migrations before fee entries recorded their payment
+	for _, m := range migrations[:17] {
+		if _, err := db.Exec(m.sql); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := setSchemaVersion(db, 17); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`
+		INSERT INTO payment (id, created, status, customer, amount, currency)
+		VALUES ('pi_1', 0, 'succeeded', 'cus_1', 1000, 'usd');
+		INSERT INTO tx (id, created, date, amount, ledger, label)
+		VALUES ('fee:pi_1', 0, 0, -59, 'fees', 'stripe fee'),
+			('fee:pi_2', 0, 0, -59, 'fees', 'stripe fee');
+	`); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := migrate(db); err != nil {
+		t.Fatalf("migrate failed: %v", err)
+	}
+
+	want := map[string]string{
+		"fee:pi_1": "pi_1",
+		"fee:pi_2": "",
+	}
+	for id, payment := range want {
+		var got sql.NullString
+		if err := db.QueryRow(`SELECT payment FROM tx WHERE id=?1;`, id).Scan(&got); err != nil {
+			t.Fatal(err)
+		}
+		if got.String != payment {
+			t.Errorf("%s: want payment %q got %q", id, payment, got.String)
+		}
+	}
+}
+
+func TestMigrateDisputePayments(t *testing.T) {
+
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	// apply migrations before dispute entries recorded their payment
+	for _, m := range migrations[:18] {
+		if _, err := db.Exec(m.sql); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := setSchemaVersion(db, 18); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`
+		INSERT INTO payment (id, created, status, customer, amount, currency)
+		VALUES ('pi_1', 0, 'succeeded', 'cus_1', 1000, 'usd');
+		INSERT INTO dispute (id, created, payment, status, reason, amount, fee, currency, held, stage)
+		VALUES ('dp_1', 0, 'pi_1', 'won', 'fraudulent', 1000, 0, 'usd', 1000, 'released'),
+			('dp_10', 0, 'pi_10', 'needs_response', 'fraudulent', 50, 0, 'usd', 0, 'held');
+		INSERT INTO tx (id, created, date, amount, ledger, label, reverses)
+		VALUES ('dispute:dp_1:hold', 0, 0, 1000, 'disputes', 'dispute hold', NULL),
+			('dispute:dp_1:hold:reversal', 0, 0, -1000, 'disputes', 'dispute won', 'dispute:dp_1:hold'),
+			('dispute:dp_1:fee', 0, 0, -1500, 'disputes', 'dispute fee', NULL),
+			('dispute:dp_10:fee', 0, 0, -1500, 'disputes', 'dispute fee', NULL);
+	`); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := migrate(db); err != nil {
+		t.Fatalf("migrate failed: %v", err)
+	}
+
+	want := map[string]string{
+		"dispute:dp_1:hold":          "pi_1",
+		"dispute:dp_1:hold:reversal": "pi_1",
+		"dispute:dp_1:fee":           "pi_1",
+		"dispute:dp_10:fee":          "",
+	}
+	for id, payment := range want {
+		var got sql.NullString
+		if err := db.QueryRow(`SELECT payment FROM tx WHERE id=?1;`, id).Scan(&got); err != nil {
+			t.Fatal(err)
+		}
+		if got.String != payment {
+			t.Errorf("%s: want payment %q got %q", id, payment, got.String)
+		}
+	}
+}
+
+func TestMigrateTransferIDs(t *testing.T) {
+
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	// apply migrations before transfer legs had their own IDs
+	for _, m := range migrations[:21] {
+		if _, err := db.Exec(m.sql); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := setSchemaVersion(db, 21); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`
+		INSERT INTO tx (id, created, date, amount, ledger, label, transfer, reverses)
+		VALUES ('tr1:general', 0, 0, -75, 'general', 'move', 'tr1', NULL),
+			('tr1:community', 0, 0, 75, 'community', 'move', 'tr1', NULL),
+			('tr1:general:reversal', 0, 0, 75, 'general', 'reversal', NULL, 'tr1:general'),
+			('tr1:community:reversal', 0, 0, -75, 'community', 'reversal', NULL, 'tr1:community'),
+			('pi_1:general', 0, 0, 100, 'general', 'patron', NULL, NULL),
+			('pi_1:general:reversal', 0, 0, -100, 'general', 'reversal', NULL, 'pi_1:general');
+	`); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := migrate(db); err != nil {
+		t.Fatalf("migrate failed: %v", err)
+	}
+
+	want := map[string]string{
+		"transfer:tr1:general":            "",
+		"transfer:tr1:community":          "",
+		"transfer:tr1:general:reversal":   "transfer:tr1:general",
+		"transfer:tr1:community:reversal": "transfer:tr1:community",
+		"pi_1:general":                    "",
+		"pi_1:general:reversal":           "pi_1:general",
+	}
+	for id, reverses := range want {
+		var got sql.NullString
+		if err := db.QueryRow(`SELECT reverses FROM tx WHERE id=?1;`, id).Scan(&got); err != nil {
+			t.Fatalf("%s: %v", id, err)
+		}
+		if got.String != reverses {
+			t.Errorf("%s: want reverses %q got %q", id, reverses, got.String)
+		}
+	}
+}
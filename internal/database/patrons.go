@@ -2,17 +2,82 @@ package database
 
 import (
 	"database/sql"
+	"errors"
+	"fmt"
 	"time"
 
 	"git.sr.ht/~jakintosh/coffer/internal/service"
+	"git.sr.ht/~jakintosh/coffer/pkg/wire"
 )
 
-func (db *DB) GetCustomers(limit, offset int) ([]service.Patron, error) {
+func (db *DB) GetCustomer(
+	id string,
+) (
+	*service.Patron,
+	error,
+) {
+	var (
+		name    sql.NullString
+		created int64
+		updated sql.NullInt64
+	)
+	err := db.Conn.QueryRow(`
+		SELECT name, created, updated
+		FROM customer
+		WHERE id=?1;`,
+		id,
+	).Scan(
+		&name,
+		&created,
+		&updated,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, service.ErrPatronNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to query customer: %w", err)
+	}
+
+	updatedAt := created
+	if updated.Valid {
+		updatedAt = updated.Int64
+	}
+	return &service.Patron{
+		ID:        id,
+		Name:      name.String,
+		CreatedAt: time.Unix(created, 0),
+		UpdatedAt: time.Unix(updatedAt, 0),
+	}, nil
+}
+
+func (db *DB) GetCustomers(
+	after *wire.Cursor,
+	limit int,
+	offset int,
+) (
+	[]service.Patron,
+	error,
+) {
+	// keyset pagination on (created, id), which never change, unbounded
+	// without a cursor
+	var (
+		afterKey sql.NullInt64
+		afterID  string
+	)
+	if after != nil {
+		afterKey = sql.NullInt64{Int64: after.Key, Valid: true}
+		afterID = after.ID
+	}
+
 	rows, err := db.Conn.Query(`
 		SELECT id, name, created, updated
 		FROM customer
-		ORDER BY COALESCE(updated, created) DESC
-		LIMIT ?1 OFFSET ?2;`,
+		WHERE ?1 IS NULL
+			OR created<?1
+			OR (created=?1 AND id<?2)
+		ORDER BY created DESC, id DESC
+		LIMIT ?3 OFFSET ?4;`,
+		afterKey,
+		afterID,
 		limit,
 		offset,
 	)
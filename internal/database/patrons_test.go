@@ -4,23 +4,44 @@ import (
 	"testing"
 
 	"git.sr.ht/~jakintosh/coffer/internal/testutil"
+	"git.sr.ht/~jakintosh/coffer/pkg/wire"
 )
 
 func TestGetCustomers(t *testing.T) {
 	env := testutil.SetupTestEnv(t)
 	testutil.SeedCustomerData(t, env.Service)
 
-	patrons, err := env.DB.GetCustomers(2, 0)
+	patrons, err := env.DB.GetCustomers(nil, 2, 0)
 	if err != nil {
 		t.Fatalf("GetCustomers: %v", err)
 	}
 	if len(patrons) != 2 {
 		t.Fatalf("want 2 patrons got %d", len(patrons))
 	}
-	if patrons[0].ID != "c2" {
-		t.Errorf("first patron should be updated customer c2")
+	if patrons[0].ID != "c3" {
+		t.Errorf("first patron should be newest customer c3")
 	}
-	if patrons[1].ID != "c3" {
-		t.Errorf("second patron should be c3")
+	if patrons[1].ID != "c2" {
+		t.Errorf("second patron should be c2")
+	}
+}
+
+func TestGetCustomersAfterCursor(t *testing.T) {
+	env := testutil.SetupTestEnv(t)
+	testutil.SeedCustomerData(t, env.Service)
+
+	first, err := env.DB.GetCustomers(nil, 2, 0)
+	if err != nil {
+		t.Fatalf("GetCustomers: %v", err)
+	}
+
+	last := first[len(first)-1]
+	after := &wire.Cursor{Key: last.CreatedAt.Unix(), ID: last.ID}
+	rest, err := env.DB.GetCustomers(after, 10, 0)
+	if err != nil {
+		t.Fatalf("GetCustomers: %v", err)
+	}
+	if len(rest) != 1 || rest[0].ID != "c1" {
+		t.Fatalf("unexpected patrons after cursor %+v", rest)
 	}
 }
@@ -0,0 +1,176 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+
+	"git.sr.ht/~jakintosh/coffer/internal/service"
+)
+
+// InsertReallocation records a reallocation and, in the same transaction,
+// reverses every live entry of each payment and posts the new shares that
+// reshare works out from the payment as read in that transaction. Each
+// payment is updated with the amount, shares and new shares it was
+// reallocated with. Live entries are those recorded for the payment that
+// are neither reversals nor already reversed. Replacement entries are suffixed with the
+// reallocation ID so that a payment can be reallocated more than once.
+// Every entry is dated when the reallocation is created, so periods that
+// were already reported keep their balances.
+func (db *DB) InsertReallocation(
+	setID int64,
+	since int64,
+	until int64,
+	payments []service.PaymentReallocation,
+	reshare service.PaymentReshare,
+	created int64,
+) (
+	int64,
+	error,
+) {
+	tx, err := db.Conn.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`
+		INSERT INTO reallocation (created, allocation_set, since, until, payments)
+		VALUES (?1, ?2, ?3, ?4, ?5);
+		`,
+		created,
+		setID,
+		since,
+		until,
+		len(payments),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert reallocation: %w", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	for i := range payments {
+		p := &payments[i]
+
+		// a refund or dispute may have changed the payment since it was read
+		payment, err := getPaymentAllocation(tx, p.PaymentID)
+		if err != nil {
+			return 0, err
+		}
+		after, err := reshare(payment)
+		if err != nil {
+			return 0, err
+		}
+		p.Amount = payment.Allocated
+		p.Before = payment.Posted
+		p.After = after
+
+		live, err := getLivePaymentEntries(tx, p.PaymentID)
+		if err != nil {
+			return 0, fmt.Errorf("failed to query payment %s: %w", p.PaymentID, err)
+		}
+		for _, orig := range live {
+			row := txRow{
+				id:       orig.id + ":reversal",
+				ledger:   orig.ledger,
+				amount:   -orig.amount,
+				date:     created,
+				label:    "reallocation",
+				reverses: sql.NullString{String: orig.id, Valid: true},
+				payment:  orig.payment,
+			}
+			if err := insertTransaction(tx, row); err != nil {
+				return 0, err
+			}
+		}
+
+		for _, a := range p.After {
+			row := txRow{
+				id:      fmt.Sprintf("%s:%s:realloc%d", p.PaymentID, a.Ledger, id),
+				ledger:  a.Ledger,
+				amount:  a.Amount,
+				date:    created,
+				label:   "patron",
+				payment: sql.NullString{String: p.PaymentID, Valid: true},
+			}
+			if err := insertTransaction(tx, row); err != nil {
+				return 0, err
+			}
+		}
+
+		if _, err := tx.Exec(`
+			UPDATE payment
+			SET allocation_set=?2,
+				updated=unixepoch()
+			WHERE id=?1;
+			`,
+			p.PaymentID,
+			setID,
+		); err != nil {
+			return 0, fmt.Errorf("failed to update payment %s: %w", p.PaymentID, err)
+		}
+	}
+
+	return id, tx.Commit()
+}
+
+// getLiveEntries returns the entries with the given ID, or prefixed with
+// it and a colon, that are neither reversals, transfers nor reversed.
+func getLiveEntries(
+	tx *sql.Tx,
+	prefix string,
+) (
+	[]txRow,
+	error,
+) {
+	return queryLiveEntries(tx, `(id=?1 OR (id>=?1 || ':' AND id<?1 || ';'))`, prefix)
+}
+
+// getLivePaymentEntries returns the share entries recorded for a payment
+// that are neither reversals, transfers nor reversed.
+func getLivePaymentEntries(
+	tx *sql.Tx,
+	payment string,
+) (
+	[]txRow,
+	error,
+) {
+	return queryLiveEntries(tx, `payment=?1 AND `+shareEntry, payment)
+}
+
+func queryLiveEntries(
+	tx *sql.Tx,
+	filter string,
+	arg string,
+) (
+	[]txRow,
+	error,
+) {
+	rows, err := tx.Query(`
+		SELECT id, ledger, amount, payment
+		FROM tx t
+		WHERE `+filter+`
+			AND reverses IS NULL
+			AND transfer IS NULL
+			AND NOT EXISTS (SELECT 1 FROM tx r WHERE r.reverses=t.id)
+		ORDER BY rowid;
+		`,
+		arg,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var live []txRow
+	for rows.Next() {
+		var r txRow
+		if err := rows.Scan(&r.id, &r.ledger, &r.amount, &r.payment); err != nil {
+			return nil, err
+		}
+		live = append(live, r)
+	}
+	return live, rows.Err()
+}
@@ -0,0 +1,125 @@
+package database_test
+
+import (
+	"testing"
+	"time"
+
+	"git.sr.ht/~jakintosh/coffer/internal/service"
+	"git.sr.ht/~jakintosh/coffer/internal/testutil"
+)
+
+func TestInsertReallocation(t *testing.T) {
+	env := testutil.SetupTestEnv(t)
+	testutil.SeedLedgers(t, env.Service, "community")
+
+	shares := []service.Allocation{{Ledger: "general", Amount: 1000}}
+	if err := env.DB.InsertPayment(makePayment("pi_1", 100, "succeeded", 1000, 1), shares, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	payments := []service.PaymentReallocation{
+		{PaymentID: "pi_1", Date: time.Unix(100, 0)},
+	}
+	reshare := func(p *service.PaymentAllocation) ([]service.Allocation, error) {
+		return []service.Allocation{
+			{Ledger: "general", Amount: p.Allocated * 2 / 5},
+			{Ledger: "community", Amount: p.Allocated * 3 / 5},
+		}, nil
+	}
+	if _, err := env.DB.InsertReallocation(2, 0, 200, payments, reshare, 300); err != nil {
+		t.Fatalf("InsertReallocation failed: %v", err)
+	}
+
+	// the original is reversed and the new shares are posted
+	reversal, err := env.DB.GetTransaction("pi_1:general:reversal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reversal.Amount != -1000 || reversal.Reverses != "pi_1:general" || reversal.Date.Unix() != 300 {
+		t.Errorf("unexpected reversal %+v", reversal)
+	}
+	if payments[0].Amount != 1000 || len(payments[0].After) != 2 {
+		t.Errorf("unexpected reallocated payment %+v", payments[0])
+	}
+	allocations, err := env.DB.GetPaymentAllocations(0, 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	posted := allocations[0].Posted
+	if len(posted) != 2 || posted[0].Amount != 400 || posted[1].Amount != 600 {
+		t.Errorf("unexpected posted amounts %+v", posted)
+	}
+	if allocations[0].AllocationSet != 2 {
+		t.Errorf("expected payment to record set 2, got %d", allocations[0].AllocationSet)
+	}
+
+	// a second reallocation only reverses the replacement entries
+	reshare = func(p *service.PaymentAllocation) ([]service.Allocation, error) {
+		return []service.Allocation{{Ledger: "general", Amount: p.Allocated}}, nil
+	}
+	if _, err := env.DB.InsertReallocation(1, 0, 200, payments, reshare, 300); err != nil {
+		t.Fatalf("second InsertReallocation failed: %v", err)
+	}
+	allocations, err = env.DB.GetPaymentAllocations(0, 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	posted = allocations[0].Posted
+	if len(posted) != 2 || posted[0].Amount != 1000 || posted[1].Amount != 0 {
+		t.Errorf("unexpected posted amounts after second reallocation %+v", posted)
+	}
+}
+
+func TestInsertReallocationAfterRefund(t *testing.T) {
+	env := testutil.SetupTestEnv(t)
+	testutil.SeedLedgers(t, env.Service, "community")
+
+	shares := []service.Allocation{{Ledger: "general", Amount: 1000}}
+	if err := env.DB.InsertPayment(makePayment("pi_1", 100, "succeeded", 1000, 1), shares, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	// a refund posted after the payment was read for the reallocation
+	payments := []service.PaymentReallocation{
+		{PaymentID: "pi_1", Date: time.Unix(100, 0), Amount: 1000},
+	}
+	refund := service.Refund{
+		ID:       "re_1",
+		Payment:  "pi_1",
+		Created:  time.Unix(200, 0),
+		Status:   "succeeded",
+		Amount:   400,
+		Currency: "usd",
+		Posted:   true,
+	}
+	reverse := func(p *service.PaymentAllocation, r *service.Refund) ([]service.Allocation, error) {
+		r.Allocated = 400
+		return []service.Allocation{{Ledger: "general", Amount: 400}}, nil
+	}
+	if err := env.DB.InsertRefund(refund, reverse, 200); err != nil {
+		t.Fatal(err)
+	}
+
+	// the new shares split what is left after the refund
+	reshare := func(p *service.PaymentAllocation) ([]service.Allocation, error) {
+		return []service.Allocation{{Ledger: "community", Amount: p.Allocated}}, nil
+	}
+	if _, err := env.DB.InsertReallocation(2, 0, 200, payments, reshare, 300); err != nil {
+		t.Fatalf("InsertReallocation failed: %v", err)
+	}
+	if payments[0].Amount != 600 {
+		t.Errorf("unexpected reallocated payment %+v", payments[0])
+	}
+
+	payment, err := env.DB.GetPaymentAllocation("pi_1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	net := map[string]int{}
+	for _, a := range payment.Posted {
+		net[a.Ledger] += a.Amount
+	}
+	if net["general"] != 0 || net["community"] != 600 {
+		t.Errorf("unexpected posted shares %+v", payment.Posted)
+	}
+}
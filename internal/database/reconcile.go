@@ -0,0 +1,229 @@
+package database
+
+import (
+	"fmt"
+	"time"
+
+	"git.sr.ht/~jakintosh/coffer/internal/service"
+)
+
+// InsertBalanceTransactions replaces the balance transactions stored for a
+// payout in a single transaction
+func (db *DB) InsertBalanceTransactions(
+	payout string,
+	txs []service.BalanceTransaction,
+) error {
+	tx, err := db.Conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`
+		DELETE FROM balance_transaction
+		WHERE payout=?1;
+		`,
+		payout,
+	); err != nil {
+		return fmt.Errorf("failed to clear balance transactions: %w", err)
+	}
+
+	for _, bt := range txs {
+		if _, err := tx.Exec(`
+			INSERT INTO balance_transaction (id, payout, created, type, source, amount, fee, net, currency)
+			VALUES (?1, ?2, ?3, ?4, ?5, ?6, ?7, ?8, ?9)
+			ON CONFLICT(id) DO UPDATE
+				SET payout=excluded.payout,
+					type=excluded.type,
+					source=excluded.source,
+					amount=excluded.amount,
+					fee=excluded.fee,
+					net=excluded.net,
+					currency=excluded.currency;`,
+			bt.ID,
+			payout,
+			bt.Created.Unix(),
+			bt.Type,
+			bt.Source,
+			bt.Amount,
+			bt.Fee,
+			bt.Net,
+			bt.Currency,
+		); err != nil {
+			return fmt.Errorf("failed to insert balance transaction %s: %w", bt.ID, err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetPayoutTotals returns the paid payouts created from since up to, but
+// not including, until, oldest first, with the total of their stored
+// balance transactions
+func (db *DB) GetPayoutTotals(
+	since int64,
+	until int64,
+) (
+	[]service.PayoutTotal,
+	error,
+) {
+	rows, err := db.Conn.Query(`
+		SELECT p.id, p.created, p.amount, p.currency, COALESCE(SUM(b.net), 0), COUNT(b.id)
+		FROM payout p
+		LEFT JOIN balance_transaction b ON b.payout=p.id
+		WHERE p.status='paid'
+			AND p.created>=?1
+			AND p.created<?2
+		GROUP BY p.id
+		ORDER BY p.created, p.id;
+		`,
+		since,
+		until,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query payout totals: %w", err)
+	}
+	defer rows.Close()
+
+	payouts := []service.PayoutTotal{}
+	for rows.Next() {
+		var (
+			p       service.PayoutTotal
+			created int64
+		)
+		if err := rows.Scan(
+			&p.ID,
+			&created,
+			&p.Amount,
+			&p.Currency,
+			&p.Net,
+			&p.Transactions,
+		); err != nil {
+			return nil, err
+		}
+		p.Created = time.Unix(created, 0)
+		payouts = append(payouts, p)
+	}
+
+	return payouts, rows.Err()
+}
+
+// GetPayoutPayments returns the payment balance transactions settled by
+// the paid payouts created from since up to, but not including, until,
+// oldest first. Each is matched to the payment recorded with it or, for a
+// payment recorded before its fee was known, to the payment of its charge.
+// A payment is ledgered while any of its shares is posted and not reversed.
+func (db *DB) GetPayoutPayments(
+	since int64,
+	until int64,
+) (
+	[]service.PayoutPayment,
+	error,
+) {
+	rows, err := db.Conn.Query(`
+		SELECT b.id, b.payout, b.created, b.type, b.source, b.amount, b.fee, b.net, b.currency,
+			COALESCE(pm.id, ''),
+			EXISTS (
+				SELECT 1
+				FROM tx t
+				WHERE t.payment=pm.id
+					AND `+shareEntry+`
+					AND t.reverses IS NULL
+					AND NOT EXISTS (SELECT 1 FROM tx r WHERE r.reverses=t.id)
+			)
+		FROM balance_transaction b
+		JOIN payout p ON p.id=b.payout
+		LEFT JOIN payment pm ON pm.balance_transaction=b.id
+			OR (pm.balance_transaction IS NULL AND pm.charge=b.source)
+		WHERE b.type IN ('charge', 'payment')
+			AND p.status='paid'
+			AND p.created>=?1
+			AND p.created<?2
+		ORDER BY b.created, b.id;
+		`,
+		since,
+		until,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query payout payments: %w", err)
+	}
+	defer rows.Close()
+
+	payments := []service.PayoutPayment{}
+	for rows.Next() {
+		var (
+			p       service.PayoutPayment
+			created int64
+		)
+		if err := rows.Scan(
+			&p.ID,
+			&p.Payout,
+			&created,
+			&p.Type,
+			&p.Source,
+			&p.Amount,
+			&p.Fee,
+			&p.Net,
+			&p.Currency,
+			&p.Payment,
+			&p.Ledgered,
+		); err != nil {
+			return nil, err
+		}
+		p.Created = time.Unix(created, 0)
+		payments = append(payments, p)
+	}
+
+	return payments, rows.Err()
+}
+
+// GetUnmatchedEntries returns the patron entries dated from since up to,
+// but not including, until, oldest first, that are not recorded for a
+// known payment
+func (db *DB) GetUnmatchedEntries(
+	since int64,
+	until int64,
+) (
+	[]service.Transaction,
+	error,
+) {
+	rows, err := db.Conn.Query(`
+		SELECT t.id, t.ledger, t.amount, t.date, t.label, t.transfer, t.reverses
+		FROM tx t
+		WHERE t.label='patron'
+			AND t.date>=?1
+			AND t.date<?2
+			AND NOT EXISTS (
+				SELECT 1
+				FROM payment p
+				WHERE p.id=t.payment
+			)
+		ORDER BY t.date, t.id;
+		`,
+		since,
+		until,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query unmatched entries: %w", err)
+	}
+	defer rows.Close()
+
+	txs := []service.Transaction{}
+	for rows.Next() {
+		var r txRow
+		if err := rows.Scan(
+			&r.id,
+			&r.ledger,
+			&r.amount,
+			&r.date,
+			&r.label,
+			&r.transfer,
+			&r.reverses,
+		); err != nil {
+			return nil, err
+		}
+		txs = append(txs, r.toTransaction())
+	}
+
+	return txs, rows.Err()
+}
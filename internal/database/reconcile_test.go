@@ -0,0 +1,139 @@
+package database_test
+
+import (
+	"testing"
+	"time"
+
+	"git.sr.ht/~jakintosh/coffer/internal/service"
+	"git.sr.ht/~jakintosh/coffer/internal/testutil"
+)
+
+func makeBalanceTransaction(
+	id string,
+	source string,
+	amount int,
+	fee int,
+) service.BalanceTransaction {
+	return service.BalanceTransaction{
+		ID:       id,
+		Created:  time.Unix(100, 0),
+		Type:     "charge",
+		Source:   source,
+		Amount:   amount,
+		Fee:      fee,
+		Net:      amount - fee,
+		Currency: "usd",
+	}
+}
+
+func TestInsertBalanceTransactions(t *testing.T) {
+	env := testutil.SetupTestEnv(t)
+
+	if err := env.DB.InsertPayout("po_1", 200, "paid", 1400, "usd"); err != nil {
+		t.Fatal(err)
+	}
+	shares := []service.Allocation{{Ledger: "general", Amount: 1000}}
+	payment := makePayment("pi_1", 100, "succeeded", 1000, 1)
+	payment.BalanceTransaction = "txn_1"
+	if err := env.DB.InsertPayment(payment, shares, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	// a payment recorded before its fee was known is matched by its charge
+	pending := makePayment("pi_3", 100, "succeeded", 300, 1)
+	pending.Charge = "ch_3"
+	pending.Pending = true
+	if err := env.DB.InsertPayment(pending, nil, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	// a payment whose shares were all reversed is not ledgered
+	reversed := makePayment("pi_4", 100, "succeeded", 400, 1)
+	reversed.BalanceTransaction = "txn_4"
+	if err := env.DB.InsertPayment(reversed, []service.Allocation{{Ledger: "general", Amount: 400}}, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := env.DB.InsertReversal("pi_4:general:reversal", "pi_4:general", 100, "reversal"); err != nil {
+		t.Fatal(err)
+	}
+
+	// storing a payout again replaces its transactions
+	if err := env.DB.InsertBalanceTransactions("po_1", []service.BalanceTransaction{
+		makeBalanceTransaction("txn_old", "ch_old", 100, 0),
+	}); err != nil {
+		t.Fatalf("InsertBalanceTransactions failed: %v", err)
+	}
+	if err := env.DB.InsertBalanceTransactions("po_1", []service.BalanceTransaction{
+		makeBalanceTransaction("txn_1", "ch_1", 1000, 50),
+		makeBalanceTransaction("txn_2", "ch_2", 500, 20),
+		makeBalanceTransaction("txn_3", "ch_3", 300, 10),
+		makeBalanceTransaction("txn_4", "ch_4", 400, 10),
+	}); err != nil {
+		t.Fatalf("InsertBalanceTransactions failed: %v", err)
+	}
+
+	payouts, err := env.DB.GetPayoutTotals(0, 300)
+	if err != nil {
+		t.Fatalf("GetPayoutTotals failed: %v", err)
+	}
+	if len(payouts) != 1 || payouts[0].Net != 2110 || payouts[0].Transactions != 4 {
+		t.Errorf("unexpected payout totals %+v", payouts)
+	}
+
+	payments, err := env.DB.GetPayoutPayments(0, 300)
+	if err != nil {
+		t.Fatalf("GetPayoutPayments failed: %v", err)
+	}
+	if len(payments) != 4 {
+		t.Fatalf("expected 4 payments, got %+v", payments)
+	}
+	if payments[0].ID != "txn_1" || payments[0].Payment != "pi_1" || !payments[0].Ledgered {
+		t.Errorf("unexpected matched payment %+v", payments[0])
+	}
+	if payments[1].ID != "txn_2" || payments[1].Payment != "" || payments[1].Ledgered {
+		t.Errorf("unexpected unmatched payment %+v", payments[1])
+	}
+	if payments[2].ID != "txn_3" || payments[2].Payment != "pi_3" || payments[2].Ledgered {
+		t.Errorf("unexpected pending payment %+v", payments[2])
+	}
+	if payments[3].ID != "txn_4" || payments[3].Payment != "pi_4" || payments[3].Ledgered {
+		t.Errorf("unexpected reversed payment %+v", payments[3])
+	}
+
+	// the end of the range is exclusive
+	payouts, err = env.DB.GetPayoutTotals(0, 200)
+	if err != nil {
+		t.Fatalf("GetPayoutTotals failed: %v", err)
+	}
+	if len(payouts) != 0 {
+		t.Errorf("expected no payouts, got %+v", payouts)
+	}
+}
+
+func TestGetUnmatchedEntries(t *testing.T) {
+	env := testutil.SetupTestEnv(t)
+
+	shares := []service.Allocation{{Ledger: "general", Amount: 100}}
+	if err := env.DB.InsertPayment(makePayment("pi_1", 100, "succeeded", 100, 1), shares, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	// entries are matched by the payment they were recorded for, not by ID
+	for _, tx := range []struct{ id, label string }{
+		{"pi_1:savings", "patron"},
+		{"pi_2:general", "patron"},
+		{"rent", "expense"},
+	} {
+		if err := env.DB.InsertTransaction(tx.id, "general", 100, 100, tx.label); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, err := env.DB.GetUnmatchedEntries(0, 200)
+	if err != nil {
+		t.Fatalf("GetUnmatchedEntries failed: %v", err)
+	}
+	if len(entries) != 2 || entries[0].ID != "pi_1:savings" || entries[1].ID != "pi_2:general" {
+		t.Errorf("unexpected entries %+v", entries)
+	}
+}
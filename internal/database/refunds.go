@@ -0,0 +1,251 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"git.sr.ht/~jakintosh/coffer/internal/service"
+)
+
+func (db *DB) GetRefund(
+	id string,
+) (
+	*service.Refund,
+	error,
+) {
+	row := db.Conn.QueryRow(`
+		SELECT id, created, payment, status, amount, currency, allocated, posted
+		FROM refund
+		WHERE id=?1;
+		`,
+		id,
+	)
+	r, err := scanRefund(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, service.ErrRefundNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to query refund: %w", err)
+	}
+
+	return r, nil
+}
+
+// InsertRefund records a refund, or updates the status of a known one. The
+// first time a refund is recorded as posted, reverse works out the shares
+// it takes back from the payment as read in the same transaction, the
+// shares are posted as negative entries, and the payment's refunded and
+// allocated amounts and status are updated. Posting a refund again changes
+// nothing. When a posted refund fails or is canceled, its entries are
+// reversed at date and the payment's amounts and status are restored.
+func (db *DB) InsertRefund(
+	r service.Refund,
+	reverse service.RefundReversal,
+	date int64,
+) error {
+	tx, err := db.Conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	payment, err := getPaymentAllocation(tx, r.Payment)
+	if err != nil {
+		return err
+	}
+
+	var (
+		wasPosted bool
+		allocated int
+	)
+	err = tx.QueryRow(`
+		SELECT posted, allocated
+		FROM refund
+		WHERE id=?1;
+		`,
+		r.ID,
+	).Scan(&wasPosted, &allocated)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("failed to query refund: %w", err)
+	}
+	post := r.Posted && !wasPosted
+	unpost := wasPosted && (r.Status == "failed" || r.Status == "canceled")
+
+	var reversed []service.Allocation
+	if post {
+		reversed, err = reverse(payment, &r)
+		if err != nil {
+			return err
+		}
+	}
+
+	// the refund keeps what it took back until it is posted or unposted
+	posted, taken := wasPosted, allocated
+	switch {
+	case post:
+		posted, taken = true, r.Allocated
+	case unpost:
+		posted, taken = false, 0
+	}
+	if _, err := tx.Exec(`
+		INSERT INTO refund (id, created, updated, payment, status, amount, currency, allocated, posted)
+		VALUES (?1, ?2, unixepoch(), ?3, ?4, ?5, ?6, ?7, ?8)
+		ON CONFLICT(id) DO UPDATE
+			SET updated=unixepoch(),
+				status=excluded.status,
+				allocated=excluded.allocated,
+				posted=excluded.posted;`,
+		r.ID,
+		r.Created.Unix(),
+		r.Payment,
+		r.Status,
+		r.Amount,
+		r.Currency,
+		taken,
+		posted,
+	); err != nil {
+		return fmt.Errorf("failed to insert refund: %w", err)
+	}
+
+	switch {
+	case post:
+		for _, a := range reversed {
+			row := txRow{
+				id:      fmt.Sprintf("%s:%s:%s", r.Payment, a.Ledger, r.ID),
+				ledger:  a.Ledger,
+				amount:  -a.Amount,
+				date:    r.Created.Unix(),
+				label:   "refund",
+				payment: sql.NullString{String: r.Payment, Valid: true},
+			}
+			if err := insertTransaction(tx, row); err != nil {
+				return err
+			}
+		}
+		if err := updateRefundedPayment(tx, r.Payment, r.Amount, r.Allocated); err != nil {
+			return err
+		}
+
+	case unpost:
+		if err := reverseRefundEntries(tx, r.Payment, r.ID, date); err != nil {
+			return err
+		}
+		if err := updateRefundedPayment(tx, r.Payment, -r.Amount, -allocated); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// updateRefundedPayment adds refunded to the refunded amount of a payment
+// and takes allocated from its allocated amount, and sets its status from
+// the refunded total
+func updateRefundedPayment(
+	tx *sql.Tx,
+	payment string,
+	refunded int,
+	allocated int,
+) error {
+	if _, err := tx.Exec(`
+		UPDATE payment
+		SET refunded=refunded + ?2,
+			allocated=allocated - ?3,
+			status=CASE
+				WHEN refunded + ?2 >= amount THEN 'refunded'
+				WHEN refunded + ?2 > 0 THEN 'partially_refunded'
+				ELSE 'succeeded'
+			END,
+			updated=unixepoch()
+		WHERE id=?1;
+		`,
+		payment,
+		refunded,
+		allocated,
+	); err != nil {
+		return fmt.Errorf("failed to update payment %s: %w", payment, err)
+	}
+	return nil
+}
+
+// reverseRefundEntries posts a reversal, dated at date, of every entry a
+// refund posted that has not been reversed yet
+func reverseRefundEntries(
+	tx *sql.Tx,
+	payment string,
+	refund string,
+	date int64,
+) error {
+	rows, err := tx.Query(`
+		SELECT id, ledger, amount
+		FROM tx t
+		WHERE payment=?1
+			AND id=payment || ':' || ledger || ':' || ?2
+			AND NOT EXISTS (SELECT 1 FROM tx r WHERE r.reverses=t.id)
+		ORDER BY rowid;
+		`,
+		payment,
+		refund,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to query refund entries: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []txRow
+	for rows.Next() {
+		var r txRow
+		if err := rows.Scan(&r.id, &r.ledger, &r.amount); err != nil {
+			return err
+		}
+		entries = append(entries, r)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	for _, e := range entries {
+		row := txRow{
+			id:       e.id + ":reversal",
+			ledger:   e.ledger,
+			amount:   -e.amount,
+			date:     date,
+			label:    "reversal",
+			reverses: sql.NullString{String: e.id, Valid: true},
+			payment:  sql.NullString{String: payment, Valid: true},
+		}
+		if err := insertTransaction(tx, row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func scanRefund(
+	row rowScanner,
+) (
+	*service.Refund,
+	error,
+) {
+	var (
+		r       service.Refund
+		created int64
+	)
+	if err := row.Scan(
+		&r.ID,
+		&created,
+		&r.Payment,
+		&r.Status,
+		&r.Amount,
+		&r.Currency,
+		&r.Allocated,
+		&r.Posted,
+	); err != nil {
+		return nil, err
+	}
+	r.Created = time.Unix(created, 0)
+
+	return &r, nil
+}
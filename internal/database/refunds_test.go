@@ -0,0 +1,86 @@
+package database_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"git.sr.ht/~jakintosh/coffer/internal/service"
+	"git.sr.ht/~jakintosh/coffer/internal/testutil"
+)
+
+func TestInsertRefund(t *testing.T) {
+	env := testutil.SetupTestEnv(t)
+
+	shares := []service.Allocation{{Ledger: "general", Amount: 1000}}
+	if err := env.DB.InsertPayment(makePayment("pi_1", 100, "succeeded", 1000, 1), shares, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	refund := service.Refund{
+		ID:       "re_1",
+		Payment:  "pi_1",
+		Created:  time.Unix(200, 0),
+		Status:   "pending",
+		Amount:   400,
+		Currency: "usd",
+	}
+	if err := env.DB.InsertRefund(refund, nil, 200); err != nil {
+		t.Fatalf("InsertRefund failed: %v", err)
+	}
+
+	// posting twice only reverses the shares once
+	refund.Status = "succeeded"
+	refund.Posted = true
+	calls := 0
+	reverse := func(p *service.PaymentAllocation, r *service.Refund) ([]service.Allocation, error) {
+		calls++
+		if p.ID != "pi_1" || len(p.Posted) != 1 || p.Posted[0].Amount != 1000 {
+			t.Errorf("unexpected payment %+v", p)
+		}
+		r.Allocated = 400
+		return []service.Allocation{{Ledger: "general", Amount: 400}}, nil
+	}
+	for range 2 {
+		if err := env.DB.InsertRefund(refund, reverse, 200); err != nil {
+			t.Fatalf("InsertRefund failed: %v", err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("want 1 reversal got %d", calls)
+	}
+
+	got, err := env.DB.GetRefund("re_1")
+	if err != nil {
+		t.Fatalf("GetRefund failed: %v", err)
+	}
+	if got.Status != "succeeded" || !got.Posted || got.Allocated != 400 {
+		t.Errorf("unexpected refund %+v", got)
+	}
+	tx, err := env.DB.GetTransaction("pi_1:general:re_1")
+	if err != nil {
+		t.Fatalf("GetTransaction failed: %v", err)
+	}
+	if tx.Amount != -400 || tx.Date.Unix() != 200 {
+		t.Errorf("unexpected refund entry %+v", tx)
+	}
+
+	payment, err := env.DB.GetPaymentAllocation("pi_1")
+	if err != nil {
+		t.Fatalf("GetPaymentAllocation failed: %v", err)
+	}
+	if payment.Status != "partially_refunded" || payment.Refunded != 400 || payment.Allocated != 600 {
+		t.Errorf("unexpected payment %+v", payment.Payment)
+	}
+	if len(payment.Posted) != 1 || payment.Posted[0].Amount != 600 {
+		t.Errorf("unexpected posted shares %+v", payment.Posted)
+	}
+}
+
+func TestGetRefundNotFound(t *testing.T) {
+	env := testutil.SetupTestEnv(t)
+
+	if _, err := env.DB.GetRefund("re_missing"); !errors.Is(err, service.ErrRefundNotFound) {
+		t.Errorf("want ErrRefundNotFound got %v", err)
+	}
+}
@@ -0,0 +1,164 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"git.sr.ht/~jakintosh/coffer/internal/service"
+)
+
+const (
+	settingFeeMode   = "fees.mode"
+	settingFeeLedger = "fees.ledger"
+
+	settingSyncSince    = "stripe.sync.since"
+	settingSyncStarted  = "stripe.sync.started"
+	settingSyncResource = "stripe.sync.resource"
+	settingSyncAfter    = "stripe.sync.after"
+	settingSyncLast     = "stripe.sync.last"
+)
+
+func (db *DB) GetFeeSettings() (
+	*service.FeeSettings,
+	error,
+) {
+	mode, err := getSetting(db.Conn, settingFeeMode, service.FeeModeGross)
+	if err != nil {
+		return nil, err
+	}
+	ledger, err := getSetting(db.Conn, settingFeeLedger, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return &service.FeeSettings{
+		Mode:   mode,
+		Ledger: ledger,
+	}, nil
+}
+
+func (db *DB) SetFeeSettings(
+	fees service.FeeSettings,
+) error {
+	tx, err := db.Conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := setSetting(tx, settingFeeMode, fees.Mode); err != nil {
+		return err
+	}
+	if err := setSetting(tx, settingFeeLedger, fees.Ledger); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (db *DB) GetStripeSync() (
+	*service.StripeSync,
+	error,
+) {
+	sync := &service.StripeSync{}
+	times := []struct {
+		key string
+		t   *time.Time
+	}{
+		{settingSyncSince, &sync.Since},
+		{settingSyncStarted, &sync.Started},
+		{settingSyncLast, &sync.Last},
+	}
+	for _, f := range times {
+		value, err := getSetting(db.Conn, f.key, "0")
+		if err != nil {
+			return nil, err
+		}
+		unix, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid setting %s: %w", f.key, err)
+		}
+		*f.t = time.Unix(unix, 0)
+	}
+
+	var err error
+	if sync.Resource, err = getSetting(db.Conn, settingSyncResource, ""); err != nil {
+		return nil, err
+	}
+	if sync.After, err = getSetting(db.Conn, settingSyncAfter, ""); err != nil {
+		return nil, err
+	}
+
+	return sync, nil
+}
+
+func (db *DB) SetStripeSync(
+	sync service.StripeSync,
+) error {
+	tx, err := db.Conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	values := map[string]string{
+		settingSyncSince:    strconv.FormatInt(sync.Since.Unix(), 10),
+		settingSyncStarted:  strconv.FormatInt(sync.Started.Unix(), 10),
+		settingSyncResource: sync.Resource,
+		settingSyncAfter:    sync.After,
+		settingSyncLast:     strconv.FormatInt(sync.Last.Unix(), 10),
+	}
+	for key, value := range values {
+		if err := setSetting(tx, key, value); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// getSetting returns the value stored for key, or fallback if it was never
+// set.
+func getSetting(
+	conn queryExecer,
+	key string,
+	fallback string,
+) (
+	string,
+	error,
+) {
+	var value string
+	err := conn.QueryRow(`
+		SELECT value
+		FROM setting
+		WHERE key=?1;
+		`,
+		key,
+	).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fallback, nil
+	} else if err != nil {
+		return "", fmt.Errorf("failed to query setting %s: %w", key, err)
+	}
+
+	return value, nil
+}
+
+func setSetting(
+	conn queryExecer,
+	key string,
+	value string,
+) error {
+	_, err := conn.Exec(`
+		INSERT INTO setting (key, value)
+		VALUES(?1, ?2)
+		ON CONFLICT(key) DO UPDATE
+			SET value=excluded.value;`,
+		key,
+		value,
+	)
+	return err
+}
@@ -0,0 +1,67 @@
+package database_test
+
+import (
+	"testing"
+
+	"git.sr.ht/~jakintosh/coffer/internal/service"
+	"git.sr.ht/~jakintosh/coffer/internal/testutil"
+)
+
+func TestFeeSettings(t *testing.T) {
+	env := testutil.SetupTestEnv(t)
+
+	fees, err := env.DB.GetFeeSettings()
+	if err != nil {
+		t.Fatalf("GetFeeSettings failed: %v", err)
+	}
+	if fees.Mode != service.FeeModeGross || fees.Ledger != "" {
+		t.Fatalf("unexpected default fee settings %+v", fees)
+	}
+
+	if err := env.DB.SetFeeSettings(service.FeeSettings{Mode: service.FeeModeLedger, Ledger: "fees"}); err != nil {
+		t.Fatalf("SetFeeSettings failed: %v", err)
+	}
+	if err := env.DB.SetFeeSettings(service.FeeSettings{Mode: service.FeeModeNet}); err != nil {
+		t.Fatalf("SetFeeSettings update failed: %v", err)
+	}
+
+	fees, err = env.DB.GetFeeSettings()
+	if err != nil {
+		t.Fatalf("GetFeeSettings failed: %v", err)
+	}
+	if fees.Mode != service.FeeModeNet || fees.Ledger != "" {
+		t.Errorf("unexpected fee settings %+v", fees)
+	}
+}
+
+func TestStripeSync(t *testing.T) {
+	env := testutil.SetupTestEnv(t)
+
+	sync, err := env.DB.GetStripeSync()
+	if err != nil {
+		t.Fatalf("GetStripeSync failed: %v", err)
+	}
+	if sync.Resource != "" || sync.After != "" || sync.Last.Unix() != 0 {
+		t.Fatalf("unexpected default sync %+v", sync)
+	}
+
+	want := service.StripeSync{
+		Since:    testutil.MakeDate(2025, 1, 1),
+		Started:  testutil.MakeDate(2025, 6, 1),
+		Resource: "payments",
+		After:    "pi_1",
+		Last:     testutil.MakeDate(2025, 5, 1),
+	}
+	if err := env.DB.SetStripeSync(want); err != nil {
+		t.Fatalf("SetStripeSync failed: %v", err)
+	}
+
+	sync, err = env.DB.GetStripeSync()
+	if err != nil {
+		t.Fatalf("GetStripeSync failed: %v", err)
+	}
+	if !sync.Since.Equal(want.Since) || !sync.Started.Equal(want.Started) || !sync.Last.Equal(want.Last) ||
+		sync.Resource != want.Resource || sync.After != want.After {
+		t.Errorf("want %+v got %+v", want, sync)
+	}
+}
@@ -1,6 +1,13 @@
 package database
 
-import "database/sql"
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"git.sr.ht/~jakintosh/coffer/internal/service"
+)
 
 func (db *DB) InsertCustomer(
 	id string,
@@ -26,52 +33,113 @@ func (db *DB) InsertCustomer(
 	return err
 }
 
-func (db *DB) InsertSubscription(
-	id string,
-	created int64,
-	customerID, status string,
-	amount int64,
-	currency string,
-) error {
-	_, err := db.Conn.Exec(`
-		INSERT INTO subscription (id, created, customer, status, amount, currency)
-		VALUES(?1, ?2, ?3, ?4, ?5, ?6)
-		ON CONFLICT(id) DO UPDATE
-			SET updated=unixepoch(),
-				status=excluded.status,
-				amount=excluded.amount,
-				currency=excluded.currency;`,
-		id,
-		created,
-		customerID,
-		status,
-		amount,
-		currency,
-	)
-	return err
-}
-
+// InsertPayment records a payment and, in the same transaction, posts its
+// shares as "<payment id>:<ledger>" and its fee as a negative entry to
+// feeLedger unless that is empty. Entries are only posted the first time
+// the payment is recorded as allocated, so a retried payment posts nothing.
 func (db *DB) InsertPayment(
-	id string,
-	created int64,
-	status, customer string,
-	amount int64,
-	currency string,
+	p service.Payment,
+	shares []service.Allocation,
+	feeLedger string,
 ) error {
-	_, err := db.Conn.Exec(`
-		INSERT INTO payment (id, created, status, customer, amount, currency)
-		VALUES(?1, ?2, ?3, ?4, ?5, ?6)
+	var setNullInt sql.NullInt64
+	if p.AllocationSet != 0 {
+		setNullInt.Int64 = p.AllocationSet
+		setNullInt.Valid = true
+	}
+	var balanceTxNullStr sql.NullString
+	if p.BalanceTransaction != "" {
+		balanceTxNullStr.String = p.BalanceTransaction
+		balanceTxNullStr.Valid = true
+	}
+	var chargeNullStr sql.NullString
+	if p.Charge != "" {
+		chargeNullStr.String = p.Charge
+		chargeNullStr.Valid = true
+	}
+	// a payment waiting on its fee has not been allocated yet
+	var allocatedNullInt sql.NullInt64
+	if !p.Pending {
+		allocatedNullInt.Int64 = int64(p.Allocated)
+		allocatedNullInt.Valid = true
+	}
+
+	tx, err := db.Conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var allocated sql.NullInt64
+	err = tx.QueryRow(`
+		SELECT allocated
+		FROM payment
+		WHERE id=?1;`,
+		p.ID,
+	).Scan(&allocated)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO payment (id, created, status, customer, amount, currency, fee, balance_transaction, allocated, allocation_set, charge)
+		VALUES(?1, ?2, ?3, ?4, ?5, ?6, ?7, ?8, ?9, ?10, ?11)
 		ON CONFLICT(id) DO UPDATE
 			SET updated=unixepoch(),
-				status=excluded.status;`,
-		id,
-		created,
-		status,
-		customer,
-		amount,
-		currency,
+				status=CASE WHEN payment.refunded>0 THEN payment.status ELSE excluded.status END,
+				fee=CASE WHEN excluded.balance_transaction IS NULL THEN payment.fee ELSE excluded.fee END,
+				balance_transaction=COALESCE(excluded.balance_transaction, payment.balance_transaction),
+				allocated=COALESCE(payment.allocated, excluded.allocated),
+				allocation_set=COALESCE(payment.allocation_set, excluded.allocation_set),
+				charge=COALESCE(excluded.charge, payment.charge);`,
+		p.ID,
+		p.Created.Unix(),
+		p.Status,
+		p.Customer,
+		p.Amount,
+		p.Currency,
+		p.Fee,
+		balanceTxNullStr,
+		allocatedNullInt,
+		setNullInt,
+		chargeNullStr,
 	)
-	return err
+	if err != nil {
+		return err
+	}
+
+	if allocated.Valid || p.Pending {
+		return tx.Commit()
+	}
+
+	for _, a := range shares {
+		row := txRow{
+			id:      fmt.Sprintf("%s:%s", p.ID, a.Ledger),
+			ledger:  a.Ledger,
+			amount:  a.Amount,
+			date:    p.Created.Unix(),
+			label:   "patron",
+			payment: sql.NullString{String: p.ID, Valid: true},
+		}
+		if err := insertTransaction(tx, row); err != nil {
+			return err
+		}
+	}
+	if feeLedger != "" && p.Fee > 0 {
+		row := txRow{
+			id:      "fee:" + p.ID,
+			ledger:  feeLedger,
+			amount:  -p.Fee,
+			date:    p.Created.Unix(),
+			label:   "stripe fee",
+			payment: sql.NullString{String: p.ID, Valid: true},
+		}
+		if err := insertTransaction(tx, row); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
 }
 
 func (db *DB) InsertPayout(
@@ -95,3 +163,284 @@ func (db *DB) InsertPayout(
 	)
 	return err
 }
+
+func (db *DB) GetPayment(
+	id string,
+) (
+	*service.Payment,
+	error,
+) {
+	return getPayment(db.Conn, id)
+}
+
+func getPayment(
+	conn queryExecer,
+	id string,
+) (
+	*service.Payment,
+	error,
+) {
+	row := conn.QueryRow(`
+		SELECT id, created, status, customer, amount, currency, fee, balance_transaction, charge, allocated, allocation_set, refunded
+		FROM payment
+		WHERE id=?1;
+		`,
+		id,
+	)
+	p, err := scanPayment(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, service.ErrPaymentNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// GetPaymentAllocations returns the payments created between since and
+// until, oldest first, with the net amount posted to each ledger for them
+// and their held dispute funds.
+// Posted amounts include every transaction recorded for the payment, so
+// refunds and reallocations are counted along with the original shares,
+// while fees and dispute holds recorded for the payment are not.
+func (db *DB) GetPaymentAllocations(
+	since int64,
+	until int64,
+) (
+	[]service.PaymentAllocation,
+	error,
+) {
+	rows, err := db.Conn.Query(`
+		SELECT id, created, status, customer, amount, currency, fee, balance_transaction, charge, allocated, allocation_set, refunded
+		FROM payment
+		WHERE created>=?1
+			AND created<=?2
+			AND allocated IS NOT NULL
+		ORDER BY created, id;
+		`,
+		since,
+		until,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	payments := []service.PaymentAllocation{}
+	index := map[string]int{}
+	for rows.Next() {
+		p, err := scanPayment(rows)
+		if err != nil {
+			return nil, err
+		}
+		index[p.ID] = len(payments)
+		payments = append(payments, service.PaymentAllocation{
+			Payment: *p,
+			Posted:  []service.Allocation{},
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	rows, err = db.Conn.Query(`
+		SELECT p.id, t.ledger, SUM(t.amount)
+		FROM payment p
+		JOIN tx t ON t.payment=p.id
+		WHERE p.created>=?1
+			AND p.created<=?2
+			AND `+shareEntry+`
+		GROUP BY p.id, t.ledger
+		ORDER BY p.id, MIN(t.rowid);
+		`,
+		since,
+		until,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			id string
+			a  service.Allocation
+		)
+		if err := rows.Scan(&id, &a.Ledger, &a.Amount); err != nil {
+			return nil, err
+		}
+		if i, ok := index[id]; ok {
+			payments[i].Posted = append(payments[i].Posted, a)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	rows, err = db.Conn.Query(`
+		SELECT d.payment, SUM(d.held)
+		FROM dispute d
+		JOIN payment p ON p.id=d.payment
+		WHERE p.created>=?1
+			AND p.created<=?2
+			AND d.stage IN (?3, ?4)
+		GROUP BY d.payment;
+		`,
+		since,
+		until,
+		service.DisputeHeld,
+		service.DisputeFinalized,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			id   string
+			held int
+		)
+		if err := rows.Scan(&id, &held); err != nil {
+			return nil, err
+		}
+		if i, ok := index[id]; ok {
+			payments[i].Held = held
+		}
+	}
+
+	return payments, rows.Err()
+}
+
+// GetPaymentAllocation returns a payment with the net amount currently
+// posted to each ledger for it and its held dispute funds, counted as in
+// GetPaymentAllocations.
+func (db *DB) GetPaymentAllocation(
+	id string,
+) (
+	*service.PaymentAllocation,
+	error,
+) {
+	return getPaymentAllocation(db.Conn, id)
+}
+
+// shareEntry matches the entries of a payment, as t, that make up its
+// ledger shares. Fees and dispute holds are recorded for the payment too,
+// but are left out along with their reversals.
+const shareEntry = `COALESCE((SELECT o.label FROM tx o WHERE o.id=t.reverses), t.label)
+		NOT IN ('stripe fee', 'dispute hold', 'dispute fee', 'dispute lost')`
+
+func getPaymentAllocation(
+	conn queryExecer,
+	id string,
+) (
+	*service.PaymentAllocation,
+	error,
+) {
+	p, err := getPayment(conn, id)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := conn.Query(`
+		SELECT t.ledger, SUM(t.amount)
+		FROM tx t
+		WHERE t.payment=?1
+			AND `+shareEntry+`
+		GROUP BY t.ledger
+		ORDER BY MIN(t.rowid);
+		`,
+		id,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	payment := &service.PaymentAllocation{
+		Payment: *p,
+		Posted:  []service.Allocation{},
+	}
+	for rows.Next() {
+		var a service.Allocation
+		if err := rows.Scan(&a.Ledger, &a.Amount); err != nil {
+			return nil, err
+		}
+		payment.Posted = append(payment.Posted, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	payment.Held, err = getDisputeHeld(conn, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return payment, nil
+}
+
+// getDisputeHeld returns what the disputes of a payment that were not won
+// have moved into the holding ledger
+func getDisputeHeld(
+	conn queryExecer,
+	payment string,
+) (
+	int,
+	error,
+) {
+	var held int
+	err := conn.QueryRow(`
+		SELECT COALESCE(SUM(held), 0)
+		FROM dispute
+		WHERE payment=?1
+			AND stage IN (?2, ?3);
+		`,
+		payment,
+		service.DisputeHeld,
+		service.DisputeFinalized,
+	).Scan(&held)
+	return held, err
+}
+
+func scanPayment(
+	row rowScanner,
+) (
+	*service.Payment,
+	error,
+) {
+	var (
+		p             service.Payment
+		created       int64
+		balanceTx     sql.NullString
+		charge        sql.NullString
+		allocated     sql.NullInt64
+		allocationSet sql.NullInt64
+	)
+	if err := row.Scan(
+		&p.ID,
+		&created,
+		&p.Status,
+		&p.Customer,
+		&p.Amount,
+		&p.Currency,
+		&p.Fee,
+		&balanceTx,
+		&charge,
+		&allocated,
+		&allocationSet,
+		&p.Refunded,
+	); err != nil {
+		return nil, err
+	}
+	p.Created = time.Unix(created, 0)
+	p.BalanceTransaction = balanceTx.String
+	p.Charge = charge.String
+	p.Allocated = int(allocated.Int64)
+	p.Pending = !allocated.Valid
+	p.AllocationSet = allocationSet.Int64
+
+	return &p, nil
+}
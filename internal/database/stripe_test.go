@@ -1,11 +1,52 @@
 package database_test
 
 import (
+	"database/sql"
 	"testing"
+	"time"
 
+	"git.sr.ht/~jakintosh/coffer/internal/service"
 	"git.sr.ht/~jakintosh/coffer/internal/testutil"
 )
 
+// makePayment builds a payment from cus_1 in usd, allocated in full
+func makePayment(
+	id string,
+	created int64,
+	status string,
+	amount int,
+	allocationSet int64,
+) service.Payment {
+	return service.Payment{
+		ID:            id,
+		Created:       time.Unix(created, 0),
+		Status:        status,
+		Customer:      "cus_1",
+		Amount:        amount,
+		Currency:      "usd",
+		Allocated:     amount,
+		AllocationSet: allocationSet,
+	}
+}
+
+func makeSubscription(
+	id string,
+	created int64,
+	customer string,
+	status string,
+	amount int,
+	currency string,
+) service.Subscription {
+	return service.Subscription{
+		ID:       id,
+		Customer: customer,
+		Created:  time.Unix(created, 0),
+		Status:   status,
+		Amount:   amount,
+		Currency: currency,
+	}
+}
+
 func TestInsertCustomer(t *testing.T) {
 	env := testutil.SetupTestEnv(t)
 
@@ -14,7 +55,7 @@ func TestInsertCustomer(t *testing.T) {
 		t.Fatalf("InsertCustomer failed: %v", err)
 	}
 
-	patrons, err := env.DB.GetCustomers(10, 0)
+	patrons, err := env.DB.GetCustomers(nil, 10, 0)
 	if err != nil {
 		t.Fatalf("GetCustomers failed: %v", err)
 	}
@@ -39,7 +80,7 @@ func TestInsertCustomerUpsert(t *testing.T) {
 		t.Fatalf("InsertCustomer upsert failed: %v", err)
 	}
 
-	patrons, err := env.DB.GetCustomers(10, 0)
+	patrons, err := env.DB.GetCustomers(nil, 10, 0)
 	if err != nil {
 		t.Fatalf("GetCustomers failed: %v", err)
 	}
@@ -58,7 +99,7 @@ func TestInsertCustomerNullName(t *testing.T) {
 		t.Fatalf("InsertCustomer with nil name failed: %v", err)
 	}
 
-	patrons, err := env.DB.GetCustomers(10, 0)
+	patrons, err := env.DB.GetCustomers(nil, 10, 0)
 	if err != nil {
 		t.Fatalf("GetCustomers failed: %v", err)
 	}
@@ -73,7 +114,7 @@ func TestInsertCustomerNullName(t *testing.T) {
 func TestInsertSubscription(t *testing.T) {
 	env := testutil.SetupTestEnv(t)
 
-	if err := env.DB.InsertSubscription("sub_123", 1700000000, "cus_123", "active", 1000, "usd"); err != nil {
+	if err := env.DB.InsertSubscription(makeSubscription("sub_123", 1700000000, "cus_123", "active", 1000, "usd"), 0, nil); err != nil {
 		t.Fatalf("InsertSubscription failed: %v", err)
 	}
 
@@ -92,11 +133,11 @@ func TestInsertSubscription(t *testing.T) {
 func TestInsertSubscriptionUpsert(t *testing.T) {
 	env := testutil.SetupTestEnv(t)
 
-	if err := env.DB.InsertSubscription("sub_123", 1700000000, "cus_123", "active", 1000, "usd"); err != nil {
+	if err := env.DB.InsertSubscription(makeSubscription("sub_123", 1700000000, "cus_123", "active", 1000, "usd"), 0, nil); err != nil {
 		t.Fatalf("InsertSubscription failed: %v", err)
 	}
 
-	if err := env.DB.InsertSubscription("sub_123", 1700000000, "cus_123", "canceled", 2000, "usd"); err != nil {
+	if err := env.DB.InsertSubscription(makeSubscription("sub_123", 1700000000, "cus_123", "canceled", 2000, "usd"), 0, nil); err != nil {
 		t.Fatalf("InsertSubscription upsert failed: %v", err)
 	}
 
@@ -112,7 +153,7 @@ func TestInsertSubscriptionUpsert(t *testing.T) {
 func TestInsertPayment(t *testing.T) {
 	env := testutil.SetupTestEnv(t)
 
-	if err := env.DB.InsertPayment("pi_123", 1700000000, "succeeded", "cus_123", 5000, "usd"); err != nil {
+	if err := env.DB.InsertPayment(makePayment("pi_123", 1700000000, "succeeded", 5000, 0), nil, ""); err != nil {
 		t.Fatalf("InsertPayment failed: %v", err)
 	}
 }
@@ -120,13 +161,149 @@ func TestInsertPayment(t *testing.T) {
 func TestInsertPaymentUpsert(t *testing.T) {
 	env := testutil.SetupTestEnv(t)
 
-	if err := env.DB.InsertPayment("pi_123", 1700000000, "processing", "cus_123", 5000, "usd"); err != nil {
+	if err := env.DB.InsertPayment(makePayment("pi_123", 1700000000, "processing", 5000, 0), nil, ""); err != nil {
+		t.Fatalf("InsertPayment failed: %v", err)
+	}
+
+	if err := env.DB.InsertPayment(makePayment("pi_123", 1700000000, "succeeded", 5000, 0), nil, ""); err != nil {
+		t.Fatalf("InsertPayment upsert failed: %v", err)
+	}
+}
+
+func TestInsertPaymentKeepsAllocationSet(t *testing.T) {
+	env := testutil.SetupTestEnv(t)
+
+	if err := env.DB.InsertPayment(makePayment("pi_123", 1700000000, "processing", 5000, 1), nil, ""); err != nil {
+		t.Fatalf("InsertPayment failed: %v", err)
+	}
+	if err := env.DB.InsertPayment(makePayment("pi_123", 1700000000, "succeeded", 5000, 2), nil, ""); err != nil {
+		t.Fatalf("InsertPayment upsert failed: %v", err)
+	}
+
+	payment, err := env.DB.GetPayment("pi_123")
+	if err != nil {
+		t.Fatalf("GetPayment failed: %v", err)
+	}
+	if payment.Status != "succeeded" || payment.AllocationSet != 1 {
+		t.Errorf("unexpected payment %+v", payment)
+	}
+}
+
+func TestInsertPaymentFee(t *testing.T) {
+	env := testutil.SetupTestEnv(t)
+
+	// recorded before the charge settles, without a fee or allocation
+	pending := makePayment("pi_123", 1700000000, "processing", 5000, 0)
+	pending.Allocated = 0
+	pending.Pending = true
+	if err := env.DB.InsertPayment(pending, nil, ""); err != nil {
 		t.Fatalf("InsertPayment failed: %v", err)
 	}
+	payments, err := env.DB.GetPaymentAllocations(0, 1800000000)
+	if err != nil {
+		t.Fatalf("GetPaymentAllocations failed: %v", err)
+	}
+	if len(payments) != 0 {
+		t.Errorf("expected unallocated payment to be skipped, got %+v", payments)
+	}
 
-	if err := env.DB.InsertPayment("pi_123", 1700000000, "succeeded", "cus_123", 5000, "usd"); err != nil {
+	settled := makePayment("pi_123", 1700000000, "succeeded", 5000, 1)
+	settled.Fee = 175
+	settled.BalanceTransaction = "txn_123"
+	settled.Allocated = 4825
+	if err := env.DB.InsertPayment(settled, nil, ""); err != nil {
 		t.Fatalf("InsertPayment upsert failed: %v", err)
 	}
+
+	// a later update without the balance transaction keeps the fee
+	if err := env.DB.InsertPayment(makePayment("pi_123", 1700000000, "succeeded", 5000, 0), nil, ""); err != nil {
+		t.Fatalf("InsertPayment upsert failed: %v", err)
+	}
+
+	payment, err := env.DB.GetPayment("pi_123")
+	if err != nil {
+		t.Fatalf("GetPayment failed: %v", err)
+	}
+	if payment.Fee != 175 || payment.BalanceTransaction != "txn_123" || payment.Allocated != 4825 || payment.AllocationSet != 1 {
+		t.Errorf("unexpected payment %+v", payment)
+	}
+}
+
+func TestInsertPaymentShares(t *testing.T) {
+	env := testutil.SetupTestEnv(t)
+
+	payment := makePayment("pi_123", 1700000000, "succeeded", 5000, 1)
+	payment.Fee = 175
+	shares := []service.Allocation{{Ledger: "general", Amount: 5000}}
+	if err := env.DB.InsertPayment(payment, shares, "fees"); err != nil {
+		t.Fatalf("InsertPayment failed: %v", err)
+	}
+
+	// entries are only posted the first time the payment is allocated
+	shares[0].Amount = 4000
+	if err := env.DB.InsertPayment(payment, shares, "fees"); err != nil {
+		t.Fatalf("InsertPayment retry failed: %v", err)
+	}
+	allocation, err := env.DB.GetPaymentAllocation("pi_123")
+	if err != nil {
+		t.Fatalf("GetPaymentAllocation failed: %v", err)
+	}
+	if len(allocation.Posted) != 1 || allocation.Posted[0].Amount != 5000 {
+		t.Errorf("unexpected shares %+v", allocation.Posted)
+	}
+	fee, err := env.DB.GetTransaction("fee:pi_123")
+	if err != nil {
+		t.Fatalf("GetTransaction failed: %v", err)
+	}
+	if fee.Ledger != "fees" || fee.Amount != -175 {
+		t.Errorf("unexpected fee %+v", fee)
+	}
+	var feePayment sql.NullString
+	if err := env.DB.Conn.QueryRow(`SELECT payment FROM tx WHERE id='fee:pi_123';`).Scan(&feePayment); err != nil {
+		t.Fatal(err)
+	}
+	if feePayment.String != "pi_123" {
+		t.Errorf("want fee recorded for pi_123 got %q", feePayment.String)
+	}
+
+	// a failed entry leaves no payment behind
+	if err := env.DB.InsertTransaction("pi_456:general", "general", 1, 1700000000, "manual"); err != nil {
+		t.Fatal(err)
+	}
+	other := makePayment("pi_456", 1700000000, "succeeded", 1, 1)
+	if err := env.DB.InsertPayment(other, []service.Allocation{{Ledger: "general", Amount: 1}}, ""); err != service.ErrTxConflict {
+		t.Fatalf("want ErrTxConflict got %v", err)
+	}
+	if _, err := env.DB.GetPayment("pi_456"); err != service.ErrPaymentNotFound {
+		t.Errorf("want ErrPaymentNotFound got %v", err)
+	}
+}
+
+func TestInsertPaymentZeroAllocation(t *testing.T) {
+	env := testutil.SetupTestEnv(t)
+
+	// a payment whose fee took all of it is allocated, not pending
+	payment := makePayment("pi_123", 1700000000, "succeeded", 50, 1)
+	payment.Fee = 50
+	payment.BalanceTransaction = "txn_123"
+	payment.Allocated = 0
+	if err := env.DB.InsertPayment(payment, nil, ""); err != nil {
+		t.Fatalf("InsertPayment failed: %v", err)
+	}
+	stored, err := env.DB.GetPayment("pi_123")
+	if err != nil {
+		t.Fatalf("GetPayment failed: %v", err)
+	}
+	if stored.Pending || stored.Allocated != 0 {
+		t.Errorf("unexpected payment %+v", stored)
+	}
+	payments, err := env.DB.GetPaymentAllocations(0, 1800000000)
+	if err != nil {
+		t.Fatalf("GetPaymentAllocations failed: %v", err)
+	}
+	if len(payments) != 1 {
+		t.Errorf("expected allocated payment, got %+v", payments)
+	}
 }
 
 func TestInsertPayout(t *testing.T) {
@@ -148,3 +325,41 @@ func TestInsertPayoutUpsert(t *testing.T) {
 		t.Fatalf("InsertPayout upsert failed: %v", err)
 	}
 }
+
+func TestGetPaymentAllocations(t *testing.T) {
+	env := testutil.SetupTestEnv(t)
+	testutil.SeedLedgers(t, env.Service, "community")
+
+	shares := []service.Allocation{
+		{Ledger: "general", Amount: 600},
+		{Ledger: "community", Amount: 400},
+	}
+	if err := env.DB.InsertPayment(makePayment("pi_1", 100, "succeeded", 1000, 1), shares, ""); err != nil {
+		t.Fatal(err)
+	}
+	shares = []service.Allocation{{Ledger: "general", Amount: 500}}
+	if err := env.DB.InsertPayment(makePayment("pi_2", 300, "succeeded", 500, 1), shares, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	// entries that only share the prefix of a payment are not its shares
+	for _, id := range []string{"pi_10:general", "pi_1:general:manual"} {
+		if err := env.DB.InsertTransaction(id, "general", 999, 100, "patron"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	payments, err := env.DB.GetPaymentAllocations(0, 200)
+	if err != nil {
+		t.Fatalf("GetPaymentAllocations failed: %v", err)
+	}
+	if len(payments) != 1 || payments[0].ID != "pi_1" {
+		t.Fatalf("unexpected payments %+v", payments)
+	}
+
+	// only transactions recorded for the payment are counted
+	posted := payments[0].Posted
+	if len(posted) != 2 || posted[0].Ledger != "general" || posted[0].Amount != 600 || posted[1].Amount != 400 {
+		t.Errorf("unexpected posted amounts %+v", posted)
+	}
+}
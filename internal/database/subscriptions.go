@@ -0,0 +1,307 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"git.sr.ht/~jakintosh/coffer/internal/service"
+)
+
+// InsertSubscription records the state of a subscription as of changed
+// and, if diff is given and finds a change from the state recorded before,
+// appends it to its history, in a single transaction. A state older than
+// the one recorded is ignored.
+func (db *DB) InsertSubscription(
+	sub service.Subscription,
+	changed int64,
+	diff service.SubscriptionDiff,
+) error {
+	tx, err := db.Conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	prev, err := getSubscription(tx, sub.ID)
+	if err != nil && !errors.Is(err, service.ErrSubscriptionNotFound) {
+		return err
+	}
+
+	res, err := tx.Exec(`
+		INSERT INTO subscription (id, created, customer, status, amount, currency, current_period_end, cancel_at_period_end, cancel_at, canceled_at, paused, resumes_at, changed)
+		VALUES(?1, ?2, ?3, ?4, ?5, ?6, ?7, ?8, ?9, ?10, ?11, ?12, ?13)
+		ON CONFLICT(id) DO UPDATE
+			SET updated=unixepoch(),
+				status=excluded.status,
+				amount=excluded.amount,
+				currency=excluded.currency,
+				current_period_end=excluded.current_period_end,
+				cancel_at_period_end=excluded.cancel_at_period_end,
+				cancel_at=excluded.cancel_at,
+				canceled_at=excluded.canceled_at,
+				paused=excluded.paused,
+				resumes_at=excluded.resumes_at,
+				changed=excluded.changed
+			WHERE subscription.changed IS NULL
+				OR subscription.changed<=excluded.changed;`,
+		sub.ID,
+		sub.Created.Unix(),
+		sub.Customer,
+		sub.Status,
+		sub.Amount,
+		sub.Currency,
+		nullUnix(sub.CurrentPeriodEnd),
+		sub.CancelAtPeriodEnd,
+		nullUnix(sub.CancelAt),
+		nullUnix(sub.CanceledAt),
+		sub.Paused,
+		nullUnix(sub.ResumesAt),
+		changed,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to insert subscription: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		// a later state is already recorded
+		return nil
+	}
+
+	var change *service.SubscriptionChange
+	if diff != nil {
+		change = diff(prev)
+	}
+	if change != nil {
+		if _, err := tx.Exec(`
+			INSERT INTO subscription_change (subscription, date, status, amount, currency, paused, cancel_at)
+			VALUES (?1, ?2, ?3, ?4, ?5, ?6, ?7);`,
+			sub.ID,
+			change.Date.Unix(),
+			change.Status,
+			change.Amount,
+			change.Currency,
+			change.Paused,
+			nullUnix(change.CancelAt),
+		); err != nil {
+			return fmt.Errorf("failed to insert subscription change: %w", err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (db *DB) GetSubscription(
+	id string,
+) (
+	*service.Subscription,
+	error,
+) {
+	return getSubscription(db.Conn, id)
+}
+
+func getSubscription(
+	conn queryExecer,
+	id string,
+) (
+	*service.Subscription,
+	error,
+) {
+	row := conn.QueryRow(`
+		SELECT id, customer, created, status, amount, currency, current_period_end, cancel_at_period_end, cancel_at, canceled_at, paused, resumes_at
+		FROM subscription
+		WHERE id=?1;
+		`,
+		id,
+	)
+	sub, err := scanSubscription(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, service.ErrSubscriptionNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to query subscription: %w", err)
+	}
+
+	return sub, nil
+}
+
+// GetSubscriptionTimelines returns the subscriptions of a customer, oldest
+// first, each with its changes in the order they were recorded and its
+// invoices, oldest first
+func (db *DB) GetSubscriptionTimelines(
+	customer string,
+) (
+	[]service.SubscriptionTimeline,
+	error,
+) {
+	rows, err := db.Conn.Query(`
+		SELECT id, customer, created, status, amount, currency, current_period_end, cancel_at_period_end, cancel_at, canceled_at, paused, resumes_at
+		FROM subscription
+		WHERE customer=?1
+		ORDER BY created, id;
+		`,
+		customer,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query subscriptions: %w", err)
+	}
+	defer rows.Close()
+
+	timelines := []service.SubscriptionTimeline{}
+	index := map[string]int{}
+	for rows.Next() {
+		sub, err := scanSubscription(rows)
+		if err != nil {
+			return nil, err
+		}
+		index[sub.ID] = len(timelines)
+		timelines = append(timelines, service.SubscriptionTimeline{
+			Subscription: *sub,
+			Changes:      []service.SubscriptionChange{},
+			Invoices:     []service.Invoice{},
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	rows, err = db.Conn.Query(`
+		SELECT c.subscription, c.date, c.status, c.amount, c.currency, c.paused, c.cancel_at
+		FROM subscription_change c
+		JOIN subscription s ON s.id=c.subscription
+		WHERE s.customer=?1
+		ORDER BY c.subscription, c.id;
+		`,
+		customer,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query subscription changes: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			id       string
+			c        service.SubscriptionChange
+			date     int64
+			cancelAt sql.NullInt64
+		)
+		if err := rows.Scan(
+			&id,
+			&date,
+			&c.Status,
+			&c.Amount,
+			&c.Currency,
+			&c.Paused,
+			&cancelAt,
+		); err != nil {
+			return nil, err
+		}
+		c.Date = time.Unix(date, 0)
+		c.CancelAt = timeFromNull(cancelAt)
+		if i, ok := index[id]; ok {
+			timelines[i].Changes = append(timelines[i].Changes, c)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	rows, err = db.Conn.Query(`
+		SELECT `+invoiceColumns+`
+		FROM invoice
+		WHERE subscription IN (
+			SELECT id
+			FROM subscription
+			WHERE customer=?1
+		)
+		ORDER BY created, id;
+		`,
+		customer,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query subscription invoices: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		inv, err := scanInvoice(rows)
+		if err != nil {
+			return nil, err
+		}
+		if i, ok := index[inv.Subscription]; ok {
+			timelines[i].Invoices = append(timelines[i].Invoices, *inv)
+		}
+	}
+
+	return timelines, rows.Err()
+}
+
+func scanSubscription(
+	row rowScanner,
+) (
+	*service.Subscription,
+	error,
+) {
+	var (
+		sub              service.Subscription
+		created          int64
+		status           sql.NullString
+		amount           sql.NullInt64
+		currency         sql.NullString
+		currentPeriodEnd sql.NullInt64
+		cancelAt         sql.NullInt64
+		canceledAt       sql.NullInt64
+		resumesAt        sql.NullInt64
+	)
+	if err := row.Scan(
+		&sub.ID,
+		&sub.Customer,
+		&created,
+		&status,
+		&amount,
+		&currency,
+		&currentPeriodEnd,
+		&sub.CancelAtPeriodEnd,
+		&cancelAt,
+		&canceledAt,
+		&sub.Paused,
+		&resumesAt,
+	); err != nil {
+		return nil, err
+	}
+	sub.Created = time.Unix(created, 0)
+	sub.Status = status.String
+	sub.Amount = int(amount.Int64)
+	sub.Currency = currency.String
+	sub.CurrentPeriodEnd = timeFromNull(currentPeriodEnd)
+	sub.CancelAt = timeFromNull(cancelAt)
+	sub.CanceledAt = timeFromNull(canceledAt)
+	sub.ResumesAt = timeFromNull(resumesAt)
+
+	return &sub, nil
+}
+
+// nullUnix stores an optional time as unix seconds
+func nullUnix(
+	t *time.Time,
+) sql.NullInt64 {
+	if t == nil {
+		return sql.NullInt64{}
+	}
+	return sql.NullInt64{Int64: t.Unix(), Valid: true}
+}
+
+// timeFromNull reads an optional time stored as unix seconds
+func timeFromNull(
+	n sql.NullInt64,
+) *time.Time {
+	if !n.Valid {
+		return nil
+	}
+	t := time.Unix(n.Int64, 0)
+	return &t
+}
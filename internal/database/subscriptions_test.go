@@ -0,0 +1,134 @@
+package database_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"git.sr.ht/~jakintosh/coffer/internal/service"
+	"git.sr.ht/~jakintosh/coffer/internal/testutil"
+)
+
+func TestGetSubscription(t *testing.T) {
+	env := testutil.SetupTestEnv(t)
+
+	if _, err := env.DB.GetSubscription("sub_1"); !errors.Is(err, service.ErrSubscriptionNotFound) {
+		t.Fatalf("expected ErrSubscriptionNotFound, got %v", err)
+	}
+
+	periodEnd := time.Unix(2000, 0)
+	sub := makeSubscription("sub_1", 1000, "cus_1", "active", 500, "usd")
+	sub.CurrentPeriodEnd = &periodEnd
+	sub.CancelAtPeriodEnd = true
+	sub.CancelAt = &periodEnd
+	sub.Paused = true
+	if err := env.DB.InsertSubscription(sub, 0, nil); err != nil {
+		t.Fatalf("InsertSubscription failed: %v", err)
+	}
+
+	got, err := env.DB.GetSubscription("sub_1")
+	if err != nil {
+		t.Fatalf("GetSubscription failed: %v", err)
+	}
+	if !got.CancelAtPeriodEnd || !got.Paused || got.CanceledAt != nil || got.ResumesAt != nil {
+		t.Errorf("unexpected subscription %+v", got)
+	}
+	if got.CancelAt == nil || got.CancelAt.Unix() != 2000 || got.CurrentPeriodEnd.Unix() != 2000 {
+		t.Errorf("unexpected cancellation %+v", got)
+	}
+}
+
+func TestGetSubscriptionTimelines(t *testing.T) {
+	env := testutil.SetupTestEnv(t)
+
+	record := func(sub service.Subscription, date int64) {
+		diff := func(prev *service.Subscription) *service.SubscriptionChange {
+			return &service.SubscriptionChange{
+				Date:     time.Unix(date, 0),
+				Status:   sub.Status,
+				Amount:   sub.Amount,
+				Currency: sub.Currency,
+			}
+		}
+		if err := env.DB.InsertSubscription(sub, date, diff); err != nil {
+			t.Fatalf("InsertSubscription failed: %v", err)
+		}
+	}
+	record(makeSubscription("sub_2", 2000, "cus_1", "active", 800, "usd"), 2000)
+	record(makeSubscription("sub_1", 1000, "cus_1", "active", 500, "usd"), 1000)
+	record(makeSubscription("sub_1", 1000, "cus_1", "canceled", 500, "usd"), 1500)
+	record(makeSubscription("sub_3", 1000, "cus_2", "active", 100, "usd"), 1000)
+
+	timelines, err := env.DB.GetSubscriptionTimelines("cus_1")
+	if err != nil {
+		t.Fatalf("GetSubscriptionTimelines failed: %v", err)
+	}
+	if len(timelines) != 2 || timelines[0].ID != "sub_1" || timelines[1].ID != "sub_2" {
+		t.Fatalf("unexpected timelines %+v", timelines)
+	}
+	changes := timelines[0].Changes
+	if len(changes) != 2 || changes[0].Status != "active" || changes[1].Status != "canceled" {
+		t.Errorf("unexpected changes %+v", changes)
+	}
+	if timelines[0].Status != "canceled" || len(timelines[1].Changes) != 1 {
+		t.Errorf("unexpected timelines %+v", timelines)
+	}
+}
+
+func TestInsertSubscriptionStale(t *testing.T) {
+	env := testutil.SetupTestEnv(t)
+
+	var seen []*service.Subscription
+	record := func(status string, changed int64) {
+		diff := func(prev *service.Subscription) *service.SubscriptionChange {
+			seen = append(seen, prev)
+			return &service.SubscriptionChange{Date: time.Unix(changed, 0), Status: status}
+		}
+		sub := makeSubscription("sub_1", 1000, "cus_1", status, 500, "usd")
+		if err := env.DB.InsertSubscription(sub, changed, diff); err != nil {
+			t.Fatalf("InsertSubscription failed: %v", err)
+		}
+	}
+	record("active", 1000)
+	record("canceled", 3000)
+	record("past_due", 2000)
+
+	got, err := env.DB.GetSubscription("sub_1")
+	if err != nil {
+		t.Fatalf("GetSubscription failed: %v", err)
+	}
+	if got.Status != "canceled" {
+		t.Errorf("expected the later state to stay, got %q", got.Status)
+	}
+	if len(seen) != 2 || seen[0] != nil || seen[1].Status != "active" {
+		t.Errorf("unexpected previous states %+v", seen)
+	}
+
+	timelines, err := env.DB.GetSubscriptionTimelines("cus_1")
+	if err != nil {
+		t.Fatalf("GetSubscriptionTimelines failed: %v", err)
+	}
+	if changes := timelines[0].Changes; len(changes) != 2 || changes[1].Status != "canceled" {
+		t.Errorf("unexpected changes %+v", changes)
+	}
+}
+
+func TestGetCustomer(t *testing.T) {
+	env := testutil.SetupTestEnv(t)
+
+	if _, err := env.DB.GetCustomer("cus_1"); !errors.Is(err, service.ErrPatronNotFound) {
+		t.Fatalf("expected ErrPatronNotFound, got %v", err)
+	}
+
+	name := "Ada"
+	if err := env.DB.InsertCustomer("cus_1", 1000, &name); err != nil {
+		t.Fatal(err)
+	}
+	patron, err := env.DB.GetCustomer("cus_1")
+	if err != nil {
+		t.Fatalf("GetCustomer failed: %v", err)
+	}
+	if patron.Name != "Ada" || patron.CreatedAt.Unix() != 1000 {
+		t.Errorf("unexpected patron %+v", patron)
+	}
+}
@@ -0,0 +1,130 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAllocate(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		amount int
+		rules  []AllocationRule
+		want   []Allocation
+	}{
+		{
+			name:   "largest remainder",
+			amount: 777,
+			rules: []AllocationRule{
+				{LedgerName: "general", BasisPoints: 2500},
+				{LedgerName: "community", BasisPoints: 7500},
+			},
+			want: []Allocation{{"general", 194}, {"community", 583}},
+		},
+		{
+			name:   "ties go to earlier rules",
+			amount: 100,
+			rules: []AllocationRule{
+				{LedgerName: "a", BasisPoints: 3333},
+				{LedgerName: "b", BasisPoints: 3333},
+				{LedgerName: "c", BasisPoints: 3334},
+			},
+			want: []Allocation{{"a", 33}, {"b", 33}, {"c", 34}},
+		},
+		{
+			name:   "fixed before proportional",
+			amount: 1000,
+			rules: []AllocationRule{
+				{LedgerName: "general", BasisPoints: 5000},
+				{LedgerName: "hosting", Fixed: 200},
+				{LedgerName: "community", BasisPoints: 5000},
+			},
+			want: []Allocation{{"general", 400}, {"hosting", 200}, {"community", 400}},
+		},
+		{
+			name:   "fixed capped by amount",
+			amount: 150,
+			rules: []AllocationRule{
+				{LedgerName: "hosting", Fixed: 100},
+				{LedgerName: "fees", Fixed: 100},
+				{LedgerName: "general", BasisPoints: 10000},
+			},
+			want: []Allocation{{"hosting", 100}, {"fees", 50}},
+		},
+		{
+			name:   "shares merged per ledger",
+			amount: 1000,
+			rules: []AllocationRule{
+				{LedgerName: "general", Fixed: 100},
+				{LedgerName: "general", BasisPoints: 5050},
+				{LedgerName: "community", BasisPoints: 4950},
+			},
+			want: []Allocation{{"general", 555}, {"community", 445}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := allocate(tt.amount, tt.rules)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("want %v got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestNormalizeRules(t *testing.T) {
+
+	valid := [][]AllocationRule{
+		{{Percentage: 100}},
+		{{BasisPoints: 2550}, {BasisPoints: 7450}},
+		{{Percentage: 40, BasisPoints: 4000}, {BasisPoints: 6000}},
+		{{Fixed: 500}, {Percentage: 100}},
+	}
+	for _, rules := range valid {
+		if _, err := normalizeRules(rules); err != nil {
+			t.Errorf("expected %+v to be valid, got %v", rules, err)
+		}
+	}
+
+	invalid := [][]AllocationRule{
+		{},
+		{{Fixed: 500}},
+		{{Percentage: 50}},
+		{{BasisPoints: 9999}},
+		{{Percentage: 40, BasisPoints: 3000}, {BasisPoints: 7000}},
+		{{BasisPoints: 10000, Fixed: 100}},
+		{{Fixed: -100}, {BasisPoints: 10000}},
+		{{BasisPoints: 12000}, {BasisPoints: -2000}},
+	}
+	for _, rules := range invalid {
+		if _, err := normalizeRules(rules); err != ErrInvalidAlloc {
+			t.Errorf("expected %+v to be invalid, got %v", rules, err)
+		}
+	}
+}
+
+func TestProrate(t *testing.T) {
+
+	shares := []Allocation{
+		{Ledger: "general", Amount: 601},
+		{Ledger: "community", Amount: 400},
+		{Ledger: "corrected", Amount: -5},
+	}
+	tests := []struct {
+		amount int
+		want   []Allocation
+	}{
+		{1001, []Allocation{{"general", 601}, {"community", 400}}},
+		{500, []Allocation{{"general", 300}, {"community", 200}}},
+		{1, []Allocation{{"general", 1}}},
+		{0, nil},
+	}
+	for _, tt := range tests {
+		got := prorate(tt.amount, shares)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("prorate(%d) = %+v, want %+v", tt.amount, got, tt.want)
+		}
+	}
+}
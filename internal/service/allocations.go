@@ -4,41 +4,263 @@ import (
 	"encoding/json"
 	"errors"
 	"net/http"
+	"sort"
+	"time"
 
 	"git.sr.ht/~jakintosh/coffer/pkg/wire"
 )
 
+// AllocationRule routes part of each payment to a ledger. Fixed rules take
+// a fixed amount first, in order; proportional rules then split the rest by
+// basis points (1/100th of a percent). Percentage is shorthand for whole
+// percentages and is reported whenever BasisPoints is a whole percentage.
 type AllocationRule struct {
-	ID         string `json:"id"`
-	LedgerName string `json:"ledger"`
-	Percentage int    `json:"percentage"`
+	ID          string `json:"id"`
+	LedgerName  string `json:"ledger"`
+	Percentage  int    `json:"percentage,omitempty"`
+	BasisPoints int    `json:"basis_points,omitempty"`
+	Fixed       int    `json:"fixed,omitempty"`
 }
 
+// Allocation is a ledger's share of a single payment
+type Allocation struct {
+	Ledger string `json:"ledger"`
+	Amount int    `json:"amount"`
+}
+
+// normalizeRules validates rules and fills in BasisPoints from Percentage.
+// Proportional rules must total exactly 10000 basis points.
+func normalizeRules(
+	rules []AllocationRule,
+) (
+	[]AllocationRule,
+	error,
+) {
+	normalized := make([]AllocationRule, len(rules))
+	total := 0
+	for i, r := range rules {
+		if r.Percentage != 0 {
+			if r.BasisPoints != 0 && r.BasisPoints != r.Percentage*100 {
+				return nil, ErrInvalidAlloc
+			}
+			r.BasisPoints = r.Percentage * 100
+		}
+		switch {
+		case r.BasisPoints < 0 || r.Fixed < 0:
+			return nil, ErrInvalidAlloc
+		case (r.BasisPoints == 0) == (r.Fixed == 0):
+			// exactly one of proportional or fixed
+			return nil, ErrInvalidAlloc
+		}
+		total += r.BasisPoints
+		normalized[i] = r
+	}
+	if total != 10000 {
+		return nil, ErrInvalidAlloc
+	}
+	return withPercentages(normalized), nil
+}
+
+// withPercentages sets Percentage on rules with whole-percent basis points
+func withPercentages(
+	rules []AllocationRule,
+) []AllocationRule {
+	for i, r := range rules {
+		r.Percentage = 0
+		if r.BasisPoints%100 == 0 {
+			r.Percentage = r.BasisPoints / 100
+		}
+		rules[i] = r
+	}
+	return rules
+}
+
+// allocate splits amount across rules. Fixed rules are paid in order until
+// the amount runs out, and the remainder is split by basis points using the
+// largest remainder method, so shares always sum to amount and no share is
+// off by more than one cent. Shares are merged per ledger in order of first
+// appearance, and empty shares are dropped.
+func allocate(
+	amount int,
+	rules []AllocationRule,
+) []Allocation {
+	shares := make([]int, len(rules))
+
+	// fixed amounts first
+	remaining := amount
+	for i, r := range rules {
+		if r.Fixed > 0 {
+			shares[i] = min(r.Fixed, remaining)
+			remaining -= shares[i]
+		}
+	}
+
+	// proportional split of the remainder, rounded down
+	type fraction struct{ index, rem int }
+	var fractions []fraction
+	allocated := 0
+	for i, r := range rules {
+		if r.BasisPoints > 0 {
+			exact := remaining * r.BasisPoints
+			shares[i] = exact / 10000
+			allocated += shares[i]
+			fractions = append(fractions, fraction{i, exact % 10000})
+		}
+	}
+
+	// hand out leftover cents by largest remainder, earlier rules first
+	sort.SliceStable(fractions, func(a, b int) bool {
+		return fractions[a].rem > fractions[b].rem
+	})
+	for i := 0; i < remaining-allocated && i < len(fractions); i++ {
+		shares[fractions[i].index]++
+	}
+
+	// merge shares per ledger
+	var allocations []Allocation
+	index := map[string]int{}
+	for i, r := range rules {
+		if shares[i] == 0 {
+			continue
+		}
+		if j, ok := index[r.LedgerName]; ok {
+			allocations[j].Amount += shares[i]
+			continue
+		}
+		index[r.LedgerName] = len(allocations)
+		allocations = append(allocations, Allocation{r.LedgerName, shares[i]})
+	}
+	return allocations
+}
+
+// AllocationSet is a versioned list of allocation rules for a selector. The
+// set in effect at a given time is the latest one for its selector whose
+// EffectiveFrom is not after it.
+type AllocationSet struct {
+	ID            int64            `json:"id"`
+	Selector      string           `json:"selector"`
+	EffectiveFrom time.Time        `json:"effective_from"`
+	CreatedAt     time.Time        `json:"created_at"`
+	Rules         []AllocationRule `json:"rules"`
+}
+
+// GetAllocations returns the allocation rules currently in effect
 func (s *Service) GetAllocations() (
 	[]AllocationRule,
 	error,
 ) {
-	rules, err := s.store.GetAllocations()
+	return s.GetAllocationsAt(s.Clock())
+}
+
+// GetAllocationsAt returns the allocation rules in effect at t
+func (s *Service) GetAllocationsAt(
+	t time.Time,
+) (
+	[]AllocationRule,
+	error,
+) {
+	rules, err := s.store.GetAllocations(t.Unix())
 	if err != nil {
 		return nil, DatabaseError{err}
 	}
 
-	return rules, nil
+	return withPercentages(rules), nil
 }
 
+// GetAllocationSet returns the set for selector in effect at t
+func (s *Service) GetAllocationSet(
+	selector string,
+	t time.Time,
+) (
+	*AllocationSet,
+	error,
+) {
+	if err := validateSelector(selector); err != nil {
+		return nil, err
+	}
+
+	set, err := s.store.GetAllocationSet(selector, t.Unix())
+	if errors.Is(err, ErrAllocNotFound) {
+		return nil, err
+	} else if err != nil {
+		return nil, DatabaseError{err}
+	}
+	set.Rules = withPercentages(set.Rules)
+
+	return set, nil
+}
+
+// GetAllocationHistory returns every allocation set, including scheduled
+// ones, grouped by selector and newest first.
+func (s *Service) GetAllocationHistory() (
+	[]AllocationSet,
+	error,
+) {
+	sets, err := s.store.GetAllocationHistory()
+	if err != nil {
+		return nil, DatabaseError{err}
+	}
+	for i := range sets {
+		sets[i].Rules = withPercentages(sets[i].Rules)
+	}
+
+	return sets, nil
+}
+
+// SetAllocations replaces the allocation rules, effective immediately
 func (s *Service) SetAllocations(
 	rules []AllocationRule,
 ) error {
-	// ensure total percentage adds to 100
-	total := 0
-	for _, r := range rules {
-		total += r.Percentage
+	return s.ScheduleAllocations(rules, time.Time{})
+}
+
+// ScheduleAllocations adds a new default allocation set that takes effect
+// at effectiveFrom. Payments are allocated with the set in effect when they
+// were created.
+func (s *Service) ScheduleAllocations(
+	rules []AllocationRule,
+	effectiveFrom time.Time,
+) error {
+	return s.ScheduleAllocationSet("", rules, effectiveFrom)
+}
+
+// ScheduleAllocationSet adds a new allocation set for selector that takes
+// effect at effectiveFrom, or immediately if it is zero. An empty rule list
+// clears a non-default selector so that its payments fall back to less
+// specific sets. Sets cannot take effect in the past, since that would
+// change which rules were in force for payments already allocated; use
+// ReallocatePayments to re-split those.
+func (s *Service) ScheduleAllocationSet(
+	selector string,
+	rules []AllocationRule,
+	effectiveFrom time.Time,
+) error {
+	if err := validateSelector(selector); err != nil {
+		return err
 	}
-	if total != 100 {
+
+	now := s.Clock()
+	if effectiveFrom.IsZero() {
+		effectiveFrom = now
+	} else if effectiveFrom.Before(now) {
 		return ErrInvalidAlloc
 	}
 
-	err := s.store.SetAllocations(rules)
+	if selector == "" || len(rules) > 0 {
+		var err error
+		if rules, err = normalizeRules(rules); err != nil {
+			return err
+		}
+	}
+
+	// ensure every rule targets an open ledger
+	for _, r := range rules {
+		if err := s.requireOpenLedger(r.LedgerName); err != nil {
+			return err
+		}
+	}
+
+	err := s.store.SetAllocations(selector, rules, effectiveFrom.Unix(), now.Unix())
 	if err != nil {
 		return DatabaseError{err}
 	}
@@ -53,18 +275,29 @@ func (s *Service) buildAllocationsRouter(
 	mux.HandleFunc("GET /settings/allocations", mw.CORS(s.handleGetAllocations))
 	mux.HandleFunc("OPTIONS /settings/allocations", mw.CORS(s.handleGetAllocations))
 	mux.HandleFunc("PUT /settings/allocations", mw.Auth(s.handlePutAllocations))
+	mux.HandleFunc("GET /settings/allocations/history", mw.CORS(s.handleGetAllocationHistory))
+	mux.HandleFunc("OPTIONS /settings/allocations/history", mw.CORS(s.handleGetAllocationHistory))
+	mux.HandleFunc("POST /settings/allocations/preview", mw.Auth(s.handlePostAllocationsPreview))
 }
 
 func (s *Service) handleGetAllocations(
 	w http.ResponseWriter,
 	r *http.Request,
 ) {
-	rules, err := s.GetAllocations()
-	if err != nil {
-		wire.WriteError(w, http.StatusInternalServerError, "Internal Server Error")
+	selector := r.URL.Query().Get("selector")
 
+	rules := []AllocationRule{}
+	set, err := s.GetAllocationSet(selector, s.Clock())
+	if err == nil {
+		rules = set.Rules
+	} else if errors.Is(err, ErrInvalidSelector) {
+		wire.WriteError(w, http.StatusBadRequest, "Invalid Allocation Selector")
+		return
+	} else if !errors.Is(err, ErrAllocNotFound) {
+		wire.WriteError(w, http.StatusInternalServerError, "Internal Server Error")
 		return
 	}
+
 	wire.WriteData(w, http.StatusOK, rules)
 }
 
@@ -78,15 +311,45 @@ func (s *Service) handlePutAllocations(
 		return
 	}
 
-	if err := s.SetAllocations(rules); err != nil {
-		if errors.Is(err, ErrInvalidAlloc) {
-			wire.WriteError(w, http.StatusBadRequest, "Invalid Allocation Percentage")
-		} else {
-			wire.WriteError(w, http.StatusInternalServerError, "Internal Server Error")
+	// validate optional effective date as RFC3339, defaulting to now
+	var effectiveFrom time.Time
+	if q := r.URL.Query().Get("effective_from"); q != "" {
+		var err error
+		if effectiveFrom, err = time.Parse(time.RFC3339, q); err != nil {
+			wire.WriteError(w, http.StatusBadRequest, "Malformed 'effective_from' Query")
+			return
+		}
+	}
 
+	selector := r.URL.Query().Get("selector")
+	if err := s.ScheduleAllocationSet(selector, rules, effectiveFrom); err != nil {
+		switch {
+		case errors.Is(err, ErrInvalidAlloc):
+			wire.WriteError(w, http.StatusBadRequest, "Invalid Allocation Rules")
+		case errors.Is(err, ErrInvalidSelector):
+			wire.WriteError(w, http.StatusBadRequest, "Invalid Allocation Selector")
+		case errors.Is(err, ErrLedgerNotFound):
+			wire.WriteError(w, http.StatusBadRequest, "Unknown Ledger")
+		case errors.Is(err, ErrLedgerArchived):
+			wire.WriteError(w, http.StatusBadRequest, "Archived Ledger")
+		default:
+			wire.WriteError(w, http.StatusInternalServerError, "Internal Server Error")
 		}
 		return
 	}
 
 	w.WriteHeader(http.StatusNoContent)
 }
+
+func (s *Service) handleGetAllocationHistory(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	sets, err := s.GetAllocationHistory()
+	if err != nil {
+		wire.WriteError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+
+	wire.WriteData(w, http.StatusOK, sets)
+}
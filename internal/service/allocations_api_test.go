@@ -28,6 +28,7 @@ func TestAPIGetAllocations(t *testing.T) {
 func TestAPIPutAllocations(t *testing.T) {
 
 	env := testutil.SetupTestEnv(t)
+	testutil.SeedLedgers(t, env.Service, "community")
 	router := env.Service.BuildRouter()
 
 	// put allocations
@@ -90,3 +91,184 @@ func TestAPIPutAllocationsBad(t *testing.T) {
 	// validate error result
 	result.ExpectStatus(t, http.StatusBadRequest)
 }
+
+func TestAPIScheduleAllocations(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	testutil.SeedLedgers(t, env.Service, "community")
+	router := env.Service.BuildRouter()
+	auth := testutil.MakeAuthHeader(t, env.Service)
+
+	// schedule a split far in the future
+	url := "/settings/allocations?effective_from=2999-01-01T00:00:00Z"
+	body := `[
+		{
+			"id": "c",
+			"ledger": "community",
+			"percentage": 100
+		}
+    ]`
+	result := wire.TestPut[any](router, url, body, auth)
+	result.ExpectStatus(t, http.StatusNoContent)
+
+	// current rules are unchanged
+	getResult := wire.TestGet[[]service.AllocationRule](router, "/settings/allocations")
+	allocations := getResult.ExpectOK(t)
+	if len(allocations) != 1 || allocations[0].LedgerName != "general" {
+		t.Errorf("unexpected current rules %+v", allocations)
+	}
+
+	// history lists the scheduled set first
+	historyResult := wire.TestGet[[]service.AllocationSet](router, "/settings/allocations/history")
+	history := historyResult.ExpectOK(t)
+	if len(history) != 2 {
+		t.Fatalf("expected 2 sets, got %d", len(history))
+	}
+	if history[0].EffectiveFrom.Year() != 2999 || history[0].Rules[0].LedgerName != "community" {
+		t.Errorf("unexpected scheduled set %+v", history[0])
+	}
+
+	// scheduled ledgers cannot be archived
+	archiveResult := wire.TestPut[any](router, "/settings/ledgers/community", `{ "archived": true }`, auth)
+	archiveResult.ExpectStatus(t, http.StatusConflict)
+}
+
+func TestAPIScheduleAllocationsBadDate(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	router := env.Service.BuildRouter()
+	auth := testutil.MakeAuthHeader(t, env.Service)
+
+	url := "/settings/allocations?effective_from=soon"
+	body := `[ { "id": "g", "ledger": "general", "percentage": 100 } ]`
+	result := wire.TestPut[any](router, url, body, auth)
+
+	result.ExpectStatus(t, http.StatusBadRequest)
+}
+
+func TestAPIPutAllocationsSelector(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	testutil.SeedLedgers(t, env.Service, "dev")
+	router := env.Service.BuildRouter()
+	auth := testutil.MakeAuthHeader(t, env.Service)
+
+	// key a set to the sponsor product
+	url := "/settings/allocations?selector=product:prod_sponsor"
+	body := `[
+		{
+			"id": "d",
+			"ledger": "dev",
+			"percentage": 100
+		}
+    ]`
+	result := wire.TestPut[any](router, url, body, auth)
+	result.ExpectStatus(t, http.StatusNoContent)
+
+	// keyed rules are separate from the default rules
+	keyed := wire.TestGet[[]service.AllocationRule](router, url).ExpectOK(t)
+	if len(keyed) != 1 || keyed[0].LedgerName != "dev" {
+		t.Errorf("unexpected keyed rules %+v", keyed)
+	}
+	def := wire.TestGet[[]service.AllocationRule](router, "/settings/allocations").ExpectOK(t)
+	if len(def) != 1 || def[0].LedgerName != "general" {
+		t.Errorf("unexpected default rules %+v", def)
+	}
+
+	// unknown selectors have no rules
+	none := wire.TestGet[[]service.AllocationRule](router, "/settings/allocations?selector=amount:500").ExpectOK(t)
+	if len(none) != 0 {
+		t.Errorf("expected no rules, got %+v", none)
+	}
+}
+
+func TestAPIPutAllocationsBadSelector(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	router := env.Service.BuildRouter()
+	auth := testutil.MakeAuthHeader(t, env.Service)
+
+	url := "/settings/allocations?selector=tier:gold"
+	body := `[{ "id": "g", "ledger": "general", "percentage": 100 }]`
+	result := wire.TestPut[any](router, url, body, auth)
+	result.ExpectStatus(t, http.StatusBadRequest)
+
+	getResult := wire.TestGet[[]service.AllocationRule](router, url)
+	getResult.ExpectStatus(t, http.StatusBadRequest)
+}
+
+func TestAPIPreviewAllocations(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	testutil.SeedLedgers(t, env.Service, "community")
+	router := env.Service.BuildRouter()
+	auth := testutil.MakeAuthHeader(t, env.Service)
+
+	if err := env.Service.CreatePayment("pi_1", testutil.MakeDateUnix(2025, 1, 2), "succeeded", "cus_1", 1000, "usd"); err != nil {
+		t.Fatal(err)
+	}
+
+	url := "/settings/allocations/preview"
+	rules := `[
+		{ "id": "g", "ledger": "general", "percentage": 40 },
+		{ "id": "c", "ledger": "community", "percentage": 60 }
+	]`
+
+	// single amount
+	body := `{ "rules": ` + rules + `, "amount": 999 }`
+	result := wire.TestPost[service.AllocationPreview](router, url, body, auth)
+	preview := result.ExpectOK(t)
+	if len(preview.Shares) != 2 || preview.Shares[0].Amount != 400 || preview.Shares[1].Amount != 599 {
+		t.Errorf("unexpected shares %+v", preview.Shares)
+	}
+
+	// replayed date range
+	body = `{ "rules": ` + rules + `, "since": "2025-01-01T00:00:00Z", "until": "2025-02-01T00:00:00Z" }`
+	result = wire.TestPost[service.AllocationPreview](router, url, body, auth)
+	preview = result.ExpectOK(t)
+	if preview.Payments != 1 || len(preview.Ledgers) != 2 {
+		t.Fatalf("unexpected replay %+v", preview)
+	}
+	if preview.Ledgers[1].Ledger != "general" || preview.Ledgers[1].Difference != -600 {
+		t.Errorf("unexpected general diff %+v", preview.Ledgers[1])
+	}
+}
+
+func TestAPIPreviewAllocationsBad(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	router := env.Service.BuildRouter()
+	auth := testutil.MakeAuthHeader(t, env.Service)
+	url := "/settings/allocations/preview"
+
+	// missing auth
+	body := `{ "rules": [{ "id": "g", "ledger": "general", "percentage": 100 }], "amount": 100 }`
+	wire.TestPost[any](router, url, body).ExpectStatus(t, http.StatusUnauthorized)
+
+	// invalid rules
+	body = `{ "rules": [{ "id": "g", "ledger": "general", "percentage": 50 }], "amount": 100 }`
+	wire.TestPost[any](router, url, body, auth).ExpectStatus(t, http.StatusBadRequest)
+
+	// missing range
+	body = `{ "rules": [{ "id": "g", "ledger": "general", "percentage": 100 }] }`
+	wire.TestPost[any](router, url, body, auth).ExpectStatus(t, http.StatusBadRequest)
+}
+
+func TestAPIScheduleAllocationsPastDate(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	router := env.Service.BuildRouter()
+	auth := testutil.MakeAuthHeader(t, env.Service)
+	env.Clock.Set(testutil.MakeDate(2025, 6, 1))
+
+	// payments since may were split with the current rules
+	url := "/settings/allocations?effective_from=2025-05-01T00:00:00Z"
+	body := `[ { "id": "g", "ledger": "general", "percentage": 100 } ]`
+	result := wire.TestPut[any](router, url, body, auth)
+	result.ExpectStatus(t, http.StatusBadRequest)
+
+	history := wire.TestGet[[]service.AllocationSet](router, "/settings/allocations/history").ExpectOK(t)
+	if len(history) != 1 {
+		t.Errorf("expected only the default set, got %d", len(history))
+	}
+}
@@ -49,6 +49,7 @@ func TestSetAllocationsInvalid(t *testing.T) {
 func TestSetAllocationsValid(t *testing.T) {
 
 	env := testutil.SetupTestEnv(t)
+	testutil.SeedLedgers(t, env.Service, "community")
 	svc := env.Service
 
 	// set new rules
@@ -82,3 +83,53 @@ func TestSetAllocationsValid(t *testing.T) {
 		t.Errorf("unexpected rules %+v", allocations)
 	}
 }
+
+func TestSetAllocationsBasisPointsAndFixed(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	testutil.SeedLedgers(t, env.Service, "community")
+	svc := env.Service
+
+	rules := []service.AllocationRule{
+		{
+			ID:         "hosting",
+			LedgerName: "general",
+			Fixed:      250,
+		},
+		{
+			ID:          "g",
+			LedgerName:  "general",
+			BasisPoints: 3350,
+		},
+		{
+			ID:         "c",
+			LedgerName: "community",
+			Percentage: 66,
+		},
+		{
+			ID:          "c2",
+			LedgerName:  "community",
+			BasisPoints: 50,
+		},
+	}
+	if err := svc.SetAllocations(rules); err != nil {
+		t.Fatalf("failed to set allocations: %v", err)
+	}
+
+	allocations, err := svc.GetAllocations()
+	if err != nil {
+		t.Fatalf("failed to get allocations: %v", err)
+	}
+	if len(allocations) != 4 {
+		t.Fatalf("want 4 rules got %d", len(allocations))
+	}
+	if allocations[0].Fixed != 250 || allocations[0].BasisPoints != 0 {
+		t.Errorf("unexpected fixed rule %+v", allocations[0])
+	}
+	if allocations[1].BasisPoints != 3350 || allocations[1].Percentage != 0 {
+		t.Errorf("unexpected basis point rule %+v", allocations[1])
+	}
+	if allocations[2].BasisPoints != 6600 || allocations[2].Percentage != 66 {
+		t.Errorf("unexpected percentage rule %+v", allocations[2])
+	}
+}
@@ -0,0 +1,297 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"git.sr.ht/~jakintosh/coffer/pkg/wire"
+)
+
+// Dispute stages record what has been posted for a dispute. A held dispute
+// has moved its share of the payment from the payment's ledgers into the
+// holding ledger. A released dispute was won and the hold was reversed. A
+// finalized dispute was lost and the held funds were written off.
+const (
+	DisputeHeld      = "held"
+	DisputeReleased  = "released"
+	DisputeFinalized = "finalized"
+
+	DefaultDisputeLedger = "disputes"
+)
+
+// Dispute is a Stripe dispute of a payment as recorded locally. Fee is the
+// dispute fee currently charged, and Held is the amount moved into the
+// holding ledger.
+type Dispute struct {
+	ID       string    `json:"id"`
+	Payment  string    `json:"payment"`
+	Created  time.Time `json:"created"`
+	Status   string    `json:"status"`
+	Reason   string    `json:"reason"`
+	Amount   int       `json:"amount"`
+	Fee      int       `json:"fee"`
+	Currency string    `json:"currency"`
+	Held     int       `json:"held"`
+	Stage    string    `json:"stage"`
+}
+
+type DisputeSummary struct {
+	Count int
+	Total int
+}
+
+// DisputeHoldID is the ID of the holding ledger entry of a dispute. The
+// entries taking the held funds from each ledger are suffixed with the
+// ledger name.
+func DisputeHoldID(
+	id string,
+) string {
+	return fmt.Sprintf("dispute:%s:hold", id)
+}
+
+// DisputeFeeID is the ID of the nth fee entry of a dispute, counting from
+// zero. The first entry posts the fee, and each later one posts a change.
+func DisputeFeeID(
+	id string,
+	n int,
+) string {
+	if n == 0 {
+		return fmt.Sprintf("dispute:%s:fee", id)
+	}
+	return fmt.Sprintf("dispute:%s:fee%d", id, n)
+}
+
+// disputeWithdrawn reports whether Stripe has withdrawn the disputed funds.
+// Inquiries, whose statuses start with "warning_", do not withdraw funds.
+func disputeWithdrawn(
+	status string,
+) bool {
+	switch status {
+	case "needs_response", "under_review", "won", "lost":
+		return true
+	}
+	return false
+}
+
+// DisputePosting works out the entries a dispute posts, given the dispute
+// as it was last recorded, or nil, its payment, or nil if it is not known,
+// and the number of fee entries it has posted, all read in the transaction
+// that records the dispute. It sets the held amount and stage of the
+// dispute.
+type DisputePosting func(
+	dispute *Dispute,
+	existing *Dispute,
+	payment *PaymentAllocation,
+	feeEntries int,
+) ([]Transaction, error)
+
+// CreateDispute records a dispute of a payment. Once Stripe withdraws the
+// disputed funds, the payment's current ledger shares are moved into the
+// holding ledger in proportion to the disputed amount. Closing the dispute
+// releases the hold if it was won, or writes the held funds off if it was
+// lost. Dispute fees are posted to the fee ledger in ledger fee mode and to
+// the holding ledger otherwise. The payment is read in the same database
+// transaction that posts the entries.
+func (s *Service) CreateDispute(
+	id string,
+	paymentID string,
+	created int64,
+	status string,
+	reason string,
+	amount int64,
+	fee int64,
+	currency string,
+) error {
+	if err := s.ensureDisputeLedger(); err != nil {
+		return err
+	}
+	fees, err := s.GetFeeSettings()
+	if err != nil {
+		return err
+	}
+	feeLedger := DefaultDisputeLedger
+	if fees.Mode == FeeModeLedger {
+		feeLedger = fees.Ledger
+	}
+
+	dispute := Dispute{
+		ID:       id,
+		Payment:  paymentID,
+		Created:  time.Unix(created, 0),
+		Status:   status,
+		Reason:   reason,
+		Amount:   int(amount),
+		Fee:      int(fee),
+		Currency: currency,
+	}
+	date := s.Clock()
+
+	err = s.store.InsertDispute(dispute, postDispute(feeLedger, date), date.Unix())
+	if errors.Is(err, ErrPaymentNotFound) || errors.Is(err, ErrTxConflict) {
+		return err
+	} else if err != nil {
+		return DatabaseError{err}
+	}
+
+	return nil
+}
+
+// postDispute posts the hold of a dispute, its fee to feeLedger, and its
+// outcome, with the entries that are not part of the hold dated at date
+func postDispute(
+	feeLedger string,
+	date time.Time,
+) DisputePosting {
+	return func(
+		dispute *Dispute,
+		existing *Dispute,
+		payment *PaymentAllocation,
+		feeEntries int,
+	) (
+		[]Transaction,
+		error,
+	) {
+		if existing == nil {
+			existing = &Dispute{}
+		}
+		dispute.Held = existing.Held
+		dispute.Stage = existing.Stage
+		id := dispute.ID
+
+		// hold the disputed share of the payment once funds are withdrawn
+		var entries []Transaction
+		if dispute.Stage == "" && disputeWithdrawn(dispute.Status) {
+			if payment == nil {
+				return nil, ErrPaymentNotFound
+			}
+
+			base := 0
+			if remaining := payment.Amount - payment.Refunded; remaining > 0 {
+				base = payment.Allocated * min(dispute.Amount, remaining) / remaining
+			}
+			hold := DisputeHoldID(id)
+			for _, a := range prorate(base, payment.Posted) {
+				entries = append(entries, Transaction{
+					ID:     hold + ":" + a.Ledger,
+					Ledger: a.Ledger,
+					Amount: -a.Amount,
+					Date:   dispute.Created,
+					Label:  "dispute hold",
+				})
+				dispute.Held += a.Amount
+			}
+			if dispute.Held > 0 {
+				entries = append(entries, Transaction{
+					ID:     hold,
+					Ledger: DefaultDisputeLedger,
+					Amount: dispute.Held,
+					Date:   dispute.Created,
+					Label:  "dispute hold",
+				})
+			}
+			dispute.Stage = DisputeHeld
+		}
+
+		// post the fee, or its change since it was last posted
+		posted := 0
+		if existing.Stage != "" {
+			posted = existing.Fee
+		}
+		if dispute.Stage != "" && dispute.Fee != posted {
+			entries = append(entries, Transaction{
+				ID:     DisputeFeeID(id, feeEntries),
+				Ledger: feeLedger,
+				Amount: posted - dispute.Fee,
+				Date:   date,
+				Label:  "dispute fee",
+			})
+		}
+
+		// close the hold
+		if dispute.Stage == DisputeHeld {
+			switch dispute.Status {
+			case "won":
+				dispute.Stage = DisputeReleased
+			case "lost":
+				dispute.Stage = DisputeFinalized
+				if dispute.Held > 0 {
+					entries = append(entries, Transaction{
+						ID:     fmt.Sprintf("dispute:%s:lost", id),
+						Ledger: DefaultDisputeLedger,
+						Amount: -dispute.Held,
+						Date:   date,
+						Label:  "dispute lost",
+					})
+				}
+			}
+		}
+
+		return entries, nil
+	}
+}
+
+// GetDisputes lists open disputes, newest first, or every dispute if all is
+// set.
+func (s *Service) GetDisputes(
+	all bool,
+) (
+	[]Dispute,
+	error,
+) {
+	disputes, err := s.store.GetDisputes(all)
+	if err != nil {
+		return nil, DatabaseError{err}
+	}
+
+	return disputes, nil
+}
+
+// ensureDisputeLedger registers the private holding ledger on first use
+func (s *Service) ensureDisputeLedger() error {
+	_, err := s.GetLedgerInfo(DefaultDisputeLedger)
+	if !errors.Is(err, ErrLedgerNotFound) {
+		return err
+	}
+
+	_, err = s.CreateLedger(Ledger{
+		Name:        DefaultDisputeLedger,
+		DisplayName: "Disputes",
+		Description: "Funds held for open payment disputes",
+	})
+	if errors.Is(err, ErrLedgerExists) {
+		return nil
+	}
+	return err
+}
+
+func (s *Service) buildDisputesRouter(
+	mux *http.ServeMux,
+	mw Middleware,
+) {
+	mux.HandleFunc("GET /disputes", mw.Auth(s.handleGetDisputes))
+}
+
+func (s *Service) handleGetDisputes(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	all := false
+	if q := r.URL.Query().Get("all"); q != "" {
+		var err error
+		if all, err = strconv.ParseBool(q); err != nil {
+			wire.WriteError(w, http.StatusBadRequest, "Malformed 'all' Query")
+			return
+		}
+	}
+
+	disputes, err := s.GetDisputes(all)
+	if err != nil {
+		wire.WriteError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+
+	wire.WriteData(w, http.StatusOK, disputes)
+}
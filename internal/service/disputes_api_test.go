@@ -0,0 +1,58 @@
+package service_test
+
+import (
+	"net/http"
+	"testing"
+
+	"git.sr.ht/~jakintosh/coffer/internal/service"
+	"git.sr.ht/~jakintosh/coffer/internal/testutil"
+	"git.sr.ht/~jakintosh/coffer/pkg/wire"
+)
+
+func TestAPIGetDisputes(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	seedRefundPayment(t, env)
+	router := env.Service.BuildRouter()
+	auth := testutil.MakeAuthHeader(t, env.Service)
+
+	created := testutil.MakeDateUnix(2025, 3, 10)
+	if err := env.Service.CreateDispute("dp_1", "pi_1", created, "lost", "fraudulent", 1001, 1500, "usd"); err != nil {
+		t.Fatal(err)
+	}
+	if err := env.Service.CreateDispute("dp_2", "pi_1", created+1, "needs_response", "fraudulent", 1, 0, "usd"); err != nil {
+		t.Fatal(err)
+	}
+
+	// only open disputes by default
+	result := wire.TestGet[[]service.Dispute](router, "/disputes", auth)
+	disputes := result.ExpectOK(t)
+	if len(disputes) != 1 || disputes[0].ID != "dp_2" {
+		t.Errorf("unexpected open disputes %+v", disputes)
+	}
+
+	result = wire.TestGet[[]service.Dispute](router, "/disputes?all=true", auth)
+	disputes = result.ExpectOK(t)
+	if len(disputes) != 2 || disputes[0].ID != "dp_2" || disputes[1].ID != "dp_1" {
+		t.Errorf("unexpected disputes %+v", disputes)
+	}
+}
+
+func TestAPIGetDisputesBadQuery(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	router := env.Service.BuildRouter()
+	auth := testutil.MakeAuthHeader(t, env.Service)
+
+	result := wire.TestGet[[]service.Dispute](router, "/disputes?all=maybe", auth)
+	result.ExpectStatus(t, http.StatusBadRequest)
+}
+
+func TestAPIGetDisputesUnauthorized(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	router := env.Service.BuildRouter()
+
+	result := wire.TestGet[[]service.Dispute](router, "/disputes")
+	result.ExpectStatus(t, http.StatusUnauthorized)
+}
@@ -0,0 +1,251 @@
+package service_test
+
+import (
+	"errors"
+	"testing"
+
+	"git.sr.ht/~jakintosh/coffer/internal/service"
+	"git.sr.ht/~jakintosh/coffer/internal/testutil"
+)
+
+func TestCreateDisputeWon(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	svc := env.Service
+	seedRefundPayment(t, env)
+	created := testutil.MakeDateUnix(2025, 3, 10)
+
+	// an open dispute moves the payment into the holding ledger
+	if err := svc.CreateDispute("dp_1", "pi_1", created, "needs_response", "fraudulent", 1001, 1500, "usd"); err != nil {
+		t.Fatalf("CreateDispute: %v", err)
+	}
+	if b := balance(t, svc, "general") + balance(t, svc, "community"); b != 0 {
+		t.Errorf("want payment ledgers emptied got %d", b)
+	}
+	if b := balance(t, svc, service.DefaultDisputeLedger); b != 1001-1500 {
+		t.Errorf("want disputes balance %d got %d", 1001-1500, b)
+	}
+	ledger, err := svc.GetLedgerInfo(service.DefaultDisputeLedger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ledger.Public {
+		t.Error("expected holding ledger to be private")
+	}
+
+	// processing the same state again posts nothing
+	if err := svc.CreateDispute("dp_1", "pi_1", created, "under_review", "fraudulent", 1001, 1500, "usd"); err != nil {
+		t.Fatalf("CreateDispute: %v", err)
+	}
+
+	// winning returns the funds and the fee
+	if err := svc.CreateDispute("dp_1", "pi_1", created, "won", "fraudulent", 1001, 0, "usd"); err != nil {
+		t.Fatalf("CreateDispute: %v", err)
+	}
+	if b := balance(t, svc, "general"); b != 601 {
+		t.Errorf("want general balance 601 got %d", b)
+	}
+	if b := balance(t, svc, "community"); b != 400 {
+		t.Errorf("want community balance 400 got %d", b)
+	}
+	if b := balance(t, svc, service.DefaultDisputeLedger); b != 0 {
+		t.Errorf("want disputes balance 0 got %d", b)
+	}
+
+	disputes, err := svc.GetDisputes(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(disputes) != 1 || disputes[0].Stage != service.DisputeReleased || disputes[0].Held != 1001 {
+		t.Errorf("unexpected disputes %+v", disputes)
+	}
+}
+
+func TestCreateDisputeLost(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	svc := env.Service
+	seedRefundPayment(t, env)
+	testutil.SeedLedgers(t, svc, "fees")
+	if err := svc.SetFeeSettings(service.FeeSettings{Mode: service.FeeModeLedger}); err != nil {
+		t.Fatal(err)
+	}
+	created := testutil.MakeDateUnix(2025, 3, 10)
+
+	// a partial dispute holds its share of the payment
+	if err := svc.CreateDispute("dp_1", "pi_1", created, "needs_response", "fraudulent", 500, 1500, "usd"); err != nil {
+		t.Fatalf("CreateDispute: %v", err)
+	}
+	if b := balance(t, svc, service.DefaultDisputeLedger); b != 500 {
+		t.Errorf("want disputes balance 500 got %d", b)
+	}
+	if b := balance(t, svc, "fees"); b != -1500 {
+		t.Errorf("want fees balance -1500 got %d", b)
+	}
+
+	// losing writes the held funds off
+	if err := svc.CreateDispute("dp_1", "pi_1", created, "lost", "fraudulent", 500, 1500, "usd"); err != nil {
+		t.Fatalf("CreateDispute: %v", err)
+	}
+	if b := balance(t, svc, service.DefaultDisputeLedger); b != 0 {
+		t.Errorf("want disputes balance 0 got %d", b)
+	}
+	if b := balance(t, svc, "general") + balance(t, svc, "community"); b != 501 {
+		t.Errorf("want 501 left on payment ledgers got %d", b)
+	}
+
+	disputes, err := svc.GetDisputes(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(disputes) != 0 {
+		t.Errorf("expected no open disputes got %+v", disputes)
+	}
+}
+
+func TestDisputeFeeChanges(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	svc := env.Service
+	seedRefundPayment(t, env)
+	created := testutil.MakeDateUnix(2025, 3, 10)
+
+	// a fee that returns to an earlier amount is still posted
+	for _, fee := range []int64{1500, 3000, 1500, 3000} {
+		if err := svc.CreateDispute("dp_1", "pi_1", created, "needs_response", "fraudulent", 1001, fee, "usd"); err != nil {
+			t.Fatalf("CreateDispute with fee %d: %v", fee, err)
+		}
+	}
+	if b := balance(t, svc, service.DefaultDisputeLedger); b != 1001-3000 {
+		t.Errorf("want disputes balance %d got %d", 1001-3000, b)
+	}
+
+	// every entry is recorded for the payment
+	var missing int
+	if err := env.DB.Conn.QueryRow(`
+		SELECT COUNT(*)
+		FROM tx
+		WHERE id LIKE 'dispute:%'
+			AND (payment IS NULL OR payment!='pi_1');
+	`).Scan(&missing); err != nil {
+		t.Fatal(err)
+	}
+	if missing != 0 {
+		t.Errorf("want every dispute entry recorded for pi_1, %d are not", missing)
+	}
+}
+
+func TestCreateDisputeInquiry(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	svc := env.Service
+	seedRefundPayment(t, env)
+	created := testutil.MakeDateUnix(2025, 3, 10)
+
+	// inquiries do not withdraw funds
+	if err := svc.CreateDispute("dp_1", "pi_1", created, "warning_needs_response", "fraudulent", 1001, 0, "usd"); err != nil {
+		t.Fatalf("CreateDispute: %v", err)
+	}
+	if b := balance(t, svc, "general"); b != 601 {
+		t.Errorf("want general balance 601 got %d", b)
+	}
+
+	metrics, err := svc.GetMetrics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metrics.DisputesOpen != 1 || metrics.DisputedCents != 1001 {
+		t.Errorf("unexpected dispute metrics %+v", metrics)
+	}
+
+	if err := svc.CreateDispute("dp_1", "pi_1", created, "warning_closed", "fraudulent", 1001, 0, "usd"); err != nil {
+		t.Fatalf("CreateDispute: %v", err)
+	}
+	disputes, err := svc.GetDisputes(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(disputes) != 1 || disputes[0].Stage != "" || disputes[0].Held != 0 {
+		t.Errorf("unexpected disputes %+v", disputes)
+	}
+}
+
+func TestProcessDispute(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	svc := env.Service
+	seedRefundPayment(t, env)
+	stripe := env.Stripe
+	stripe.AddObject("/v1/disputes/dp_1", map[string]any{
+		"id":             "dp_1",
+		"object":         "dispute",
+		"amount":         1001,
+		"currency":       "usd",
+		"created":        testutil.MakeDateUnix(2025, 3, 10),
+		"status":         "needs_response",
+		"reason":         "fraudulent",
+		"charge":         "ch_1",
+		"payment_intent": "pi_1",
+		"balance_transactions": []map[string]any{
+			{"id": "txn_1", "object": "balance_transaction", "amount": -1001, "fee": 1500},
+		},
+	})
+
+	svc.HandleStripeResource("dispute", "dp_1")
+
+	disputes, err := svc.GetDisputes(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(disputes) != 1 || disputes[0].Payment != "pi_1" || disputes[0].Fee != 1500 || disputes[0].Stage != service.DisputeHeld {
+		t.Errorf("unexpected disputes %+v", disputes)
+	}
+}
+
+func TestDisputedPaymentKeepsShares(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	svc := env.Service
+	seedRefundPayment(t, env)
+	created := testutil.MakeDateUnix(2025, 3, 10)
+	if err := svc.CreateDispute("dp_1", "pi_1", created, "needs_response", "fraudulent", 500, 0, "usd"); err != nil {
+		t.Fatalf("CreateDispute: %v", err)
+	}
+
+	// the held part of the payment cannot be taken back again
+	err := svc.CreateRefund("re_1", "pi_1", testutil.MakeDateUnix(2025, 3, 11), "succeeded", 501, "usd")
+	if !errors.Is(err, service.ErrPaymentDisputed) {
+		t.Errorf("want ErrPaymentDisputed got %v", err)
+	}
+
+	// reallocation leaves the payment as it is
+	rules := []service.AllocationRule{
+		{ID: "c", LedgerName: "community", Percentage: 100},
+	}
+	testutil.ScheduleAllocations(t, env, rules, testutil.MakeDate(2025, 4, 1))
+	sets, err := svc.GetAllocationHistory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	realloc, err := svc.ReallocatePayments(sets[0].ID, testutil.MakeDate(2025, 3, 1), testutil.MakeDate(2025, 4, 1), false)
+	if err != nil {
+		t.Fatalf("ReallocatePayments: %v", err)
+	}
+	if len(realloc.Payments) != 0 || len(realloc.Disputed) != 1 || realloc.Disputed[0] != "pi_1" {
+		t.Errorf("unexpected reallocation %+v", realloc)
+	}
+	if b := balance(t, svc, "general") + balance(t, svc, "community"); b != 1001-500 {
+		t.Errorf("want payment ledgers at %d got %d", 1001-500, b)
+	}
+
+	// once the dispute is won the payment can be refunded
+	if err := svc.CreateDispute("dp_1", "pi_1", created, "won", "fraudulent", 500, 0, "usd"); err != nil {
+		t.Fatalf("CreateDispute: %v", err)
+	}
+	if err := svc.CreateRefund("re_1", "pi_1", testutil.MakeDateUnix(2025, 3, 11), "succeeded", 1001, "usd"); err != nil {
+		t.Fatalf("CreateRefund: %v", err)
+	}
+	if b := balance(t, svc, "general") + balance(t, svc, "community"); b != 0 {
+		t.Errorf("want payment ledgers emptied got %d", b)
+	}
+}
@@ -0,0 +1,299 @@
+package service
+
+import (
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"time"
+
+	"git.sr.ht/~jakintosh/coffer/pkg/wire"
+)
+
+// Stripe event statuses
+const (
+	EventPending   = "pending"
+	EventProcessed = "processed"
+	EventFailed    = "failed"
+	EventIgnored   = "ignored"
+)
+
+const (
+	// DefaultRetryInterval is how often due events are retried, and the
+	// delay before the first retry of a failed event
+	DefaultRetryInterval = 30 * time.Second
+
+	// DefaultMaxAttempts is how many times an event is processed before it
+	// is marked failed
+	DefaultMaxAttempts = 8
+
+	// maxRetryDelay caps the backoff between attempts
+	maxRetryDelay = time.Hour
+
+	// eventLease is how long a queued or claimed event is left to the
+	// processor before it is considered lost and claimed again
+	eventLease = time.Minute
+)
+
+// StripeEvent is a received webhook event, stored until the resource it
+// refers to has been processed.
+type StripeEvent struct {
+	ID           string    `json:"id"`
+	Type         string    `json:"type"`
+	ResourceType string    `json:"resource_type"`
+	ResourceID   string    `json:"resource_id"`
+	Created      time.Time `json:"created"`
+	Received     time.Time `json:"received"`
+	Status       string    `json:"status"`
+	Attempts     int       `json:"attempts"`
+	NextAttempt  time.Time `json:"next_attempt"`
+	LastError    string    `json:"last_error,omitempty"`
+}
+
+// QueryStripeEvents lists received events, newest first, optionally only
+// those with the given status.
+func (s *Service) QueryStripeEvents(
+	status string,
+	cursor *wire.Cursor,
+	limit int,
+	offset int,
+) (
+	[]StripeEvent,
+	*wire.Cursor,
+	error,
+) {
+	switch status {
+	case "", EventPending, EventProcessed, EventFailed, EventIgnored:
+	default:
+		return nil, nil, ErrInvalidStatus
+	}
+	if limit <= 0 {
+		limit = 100
+	}
+	offset = max(offset, 0)
+
+	// fetch one extra row to detect a following page
+	events, err := s.store.GetStripeEvents(status, cursor, limit+1, offset)
+	if err != nil {
+		return nil, nil, DatabaseError{err}
+	}
+
+	var next *wire.Cursor
+	if len(events) > limit {
+		events = events[:limit]
+		last := events[limit-1]
+		next = &wire.Cursor{Key: last.Received.Unix(), ID: last.ID}
+	}
+
+	return events, next, nil
+}
+
+// RetryStripeEvent queues the resource of an event to be processed again,
+// whatever its status, and returns the event as queued. The event is reset
+// to pending and handed to the processor like a new event, so a retry never
+// runs alongside other work on the same resource. A failed event is given
+// one more attempt.
+func (s *Service) RetryStripeEvent(
+	id string,
+) (
+	*StripeEvent,
+	error,
+) {
+	if s.stripeProcessor == nil {
+		return nil, ErrNoStripeProcessor
+	}
+
+	e, err := s.store.GetStripeEvent(id)
+	if errors.Is(err, ErrEventNotFound) {
+		return nil, err
+	} else if err != nil {
+		return nil, DatabaseError{err}
+	}
+	if e.Status == EventIgnored {
+		return nil, ErrEventIgnored
+	}
+
+	e.Status = EventPending
+	e.NextAttempt = s.Clock().Add(eventLease)
+	if err := s.store.UpdateStripeEvent(*e); err != nil {
+		return nil, DatabaseError{err}
+	}
+	if err := s.stripeProcessor.submit(ResourceEvent{e.ResourceType, e.ResourceID}); err != nil {
+		log.Printf("Event %s queued for restart: %v", e.ID, err)
+	}
+
+	return e, nil
+}
+
+// processStripeResource handles a debounced resource and records the
+// outcome on each of its pending events. Failed events are retried with
+// exponential backoff until they run out of attempts.
+func (s *Service) processStripeResource(
+	req ResourceEvent,
+) {
+	events, err := s.store.GetPendingStripeEvents(req.Type, req.ID)
+	if err != nil {
+		log.Printf("Error loading events for %s %s: %v", req.Type, req.ID, err)
+	}
+
+	procErr := s.handleStripeResource(req.Type, req.ID)
+	if procErr != nil {
+		log.Printf("Error processing %s %s: %v", req.Type, req.ID, procErr)
+	}
+
+	now := s.Clock()
+	for _, e := range events {
+		e.Attempts++
+		if procErr == nil {
+			e.Status = EventProcessed
+			e.LastError = ""
+		} else {
+			e.LastError = procErr.Error()
+			if e.Attempts >= s.stripeProcessor.MaxAttempts {
+				e.Status = EventFailed
+			} else {
+				e.NextAttempt = now.Add(s.stripeProcessor.retryDelay(e.Attempts))
+			}
+		}
+		if err := s.store.UpdateStripeEvent(e); err != nil {
+			log.Printf("Error updating event %s: %v", e.ID, err)
+		}
+	}
+}
+
+// queueStripeResource stores a pending event for a resource that failed to
+// process without one having been received, as when a sync finds it, so
+// that it is retried like a webhook event. The failed attempt counts as
+// the first.
+func (s *Service) queueStripeResource(
+	req ResourceEvent,
+	created time.Time,
+	procErr error,
+) error {
+	now := s.Clock()
+	e := StripeEvent{
+		ID:           "sync:" + req.Type + ":" + req.ID,
+		Type:         "sync",
+		ResourceType: req.Type,
+		ResourceID:   req.ID,
+		Created:      created,
+		Received:     now,
+		Status:       EventPending,
+		Attempts:     1,
+		NextAttempt:  now.Add(s.stripeProcessor.retryDelay(1)),
+		LastError:    procErr.Error(),
+	}
+	if err := s.store.InsertStripeEvent(e); err != nil {
+		return DatabaseError{err}
+	}
+	return nil
+}
+
+// retryStripeEvents resubmits every pending event once, then keeps
+// resubmitting events as they come due until the service stops.
+func (s *Service) retryStripeEvents() {
+	defer s.wg.Done()
+
+	s.resumeStripeEvents(time.Unix(math.MaxInt64, 0))
+
+	ticker := time.NewTicker(s.stripeProcessor.RetryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			s.resumeStripeEvents(s.Clock())
+		}
+	}
+}
+
+// resumeStripeEvents claims the pending events due by due and submits
+// their resources to the processor.
+func (s *Service) resumeStripeEvents(
+	due time.Time,
+) {
+	now := s.Clock()
+	events, err := s.store.ClaimStripeEvents(due.Unix(), now.Add(eventLease).Unix())
+	if err != nil {
+		log.Printf("Error claiming stripe events: %v", err)
+		return
+	}
+
+	seen := map[ResourceEvent]bool{}
+	for _, e := range events {
+		req := ResourceEvent{e.ResourceType, e.ResourceID}
+		if seen[req] {
+			continue
+		}
+		seen[req] = true
+		if err := s.stripeProcessor.submit(req); err != nil {
+			return
+		}
+	}
+}
+
+// retryDelay is the backoff before the attempt after the given one
+func (p *StripeProcessor) retryDelay(
+	attempts int,
+) time.Duration {
+	delay := p.RetryInterval
+	for i := 1; i < attempts && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, maxRetryDelay)
+}
+
+func (s *Service) handleListStripeEvents(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	limit, offset, malformedQueryErr := wire.ParsePagination(r)
+	if malformedQueryErr != nil {
+		wire.WriteError(w, http.StatusBadRequest, malformedQueryErr.Error())
+		return
+	}
+
+	cursor, malformedQueryErr := wire.ParseCursor(r)
+	if malformedQueryErr != nil {
+		wire.WriteError(w, http.StatusBadRequest, malformedQueryErr.Error())
+		return
+	}
+
+	status := r.URL.Query().Get("status")
+	events, next, err := s.QueryStripeEvents(status, cursor, limit, offset)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrInvalidStatus):
+			wire.WriteError(w, http.StatusBadRequest, "Invalid Event Status")
+		default:
+			wire.WriteError(w, http.StatusInternalServerError, "Internal Server Error")
+		}
+		return
+	}
+
+	wire.WritePage(w, http.StatusOK, events, next)
+}
+
+func (s *Service) handleRetryStripeEvent(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	id := r.PathValue("id")
+	event, err := s.RetryStripeEvent(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrEventNotFound):
+			wire.WriteError(w, http.StatusNotFound, "Event Not Found")
+		case errors.Is(err, ErrEventIgnored):
+			wire.WriteError(w, http.StatusConflict, "Event Not Handled")
+		case errors.Is(err, ErrNoStripeProcessor):
+			wire.WriteError(w, http.StatusServiceUnavailable, "Stripe Not Configured")
+		default:
+			wire.WriteError(w, http.StatusInternalServerError, "Internal Server Error")
+		}
+		return
+	}
+
+	wire.WriteData(w, http.StatusAccepted, event)
+}
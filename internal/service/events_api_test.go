@@ -0,0 +1,145 @@
+package service_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"git.sr.ht/~jakintosh/coffer/internal/service"
+	"git.sr.ht/~jakintosh/coffer/internal/testutil"
+	"git.sr.ht/~jakintosh/coffer/pkg/wire"
+)
+
+// seedStripeEvents stores a processed, a failed and an ignored event,
+// received a second apart in that order
+func seedStripeEvents(
+	t *testing.T,
+	env *testutil.TestEnv,
+) {
+	t.Helper()
+
+	received := testutil.MakeDate(2025, 1, 1)
+	events := []service.StripeEvent{
+		{ID: "evt_1", Type: "payment_intent.succeeded", ResourceType: "payment", ResourceID: "pi_1", Status: service.EventProcessed, Attempts: 1},
+		{ID: "evt_2", Type: "payment_intent.succeeded", ResourceType: "payment", ResourceID: "pi_2", Status: service.EventFailed, Attempts: 3, LastError: "stripe unavailable"},
+		{ID: "evt_3", Type: "customer.created", Status: service.EventIgnored},
+	}
+	for i, e := range events {
+		e.Created = received.Add(time.Duration(i) * time.Second)
+		e.Received = e.Created
+		e.NextAttempt = e.Created
+		if err := env.DB.InsertStripeEvent(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestAPIListStripeEvents(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	seedStripeEvents(t, env)
+	router := env.Service.BuildRouter()
+	auth := testutil.MakeAuthHeader(t, env.Service)
+
+	result := wire.TestGet[[]service.StripeEvent](router, "/stripe/events", auth)
+	events := result.ExpectOK(t)
+	if len(events) != 3 || events[0].ID != "evt_3" || events[2].ID != "evt_1" {
+		t.Errorf("unexpected events %+v", events)
+	}
+
+	result = wire.TestGet[[]service.StripeEvent](router, "/stripe/events?status=failed", auth)
+	events = result.ExpectOK(t)
+	if len(events) != 1 || events[0].ID != "evt_2" || events[0].LastError != "stripe unavailable" {
+		t.Errorf("unexpected failed events %+v", events)
+	}
+}
+
+func TestAPIListStripeEventsCursor(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	seedStripeEvents(t, env)
+	router := env.Service.BuildRouter()
+	auth := testutil.MakeAuthHeader(t, env.Service)
+
+	result := wire.TestGet[[]service.StripeEvent](router, "/stripe/events?limit=2", auth)
+	first := result.ExpectOK(t)
+	if len(first) != 2 || result.NextCursor == "" {
+		t.Fatalf("expected full first page with cursor, got %d and %q", len(first), result.NextCursor)
+	}
+
+	url := "/stripe/events?limit=2&cursor=" + result.NextCursor
+	result = wire.TestGet[[]service.StripeEvent](router, url, auth)
+	second := result.ExpectOK(t)
+	if len(second) != 1 || second[0].ID != "evt_1" {
+		t.Errorf("unexpected second page %+v", second)
+	}
+	if result.NextCursor != "" {
+		t.Errorf("expected no cursor on last page, got %q", result.NextCursor)
+	}
+}
+
+func TestAPIListStripeEventsBadStatus(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	router := env.Service.BuildRouter()
+	auth := testutil.MakeAuthHeader(t, env.Service)
+
+	result := wire.TestGet[[]service.StripeEvent](router, "/stripe/events?status=done", auth)
+	result.ExpectStatus(t, http.StatusBadRequest)
+}
+
+func TestAPIRetryStripeEvent(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	seedStripeEvents(t, env)
+	stripe := env.Stripe
+	addSettledIntent(stripe, "pi_2", testutil.MakeDateUnix(2025, 1, 2), 1000, 59)
+	router := env.Service.BuildRouter()
+	auth := testutil.MakeAuthHeader(t, env.Service)
+
+	result := wire.TestPost[service.StripeEvent](router, "/stripe/events/evt_2/retry", "", auth)
+	result.ExpectStatus(t, http.StatusAccepted)
+	if result.Data.Status != service.EventPending {
+		t.Errorf("unexpected queued event %+v", result.Data)
+	}
+
+	event := waitForEvent(t, env, "evt_2", service.EventProcessed)
+	if event.Attempts != 4 || event.LastError != "" {
+		t.Errorf("unexpected event %+v", event)
+	}
+}
+
+func TestAPIRetryStripeEventErrors(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	seedStripeEvents(t, env)
+	router := env.Service.BuildRouter()
+	auth := testutil.MakeAuthHeader(t, env.Service)
+
+	result := wire.TestPost[service.StripeEvent](router, "/stripe/events/evt_9/retry", "", auth)
+	result.ExpectStatus(t, http.StatusNotFound)
+
+	result = wire.TestPost[service.StripeEvent](router, "/stripe/events/evt_3/retry", "", auth)
+	result.ExpectStatus(t, http.StatusConflict)
+}
+
+func TestAPIStripeEventsUnauthorized(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	router := env.Service.BuildRouter()
+
+	result := wire.TestGet[[]service.StripeEvent](router, "/stripe/events")
+	result.ExpectStatus(t, http.StatusUnauthorized)
+
+	retry := wire.TestPost[service.StripeEvent](router, "/stripe/events/evt_1/retry", "")
+	retry.ExpectStatus(t, http.StatusUnauthorized)
+}
+
+func TestAPIRetryStripeEventNoProcessor(t *testing.T) {
+
+	router := setupMiddlewareTest(t)
+	auth := wire.TestHeader{Key: "Authorization", Value: "Bearer " + testToken}
+
+	result := wire.TestPost[service.StripeEvent](router, "/stripe/events/evt_1/retry", "", auth)
+	result.ExpectStatus(t, http.StatusServiceUnavailable)
+}
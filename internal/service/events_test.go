@@ -0,0 +1,263 @@
+package service_test
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"git.sr.ht/~jakintosh/coffer/internal/service"
+	"git.sr.ht/~jakintosh/coffer/internal/testutil"
+	stripe "github.com/stripe/stripe-go/v82"
+)
+
+func makeIntentEvent(
+	id string,
+	intent string,
+) stripe.Event {
+	return stripe.Event{
+		ID:      id,
+		Type:    "payment_intent.succeeded",
+		Created: testutil.MakeDateUnix(2025, 1, 2),
+		Data: &stripe.EventData{
+			Raw: json.RawMessage(`{"id":"` + intent + `","object":"payment_intent"}`),
+		},
+	}
+}
+
+// waitForEvent polls until the stored event has the given status
+func waitForEvent(
+	t *testing.T,
+	env *testutil.TestEnv,
+	id string,
+	status string,
+) *service.StripeEvent {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		e, err := env.DB.GetStripeEvent(id)
+		if err != nil {
+			t.Fatalf("failed to get event: %v", err)
+		}
+		if e.Status == status {
+			return e
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("want event status %s got %+v", status, e)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestStripeEventProcessed(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	stripe := env.Stripe
+	addSettledIntent(stripe, "pi_1", testutil.MakeDateUnix(2025, 1, 2), 1000, 59)
+
+	if err := env.Service.ProcessStripeEvent(makeIntentEvent("evt_1", "pi_1")); err != nil {
+		t.Fatalf("ProcessStripeEvent: %v", err)
+	}
+
+	// the event is stored before it is processed
+	e, err := env.DB.GetStripeEvent("evt_1")
+	if err != nil {
+		t.Fatalf("failed to get event: %v", err)
+	}
+	if e.ResourceType != "payment" || e.ResourceID != "pi_1" {
+		t.Errorf("unexpected event %+v", e)
+	}
+
+	e = waitForEvent(t, env, "evt_1", service.EventProcessed)
+	if e.Attempts != 1 || e.LastError != "" {
+		t.Errorf("unexpected event %+v", e)
+	}
+	if _, err := env.Service.GetPayment("pi_1"); err != nil {
+		t.Errorf("failed to get payment: %v", err)
+	}
+}
+
+func TestStripeEventDuplicate(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	stripe := env.Stripe
+	addSettledIntent(stripe, "pi_1", testutil.MakeDateUnix(2025, 1, 2), 1000, 59)
+
+	event := makeIntentEvent("evt_1", "pi_1")
+	if err := env.Service.ProcessStripeEvent(event); err != nil {
+		t.Fatalf("ProcessStripeEvent: %v", err)
+	}
+	waitForEvent(t, env, "evt_1", service.EventProcessed)
+
+	// a redelivered event keeps its record
+	if err := env.Service.ProcessStripeEvent(event); err != nil {
+		t.Fatalf("ProcessStripeEvent: %v", err)
+	}
+	e := waitForEvent(t, env, "evt_1", service.EventProcessed)
+	if e.Attempts != 1 {
+		t.Errorf("want 1 attempt got %d", e.Attempts)
+	}
+}
+
+func TestStripeEventIgnored(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+
+	event := stripe.Event{ID: "evt_1", Type: "customer.created"}
+	if err := env.Service.ProcessStripeEvent(event); err != nil {
+		t.Fatalf("ProcessStripeEvent: %v", err)
+	}
+
+	// unhandled events are recorded but never processed
+	e, err := env.DB.GetStripeEvent("evt_1")
+	if err != nil {
+		t.Fatalf("failed to get event: %v", err)
+	}
+	if e.Status != service.EventIgnored || e.Type != "customer.created" {
+		t.Errorf("unexpected event %+v", e)
+	}
+
+	if _, err := env.Service.RetryStripeEvent("evt_1"); !errors.Is(err, service.ErrEventIgnored) {
+		t.Errorf("want ErrEventIgnored got %v", err)
+	}
+}
+
+func TestStripeEventRetry(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+
+	// the payment intent is missing, so every attempt fails
+	if err := env.Service.ProcessStripeEvent(makeIntentEvent("evt_1", "pi_1")); err != nil {
+		t.Fatalf("ProcessStripeEvent: %v", err)
+	}
+
+	e := waitForEvent(t, env, "evt_1", service.EventFailed)
+	if e.Attempts != 3 {
+		t.Errorf("want 3 attempts got %d", e.Attempts)
+	}
+	if e.LastError == "" {
+		t.Error("want last error")
+	}
+}
+
+func TestStripeEventFeePending(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	if err := env.Service.SetFeeSettings(service.FeeSettings{Mode: service.FeeModeNet}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the charge has no balance transaction yet
+	env.Stripe.AddObject("/v1/payment_intents/pi_1", map[string]any{
+		"id":            "pi_1",
+		"object":        "payment_intent",
+		"amount":        1000,
+		"currency":      "usd",
+		"created":       testutil.MakeDateUnix(2025, 1, 2),
+		"status":        "succeeded",
+		"customer":      "cus_1",
+		"latest_charge": map[string]any{"id": "ch_pi_1", "object": "charge"},
+	})
+	if err := env.Service.ProcessStripeEvent(makeIntentEvent("evt_1", "pi_1")); err != nil {
+		t.Fatalf("ProcessStripeEvent: %v", err)
+	}
+	e := waitForEvent(t, env, "evt_1", service.EventFailed)
+	if e.Attempts != 3 {
+		t.Errorf("want 3 attempts got %d", e.Attempts)
+	}
+
+	// the charge update that adds the balance transaction allocates it
+	addSettledIntent(env.Stripe, "pi_1", testutil.MakeDateUnix(2025, 1, 2), 1000, 30)
+	update := stripe.Event{
+		ID:      "evt_2",
+		Type:    "charge.updated",
+		Created: testutil.MakeDateUnix(2025, 1, 2),
+		Data: &stripe.EventData{
+			Raw: json.RawMessage(`{"id":"ch_pi_1","object":"charge","payment_intent":"pi_1"}`),
+		},
+	}
+	if err := env.Service.ProcessStripeEvent(update); err != nil {
+		t.Fatalf("ProcessStripeEvent: %v", err)
+	}
+	waitForEvent(t, env, "evt_2", service.EventProcessed)
+
+	payment, err := env.Service.GetPayment("pi_1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payment.Fee != 30 || payment.Allocated != 970 {
+		t.Errorf("unexpected payment %+v", payment)
+	}
+}
+
+func TestStripeEventResumedOnStart(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	stripe := env.Stripe
+	addSettledIntent(stripe, "pi_1", testutil.MakeDateUnix(2025, 1, 2), 1000, 59)
+
+	// an event left pending by a previous run, still leased to it
+	now := time.Now()
+	pending := service.StripeEvent{
+		ID:           "evt_1",
+		Type:         "payment_intent.succeeded",
+		ResourceType: "payment",
+		ResourceID:   "pi_1",
+		Created:      now,
+		Received:     now,
+		Status:       service.EventPending,
+		NextAttempt:  now.Add(time.Hour),
+	}
+	if err := env.DB.InsertStripeEvent(pending); err != nil {
+		t.Fatal(err)
+	}
+
+	testutil.NewTestService(t, env.DB, env.Stripe)
+
+	waitForEvent(t, env, "evt_1", service.EventProcessed)
+	if _, err := env.Service.GetPayment("pi_1"); err != nil {
+		t.Errorf("failed to get payment: %v", err)
+	}
+}
+
+func TestRetryStripeEvent(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	stripe := env.Stripe
+
+	if err := env.Service.ProcessStripeEvent(makeIntentEvent("evt_1", "pi_1")); err != nil {
+		t.Fatalf("ProcessStripeEvent: %v", err)
+	}
+	waitForEvent(t, env, "evt_1", service.EventFailed)
+
+	// once the payment intent can be fetched, a retry processes it
+	addSettledIntent(stripe, "pi_1", testutil.MakeDateUnix(2025, 1, 2), 1000, 59)
+	e, err := env.Service.RetryStripeEvent("evt_1")
+	if err != nil {
+		t.Fatalf("RetryStripeEvent: %v", err)
+	}
+	if e.Status != service.EventPending || e.Attempts != 3 {
+		t.Errorf("unexpected queued event %+v", e)
+	}
+	e = waitForEvent(t, env, "evt_1", service.EventProcessed)
+	if e.Attempts != 4 || e.LastError != "" {
+		t.Errorf("unexpected event %+v", e)
+	}
+	if _, err := env.Service.GetPayment("pi_1"); err != nil {
+		t.Errorf("failed to get payment: %v", err)
+	}
+
+	if _, err := env.Service.RetryStripeEvent("evt_2"); !errors.Is(err, service.ErrEventNotFound) {
+		t.Errorf("want ErrEventNotFound got %v", err)
+	}
+}
+
+func TestQueryStripeEventsInvalidStatus(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+
+	if _, _, err := env.Service.QueryStripeEvents("done", nil, 10, 0); !errors.Is(err, service.ErrInvalidStatus) {
+		t.Errorf("want ErrInvalidStatus got %v", err)
+	}
+}
@@ -0,0 +1,374 @@
+package service
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"git.sr.ht/~jakintosh/coffer/pkg/wire"
+)
+
+// Export formats accepted by ExportLedger
+const (
+	FormatCSV  = "csv"
+	FormatJSON = "json"
+	FormatOFX  = "ofx"
+)
+
+// ExportRow is a transaction as written to an export, with the amount in
+// major currency units.
+type ExportRow struct {
+	ID         string    `json:"id"`
+	Ledger     string    `json:"ledger"`
+	Date       time.Time `json:"date"`
+	Amount     string    `json:"amount"`
+	Currency   string    `json:"currency"`
+	Label      string    `json:"label"`
+	TransferID string    `json:"transfer_id,omitempty"`
+	Reverses   string    `json:"reverses,omitempty"`
+}
+
+// exportWriter serializes a stream of transactions in one format
+type exportWriter interface {
+	begin() error
+	write(ExportRow) error
+	end(*LedgerSnapshot) error
+}
+
+// ExportLedger writes every transaction on ledger between since and until,
+// oldest first, to w in the given format.
+func (s *Service) ExportLedger(
+	w io.Writer,
+	ledger string,
+	format string,
+	since time.Time,
+	until time.Time,
+) error {
+	export, err := s.readLedgerExport(ledger, format, since, until)
+	if err != nil {
+		return err
+	}
+	return s.writeLedgerExport(w, export)
+}
+
+// ledgerExport is an export read from the store, ready to be written
+type ledgerExport struct {
+	ledger   string
+	format   string
+	since    time.Time
+	until    time.Time
+	txs      []Transaction
+	snapshot *LedgerSnapshot
+}
+
+// readLedgerExport reads the transactions of an export, so that a failed
+// read can be reported before any of the export is written
+func (s *Service) readLedgerExport(
+	ledger string,
+	format string,
+	since time.Time,
+	until time.Time,
+) (
+	*ledgerExport,
+	error,
+) {
+	switch format {
+	case FormatCSV, FormatJSON, FormatOFX:
+	default:
+		return nil, ErrInvalidFormat
+	}
+
+	txs, snapshot, err := s.store.ExportTransactions(
+		ledger,
+		since.Unix(),
+		until.Unix(),
+	)
+	if err != nil {
+		return nil, DatabaseError{err}
+	}
+
+	return &ledgerExport{
+		ledger:   ledger,
+		format:   format,
+		since:    since,
+		until:    until,
+		txs:      txs,
+		snapshot: snapshot,
+	}, nil
+}
+
+func (s *Service) writeLedgerExport(
+	w io.Writer,
+	export *ledgerExport,
+) error {
+	ew, err := s.newExportWriter(w, export.format, export.ledger, export.since, export.until)
+	if err != nil {
+		return err
+	}
+
+	if err := ew.begin(); err != nil {
+		return err
+	}
+	for _, tx := range export.txs {
+		if err := ew.write(s.exportRow(tx)); err != nil {
+			return err
+		}
+	}
+	return ew.end(export.snapshot)
+}
+
+func (s *Service) exportRow(
+	tx Transaction,
+) ExportRow {
+	return ExportRow{
+		ID:         tx.ID,
+		Ledger:     tx.Ledger,
+		Date:       tx.Date.UTC(),
+		Amount:     formatMajorUnits(tx.Amount),
+		Currency:   strings.ToUpper(s.currency),
+		Label:      tx.Label,
+		TransferID: tx.TransferID,
+		Reverses:   tx.Reverses,
+	}
+}
+
+func (s *Service) newExportWriter(
+	w io.Writer,
+	format string,
+	ledger string,
+	since time.Time,
+	until time.Time,
+) (
+	exportWriter,
+	error,
+) {
+	switch format {
+	case FormatCSV:
+		return &csvExportWriter{w: csv.NewWriter(w)}, nil
+	case FormatJSON:
+		return &jsonExportWriter{w: w}, nil
+	case FormatOFX:
+		return &ofxExportWriter{
+			w:        w,
+			ledger:   ledger,
+			currency: strings.ToUpper(s.currency),
+			since:    since,
+			until:    until,
+			now:      s.Clock(),
+		}, nil
+	default:
+		return nil, ErrInvalidFormat
+	}
+}
+
+// formatMajorUnits formats an amount in minor units (cents) as a decimal
+// amount in major units.
+func formatMajorUnits(amount int) string {
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, amount/100, amount%100)
+}
+
+type csvExportWriter struct {
+	w *csv.Writer
+}
+
+func (e *csvExportWriter) begin() error {
+	return e.w.Write([]string{
+		"id",
+		"date",
+		"amount",
+		"currency",
+		"label",
+		"transfer_id",
+		"reverses",
+	})
+}
+
+func (e *csvExportWriter) write(row ExportRow) error {
+	return e.w.Write([]string{
+		row.ID,
+		row.Date.Format(time.RFC3339),
+		row.Amount,
+		row.Currency,
+		row.Label,
+		row.TransferID,
+		row.Reverses,
+	})
+}
+
+func (e *csvExportWriter) end(*LedgerSnapshot) error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+type jsonExportWriter struct {
+	w     io.Writer
+	count int
+}
+
+func (e *jsonExportWriter) begin() error {
+	_, err := io.WriteString(e.w, "[")
+	return err
+}
+
+func (e *jsonExportWriter) write(row ExportRow) error {
+	if e.count > 0 {
+		if _, err := io.WriteString(e.w, ","); err != nil {
+			return err
+		}
+	}
+	e.count++
+
+	data, err := json.Marshal(row)
+	if err != nil {
+		return err
+	}
+	_, err = e.w.Write(data)
+	return err
+}
+
+func (e *jsonExportWriter) end(*LedgerSnapshot) error {
+	_, err := io.WriteString(e.w, "]\n")
+	return err
+}
+
+// ofxExportWriter writes an OFX 2 bank statement for a single ledger
+type ofxExportWriter struct {
+	w        io.Writer
+	ledger   string
+	currency string
+	since    time.Time
+	until    time.Time
+	now      time.Time
+}
+
+const ofxDateFormat = "20060102150405"
+
+func (e *ofxExportWriter) begin() error {
+	_, err := fmt.Fprintf(e.w, `<?xml version="1.0" encoding="UTF-8"?>
+<?OFX OFXHEADER="200" VERSION="220" SECURITY="NONE" OLDFILEUID="NONE" NEWFILEUID="NONE"?>
+<OFX>
+<SIGNONMSGSRSV1><SONRS><STATUS><CODE>0</CODE><SEVERITY>INFO</SEVERITY></STATUS><DTSERVER>%s</DTSERVER><LANGUAGE>ENG</LANGUAGE></SONRS></SIGNONMSGSRSV1>
+<BANKMSGSRSV1><STMTTRNRS><TRNUID>0</TRNUID><STATUS><CODE>0</CODE><SEVERITY>INFO</SEVERITY></STATUS>
+<STMTRS><CURDEF>%s</CURDEF>
+<BANKACCTFROM><BANKID>coffer</BANKID><ACCTID>%s</ACCTID><ACCTTYPE>CHECKING</ACCTTYPE></BANKACCTFROM>
+<BANKTRANLIST><DTSTART>%s</DTSTART><DTEND>%s</DTEND>
+`,
+		e.now.UTC().Format(ofxDateFormat),
+		e.currency,
+		ofxEscape(e.ledger),
+		e.since.UTC().Format(ofxDateFormat),
+		e.until.UTC().Format(ofxDateFormat),
+	)
+	return err
+}
+
+func (e *ofxExportWriter) write(row ExportRow) error {
+	trnType := "CREDIT"
+	if strings.HasPrefix(row.Amount, "-") {
+		trnType = "DEBIT"
+	}
+	_, err := fmt.Fprintf(e.w,
+		"<STMTTRN><TRNTYPE>%s</TRNTYPE><DTPOSTED>%s</DTPOSTED><TRNAMT>%s</TRNAMT><FITID>%s</FITID><NAME>%s</NAME></STMTTRN>\n",
+		trnType,
+		row.Date.Format(ofxDateFormat),
+		row.Amount,
+		ofxEscape(row.ID),
+		ofxEscape(row.Label),
+	)
+	return err
+}
+
+func (e *ofxExportWriter) end(snapshot *LedgerSnapshot) error {
+	_, err := fmt.Fprintf(e.w, `</BANKTRANLIST>
+<LEDGERBAL><BALAMT>%s</BALAMT><DTASOF>%s</DTASOF></LEDGERBAL>
+</STMTRS></STMTTRNRS></BANKMSGSRSV1>
+</OFX>
+`,
+		formatMajorUnits(snapshot.ClosingBalance),
+		e.until.UTC().Format(ofxDateFormat),
+	)
+	return err
+}
+
+func ofxEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+func (s *Service) handleGetLedgerExport(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	ledger := r.PathValue("ledger")
+	format := r.URL.Query().Get("format")
+	sinceQ := r.URL.Query().Get("since")
+	untilQ := r.URL.Query().Get("until")
+
+	var (
+		err   error
+		since time.Time
+		until time.Time
+	)
+
+	if format == "" {
+		format = FormatCSV
+	}
+
+	if sinceQ != "" {
+		if since, err = time.Parse("2006-01-02", sinceQ); err != nil {
+			wire.WriteError(w, http.StatusBadRequest, "Malformed 'since' Query")
+			return
+		}
+	} else {
+		since = time.Unix(0, 0)
+	}
+
+	if untilQ != "" {
+		if until, err = time.Parse("2006-01-02", untilQ); err != nil {
+			wire.WriteError(w, http.StatusBadRequest, "Malformed 'until' Query")
+			return
+		}
+	} else {
+		until = s.Clock()
+	}
+
+	var contentType string
+	switch format {
+	case FormatCSV:
+		contentType = "text/csv"
+	case FormatJSON:
+		contentType = "application/json"
+	case FormatOFX:
+		contentType = "application/x-ofx"
+	default:
+		wire.WriteError(w, http.StatusBadRequest, "Malformed 'format' Query")
+		return
+	}
+
+	export, err := s.readLedgerExport(ledger, format, since, until)
+	if err != nil {
+		wire.WriteError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", ledger+"."+format))
+	w.WriteHeader(http.StatusOK)
+
+	// headers are already sent, so failures can only be logged
+	if err := s.writeLedgerExport(w, export); err != nil {
+		log.Printf("failed to export ledger %s: %v", ledger, err)
+	}
+}
@@ -0,0 +1,42 @@
+package service_test
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"git.sr.ht/~jakintosh/coffer/internal/testutil"
+	"git.sr.ht/~jakintosh/coffer/pkg/wire"
+)
+
+func TestAPIExportLedger(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	router := env.Service.BuildRouter()
+	testutil.SeedTransactionData(t, env.Service)
+
+	url := "/ledger/general/export?format=csv&since=2025-02-01"
+	result := wire.TestGet[any](router, url)
+
+	// verify result
+	result.ExpectStatus(t, http.StatusOK)
+	if ct := result.Headers.Get("Content-Type"); ct != "text/csv" {
+		t.Errorf("expected text/csv, got %q", ct)
+	}
+	lines := strings.Split(strings.TrimSpace(string(result.Raw)), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected header and 2 rows, got %d:\n%s", len(lines), result.Raw)
+	}
+}
+
+func TestAPIExportLedgerBadFormat(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	router := env.Service.BuildRouter()
+
+	url := "/ledger/general/export?format=xlsx"
+	result := wire.TestGet[any](router, url)
+
+	// verify result
+	result.ExpectStatus(t, http.StatusBadRequest)
+}
@@ -0,0 +1,98 @@
+package service_test
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"git.sr.ht/~jakintosh/coffer/internal/service"
+	"git.sr.ht/~jakintosh/coffer/internal/testutil"
+)
+
+func TestExportLedgerCSV(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	svc := env.Service
+	start, end := testutil.SeedTransactionData(t, svc)
+
+	var buf bytes.Buffer
+	if err := svc.ExportLedger(&buf, "general", service.FormatCSV, start, end); err != nil {
+		t.Fatalf("ExportLedger: %v", err)
+	}
+
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("invalid csv: %v", err)
+	}
+	if len(records) != 4 {
+		t.Fatalf("expected header and 3 rows, got %d", len(records))
+	}
+	if records[1][0] != "t1" || records[1][2] != "1.00" || records[1][3] != "USD" {
+		t.Errorf("unexpected first row %v", records[1])
+	}
+	if records[3][2] != "-0.50" {
+		t.Errorf("unexpected outgoing amount %q", records[3][2])
+	}
+}
+
+func TestExportLedgerJSON(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	svc := env.Service
+	start, end := testutil.SeedTransactionData(t, svc)
+
+	var buf bytes.Buffer
+	if err := svc.ExportLedger(&buf, "general", service.FormatJSON, start, end); err != nil {
+		t.Fatalf("ExportLedger: %v", err)
+	}
+
+	var rows []service.ExportRow
+	if err := json.Unmarshal(buf.Bytes(), &rows); err != nil {
+		t.Fatalf("invalid json: %v", err)
+	}
+	if len(rows) != 3 {
+		t.Fatalf("expected 3 rows, got %d", len(rows))
+	}
+	if rows[1].Amount != "2.00" || rows[1].Currency != "USD" {
+		t.Errorf("unexpected row %+v", rows[1])
+	}
+}
+
+func TestExportLedgerOFX(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	svc := env.Service
+	start, end := testutil.SeedTransactionData(t, svc)
+
+	var buf bytes.Buffer
+	if err := svc.ExportLedger(&buf, "general", service.FormatOFX, start, end); err != nil {
+		t.Fatalf("ExportLedger: %v", err)
+	}
+
+	ofx := buf.String()
+	if strings.Count(ofx, "<STMTTRN>") != 3 {
+		t.Errorf("expected 3 statement transactions:\n%s", ofx)
+	}
+	if !strings.Contains(ofx, "<TRNTYPE>DEBIT</TRNTYPE><DTPOSTED>20250301000000</DTPOSTED><TRNAMT>-0.50</TRNAMT>") {
+		t.Errorf("missing debit transaction:\n%s", ofx)
+	}
+	if !strings.Contains(ofx, "<BALAMT>2.50</BALAMT>") {
+		t.Errorf("missing ledger balance:\n%s", ofx)
+	}
+}
+
+func TestExportLedgerInvalidFormat(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	svc := env.Service
+	start, end := testutil.SeedTransactionData(t, svc)
+
+	var buf bytes.Buffer
+	err := svc.ExportLedger(&buf, "general", "xlsx", start, end)
+	if !errors.Is(err, service.ErrInvalidFormat) {
+		t.Fatalf("expected ErrInvalidFormat, got %v", err)
+	}
+}
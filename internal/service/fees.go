@@ -0,0 +1,121 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"git.sr.ht/~jakintosh/coffer/pkg/wire"
+)
+
+// Fee modes decide how the Stripe fee on a payment reaches the ledgers.
+// Gross allocates the full payment amount and ignores the fee, net
+// allocates the amount left after the fee, and ledger allocates the full
+// amount and posts the fee as a negative entry to the fee ledger.
+const (
+	FeeModeGross  = "gross"
+	FeeModeNet    = "net"
+	FeeModeLedger = "ledger"
+
+	DefaultFeeLedger = "fees"
+)
+
+// FeeSettings is how Stripe fees are accounted for. Ledger is only used in
+// ledger mode.
+type FeeSettings struct {
+	Mode   string `json:"mode"`
+	Ledger string `json:"ledger,omitempty"`
+}
+
+// PaymentFee is what Stripe kept from a payment, read from the balance
+// transaction of its charge.
+type PaymentFee struct {
+	Amount             int64
+	BalanceTransaction string
+}
+
+func (s *Service) GetFeeSettings() (
+	*FeeSettings,
+	error,
+) {
+	fees, err := s.store.GetFeeSettings()
+	if err != nil {
+		return nil, DatabaseError{err}
+	}
+
+	return fees, nil
+}
+
+// SetFeeSettings changes how fees on future payments are accounted for.
+// Ledger mode defaults to the "fees" ledger, which must be open.
+func (s *Service) SetFeeSettings(
+	fees FeeSettings,
+) error {
+	switch fees.Mode {
+	case FeeModeGross, FeeModeNet:
+		fees.Ledger = ""
+	case FeeModeLedger:
+		if fees.Ledger == "" {
+			fees.Ledger = DefaultFeeLedger
+		}
+		if err := s.requireOpenLedger(fees.Ledger); err != nil {
+			return err
+		}
+	default:
+		return ErrInvalidFees
+	}
+
+	if err := s.store.SetFeeSettings(fees); err != nil {
+		return DatabaseError{err}
+	}
+
+	return nil
+}
+
+func (s *Service) buildFeesRouter(
+	mux *http.ServeMux,
+	mw Middleware,
+) {
+	mux.HandleFunc("GET /settings/fees", mw.Auth(s.handleGetFees))
+	mux.HandleFunc("PUT /settings/fees", mw.Auth(s.handlePutFees))
+}
+
+func (s *Service) handleGetFees(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	fees, err := s.GetFeeSettings()
+	if err != nil {
+		wire.WriteError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+
+	wire.WriteData(w, http.StatusOK, fees)
+}
+
+func (s *Service) handlePutFees(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	var fees FeeSettings
+	if err := json.NewDecoder(r.Body).Decode(&fees); err != nil {
+		wire.WriteError(w, http.StatusBadRequest, "Malformed JSON")
+		return
+	}
+
+	if err := s.SetFeeSettings(fees); err != nil {
+		switch {
+		case errors.Is(err, ErrInvalidFees):
+			wire.WriteError(w, http.StatusBadRequest, "Invalid Fee Settings")
+		case errors.Is(err, ErrLedgerNotFound):
+			wire.WriteError(w, http.StatusBadRequest, "Unknown Ledger")
+		case errors.Is(err, ErrLedgerArchived):
+			wire.WriteError(w, http.StatusBadRequest, "Archived Ledger")
+		default:
+			wire.WriteError(w, http.StatusInternalServerError, "Internal Server Error")
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,76 @@
+package service_test
+
+import (
+	"net/http"
+	"testing"
+
+	"git.sr.ht/~jakintosh/coffer/internal/service"
+	"git.sr.ht/~jakintosh/coffer/internal/testutil"
+	"git.sr.ht/~jakintosh/coffer/pkg/wire"
+)
+
+func TestAPIGetFees(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	router := env.Service.BuildRouter()
+	auth := testutil.MakeAuthHeader(t, env.Service)
+
+	result := wire.TestGet[service.FeeSettings](router, "/settings/fees", auth)
+
+	// validate response
+	fees := result.ExpectOK(t)
+	if fees.Mode != service.FeeModeGross {
+		t.Errorf("unexpected default fee settings %+v", fees)
+	}
+}
+
+func TestAPIPutFees(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	testutil.SeedLedgers(t, env.Service, "fees")
+	router := env.Service.BuildRouter()
+	auth := testutil.MakeAuthHeader(t, env.Service)
+
+	body := `{"mode": "ledger"}`
+	result := wire.TestPut[any](router, "/settings/fees", body, auth)
+	result.ExpectStatus(t, http.StatusNoContent)
+
+	// validate response
+	getResult := wire.TestGet[service.FeeSettings](router, "/settings/fees", auth)
+	fees := getResult.ExpectOK(t)
+	if fees.Mode != service.FeeModeLedger || fees.Ledger != "fees" {
+		t.Errorf("unexpected fee settings %+v", fees)
+	}
+}
+
+func TestAPIPutFeesInvalid(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	router := env.Service.BuildRouter()
+	auth := testutil.MakeAuthHeader(t, env.Service)
+
+	tests := []struct {
+		name string
+		body string
+		code int
+	}{
+		{"malformed", `{`, http.StatusBadRequest},
+		{"unknown mode", `{"mode": "bogus"}`, http.StatusBadRequest},
+		{"unknown ledger", `{"mode": "ledger", "ledger": "missing"}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := wire.TestPut[any](router, "/settings/fees", tt.body, auth)
+			result.ExpectStatus(t, tt.code)
+		})
+	}
+}
+
+func TestAPIPutFeesUnauthorized(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	router := env.Service.BuildRouter()
+
+	result := wire.TestPut[any](router, "/settings/fees", `{"mode": "net"}`)
+	result.ExpectStatus(t, http.StatusUnauthorized)
+}
@@ -0,0 +1,190 @@
+package service_test
+
+import (
+	"errors"
+	"testing"
+
+	"git.sr.ht/~jakintosh/coffer/internal/service"
+	"git.sr.ht/~jakintosh/coffer/internal/testutil"
+)
+
+// addSettledIntent serves a succeeded payment intent whose charge has
+// settled with fee.
+func addSettledIntent(
+	stripe *testutil.FakeStripe,
+	id string,
+	created int64,
+	amount int,
+	fee int,
+) {
+	stripe.AddObject("/v1/payment_intents/"+id, map[string]any{
+		"id":       id,
+		"object":   "payment_intent",
+		"amount":   amount,
+		"currency": "usd",
+		"created":  created,
+		"status":   "succeeded",
+		"customer": "cus_1",
+		"latest_charge": map[string]any{
+			"id":     "ch_" + id,
+			"object": "charge",
+			"balance_transaction": map[string]any{
+				"id":     "txn_" + id,
+				"object": "balance_transaction",
+				"amount": amount,
+				"fee":    fee,
+				"net":    amount - fee,
+			},
+		},
+	})
+}
+
+func TestPaymentFeeGross(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	stripe := env.Stripe
+	addSettledIntent(stripe, "pi_1", testutil.MakeDateUnix(2025, 1, 2), 1000, 59)
+
+	env.Service.HandleStripeResource("payment", "pi_1")
+
+	// fee is recorded but the gross amount is allocated
+	payment, err := env.Service.GetPayment("pi_1")
+	if err != nil {
+		t.Fatalf("failed to get payment: %v", err)
+	}
+	if payment.Fee != 59 || payment.BalanceTransaction != "txn_pi_1" || payment.Allocated != 1000 {
+		t.Errorf("unexpected payment %+v", payment)
+	}
+	txs, err := env.Service.GetTransactions("general", 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(txs) != 1 || txs[0].Amount != 1000 {
+		t.Errorf("unexpected transactions %+v", txs)
+	}
+}
+
+func TestPaymentFeeNet(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	testutil.SeedLedgers(t, env.Service, "community")
+	stripe := env.Stripe
+	addSettledIntent(stripe, "pi_1", testutil.MakeDateUnix(2025, 1, 2), 1000, 60)
+
+	rules := []service.AllocationRule{
+		{ID: "g", LedgerName: "general", Percentage: 50},
+		{ID: "c", LedgerName: "community", Percentage: 50},
+	}
+	testutil.ScheduleAllocations(t, env, rules, testutil.MakeDate(2025, 1, 1))
+	if err := env.Service.SetFeeSettings(service.FeeSettings{Mode: service.FeeModeNet}); err != nil {
+		t.Fatalf("failed to set fee settings: %v", err)
+	}
+
+	env.Service.HandleStripeResource("payment", "pi_1")
+
+	payment, err := env.Service.GetPayment("pi_1")
+	if err != nil {
+		t.Fatalf("failed to get payment: %v", err)
+	}
+	if payment.Fee != 60 || payment.Allocated != 940 {
+		t.Errorf("unexpected payment %+v", payment)
+	}
+	for _, ledger := range []string{"general", "community"} {
+		txs, err := env.Service.GetTransactions(ledger, 10, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(txs) != 1 || txs[0].Amount != 470 {
+			t.Errorf("unexpected %s transactions %+v", ledger, txs)
+		}
+	}
+}
+
+func TestPaymentFeeLedger(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	testutil.SeedLedgers(t, env.Service, "fees")
+	stripe := env.Stripe
+	addSettledIntent(stripe, "pi_1", testutil.MakeDateUnix(2025, 1, 2), 1000, 59)
+
+	if err := env.Service.SetFeeSettings(service.FeeSettings{Mode: service.FeeModeLedger}); err != nil {
+		t.Fatalf("failed to set fee settings: %v", err)
+	}
+
+	env.Service.HandleStripeResource("payment", "pi_1")
+
+	txs, err := env.Service.GetTransactions("general", 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(txs) != 1 || txs[0].Amount != 1000 {
+		t.Errorf("unexpected general transactions %+v", txs)
+	}
+	txs, err = env.Service.GetTransactions("fees", 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(txs) != 1 || txs[0].ID != "fee:pi_1" || txs[0].Amount != -59 {
+		t.Errorf("unexpected fee transactions %+v", txs)
+	}
+
+	// processing the payment again posts nothing new
+	env.Service.HandleStripeResource("payment", "pi_1")
+	txs, err = env.Service.GetTransactions("fees", 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(txs) != 1 {
+		t.Errorf("want 1 fee transaction got %d", len(txs))
+	}
+}
+
+func TestPaymentFeeDeferred(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	if err := env.Service.SetFeeSettings(service.FeeSettings{Mode: service.FeeModeNet}); err != nil {
+		t.Fatal(err)
+	}
+
+	// without a fee the payment waits to be allocated
+	ts := testutil.MakeDateUnix(2025, 1, 2)
+	err := env.Service.CreatePayment("pi_1", ts, "processing", "cus_1", 1000, "usd")
+	if !errors.Is(err, service.ErrFeePending) {
+		t.Fatalf("want ErrFeePending got %v", err)
+	}
+	txs, err := env.Service.GetTransactions("general", 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(txs) != 0 {
+		t.Fatalf("expected no transactions before the fee is known, got %+v", txs)
+	}
+
+	fee := &service.PaymentFee{Amount: 30, BalanceTransaction: "txn_1"}
+	if err := env.Service.CreatePaymentWithFee("pi_1", ts, "succeeded", "cus_1", 1000, "usd", "ch_pi_1", service.PaymentContext{}, fee); err != nil {
+		t.Fatalf("failed to create payment: %v", err)
+	}
+	txs, err = env.Service.GetTransactions("general", 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(txs) != 1 || txs[0].Amount != 970 {
+		t.Errorf("unexpected transactions %+v", txs)
+	}
+}
+
+func TestSetFeeSettingsInvalid(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+
+	err := env.Service.SetFeeSettings(service.FeeSettings{Mode: "bogus"})
+	if !errors.Is(err, service.ErrInvalidFees) {
+		t.Errorf("want ErrInvalidFees got %v", err)
+	}
+
+	// ledger mode defaults to the unregistered "fees" ledger
+	err = env.Service.SetFeeSettings(service.FeeSettings{Mode: service.FeeModeLedger})
+	if !errors.Is(err, service.ErrLedgerNotFound) {
+		t.Errorf("want ErrLedgerNotFound got %v", err)
+	}
+}
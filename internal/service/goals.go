@@ -0,0 +1,380 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"git.sr.ht/~jakintosh/coffer/pkg/wire"
+	"github.com/google/uuid"
+)
+
+// Goal is a funding target for a ledger, raised between Start and Deadline
+type Goal struct {
+	ID          string    `json:"id"`
+	Ledger      string    `json:"ledger"`
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	Target      int       `json:"target"`
+	Start       time.Time `json:"start"`
+	Deadline    time.Time `json:"deadline"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// GoalProgress is a goal with the funds raised so far, which are the
+// incoming funds on its ledger from Start until now or Deadline.
+type GoalProgress struct {
+	Goal
+	Raised    int  `json:"raised"`
+	Remaining int  `json:"remaining"`
+	Percent   int  `json:"percent"`
+	Reached   bool `json:"reached"`
+	Ended     bool `json:"ended"`
+}
+
+func (g Goal) validate() error {
+	if g.Target <= 0 {
+		return ErrInvalidGoal
+	}
+	if !g.Deadline.After(g.Start) {
+		return ErrInvalidGoal
+	}
+	return nil
+}
+
+func (s *Service) ListGoals() (
+	[]Goal,
+	error,
+) {
+	goals, err := s.store.GetGoals()
+	if err != nil {
+		return nil, DatabaseError{err}
+	}
+
+	return goals, nil
+}
+
+func (s *Service) GetGoal(
+	id string,
+) (
+	*Goal,
+	error,
+) {
+	goal, err := s.store.GetGoal(id)
+	if errors.Is(err, ErrGoalNotFound) {
+		return nil, err
+	} else if err != nil {
+		return nil, DatabaseError{err}
+	}
+
+	return goal, nil
+}
+
+func (s *Service) CreateGoal(
+	goal Goal,
+) (
+	*Goal,
+	error,
+) {
+	if err := goal.validate(); err != nil {
+		return nil, err
+	}
+	if err := s.requireOpenLedger(goal.Ledger); err != nil {
+		return nil, err
+	}
+	if goal.ID == "" {
+		goal.ID = uuid.NewString()
+	}
+
+	err := s.store.InsertGoal(goal)
+	if errors.Is(err, ErrGoalExists) {
+		return nil, err
+	} else if err != nil {
+		return nil, DatabaseError{err}
+	}
+
+	return s.GetGoal(goal.ID)
+}
+
+func (s *Service) UpdateGoal(
+	goal Goal,
+) (
+	*Goal,
+	error,
+) {
+	if err := goal.validate(); err != nil {
+		return nil, err
+	}
+	if _, err := s.GetLedgerInfo(goal.Ledger); err != nil {
+		return nil, err
+	}
+
+	err := s.store.UpdateGoal(goal)
+	if errors.Is(err, ErrGoalNotFound) {
+		return nil, err
+	} else if err != nil {
+		return nil, DatabaseError{err}
+	}
+
+	return s.GetGoal(goal.ID)
+}
+
+func (s *Service) DeleteGoal(
+	id string,
+) error {
+	err := s.store.DeleteGoal(id)
+	if errors.Is(err, ErrGoalNotFound) {
+		return err
+	} else if err != nil {
+		return DatabaseError{err}
+	}
+
+	return nil
+}
+
+// GetGoalProgress computes the progress of a goal from its ledger snapshot.
+// Goals on private ledgers are reported as not found.
+func (s *Service) GetGoalProgress(
+	id string,
+) (
+	*GoalProgress,
+	error,
+) {
+	goal, err := s.GetGoal(id)
+	if err != nil {
+		return nil, err
+	}
+
+	ledger, err := s.GetLedgerInfo(goal.Ledger)
+	if errors.Is(err, ErrLedgerNotFound) {
+		return nil, ErrGoalNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	if !ledger.Public {
+		return nil, ErrGoalNotFound
+	}
+
+	now := s.Clock()
+	until := goal.Deadline
+	if now.Before(until) {
+		until = now
+	}
+	snapshot, err := s.GetSnapshot(goal.Ledger, goal.Start, until)
+	if err != nil {
+		return nil, err
+	}
+
+	progress := &GoalProgress{
+		Goal:      *goal,
+		Raised:    snapshot.IncomingFunds,
+		Remaining: max(goal.Target-snapshot.IncomingFunds, 0),
+		Percent:   snapshot.IncomingFunds * 100 / goal.Target,
+		Reached:   snapshot.IncomingFunds >= goal.Target,
+		Ended:     !now.Before(goal.Deadline),
+	}
+	return progress, nil
+}
+
+type CreateGoalRequest struct {
+	ID          string `json:"id"`
+	Ledger      string `json:"ledger"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Target      int    `json:"target"`
+	Start       string `json:"start"`
+	Deadline    string `json:"deadline"`
+}
+
+// UpdateGoalRequest changes a goal; omitted fields are unchanged
+type UpdateGoalRequest struct {
+	Ledger      *string `json:"ledger"`
+	Title       *string `json:"title"`
+	Description *string `json:"description"`
+	Target      *int    `json:"target"`
+	Start       *string `json:"start"`
+	Deadline    *string `json:"deadline"`
+}
+
+func (s *Service) buildGoalsRouter(
+	mux *http.ServeMux,
+	mw Middleware,
+) {
+	mux.HandleFunc("GET /goals/{id}", mw.CORS(s.handleGetGoalProgress))
+	mux.HandleFunc("OPTIONS /goals/{id}", mw.CORS(s.handleGetGoalProgress))
+
+	mux.HandleFunc("GET /settings/goals", mw.Auth(s.handleGetGoals))
+	mux.HandleFunc("POST /settings/goals", mw.Auth(s.handlePostGoal))
+	mux.HandleFunc("GET /settings/goals/{id}", mw.Auth(s.handleGetGoal))
+	mux.HandleFunc("PUT /settings/goals/{id}", mw.Auth(s.handlePutGoal))
+	mux.HandleFunc("DELETE /settings/goals/{id}", mw.Auth(s.handleDeleteGoal))
+}
+
+func (s *Service) handleGetGoalProgress(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	progress, err := s.GetGoalProgress(r.PathValue("id"))
+	if err != nil {
+		if errors.Is(err, ErrGoalNotFound) {
+			wire.WriteError(w, http.StatusNotFound, "Goal Not Found")
+		} else {
+			wire.WriteError(w, http.StatusInternalServerError, "Internal Server Error")
+		}
+		return
+	}
+
+	wire.WriteData(w, http.StatusOK, progress)
+}
+
+func (s *Service) handleGetGoals(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	goals, err := s.ListGoals()
+	if err != nil {
+		wire.WriteError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+
+	wire.WriteData(w, http.StatusOK, goals)
+}
+
+func (s *Service) handlePostGoal(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	var req CreateGoalRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		wire.WriteError(w, http.StatusBadRequest, "Malformed JSON")
+		return
+	}
+
+	// validate dates as RFC3339, starting now by default
+	start := s.Clock()
+	if req.Start != "" {
+		var err error
+		if start, err = time.Parse(time.RFC3339, req.Start); err != nil {
+			wire.WriteError(w, http.StatusBadRequest, "Invalid RFC3339 Date")
+			return
+		}
+	}
+	deadline, err := time.Parse(time.RFC3339, req.Deadline)
+	if err != nil {
+		wire.WriteError(w, http.StatusBadRequest, "Invalid RFC3339 Date")
+		return
+	}
+
+	goal, err := s.CreateGoal(Goal{
+		ID:          req.ID,
+		Ledger:      req.Ledger,
+		Title:       req.Title,
+		Description: req.Description,
+		Target:      req.Target,
+		Start:       start,
+		Deadline:    deadline,
+	})
+	if err != nil {
+		writeGoalError(w, err)
+		return
+	}
+
+	wire.WriteData(w, http.StatusCreated, goal)
+}
+
+func (s *Service) handleGetGoal(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	goal, err := s.GetGoal(r.PathValue("id"))
+	if err != nil {
+		writeGoalError(w, err)
+		return
+	}
+
+	wire.WriteData(w, http.StatusOK, goal)
+}
+
+func (s *Service) handlePutGoal(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	var req UpdateGoalRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		wire.WriteError(w, http.StatusBadRequest, "Malformed JSON")
+		return
+	}
+
+	goal, err := s.GetGoal(r.PathValue("id"))
+	if err != nil {
+		writeGoalError(w, err)
+		return
+	}
+
+	if req.Ledger != nil {
+		goal.Ledger = *req.Ledger
+	}
+	if req.Title != nil {
+		goal.Title = *req.Title
+	}
+	if req.Description != nil {
+		goal.Description = *req.Description
+	}
+	if req.Target != nil {
+		goal.Target = *req.Target
+	}
+	if req.Start != nil {
+		if goal.Start, err = time.Parse(time.RFC3339, *req.Start); err != nil {
+			wire.WriteError(w, http.StatusBadRequest, "Invalid RFC3339 Date")
+			return
+		}
+	}
+	if req.Deadline != nil {
+		if goal.Deadline, err = time.Parse(time.RFC3339, *req.Deadline); err != nil {
+			wire.WriteError(w, http.StatusBadRequest, "Invalid RFC3339 Date")
+			return
+		}
+	}
+
+	goal, err = s.UpdateGoal(*goal)
+	if err != nil {
+		writeGoalError(w, err)
+		return
+	}
+
+	wire.WriteData(w, http.StatusOK, goal)
+}
+
+func (s *Service) handleDeleteGoal(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	if err := s.DeleteGoal(r.PathValue("id")); err != nil {
+		writeGoalError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeGoalError(
+	w http.ResponseWriter,
+	err error,
+) {
+	switch {
+	case errors.Is(err, ErrInvalidGoal):
+		wire.WriteError(w, http.StatusBadRequest, "Invalid Goal")
+	case errors.Is(err, ErrLedgerNotFound):
+		wire.WriteError(w, http.StatusBadRequest, "Unknown Ledger")
+	case errors.Is(err, ErrLedgerArchived):
+		wire.WriteError(w, http.StatusBadRequest, "Archived Ledger")
+	case errors.Is(err, ErrGoalNotFound):
+		wire.WriteError(w, http.StatusNotFound, "Goal Not Found")
+	case errors.Is(err, ErrGoalExists):
+		wire.WriteError(w, http.StatusConflict, "Goal Exists")
+	default:
+		wire.WriteError(w, http.StatusInternalServerError, "Internal Server Error")
+	}
+}
@@ -0,0 +1,87 @@
+package service_test
+
+import (
+	"net/http"
+	"testing"
+
+	"git.sr.ht/~jakintosh/coffer/internal/service"
+	"git.sr.ht/~jakintosh/coffer/internal/testutil"
+	"git.sr.ht/~jakintosh/coffer/pkg/wire"
+)
+
+func TestAPIGoals(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	router := env.Service.BuildRouter()
+	auth := testutil.MakeAuthHeader(t, env.Service)
+	testutil.SeedTransactionData(t, env.Service)
+
+	// create goal
+	body := `
+	{
+		"id": "servers",
+		"ledger": "general",
+		"title": "server costs",
+		"target": 1000,
+		"start": "2025-01-01T00:00:00Z",
+		"deadline": "2025-12-31T00:00:00Z"
+	}`
+	result := wire.TestPost[service.Goal](router, "/settings/goals", body, auth)
+	result.ExpectStatus(t, http.StatusCreated)
+
+	// duplicate id
+	result = wire.TestPost[service.Goal](router, "/settings/goals", body, auth)
+	result.ExpectStatus(t, http.StatusConflict)
+
+	// update target only
+	putResult := wire.TestPut[service.Goal](router, "/settings/goals/servers", `{ "target": 300 }`, auth)
+	goal := putResult.ExpectOK(t)
+	if goal.Target != 300 || goal.Title != "server costs" {
+		t.Errorf("unexpected goal %+v", goal)
+	}
+
+	// public progress needs no auth
+	progressResult := wire.TestGet[service.GoalProgress](router, "/goals/servers")
+	progress := progressResult.ExpectOK(t)
+	if progress.Raised != 300 || !progress.Reached || progress.Percent != 100 {
+		t.Errorf("unexpected progress %+v", progress)
+	}
+
+	// list and delete
+	listResult := wire.TestGet[[]service.Goal](router, "/settings/goals", auth)
+	if goals := listResult.ExpectOK(t); len(goals) != 1 {
+		t.Errorf("expected 1 goal, got %d", len(goals))
+	}
+	deleteResult := wire.TestDelete[any](router, "/settings/goals/servers", auth)
+	deleteResult.ExpectStatus(t, http.StatusNoContent)
+
+	missingResult := wire.TestGet[any](router, "/goals/servers")
+	missingResult.ExpectStatus(t, http.StatusNotFound)
+}
+
+func TestAPICreateGoalBadInput(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	router := env.Service.BuildRouter()
+	auth := testutil.MakeAuthHeader(t, env.Service)
+
+	body := `
+	{
+		"ledger": "general",
+		"target": 1000,
+		"deadline": "next year"
+	}`
+	result := wire.TestPost[any](router, "/settings/goals", body, auth)
+
+	result.ExpectStatus(t, http.StatusBadRequest)
+}
+
+func TestAPIGoalSettingsUnauthorized(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	router := env.Service.BuildRouter()
+
+	result := wire.TestGet[any](router, "/settings/goals")
+
+	result.ExpectStatus(t, http.StatusUnauthorized)
+}
@@ -0,0 +1,85 @@
+package service_test
+
+import (
+	"errors"
+	"testing"
+
+	"git.sr.ht/~jakintosh/coffer/internal/service"
+	"git.sr.ht/~jakintosh/coffer/internal/testutil"
+)
+
+func TestGoalProgress(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	svc := env.Service
+	testutil.SeedTransactionData(t, svc)
+
+	// only the february income falls within the goal
+	goal, err := svc.CreateGoal(service.Goal{
+		ID:       "servers",
+		Ledger:   "general",
+		Title:    "server costs",
+		Target:   400,
+		Start:    testutil.MakeDate(2025, 1, 15),
+		Deadline: testutil.MakeDate(2025, 6, 1),
+	})
+	if err != nil {
+		t.Fatalf("CreateGoal: %v", err)
+	}
+
+	progress, err := svc.GetGoalProgress(goal.ID)
+	if err != nil {
+		t.Fatalf("GetGoalProgress: %v", err)
+	}
+	if progress.Raised != 200 || progress.Remaining != 200 || progress.Percent != 50 {
+		t.Errorf("unexpected progress %+v", progress)
+	}
+	if progress.Reached || !progress.Ended {
+		t.Errorf("expected unreached, ended goal: %+v", progress)
+	}
+}
+
+func TestGoalProgressPrivateLedger(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	svc := env.Service
+	if _, err := svc.CreateLedger(service.Ledger{Name: "private"}); err != nil {
+		t.Fatal(err)
+	}
+
+	goal, err := svc.CreateGoal(service.Goal{
+		Ledger:   "private",
+		Target:   100,
+		Start:    testutil.MakeDate(2025, 1, 1),
+		Deadline: testutil.MakeDate(2025, 2, 1),
+	})
+	if err != nil {
+		t.Fatalf("CreateGoal: %v", err)
+	}
+
+	if _, err := svc.GetGoalProgress(goal.ID); !errors.Is(err, service.ErrGoalNotFound) {
+		t.Fatalf("expected ErrGoalNotFound, got %v", err)
+	}
+}
+
+func TestCreateGoalInvalid(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	svc := env.Service
+	start := testutil.MakeDate(2025, 1, 1)
+	deadline := testutil.MakeDate(2025, 2, 1)
+
+	cases := []struct {
+		goal service.Goal
+		want error
+	}{
+		{service.Goal{Ledger: "general", Target: 0, Start: start, Deadline: deadline}, service.ErrInvalidGoal},
+		{service.Goal{Ledger: "general", Target: 100, Start: deadline, Deadline: start}, service.ErrInvalidGoal},
+		{service.Goal{Ledger: "missing", Target: 100, Start: start, Deadline: deadline}, service.ErrLedgerNotFound},
+	}
+	for _, c := range cases {
+		if _, err := svc.CreateGoal(c.goal); !errors.Is(err, c.want) {
+			t.Errorf("CreateGoal(%+v): expected %v, got %v", c.goal, c.want, err)
+		}
+	}
+}
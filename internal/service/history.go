@@ -0,0 +1,177 @@
+package service
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"git.sr.ht/~jakintosh/coffer/pkg/wire"
+)
+
+// History intervals accepted by GetHistory
+const (
+	IntervalDay   = "day"
+	IntervalWeek  = "week"
+	IntervalMonth = "month"
+)
+
+// maxHistoryBuckets bounds the length of a single history series
+const maxHistoryBuckets = 5000
+
+// HistoryBucket is a ledger snapshot over one interval starting at Start
+type HistoryBucket struct {
+	Start time.Time `json:"start"`
+	LedgerSnapshot
+}
+
+// GetHistory returns a continuous series of snapshots for ledger, one per
+// interval. The range is widened to whole intervals: since is moved back to
+// the start of its bucket and until forward to the end of its bucket.
+func (s *Service) GetHistory(
+	ledger string,
+	interval string,
+	since time.Time,
+	until time.Time,
+) (
+	[]HistoryBucket,
+	error,
+) {
+	first, err := bucketStart(interval, since)
+	if err != nil {
+		return nil, err
+	}
+	last, _ := bucketStart(interval, until)
+	if last.Before(first) {
+		return nil, ErrInvalidInterval
+	}
+	end := nextBucket(interval, last).Add(-time.Second)
+
+	// count buckets before querying to bound the response
+	var starts []time.Time
+	for t := first; !t.After(last); t = nextBucket(interval, t) {
+		if len(starts) == maxHistoryBuckets {
+			return nil, ErrInvalidInterval
+		}
+		starts = append(starts, t)
+	}
+
+	opening, err := s.store.GetLedgerSnapshot(ledger, first.Unix(), first.Unix())
+	if err != nil {
+		return nil, DatabaseError{err}
+	}
+	flows, err := s.store.GetLedgerFlows(ledger, interval, first.Unix(), end.Unix())
+	if err != nil {
+		return nil, DatabaseError{err}
+	}
+
+	// fill in empty buckets and carry the running balance
+	balance := opening.OpeningBalance
+	history := make([]HistoryBucket, 0, len(starts))
+	for _, start := range starts {
+		b := HistoryBucket{Start: start}
+		if len(flows) > 0 && flows[0].Start.Equal(start) {
+			b.IncomingFunds = flows[0].IncomingFunds
+			b.OutgoingFunds = flows[0].OutgoingFunds
+			flows = flows[1:]
+		}
+		b.OpeningBalance = balance
+		b.ClosingBalance = balance + b.IncomingFunds + b.OutgoingFunds
+		balance = b.ClosingBalance
+		history = append(history, b)
+	}
+
+	return history, nil
+}
+
+// bucketStart truncates t to the start of its UTC interval
+func bucketStart(
+	interval string,
+	t time.Time,
+) (
+	time.Time,
+	error,
+) {
+	t = t.UTC()
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	switch interval {
+	case IntervalDay:
+		return day, nil
+	case IntervalWeek:
+		// weeks start on Monday
+		offset := (int(day.Weekday()) + 6) % 7
+		return day.AddDate(0, 0, -offset), nil
+	case IntervalMonth:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC), nil
+	default:
+		return time.Time{}, ErrInvalidInterval
+	}
+}
+
+func nextBucket(
+	interval string,
+	start time.Time,
+) time.Time {
+	switch interval {
+	case IntervalWeek:
+		return start.AddDate(0, 0, 7)
+	case IntervalMonth:
+		return start.AddDate(0, 1, 0)
+	default:
+		return start.AddDate(0, 0, 1)
+	}
+}
+
+func (s *Service) handleGetLedgerHistory(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	ledger := r.PathValue("ledger")
+	interval := r.URL.Query().Get("interval")
+	sinceQ := r.URL.Query().Get("since")
+	untilQ := r.URL.Query().Get("until")
+
+	var (
+		err   error
+		since time.Time
+		until time.Time
+	)
+
+	if interval == "" {
+		interval = IntervalMonth
+	}
+
+	if untilQ != "" {
+		if until, err = time.Parse("2006-01-02", untilQ); err != nil {
+			wire.WriteError(w, http.StatusBadRequest, "Malformed 'until' Query")
+			return
+		}
+	} else {
+		until = s.Clock()
+	}
+
+	if sinceQ != "" {
+		if since, err = time.Parse("2006-01-02", sinceQ); err != nil {
+			wire.WriteError(w, http.StatusBadRequest, "Malformed 'since' Query")
+			return
+		}
+	} else {
+		// default to the last twelve intervals
+		since, _ = bucketStart(interval, until)
+		for range 11 {
+			since, _ = bucketStart(interval, since.Add(-time.Second))
+		}
+	}
+
+	history, err := s.GetHistory(ledger, interval, since, until)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrInvalidInterval):
+			wire.WriteError(w, http.StatusBadRequest, "Invalid History Interval")
+		default:
+			wire.WriteError(w, http.StatusInternalServerError, "Internal Server Error")
+		}
+		return
+	}
+
+	wire.WriteData(w, http.StatusOK, history)
+}
@@ -0,0 +1,53 @@
+package service_test
+
+import (
+	"net/http"
+	"testing"
+
+	"git.sr.ht/~jakintosh/coffer/internal/service"
+	"git.sr.ht/~jakintosh/coffer/internal/testutil"
+	"git.sr.ht/~jakintosh/coffer/pkg/wire"
+)
+
+func TestAPIGetLedgerHistory(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	router := env.Service.BuildRouter()
+	testutil.SeedTransactionData(t, env.Service)
+
+	// get history
+	url := "/ledger/general/history?interval=day&since=2025-01-01&until=2025-01-03"
+	result := wire.TestGet[[]service.HistoryBucket](router, url)
+
+	// verify result
+	history := result.ExpectOK(t)
+	if len(history) != 3 {
+		t.Fatalf("expected 3 buckets, got %d", len(history))
+	}
+	if history[0].IncomingFunds != 100 || history[2].ClosingBalance != 100 {
+		t.Errorf("unexpected history %+v", history)
+	}
+}
+
+func TestAPIGetLedgerHistoryDefaultRange(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	router := env.Service.BuildRouter()
+
+	result := wire.TestGet[[]service.HistoryBucket](router, "/ledger/general/history")
+
+	history := result.ExpectOK(t)
+	if len(history) != 12 {
+		t.Fatalf("expected 12 monthly buckets, got %d", len(history))
+	}
+}
+
+func TestAPIGetLedgerHistoryBadInterval(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	router := env.Service.BuildRouter()
+
+	result := wire.TestGet[any](router, "/ledger/general/history?interval=hour")
+
+	result.ExpectStatus(t, http.StatusBadRequest)
+}
@@ -0,0 +1,86 @@
+package service_test
+
+import (
+	"errors"
+	"testing"
+
+	"git.sr.ht/~jakintosh/coffer/internal/service"
+	"git.sr.ht/~jakintosh/coffer/internal/testutil"
+)
+
+func TestGetHistoryMonthly(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	svc := env.Service
+	testutil.SeedTransactionData(t, svc)
+
+	// mid-month bounds widen to whole months
+	since := testutil.MakeDate(2024, 12, 15)
+	until := testutil.MakeDate(2025, 4, 10)
+	history, err := svc.GetHistory("general", service.IntervalMonth, since, until)
+	if err != nil {
+		t.Fatalf("GetHistory: %v", err)
+	}
+	if len(history) != 5 {
+		t.Fatalf("expected 5 buckets, got %d", len(history))
+	}
+
+	want := []struct {
+		opening, in, out, closing int
+	}{
+		{0, 0, 0, 0},
+		{0, 100, 0, 100},
+		{100, 200, 0, 300},
+		{300, 0, -50, 250},
+		{250, 0, 0, 250},
+	}
+	for i, w := range want {
+		b := history[i]
+		if !b.Start.Equal(testutil.MakeDate(2024, 12+i, 1)) {
+			t.Errorf("bucket %d: unexpected start %v", i, b.Start)
+		}
+		if b.OpeningBalance != w.opening || b.IncomingFunds != w.in ||
+			b.OutgoingFunds != w.out || b.ClosingBalance != w.closing {
+			t.Errorf("bucket %d: unexpected %+v", i, b.LedgerSnapshot)
+		}
+	}
+}
+
+func TestGetHistoryWeekly(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	svc := env.Service
+	testutil.SeedTransactionData(t, svc)
+
+	// 2025-01-01 is a Wednesday, so its week starts on 2024-12-30
+	since := testutil.MakeDate(2025, 1, 1)
+	until := testutil.MakeDate(2025, 1, 8)
+	history, err := svc.GetHistory("general", service.IntervalWeek, since, until)
+	if err != nil {
+		t.Fatalf("GetHistory: %v", err)
+	}
+	if len(history) != 2 {
+		t.Fatalf("expected 2 buckets, got %d", len(history))
+	}
+	if !history[0].Start.Equal(testutil.MakeDate(2024, 12, 30)) || history[0].IncomingFunds != 100 {
+		t.Errorf("unexpected first week %+v", history[0])
+	}
+	if history[1].OpeningBalance != 100 || history[1].ClosingBalance != 100 {
+		t.Errorf("unexpected second week %+v", history[1])
+	}
+}
+
+func TestGetHistoryInvalid(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	svc := env.Service
+	since := testutil.MakeDate(2025, 1, 1)
+	until := testutil.MakeDate(2025, 2, 1)
+
+	if _, err := svc.GetHistory("general", "year", since, until); !errors.Is(err, service.ErrInvalidInterval) {
+		t.Errorf("expected ErrInvalidInterval for unknown interval, got %v", err)
+	}
+	if _, err := svc.GetHistory("general", service.IntervalDay, until, since); !errors.Is(err, service.ErrInvalidInterval) {
+		t.Errorf("expected ErrInvalidInterval for reversed range, got %v", err)
+	}
+}
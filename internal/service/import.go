@@ -0,0 +1,405 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"git.sr.ht/~jakintosh/coffer/pkg/wire"
+)
+
+// maxImportSize caps the size of an import request body
+const maxImportSize = 10 << 20
+
+// ImportColumns maps transaction fields to CSV header names. Empty fields
+// fall back to the field name itself ("id", "date", "amount", "label"). The
+// id column is optional; rows without one get a stable content hash.
+type ImportColumns struct {
+	ID     string `json:"id"`
+	Date   string `json:"date"`
+	Amount string `json:"amount"`
+	Label  string `json:"label"`
+}
+
+// ImportOptions controls how CSV rows are parsed into transactions
+type ImportOptions struct {
+	Columns    ImportColumns
+	DateFormat string
+	MajorUnits bool
+	DryRun     bool
+}
+
+// ImportRow is a parsed CSV row. Duplicate rows are already on the ledger
+// and are skipped on commit.
+type ImportRow struct {
+	Line      int         `json:"line"`
+	Tx        Transaction `json:"transaction"`
+	Duplicate bool        `json:"duplicate"`
+}
+
+// ImportError is a validation failure on a single CSV row
+type ImportError struct {
+	Line    int    `json:"line"`
+	Message string `json:"message"`
+}
+
+// ImportResult reports the outcome of an import. Nothing is committed if
+// there are any errors or if the import was a dry run.
+type ImportResult struct {
+	Rows       []ImportRow   `json:"rows"`
+	Errors     []ImportError `json:"errors"`
+	Imported   int           `json:"imported"`
+	Duplicates int           `json:"duplicates"`
+	Committed  bool          `json:"committed"`
+}
+
+// ImportTransactions parses CSV transactions from r onto ledger. Every row
+// is validated before anything is written, and all new rows are committed
+// together or not at all.
+func (s *Service) ImportTransactions(
+	ledger string,
+	r io.Reader,
+	opts ImportOptions,
+) (
+	*ImportResult,
+	error,
+) {
+	if err := s.requireOpenLedger(ledger); err != nil {
+		return nil, err
+	}
+
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to read header: %v", ErrInvalidImport, err)
+	}
+	cols, err := opts.Columns.indices(header)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ImportResult{
+		Rows:   []ImportRow{},
+		Errors: []ImportError{},
+	}
+	seen := map[string]int{}
+	ids := map[string]int{}
+	var pending []Transaction
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				result.Errors = append(result.Errors, ImportError{
+					Line:    parseErr.Line,
+					Message: parseErr.Err.Error(),
+				})
+				continue
+			}
+			return nil, fmt.Errorf("%w: %v", ErrInvalidImport, err)
+		}
+		line, _ := reader.FieldPos(0)
+
+		tx, err := parseImportRecord(record, cols, ledger, opts)
+		if err != nil {
+			result.Errors = append(result.Errors, ImportError{line, err.Error()})
+			continue
+		}
+
+		if tx.ID == "" {
+			key := importKey(tx)
+			tx.ID = importID(key, seen[key])
+			seen[key]++
+		}
+		if prev, ok := ids[tx.ID]; ok {
+			result.Errors = append(result.Errors, ImportError{
+				Line:    line,
+				Message: fmt.Sprintf("duplicate id %q (also on line %d)", tx.ID, prev),
+			})
+			continue
+		}
+		ids[tx.ID] = line
+
+		existing, err := s.store.GetTransaction(tx.ID)
+		if err != nil && !errors.Is(err, ErrTxNotFound) {
+			return nil, DatabaseError{err}
+		}
+		row := ImportRow{Line: line, Tx: tx}
+		if existing != nil {
+			if !sameTransaction(*existing, tx) {
+				result.Errors = append(result.Errors, ImportError{
+					Line:    line,
+					Message: fmt.Sprintf("id %q conflicts with an existing transaction", tx.ID),
+				})
+				continue
+			}
+			row.Duplicate = true
+			result.Duplicates++
+		} else {
+			pending = append(pending, tx)
+		}
+		result.Rows = append(result.Rows, row)
+	}
+
+	if opts.DryRun || len(result.Errors) > 0 {
+		return result, nil
+	}
+
+	if len(pending) > 0 {
+		err := s.store.InsertTransactions(pending)
+		if errors.Is(err, ErrTxConflict) {
+			return nil, err
+		} else if err != nil {
+			return nil, DatabaseError{err}
+		}
+	}
+	result.Imported = len(pending)
+	result.Committed = true
+
+	return result, nil
+}
+
+type importColumnIndices struct {
+	id, date, amount, label int
+}
+
+func (c ImportColumns) indices(
+	header []string,
+) (
+	importColumnIndices,
+	error,
+) {
+	find := func(name, fallback string, required bool) (int, error) {
+		if name == "" {
+			name = fallback
+		}
+		for i, h := range header {
+			if strings.EqualFold(strings.TrimSpace(h), name) {
+				return i, nil
+			}
+		}
+		if required {
+			return -1, fmt.Errorf("%w: missing %q column", ErrInvalidImport, name)
+		}
+		return -1, nil
+	}
+
+	var (
+		idx importColumnIndices
+		err error
+	)
+	if idx.id, err = find(c.ID, "id", c.ID != ""); err != nil {
+		return idx, err
+	}
+	if idx.date, err = find(c.Date, "date", true); err != nil {
+		return idx, err
+	}
+	if idx.amount, err = find(c.Amount, "amount", true); err != nil {
+		return idx, err
+	}
+	if idx.label, err = find(c.Label, "label", c.Label != ""); err != nil {
+		return idx, err
+	}
+	return idx, nil
+}
+
+func parseImportRecord(
+	record []string,
+	cols importColumnIndices,
+	ledger string,
+	opts ImportOptions,
+) (
+	Transaction,
+	error,
+) {
+	field := func(i int) string {
+		if i < 0 || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	date, err := parseImportDate(field(cols.date), opts.DateFormat)
+	if err != nil {
+		return Transaction{}, err
+	}
+	amount, err := parseImportAmount(field(cols.amount), opts.MajorUnits)
+	if err != nil {
+		return Transaction{}, err
+	}
+
+	return Transaction{
+		ID:     field(cols.id),
+		Ledger: ledger,
+		Amount: amount,
+		Date:   date,
+		Label:  field(cols.label),
+	}, nil
+}
+
+func parseImportDate(
+	value string,
+	layout string,
+) (
+	time.Time,
+	error,
+) {
+	if value == "" {
+		return time.Time{}, errors.New("missing date")
+	}
+	if layout != "" {
+		date, err := time.Parse(layout, value)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid date %q", value)
+		}
+		return date, nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+		if date, err := time.Parse(layout, value); err == nil {
+			return date, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q", value)
+}
+
+// parseImportAmount parses an amount in minor units, or in major units with
+// up to two decimal places. Thousands separators are ignored.
+func parseImportAmount(
+	value string,
+	major bool,
+) (
+	int,
+	error,
+) {
+	s := strings.ReplaceAll(value, ",", "")
+	if s == "" {
+		return 0, errors.New("missing amount")
+	}
+	if !major {
+		amount, err := strconv.Atoi(s)
+		if err != nil {
+			return 0, fmt.Errorf("invalid amount %q", value)
+		}
+		return amount, nil
+	}
+
+	sign := 1
+	switch s[0] {
+	case '-':
+		sign = -1
+		s = s[1:]
+	case '+':
+		s = s[1:]
+	}
+	whole, frac, _ := strings.Cut(s, ".")
+	if whole == "" && frac == "" || len(frac) > 2 {
+		return 0, fmt.Errorf("invalid amount %q", value)
+	}
+	for len(frac) < 2 {
+		frac += "0"
+	}
+	if whole == "" {
+		whole = "0"
+	}
+	units, err := strconv.Atoi(whole)
+	if err != nil || units < 0 {
+		return 0, fmt.Errorf("invalid amount %q", value)
+	}
+	cents, err := strconv.Atoi(frac)
+	if err != nil || cents < 0 {
+		return 0, fmt.Errorf("invalid amount %q", value)
+	}
+	return sign * (units*100 + cents), nil
+}
+
+// importKey identifies the content of an imported transaction
+func importKey(
+	tx Transaction,
+) string {
+	return fmt.Sprintf("%s|%d|%d|%s", tx.Ledger, tx.Date.Unix(), tx.Amount, tx.Label)
+}
+
+// importID derives a stable transaction ID from its content and the number
+// of identical rows before it in the same file, so that re-importing a file
+// produces the same IDs.
+func importID(
+	key string,
+	occurrence int,
+) string {
+	sum := sha256.Sum256(fmt.Appendf(nil, "%s|%d", key, occurrence))
+	return "import:" + hex.EncodeToString(sum[:12])
+}
+
+func sameTransaction(a, b Transaction) bool {
+	return a.Ledger == b.Ledger &&
+		a.Amount == b.Amount &&
+		a.Date.Unix() == b.Date.Unix() &&
+		a.Label == b.Label
+}
+
+type ImportTransactionsRequest struct {
+	CSV        string        `json:"csv"`
+	Columns    ImportColumns `json:"columns"`
+	DateFormat string        `json:"date_format"`
+	MajorUnits bool          `json:"major_units"`
+	DryRun     bool          `json:"dry_run"`
+}
+
+func (s *Service) handlePostLedgerImport(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	ledger := r.PathValue("ledger")
+
+	// decode body
+	var req ImportTransactionsRequest
+	body := http.MaxBytesReader(w, r.Body, maxImportSize)
+	if err := json.NewDecoder(body).Decode(&req); err != nil {
+		wire.WriteError(w, http.StatusBadRequest, "Malformed JSON")
+		return
+	}
+
+	opts := ImportOptions{
+		Columns:    req.Columns,
+		DateFormat: req.DateFormat,
+		MajorUnits: req.MajorUnits,
+		DryRun:     req.DryRun,
+	}
+	result, err := s.ImportTransactions(ledger, strings.NewReader(req.CSV), opts)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrInvalidImport):
+			wire.WriteError(w, http.StatusBadRequest, err.Error())
+		case errors.Is(err, ErrLedgerNotFound):
+			wire.WriteError(w, http.StatusNotFound, "Ledger Not Found")
+		case errors.Is(err, ErrLedgerArchived):
+			wire.WriteError(w, http.StatusConflict, "Ledger Archived")
+		case errors.Is(err, ErrTxConflict):
+			wire.WriteError(w, http.StatusConflict, "Transaction Conflict")
+		default:
+			wire.WriteError(w, http.StatusInternalServerError, "Internal Server Error")
+		}
+		return
+	}
+
+	code := http.StatusOK
+	if result.Committed {
+		code = http.StatusCreated
+	}
+	wire.WriteData(w, code, result)
+}
@@ -0,0 +1,82 @@
+package service_test
+
+import (
+	"net/http"
+	"testing"
+
+	"git.sr.ht/~jakintosh/coffer/internal/service"
+	"git.sr.ht/~jakintosh/coffer/internal/testutil"
+	"git.sr.ht/~jakintosh/coffer/pkg/wire"
+)
+
+func TestAPIImportTransactions(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	router := env.Service.BuildRouter()
+
+	// post import
+	url := "/ledger/general/import"
+	body := `
+	{
+		"csv": "id,date,amount,label\nbank-1,2025-01-02,500,deposit\n",
+		"dry_run": false
+	}`
+	auth := testutil.MakeAuthHeader(t, env.Service)
+	result := wire.TestPost[service.ImportResult](router, url, body, auth)
+
+	// verify result
+	result.ExpectStatus(t, http.StatusCreated)
+	imported := result.ExpectOK(t)
+	if !imported.Committed || imported.Imported != 1 {
+		t.Fatalf("unexpected result %+v", imported)
+	}
+	if imported.Rows[0].Tx.ID != "bank-1" {
+		t.Errorf("expected mapped id, got %q", imported.Rows[0].Tx.ID)
+	}
+}
+
+func TestAPIImportTransactionsDryRun(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	router := env.Service.BuildRouter()
+
+	url := "/ledger/general/import"
+	body := `
+	{
+		"csv": "date,amount,label\n2025-01-02,500,deposit\nbad,1,x\n",
+		"dry_run": true
+	}`
+	auth := testutil.MakeAuthHeader(t, env.Service)
+	result := wire.TestPost[service.ImportResult](router, url, body, auth)
+
+	// verify result
+	result.ExpectStatus(t, http.StatusOK)
+	preview := result.ExpectOK(t)
+	if preview.Committed || len(preview.Errors) != 1 || preview.Errors[0].Line != 3 {
+		t.Fatalf("unexpected preview %+v", preview)
+	}
+}
+
+func TestAPIImportTransactionsMissingColumn(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	router := env.Service.BuildRouter()
+
+	url := "/ledger/general/import"
+	body := `{ "csv": "foo,bar\n1,2\n" }`
+	auth := testutil.MakeAuthHeader(t, env.Service)
+	result := wire.TestPost[any](router, url, body, auth)
+
+	result.ExpectStatus(t, http.StatusBadRequest)
+}
+
+func TestAPIImportTransactionsUnauthorized(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	router := env.Service.BuildRouter()
+
+	url := "/ledger/general/import"
+	result := wire.TestPost[any](router, url, `{}`)
+
+	result.ExpectStatus(t, http.StatusUnauthorized)
+}
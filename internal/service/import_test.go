@@ -0,0 +1,126 @@
+package service_test
+
+import (
+	"strings"
+	"testing"
+
+	"git.sr.ht/~jakintosh/coffer/internal/service"
+	"git.sr.ht/~jakintosh/coffer/internal/testutil"
+)
+
+const importCSV = `Date,Amount,Description
+2025-01-02,12.50,coffee beans
+2025-01-03,"1,000.00",grant
+2025-01-03,-4,milk
+2025-01-03,-4,milk
+`
+
+var importOpts = service.ImportOptions{
+	Columns: service.ImportColumns{
+		Label: "Description",
+	},
+	MajorUnits: true,
+}
+
+func TestImportTransactions(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	svc := env.Service
+
+	result, err := svc.ImportTransactions("general", strings.NewReader(importCSV), importOpts)
+	if err != nil {
+		t.Fatalf("ImportTransactions: %v", err)
+	}
+	if !result.Committed || result.Imported != 4 || len(result.Errors) != 0 {
+		t.Fatalf("unexpected result %+v", result)
+	}
+	if result.Rows[1].Tx.Amount != 100000 || result.Rows[2].Tx.Amount != -400 {
+		t.Errorf("unexpected amounts %+v", result.Rows)
+	}
+	if result.Rows[2].Tx.ID == result.Rows[3].Tx.ID {
+		t.Errorf("identical rows should get distinct ids")
+	}
+
+	txs, err := svc.GetTransactions("general", 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(txs) != 4 {
+		t.Fatalf("expected 4 transactions, got %d", len(txs))
+	}
+
+	// re-importing the same file only finds duplicates
+	result, err = svc.ImportTransactions("general", strings.NewReader(importCSV), importOpts)
+	if err != nil {
+		t.Fatalf("ImportTransactions: %v", err)
+	}
+	if result.Imported != 0 || result.Duplicates != 4 {
+		t.Errorf("expected 4 duplicates, got %+v", result)
+	}
+}
+
+func TestImportTransactionsDryRun(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	svc := env.Service
+
+	opts := importOpts
+	opts.DryRun = true
+	result, err := svc.ImportTransactions("general", strings.NewReader(importCSV), opts)
+	if err != nil {
+		t.Fatalf("ImportTransactions: %v", err)
+	}
+	if result.Committed || len(result.Rows) != 4 {
+		t.Fatalf("unexpected result %+v", result)
+	}
+
+	txs, err := svc.GetTransactions("general", 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(txs) != 0 {
+		t.Errorf("dry run should not write, got %d transactions", len(txs))
+	}
+}
+
+func TestImportTransactionsRowErrors(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	svc := env.Service
+
+	csv := `date,amount,label
+2025-01-02,100,ok
+not-a-date,100,bad date
+2025-01-04,1.5,bad amount
+`
+	result, err := svc.ImportTransactions("general", strings.NewReader(csv), service.ImportOptions{})
+	if err != nil {
+		t.Fatalf("ImportTransactions: %v", err)
+	}
+	if result.Committed {
+		t.Fatalf("import with errors should not commit")
+	}
+	if len(result.Errors) != 2 || result.Errors[0].Line != 3 || result.Errors[1].Line != 4 {
+		t.Fatalf("unexpected errors %+v", result.Errors)
+	}
+
+	txs, err := svc.GetTransactions("general", 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(txs) != 0 {
+		t.Errorf("expected nothing written, got %d transactions", len(txs))
+	}
+}
+
+func TestImportTransactionsMissingColumn(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	svc := env.Service
+
+	csv := "when,amount\n2025-01-02,100\n"
+	_, err := svc.ImportTransactions("general", strings.NewReader(csv), service.ImportOptions{})
+	if err == nil {
+		t.Fatalf("expected error for missing date column")
+	}
+}
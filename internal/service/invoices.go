@@ -0,0 +1,189 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	stripe "github.com/stripe/stripe-go/v82"
+)
+
+// Invoice is a Stripe invoice as recorded locally, linked to the
+// subscription that generated it and the payment intent that paid it. The
+// period, price and product are those of its first line, which for a
+// subscription is the period billed. Failed is set while the invoice is
+// open after a failed payment attempt, such as a failed renewal, and is
+// cleared once it is paid, voided or marked uncollectible.
+type Invoice struct {
+	ID            string     `json:"id"`
+	Customer      string     `json:"customer"`
+	Subscription  string     `json:"subscription,omitempty"`
+	Payment       string     `json:"payment,omitempty"`
+	Created       time.Time  `json:"created"`
+	Status        string     `json:"status"`
+	BillingReason string     `json:"billing_reason"`
+	AmountDue     int        `json:"amount_due"`
+	AmountPaid    int        `json:"amount_paid"`
+	Currency      string     `json:"currency"`
+	PeriodStart   *time.Time `json:"period_start,omitempty"`
+	PeriodEnd     *time.Time `json:"period_end,omitempty"`
+	PriceID       string     `json:"price_id,omitempty"`
+	ProductID     string     `json:"product_id,omitempty"`
+	Attempts      int        `json:"attempts"`
+	NextAttempt   *time.Time `json:"next_attempt,omitempty"`
+	Failed        bool       `json:"failed"`
+}
+
+func (s *Service) GetInvoice(
+	id string,
+) (
+	*Invoice,
+	error,
+) {
+	inv, err := s.store.GetInvoice(id)
+	if errors.Is(err, ErrInvoiceNotFound) {
+		return nil, err
+	} else if err != nil {
+		return nil, DatabaseError{err}
+	}
+
+	return inv, nil
+}
+
+// RecordInvoice records the current state of an invoice
+func (s *Service) RecordInvoice(
+	inv Invoice,
+) error {
+	inv.Failed = inv.Status == string(stripe.InvoiceStatusOpen) && inv.Attempts > 0
+	if err := s.store.InsertInvoice(inv); err != nil {
+		return DatabaseError{err}
+	}
+	return nil
+}
+
+func (s *Service) processInvoice(
+	id string,
+) error {
+	log.Printf(" -> invoice %s", id)
+	sc, err := s.stripeClient()
+	if err != nil {
+		return err
+	}
+	params := &stripe.InvoiceRetrieveParams{}
+	inv, err := sc.V1Invoices.Retrieve(context.Background(), id, params)
+	if err != nil {
+		if stripeErr, ok := err.(*stripe.Error); ok {
+			log.Printf("<-  invoice %s STRIPE ERROR: %v", id, stripeErr)
+		} else {
+			log.Printf("<-  invoice %s ERROR: %v", id, err)
+		}
+		return err
+	}
+	log.Printf("<-  invoice %s", id)
+
+	// the payment that settled the invoice, or the default one until then
+	payment := ""
+	ipParams := &stripe.InvoicePaymentListParams{
+		Invoice: stripe.String(id),
+	}
+	for ip, err := range sc.V1InvoicePayments.List(context.Background(), ipParams) {
+		if err != nil {
+			log.Printf("<-  invoice %s payments ERROR: %v", id, err)
+			return err
+		}
+		if ip.Payment == nil || ip.Payment.PaymentIntent == nil {
+			continue
+		}
+		if ip.Status == "paid" {
+			payment = ip.Payment.PaymentIntent.ID
+			break
+		}
+		if ip.IsDefault {
+			payment = ip.Payment.PaymentIntent.ID
+		}
+	}
+
+	return s.recordInvoice(inv, payment)
+}
+
+func (s *Service) recordInvoice(
+	inv *stripe.Invoice,
+	payment string,
+) error {
+	id := inv.ID
+	record := Invoice{
+		ID:            id,
+		Subscription:  invoiceSubscription(inv),
+		Payment:       payment,
+		Created:       time.Unix(inv.Created, 0),
+		Status:        string(inv.Status),
+		BillingReason: string(inv.BillingReason),
+		AmountDue:     int(inv.AmountDue),
+		AmountPaid:    int(inv.AmountPaid),
+		Currency:      string(inv.Currency),
+		PeriodStart:   unixTime(inv.PeriodStart),
+		PeriodEnd:     unixTime(inv.PeriodEnd),
+		Attempts:      int(inv.AttemptCount),
+		NextAttempt:   unixTime(inv.NextPaymentAttempt),
+	}
+	if inv.Customer != nil {
+		record.Customer = inv.Customer.ID
+	}
+	if inv.Lines != nil && len(inv.Lines.Data) > 0 {
+		line := inv.Lines.Data[0]
+		if line.Period != nil {
+			record.PeriodStart = unixTime(line.Period.Start)
+			record.PeriodEnd = unixTime(line.Period.End)
+		}
+		if p := line.Pricing; p != nil && p.PriceDetails != nil {
+			record.PriceID = p.PriceDetails.Price
+			record.ProductID = p.PriceDetails.Product
+		}
+	}
+
+	if err := s.RecordInvoice(record); err != nil {
+		log.Printf("DB ERROR invoice %s: %v", id, err)
+		return err
+	}
+	if record.Failed {
+		log.Printf("[!] invoice %s payment failed after %d attempts", id, record.Attempts)
+	}
+	log.Printf("OK invoice %s", id)
+	return nil
+}
+
+// invoiceSubscription returns the ID of the subscription that generated an
+// invoice, if any
+func invoiceSubscription(
+	inv *stripe.Invoice,
+) string {
+	if p := inv.Parent; p != nil && p.SubscriptionDetails != nil && p.SubscriptionDetails.Subscription != nil {
+		return p.SubscriptionDetails.Subscription.ID
+	}
+	return ""
+}
+
+// invoiceContext collects the metadata, price and product of a payment
+// from the invoice it paid. Invoice metadata takes precedence over the
+// metadata of the subscription when the invoice was finalized.
+func invoiceContext(
+	inv *stripe.Invoice,
+) PaymentContext {
+	pctx := PaymentContext{Metadata: map[string]string{}}
+	if p := inv.Parent; p != nil && p.SubscriptionDetails != nil {
+		for k, v := range p.SubscriptionDetails.Metadata {
+			pctx.Metadata[k] = v
+		}
+	}
+	for k, v := range inv.Metadata {
+		pctx.Metadata[k] = v
+	}
+	if inv.Lines != nil && len(inv.Lines.Data) > 0 {
+		if p := inv.Lines.Data[0].Pricing; p != nil && p.PriceDetails != nil {
+			pctx.PriceID = p.PriceDetails.Price
+			pctx.ProductID = p.PriceDetails.Product
+		}
+	}
+	return pctx
+}
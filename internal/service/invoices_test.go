@@ -0,0 +1,214 @@
+package service_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"git.sr.ht/~jakintosh/coffer/internal/service"
+	"git.sr.ht/~jakintosh/coffer/internal/testutil"
+	stripe "github.com/stripe/stripe-go/v82"
+)
+
+// addInvoice serves a renewal invoice of sub_1 for February 2025, billing
+// prod_sponsor, with any extra fields set at the top level
+func addInvoice(
+	stripe *testutil.FakeStripe,
+	id string,
+	status string,
+	extra map[string]any,
+) {
+	inv := map[string]any{
+		"id":             id,
+		"object":         "invoice",
+		"created":        testutil.MakeDateUnix(2025, 2, 1),
+		"status":         status,
+		"customer":       "cus_1",
+		"billing_reason": "subscription_cycle",
+		"amount_due":     500,
+		"amount_paid":    0,
+		"currency":       "usd",
+		"parent": map[string]any{
+			"type": "subscription_details",
+			"subscription_details": map[string]any{
+				"subscription": "sub_1",
+				"metadata":     map[string]any{"campaign": "renewal"},
+			},
+		},
+		"lines": map[string]any{
+			"object": "list",
+			"data": []any{map[string]any{
+				"id":     "il_" + id,
+				"object": "line_item",
+				"period": map[string]any{
+					"start": testutil.MakeDateUnix(2025, 2, 1),
+					"end":   testutil.MakeDateUnix(2025, 3, 1),
+				},
+				"pricing": map[string]any{
+					"type": "price_details",
+					"price_details": map[string]any{
+						"price":   "price_1",
+						"product": "prod_sponsor",
+					},
+				},
+			}},
+		},
+	}
+	for k, v := range extra {
+		inv[k] = v
+	}
+	stripe.AddObject("/v1/invoices/"+id, inv)
+}
+
+// addInvoicePayment serves the payment of an invoice by a payment intent
+func addInvoicePayment(
+	stripe *testutil.FakeStripe,
+	invoice string,
+	intent string,
+	status string,
+) {
+	stripe.AddList("/v1/invoice_payments", map[string]any{
+		"id":         "inpay_" + intent,
+		"object":     "invoice_payment",
+		"invoice":    invoice,
+		"is_default": true,
+		"status":     status,
+		"payment": map[string]any{
+			"type":           "payment_intent",
+			"payment_intent": intent,
+		},
+	})
+}
+
+func TestInvoicePaid(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	addInvoice(env.Stripe, "in_1", "paid", map[string]any{"amount_paid": 500})
+	addInvoicePayment(env.Stripe, "in_1", "pi_1", "paid")
+
+	env.Service.HandleStripeResource("invoice", "in_1")
+
+	inv, err := env.Service.GetInvoice("in_1")
+	if err != nil {
+		t.Fatalf("GetInvoice: %v", err)
+	}
+	if inv.Subscription != "sub_1" || inv.Payment != "pi_1" || inv.Customer != "cus_1" {
+		t.Errorf("unexpected links %+v", inv)
+	}
+	if inv.PeriodStart == nil || !inv.PeriodStart.Equal(testutil.MakeDate(2025, 2, 1)) ||
+		inv.PeriodEnd == nil || !inv.PeriodEnd.Equal(testutil.MakeDate(2025, 3, 1)) {
+		t.Errorf("unexpected period %v - %v", inv.PeriodStart, inv.PeriodEnd)
+	}
+	if inv.ProductID != "prod_sponsor" || inv.PriceID != "price_1" {
+		t.Errorf("unexpected line item %+v", inv)
+	}
+	if inv.Status != "paid" || inv.AmountPaid != 500 || inv.Failed {
+		t.Errorf("unexpected state %+v", inv)
+	}
+}
+
+func TestInvoicePaymentFailed(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	next := testutil.MakeDateUnix(2025, 2, 4)
+	addInvoice(env.Stripe, "in_1", "open", map[string]any{
+		"attempt_count":        1,
+		"next_payment_attempt": next,
+	})
+	addInvoicePayment(env.Stripe, "in_1", "pi_1", "open")
+
+	env.Service.HandleStripeResource("invoice", "in_1")
+
+	inv, err := env.Service.GetInvoice("in_1")
+	if err != nil {
+		t.Fatalf("GetInvoice: %v", err)
+	}
+	if !inv.Failed || inv.Attempts != 1 || inv.Payment != "pi_1" {
+		t.Errorf("expected failed renewal, got %+v", inv)
+	}
+	if inv.NextAttempt == nil || inv.NextAttempt.Unix() != next {
+		t.Errorf("unexpected next attempt %v", inv.NextAttempt)
+	}
+
+	// a later successful retry clears the failure
+	addInvoice(env.Stripe, "in_1", "paid", map[string]any{
+		"attempt_count": 2,
+		"amount_paid":   500,
+	})
+	env.Stripe.RemoveList("/v1/invoice_payments")
+	addInvoicePayment(env.Stripe, "in_1", "pi_2", "paid")
+	env.Service.HandleStripeResource("invoice", "in_1")
+
+	inv, err = env.Service.GetInvoice("in_1")
+	if err != nil {
+		t.Fatalf("GetInvoice: %v", err)
+	}
+	if inv.Failed || inv.Status != "paid" || inv.Payment != "pi_2" || inv.NextAttempt != nil {
+		t.Errorf("expected paid invoice, got %+v", inv)
+	}
+}
+
+func TestInvoiceVoidedAfterFailure(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	addInvoice(env.Stripe, "in_1", "open", map[string]any{
+		"attempt_count": 1,
+	})
+	addInvoicePayment(env.Stripe, "in_1", "pi_1", "open")
+	env.Service.HandleStripeResource("invoice", "in_1")
+
+	// voiding the invoice ends the failure
+	addInvoice(env.Stripe, "in_1", "void", map[string]any{
+		"attempt_count": 1,
+	})
+	event := stripe.Event{
+		ID:      "evt_1",
+		Type:    "invoice.voided",
+		Created: testutil.MakeDateUnix(2025, 2, 10),
+		Data: &stripe.EventData{
+			Raw: json.RawMessage(`{"id":"in_1","object":"invoice"}`),
+		},
+	}
+	if err := env.Service.ProcessStripeEvent(event); err != nil {
+		t.Fatalf("ProcessStripeEvent: %v", err)
+	}
+	waitForEvent(t, env, "evt_1", service.EventProcessed)
+
+	inv, err := env.Service.GetInvoice("in_1")
+	if err != nil {
+		t.Fatalf("GetInvoice: %v", err)
+	}
+	if inv.Failed || inv.Status != "void" {
+		t.Errorf("expected voided invoice, got %+v", inv)
+	}
+}
+
+func TestInvoiceNotFound(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	if _, err := env.Service.GetInvoice("in_missing"); err != service.ErrInvoiceNotFound {
+		t.Errorf("expected ErrInvoiceNotFound, got %v", err)
+	}
+}
+
+func TestRenewalAllocatedBySubscriptionContext(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	testutil.SeedLedgers(t, env.Service, "dev", "events")
+	svc := env.Service
+	jan := testutil.MakeDate(2025, 1, 1)
+	rules := []service.AllocationRule{{ID: "dev", LedgerName: "dev", Percentage: 100}}
+	testutil.ScheduleAllocationSet(t, env, "product:prod_sponsor", rules, jan)
+	rules = []service.AllocationRule{{ID: "events", LedgerName: "events", Percentage: 100}}
+	testutil.ScheduleAllocationSet(t, env, "metadata:campaign=renewal", rules, jan)
+
+	// the renewal intent carries no metadata of its own
+	addSettledIntent(env.Stripe, "pi_1", testutil.MakeDateUnix(2025, 2, 1), 500, 0)
+	addInvoice(env.Stripe, "in_1", "paid", map[string]any{"amount_paid": 500})
+	addInvoicePayment(env.Stripe, "in_1", "pi_1", "paid")
+
+	svc.HandleStripeResource("payment", "pi_1")
+
+	if _, err := env.DB.GetTransaction("pi_1:events"); err != nil {
+		t.Errorf("expected renewal allocated by subscription metadata: %v", err)
+	}
+}
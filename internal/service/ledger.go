@@ -3,8 +3,11 @@ package service
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"strconv"
 	"time"
 
 	"git.sr.ht/~jakintosh/coffer/pkg/wire"
@@ -18,12 +21,61 @@ type LedgerSnapshot struct {
 	ClosingBalance int `json:"closing_balance"`
 }
 
+// LedgerSummary describes a public ledger and its current balance. Activity
+// dates are omitted for ledgers without transactions.
+type LedgerSummary struct {
+	Name             string     `json:"name"`
+	DisplayName      string     `json:"display_name"`
+	Description      string     `json:"description"`
+	Archived         bool       `json:"archived"`
+	Balance          int        `json:"balance"`
+	TransactionCount int        `json:"transaction_count"`
+	FirstActivity    *time.Time `json:"first_activity,omitempty"`
+	LastActivity     *time.Time `json:"last_activity,omitempty"`
+}
+
 type Transaction struct {
-	ID     string    `json:"id"`
-	Ledger string    `json:"ledger"`
-	Amount int       `json:"amount"`
-	Date   time.Time `json:"date"`
-	Label  string    `json:"label"`
+	ID         string    `json:"id"`
+	Ledger     string    `json:"ledger"`
+	Amount     int       `json:"amount"`
+	Date       time.Time `json:"date"`
+	Label      string    `json:"label"`
+	TransferID string    `json:"transfer_id,omitempty"`
+	Reverses   string    `json:"reverses,omitempty"`
+}
+
+// Amount signs accepted by TransactionFilter
+const (
+	SignIncome   = "income"
+	SignSpending = "spending"
+)
+
+// TransactionFilter narrows a transaction listing. Zero values match all
+// transactions; results are sorted by date descending unless Ascending.
+type TransactionFilter struct {
+	Since     time.Time
+	Until     time.Time
+	Label     string
+	Search    string
+	Sign      string
+	MinAmount *int
+	MaxAmount *int
+	Ascending bool
+}
+
+func (f TransactionFilter) validate() error {
+	switch f.Sign {
+	case "", SignIncome, SignSpending:
+	default:
+		return ErrInvalidFilter
+	}
+	if f.MinAmount != nil && f.MaxAmount != nil && *f.MinAmount > *f.MaxAmount {
+		return ErrInvalidFilter
+	}
+	if !f.Since.IsZero() && !f.Until.IsZero() && f.Since.After(f.Until) {
+		return ErrInvalidFilter
+	}
+	return nil
 }
 
 func (s *Service) AddTransaction(
@@ -33,6 +85,9 @@ func (s *Service) AddTransaction(
 	date time.Time,
 	label string,
 ) error {
+	if err := s.requireOpenLedger(ledger); err != nil {
+		return err
+	}
 	if id == "" {
 		id = uuid.NewString()
 	}
@@ -44,13 +99,92 @@ func (s *Service) AddTransaction(
 		date.Unix(),
 		label,
 	)
-	if err != nil {
+	if errors.Is(err, ErrTxConflict) {
+		return err
+	} else if err != nil {
 		return DatabaseError{err}
 	}
 
 	return nil
 }
 
+// ReverseTransaction appends a compensating entry that cancels out the
+// transaction with the given id. A voided transaction is reversed on its
+// original date; otherwise the reversal is dated at date. Like any other
+// entry, a reversal cannot be posted to an archived ledger.
+func (s *Service) ReverseTransaction(
+	ledger string,
+	id string,
+	date time.Time,
+	label string,
+	void bool,
+) (
+	*Transaction,
+	error,
+) {
+	orig, err := s.store.GetTransaction(id)
+	if errors.Is(err, ErrTxNotFound) {
+		return nil, err
+	} else if err != nil {
+		return nil, DatabaseError{err}
+	}
+	if orig.Ledger != ledger {
+		return nil, ErrTxNotFound
+	}
+
+	// reversals cannot be reversed, and transfers are reversed whole
+	if orig.Reverses != "" || orig.TransferID != "" {
+		return nil, ErrInvalidReversal
+	}
+	if err := s.requireOpenLedger(ledger); err != nil {
+		return nil, err
+	}
+
+	if void {
+		date = orig.Date
+	}
+	if label == "" {
+		label = "reversal"
+	}
+	reversalID := fmt.Sprintf("%s:reversal", id)
+
+	err = s.store.InsertReversal(
+		reversalID,
+		id,
+		date.Unix(),
+		label,
+	)
+	if errors.Is(err, ErrTxConflict) {
+		return nil, err
+	} else if err != nil {
+		return nil, DatabaseError{err}
+	}
+
+	reversal := &Transaction{
+		ID:       reversalID,
+		Ledger:   orig.Ledger,
+		Amount:   -orig.Amount,
+		Date:     date,
+		Label:    label,
+		Reverses: id,
+	}
+	return reversal, nil
+}
+
+// ListLedgers returns every public ledger with its current balance, sorted
+// by name.
+func (s *Service) ListLedgers() (
+	[]LedgerSummary,
+	error,
+) {
+	ledgers, err := s.store.GetLedgers()
+	if err != nil {
+		return nil, DatabaseError{err}
+	}
+
+	return ledgers, nil
+}
+
 func (s *Service) GetSnapshot(
 	ledger string,
 	since time.Time,
@@ -79,21 +213,51 @@ func (s *Service) GetTransactions(
 	[]Transaction,
 	error,
 ) {
+	txs, _, err := s.QueryTransactions(ledger, TransactionFilter{}, nil, limit, offset)
+	return txs, err
+}
+
+// QueryTransactions lists filtered transactions starting after cursor, if
+// given. The returned cursor points at the next page and is nil on the last.
+func (s *Service) QueryTransactions(
+	ledger string,
+	filter TransactionFilter,
+	cursor *wire.Cursor,
+	limit int,
+	offset int,
+) (
+	[]Transaction,
+	*wire.Cursor,
+	error,
+) {
+	if err := filter.validate(); err != nil {
+		return nil, nil, err
+	}
 	if limit <= 0 {
 		limit = 100
 	}
 	offset = max(offset, 0)
 
+	// fetch one extra row to detect a following page
 	txs, err := s.store.GetTransactions(
 		ledger,
-		limit,
+		filter,
+		cursor,
+		limit+1,
 		offset,
 	)
 	if err != nil {
-		return nil, DatabaseError{err}
+		return nil, nil, DatabaseError{err}
 	}
 
-	return txs, nil
+	var next *wire.Cursor
+	if len(txs) > limit {
+		txs = txs[:limit]
+		last := txs[limit-1]
+		next = &wire.Cursor{Key: last.Date.Unix(), ID: last.ID}
+	}
+
+	return txs, next, nil
 }
 
 type CreateTransactionRequest struct {
@@ -103,17 +267,75 @@ type CreateTransactionRequest struct {
 	Label  string `json:"label"`
 }
 
+type ReverseTransactionRequest struct {
+	Date  string `json:"date"`
+	Label string `json:"label"`
+	Void  bool   `json:"void"`
+}
+
 func (s *Service) buildLedgerRouter(
 	mux *http.ServeMux,
 	mw Middleware,
 ) {
-	mux.HandleFunc("GET /ledger/{ledger}", mw.CORS(s.handleGetLedger))
-	mux.HandleFunc("OPTIONS /ledger/{ledger}", mw.CORS(s.handleGetLedger))
+	mux.HandleFunc("GET /ledgers", mw.CORS(s.handleGetLedgers))
+	mux.HandleFunc("OPTIONS /ledgers", mw.CORS(s.handleGetLedgers))
+
+	// private ledgers are hidden from unauthenticated requests
+	read := func(next http.HandlerFunc) http.HandlerFunc {
+		return mw.CORS(s.withLedgerAccess(mw.Authorized, next))
+	}
+
+	mux.HandleFunc("GET /ledger/{ledger}", read(s.handleGetLedger))
+	mux.HandleFunc("OPTIONS /ledger/{ledger}", read(s.handleGetLedger))
 
-	mux.HandleFunc("GET /ledger/{ledger}/transactions", mw.CORS(s.handleGetLedgerTransactions))
-	mux.HandleFunc("OPTIONS /ledger/{ledger}/transactions", mw.CORS(s.handleGetLedgerTransactions))
+	mux.HandleFunc("GET /ledger/{ledger}/transactions", read(s.handleGetLedgerTransactions))
+	mux.HandleFunc("OPTIONS /ledger/{ledger}/transactions", read(s.handleGetLedgerTransactions))
 
 	mux.HandleFunc("POST /ledger/{ledger}/transactions", mw.Auth(s.handlePostLedgerTransaction))
+	mux.HandleFunc("POST /ledger/{ledger}/transactions/{id}/reverse", mw.Auth(s.handlePostReverseTransaction))
+	mux.HandleFunc("POST /ledger/{ledger}/import", mw.Auth(s.handlePostLedgerImport))
+	mux.HandleFunc("POST /ledger/reallocate", mw.Auth(s.handlePostReallocate))
+
+	mux.HandleFunc("GET /ledger/{ledger}/history", read(s.handleGetLedgerHistory))
+	mux.HandleFunc("OPTIONS /ledger/{ledger}/history", read(s.handleGetLedgerHistory))
+
+	mux.HandleFunc("GET /ledger/{ledger}/export", read(s.handleGetLedgerExport))
+	mux.HandleFunc("OPTIONS /ledger/{ledger}/export", read(s.handleGetLedgerExport))
+
+	s.buildTransfersRouter(mux, mw)
+}
+
+// withLedgerAccess answers as if a registered ledger that is not public did
+// not exist, unless the request is authenticated
+func (s *Service) withLedgerAccess(
+	authorized func(*http.Request) bool,
+	next http.HandlerFunc,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ledger, err := s.GetLedgerInfo(r.PathValue("ledger"))
+		if err != nil && !errors.Is(err, ErrLedgerNotFound) {
+			wire.WriteError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+		if ledger != nil && !ledger.Public && !authorized(r) {
+			wire.WriteError(w, http.StatusNotFound, "Ledger Not Found")
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (s *Service) handleGetLedgers(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	ledgers, err := s.ListLedgers()
+	if err != nil {
+		wire.WriteError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+
+	wire.WriteData(w, http.StatusOK, ledgers)
 }
 
 func (s *Service) handleGetLedger(
@@ -174,19 +396,30 @@ func (s *Service) handleGetLedgerTransactions(
 		return
 	}
 
-	transactions, err := s.GetTransactions(f, limit, offset)
+	cursor, malformedQueryErr := wire.ParseCursor(r)
+	if malformedQueryErr != nil {
+		wire.WriteError(w, http.StatusBadRequest, malformedQueryErr.Error())
+		return
+	}
+
+	filter, malformedQueryErr := parseTransactionFilter(r)
+	if malformedQueryErr != nil {
+		wire.WriteError(w, http.StatusBadRequest, malformedQueryErr.Error())
+		return
+	}
+
+	transactions, next, err := s.QueryTransactions(f, filter, cursor, limit, offset)
 	if err != nil {
-		wire.WriteError(w, http.StatusInternalServerError, "Internal Server Error")
 		switch {
-		case errors.As(err, &DatabaseError{}):
-			// TODO: log?
+		case errors.Is(err, ErrInvalidFilter):
+			wire.WriteError(w, http.StatusBadRequest, "Invalid Transaction Filter")
 		default:
-			// TODO: log?
+			wire.WriteError(w, http.StatusInternalServerError, "Internal Server Error")
 		}
 		return
 	}
 
-	wire.WriteData(w, http.StatusOK, transactions)
+	wire.WritePage(w, http.StatusOK, transactions, next)
 }
 
 func (s *Service) handlePostLedgerTransaction(
@@ -212,15 +445,123 @@ func (s *Service) handlePostLedgerTransaction(
 
 	err = s.AddTransaction(req.ID, ledger, req.Amount, date, req.Label)
 	if err != nil {
-		wire.WriteError(w, http.StatusInternalServerError, "Internal Server Error")
 		switch {
-		case errors.As(err, &DatabaseError{}):
-			// TODO: log?
+		case errors.Is(err, ErrLedgerNotFound):
+			wire.WriteError(w, http.StatusNotFound, "Ledger Not Found")
+		case errors.Is(err, ErrLedgerArchived):
+			wire.WriteError(w, http.StatusConflict, "Ledger Archived")
+		case errors.Is(err, ErrTxConflict):
+			wire.WriteError(w, http.StatusConflict, "Transaction Conflict")
 		default:
-			// TODO: log?
+			wire.WriteError(w, http.StatusInternalServerError, "Internal Server Error")
 		}
 		return
 	}
 
 	w.WriteHeader(http.StatusCreated)
 }
+
+func (s *Service) handlePostReverseTransaction(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	ledger := r.PathValue("ledger")
+	id := r.PathValue("id")
+
+	// decode optional body
+	var req ReverseTransactionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		wire.WriteError(w, http.StatusBadRequest, "Malformed JSON")
+		return
+	}
+
+	// validate date as RFC3339, defaulting to now
+	date := s.Clock()
+	if req.Date != "" {
+		var err error
+		if date, err = time.Parse(time.RFC3339, req.Date); err != nil {
+			log.Printf("invalid RFC3339 date: %v", err)
+			wire.WriteError(w, http.StatusBadRequest, "Invalid RFC3339 Date")
+			return
+		}
+	}
+
+	reversal, err := s.ReverseTransaction(ledger, id, date, req.Label, req.Void)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrTxNotFound):
+			wire.WriteError(w, http.StatusNotFound, "Transaction Not Found")
+		case errors.Is(err, ErrInvalidReversal):
+			wire.WriteError(w, http.StatusBadRequest, "Transaction Cannot Be Reversed")
+		case errors.Is(err, ErrLedgerNotFound):
+			wire.WriteError(w, http.StatusNotFound, "Ledger Not Found")
+		case errors.Is(err, ErrLedgerArchived):
+			wire.WriteError(w, http.StatusConflict, "Ledger Archived")
+		case errors.Is(err, ErrTxConflict):
+			wire.WriteError(w, http.StatusConflict, "Transaction Already Reversed")
+		default:
+			wire.WriteError(w, http.StatusInternalServerError, "Internal Server Error")
+		}
+		return
+	}
+
+	wire.WriteData(w, http.StatusCreated, reversal)
+}
+
+// parseTransactionFilter reads transaction filter queries.
+func parseTransactionFilter(
+	r *http.Request,
+) (
+	TransactionFilter,
+	*wire.ErrMalformedQuery,
+) {
+	q := r.URL.Query()
+	filter := TransactionFilter{
+		Label:  q.Get("label"),
+		Search: q.Get("search"),
+		Sign:   q.Get("sign"),
+	}
+
+	if v := q.Get("since"); v != "" {
+		since, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			return filter, &wire.ErrMalformedQuery{Query: "since"}
+		}
+		filter.Since = since
+	}
+
+	if v := q.Get("until"); v != "" {
+		until, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			return filter, &wire.ErrMalformedQuery{Query: "until"}
+		}
+		filter.Until = until
+	}
+
+	if v := q.Get("min"); v != "" {
+		minAmount, err := strconv.Atoi(v)
+		if err != nil {
+			return filter, &wire.ErrMalformedQuery{Query: "min"}
+		}
+		filter.MinAmount = &minAmount
+	}
+
+	if v := q.Get("max"); v != "" {
+		maxAmount, err := strconv.Atoi(v)
+		if err != nil {
+			return filter, &wire.ErrMalformedQuery{Query: "max"}
+		}
+		filter.MaxAmount = &maxAmount
+	}
+
+	switch q.Get("sort") {
+	case "", "desc":
+		filter.Ascending = false
+	case "asc":
+		filter.Ascending = true
+	default:
+		return filter, &wire.ErrMalformedQuery{Query: "sort"}
+	}
+
+	return filter, nil
+}
@@ -209,3 +209,201 @@ func TestAPIGetTransactionsBadQuery(t *testing.T) {
 	// verify result
 	result.ExpectStatus(t, http.StatusBadRequest)
 }
+
+func TestAPICreateTransactionIdempotent(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	router := env.Service.BuildRouter()
+	auth := testutil.MakeAuthHeader(t, env.Service)
+
+	// post transaction twice
+	url := "/ledger/general/transactions"
+	body := `
+	{
+		"id": "t1",
+		"date": "2025-01-01T12:00:00Z",
+		"label": "base",
+		"amount": 50
+	}`
+	wire.TestPost[any](router, url, body, auth).ExpectStatus(t, http.StatusCreated)
+	wire.TestPost[any](router, url, body, auth).ExpectStatus(t, http.StatusCreated)
+
+	// post conflicting transaction
+	conflict := `
+	{
+		"id": "t1",
+		"date": "2025-01-01T12:00:00Z",
+		"label": "base",
+		"amount": 75
+	}`
+	result := wire.TestPost[any](router, url, conflict, auth)
+
+	// verify result
+	result.ExpectStatus(t, http.StatusConflict)
+}
+
+func TestAPIReverseTransaction(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	router := env.Service.BuildRouter()
+	testutil.SeedTransactionData(t, env.Service)
+
+	// reverse transaction
+	url := "/ledger/general/transactions/t2/reverse"
+	body := `
+	{
+		"date": "2025-04-01T00:00:00Z",
+		"label": "correction"
+	}`
+	auth := testutil.MakeAuthHeader(t, env.Service)
+	result := wire.TestPost[service.Transaction](router, url, body, auth)
+
+	// verify result
+	result.ExpectStatus(t, http.StatusCreated)
+	reversal := result.ExpectOK(t)
+	if reversal.Amount != -200 || reversal.Reverses != "t2" || reversal.Label != "correction" {
+		t.Errorf("unexpected reversal %+v", reversal)
+	}
+
+	// reversing again with a different body conflicts
+	again := wire.TestPost[any](router, url, `{"date": "2025-05-01T00:00:00Z"}`, auth)
+	again.ExpectStatus(t, http.StatusConflict)
+}
+
+func TestAPIReverseTransactionNoBody(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	router := env.Service.BuildRouter()
+	testutil.SeedTransactionData(t, env.Service)
+
+	url := "/ledger/general/transactions/t1/reverse"
+	auth := testutil.MakeAuthHeader(t, env.Service)
+	result := wire.TestPost[service.Transaction](router, url, "", auth)
+
+	// verify result
+	result.ExpectStatus(t, http.StatusCreated)
+}
+
+func TestAPIReverseTransactionNotFound(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	router := env.Service.BuildRouter()
+
+	url := "/ledger/general/transactions/missing/reverse"
+	auth := testutil.MakeAuthHeader(t, env.Service)
+	result := wire.TestPost[any](router, url, "{}", auth)
+
+	// verify result
+	result.ExpectStatus(t, http.StatusNotFound)
+}
+
+func TestAPIGetTransactionsFiltered(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	router := env.Service.BuildRouter()
+	testutil.SeedTransactionData(t, env.Service)
+
+	// get filtered transactions
+	url := "/ledger/general/transactions?since=2025-01-15&label=in&sort=asc"
+	result := wire.TestGet[[]service.Transaction](router, url)
+
+	// verify result
+	txs := result.ExpectOK(t)
+	if len(txs) != 1 || txs[0].ID != "t2" {
+		t.Fatalf("unexpected transactions %+v", txs)
+	}
+}
+
+func TestAPIGetTransactionsBadFilter(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	router := env.Service.BuildRouter()
+
+	urls := []string{
+		"/ledger/general/transactions?since=yesterday",
+		"/ledger/general/transactions?min=lots",
+		"/ledger/general/transactions?sort=sideways",
+		"/ledger/general/transactions?sign=sideways",
+		"/ledger/general/transactions?min=10&max=1",
+	}
+	for _, url := range urls {
+		result := wire.TestGet[any](router, url)
+		result.ExpectStatus(t, http.StatusBadRequest)
+	}
+}
+
+func TestAPIGetTransactionsCursor(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	router := env.Service.BuildRouter()
+	testutil.SeedTransactionData(t, env.Service)
+
+	// get first page
+	result := wire.TestGet[[]service.Transaction](router, "/ledger/general/transactions?limit=2")
+	first := result.ExpectOK(t)
+	if len(first) != 2 || result.NextCursor == "" {
+		t.Fatalf("expected full first page with cursor, got %d and %q", len(first), result.NextCursor)
+	}
+
+	// a new transaction arrives while paging
+	if err := env.Service.AddTransaction("t4", "general", 10, testutil.MakeDate(2025, 4, 1), "in"); err != nil {
+		t.Fatal(err)
+	}
+
+	// get second page
+	url := "/ledger/general/transactions?limit=2&cursor=" + result.NextCursor
+	result = wire.TestGet[[]service.Transaction](router, url)
+	second := result.ExpectOK(t)
+	if len(second) != 1 || second[0].ID != "t1" {
+		t.Fatalf("unexpected second page %+v", second)
+	}
+	if result.NextCursor != "" {
+		t.Errorf("expected no cursor on last page, got %q", result.NextCursor)
+	}
+}
+
+func TestAPIGetTransactionsBadCursor(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	router := env.Service.BuildRouter()
+
+	url := "/ledger/general/transactions?cursor=not-a-cursor"
+	result := wire.TestGet[any](router, url)
+
+	// verify result
+	result.ExpectStatus(t, http.StatusBadRequest)
+}
+
+func TestAPIListLedgers(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	testutil.SeedLedgers(t, env.Service, "community")
+	router := env.Service.BuildRouter()
+	start, end := testutil.SeedTransactionData(t, env.Service)
+	date := testutil.MakeDate(2025, 4, 1)
+	if _, err := env.Service.Transfer("tr1", "general", "community", 25, date, "grant"); err != nil {
+		t.Fatal(err)
+	}
+
+	// get ledgers
+	result := wire.TestGet[[]service.LedgerSummary](router, "/ledgers")
+
+	// verify result
+	ledgers := result.ExpectOK(t)
+	if len(ledgers) != 2 {
+		t.Fatalf("expected 2 ledgers, got %d", len(ledgers))
+	}
+	community, general := ledgers[0], ledgers[1]
+	if community.Name != "community" || community.Balance != 25 || community.TransactionCount != 1 {
+		t.Errorf("unexpected community ledger %+v", community)
+	}
+	if general.Name != "general" || general.Balance != 225 || general.TransactionCount != 4 {
+		t.Errorf("unexpected general ledger %+v", general)
+	}
+	if general.FirstActivity == nil || !general.FirstActivity.Equal(start) {
+		t.Errorf("expected first activity %v, got %v", start, general.FirstActivity)
+	}
+	if general.LastActivity == nil || !general.LastActivity.After(end) {
+		t.Errorf("expected last activity after %v, got %v", end, general.LastActivity)
+	}
+}
@@ -1,9 +1,11 @@
 package service_test
 
 import (
+	"errors"
 	"testing"
 	"time"
 
+	"git.sr.ht/~jakintosh/coffer/internal/service"
 	"git.sr.ht/~jakintosh/coffer/internal/testutil"
 )
 
@@ -85,3 +87,148 @@ func TestGetTransactionsNegativePagination(t *testing.T) {
 		t.Fatalf("expected 3 tx got %d", len(txs))
 	}
 }
+
+func TestAddTransactionConflict(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	svc := env.Service
+	testutil.SeedTransactionData(t, svc)
+
+	err := svc.AddTransaction("t1", "general", 999, testutil.MakeDate(2025, 1, 1), "in")
+	if !errors.Is(err, service.ErrTxConflict) {
+		t.Fatalf("expected ErrTxConflict, got %v", err)
+	}
+}
+
+func TestReverseTransaction(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	svc := env.Service
+	testutil.SeedTransactionData(t, svc)
+
+	date := testutil.MakeDate(2025, 4, 1)
+	reversal, err := svc.ReverseTransaction("general", "t2", date, "", false)
+	if err != nil {
+		t.Fatalf("ReverseTransaction: %v", err)
+	}
+	if reversal.Amount != -200 || reversal.Reverses != "t2" || !reversal.Date.Equal(date) {
+		t.Errorf("unexpected reversal %+v", reversal)
+	}
+
+	// original remains alongside its reversal
+	txs, err := svc.GetTransactions("general", 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(txs) != 4 {
+		t.Fatalf("expected 4 tx, got %d", len(txs))
+	}
+
+	// a second reversal on a different date is refused
+	_, err = svc.ReverseTransaction("general", "t2", date.Add(time.Hour), "", false)
+	if !errors.Is(err, service.ErrTxConflict) {
+		t.Fatalf("expected ErrTxConflict, got %v", err)
+	}
+}
+
+func TestReverseTransactionVoid(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	svc := env.Service
+	testutil.SeedTransactionData(t, svc)
+
+	reversal, err := svc.ReverseTransaction("general", "t2", time.Now(), "void", true)
+	if err != nil {
+		t.Fatalf("ReverseTransaction: %v", err)
+	}
+	if !reversal.Date.Equal(testutil.MakeDate(2025, 2, 1)) {
+		t.Errorf("void should use original date, got %v", reversal.Date)
+	}
+
+	// voiding is idempotent
+	if _, err := svc.ReverseTransaction("general", "t2", time.Now(), "void", true); err != nil {
+		t.Fatalf("repeat void: %v", err)
+	}
+
+	snap, err := svc.GetSnapshot("general", time.Unix(0, 0), testutil.MakeDate(2025, 12, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snap.ClosingBalance != 50 {
+		t.Errorf("closing want 50 got %d", snap.ClosingBalance)
+	}
+}
+
+func TestReverseTransactionInvalid(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	testutil.SeedLedgers(t, env.Service, "community")
+	svc := env.Service
+	testutil.SeedTransactionData(t, svc)
+	date := testutil.MakeDate(2025, 4, 1)
+
+	// unknown id, or wrong ledger
+	if _, err := svc.ReverseTransaction("general", "nope", date, "", false); !errors.Is(err, service.ErrTxNotFound) {
+		t.Errorf("expected ErrTxNotFound, got %v", err)
+	}
+	if _, err := svc.ReverseTransaction("community", "t1", date, "", false); !errors.Is(err, service.ErrTxNotFound) {
+		t.Errorf("expected ErrTxNotFound, got %v", err)
+	}
+
+	// reversal of a reversal
+	reversal, err := svc.ReverseTransaction("general", "t1", date, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.ReverseTransaction("general", reversal.ID, date, "", false); !errors.Is(err, service.ErrInvalidReversal) {
+		t.Errorf("expected ErrInvalidReversal, got %v", err)
+	}
+
+	// transfer leg
+	if _, err := svc.Transfer("tr1", "general", "community", 10, date, "x"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.ReverseTransaction("general", "transfer:tr1:general", date, "", false); !errors.Is(err, service.ErrInvalidReversal) {
+		t.Errorf("expected ErrInvalidReversal, got %v", err)
+	}
+}
+
+func TestQueryTransactionsFiltered(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	svc := env.Service
+	testutil.SeedTransactionData(t, svc)
+
+	filter := service.TransactionFilter{
+		Sign:      service.SignIncome,
+		Ascending: true,
+	}
+	txs, _, err := svc.QueryTransactions("general", filter, nil, 10, 0)
+	if err != nil {
+		t.Fatalf("QueryTransactions: %v", err)
+	}
+	if len(txs) != 2 {
+		t.Fatalf("expected 2 tx, got %d", len(txs))
+	}
+	if txs[0].ID != "t1" || txs[1].ID != "t2" {
+		t.Errorf("unexpected order %+v", txs)
+	}
+}
+
+func TestQueryTransactionsInvalidFilter(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	svc := env.Service
+
+	minAmount, maxAmount := 100, 10
+	filters := []service.TransactionFilter{
+		{Sign: "sideways"},
+		{MinAmount: &minAmount, MaxAmount: &maxAmount},
+		{Since: testutil.MakeDate(2025, 2, 1), Until: testutil.MakeDate(2025, 1, 1)},
+	}
+	for _, f := range filters {
+		if _, _, err := svc.QueryTransactions("general", f, nil, 10, 0); !errors.Is(err, service.ErrInvalidFilter) {
+			t.Errorf("%+v: expected ErrInvalidFilter, got %v", f, err)
+		}
+	}
+}
@@ -0,0 +1,274 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"regexp"
+	"time"
+
+	"git.sr.ht/~jakintosh/coffer/pkg/wire"
+)
+
+// Ledger is the registered metadata for a ledger name. Transactions and
+// allocation rules can only target registered, unarchived ledgers, and only
+// public ledgers are listed in the catalog.
+type Ledger struct {
+	Name        string    `json:"name"`
+	DisplayName string    `json:"display_name"`
+	Description string    `json:"description"`
+	Public      bool      `json:"public"`
+	Archived    bool      `json:"archived"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+var ledgerNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+func (s *Service) ListLedgerInfo() (
+	[]Ledger,
+	error,
+) {
+	ledgers, err := s.store.GetLedgerInfos()
+	if err != nil {
+		return nil, DatabaseError{err}
+	}
+
+	return ledgers, nil
+}
+
+func (s *Service) GetLedgerInfo(
+	name string,
+) (
+	*Ledger,
+	error,
+) {
+	ledger, err := s.store.GetLedgerInfo(name)
+	if errors.Is(err, ErrLedgerNotFound) {
+		return nil, err
+	} else if err != nil {
+		return nil, DatabaseError{err}
+	}
+
+	return ledger, nil
+}
+
+func (s *Service) CreateLedger(
+	ledger Ledger,
+) (
+	*Ledger,
+	error,
+) {
+	if !ledgerNamePattern.MatchString(ledger.Name) {
+		return nil, ErrInvalidLedger
+	}
+
+	err := s.store.InsertLedger(ledger)
+	if errors.Is(err, ErrLedgerExists) {
+		return nil, err
+	} else if err != nil {
+		return nil, DatabaseError{err}
+	}
+
+	return s.GetLedgerInfo(ledger.Name)
+}
+
+// UpdateLedger replaces the metadata of an existing ledger. Ledgers that
+// are targeted by allocation rules cannot be archived.
+func (s *Service) UpdateLedger(
+	ledger Ledger,
+) (
+	*Ledger,
+	error,
+) {
+	err := s.store.UpdateLedger(ledger)
+	if errors.Is(err, ErrLedgerNotFound) || errors.Is(err, ErrLedgerInUse) {
+		return nil, err
+	} else if err != nil {
+		return nil, DatabaseError{err}
+	}
+
+	return s.GetLedgerInfo(ledger.Name)
+}
+
+// DeleteLedger removes a ledger that has no transactions or allocation
+// rules. Ledgers with history should be archived instead.
+func (s *Service) DeleteLedger(
+	name string,
+) error {
+	err := s.store.DeleteLedger(name)
+	if errors.Is(err, ErrLedgerNotFound) || errors.Is(err, ErrLedgerInUse) {
+		return err
+	} else if err != nil {
+		return DatabaseError{err}
+	}
+
+	return nil
+}
+
+// requireOpenLedger returns ErrLedgerNotFound or ErrLedgerArchived unless
+// name is a registered ledger that accepts new entries.
+func (s *Service) requireOpenLedger(
+	name string,
+) error {
+	ledger, err := s.GetLedgerInfo(name)
+	if err != nil {
+		return err
+	}
+	if ledger.Archived {
+		return ErrLedgerArchived
+	}
+	return nil
+}
+
+type CreateLedgerRequest struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"display_name"`
+	Description string `json:"description"`
+	Public      *bool  `json:"public"`
+}
+
+// UpdateLedgerRequest changes ledger metadata; omitted fields are unchanged
+type UpdateLedgerRequest struct {
+	DisplayName *string `json:"display_name"`
+	Description *string `json:"description"`
+	Public      *bool   `json:"public"`
+	Archived    *bool   `json:"archived"`
+}
+
+func (s *Service) buildLedgersRouter(
+	mux *http.ServeMux,
+	mw Middleware,
+) {
+	mux.HandleFunc("GET /settings/ledgers", mw.Auth(s.handleGetLedgerSettings))
+	mux.HandleFunc("POST /settings/ledgers", mw.Auth(s.handlePostLedgerSettings))
+	mux.HandleFunc("GET /settings/ledgers/{name}", mw.Auth(s.handleGetLedgerSetting))
+	mux.HandleFunc("PUT /settings/ledgers/{name}", mw.Auth(s.handlePutLedgerSetting))
+	mux.HandleFunc("DELETE /settings/ledgers/{name}", mw.Auth(s.handleDeleteLedgerSetting))
+}
+
+func (s *Service) handleGetLedgerSettings(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	ledgers, err := s.ListLedgerInfo()
+	if err != nil {
+		wire.WriteError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+
+	wire.WriteData(w, http.StatusOK, ledgers)
+}
+
+func (s *Service) handlePostLedgerSettings(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	var req CreateLedgerRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		wire.WriteError(w, http.StatusBadRequest, "Malformed JSON")
+		return
+	}
+
+	ledger := Ledger{
+		Name:        req.Name,
+		DisplayName: req.DisplayName,
+		Description: req.Description,
+		Public:      true,
+	}
+	if req.Public != nil {
+		ledger.Public = *req.Public
+	}
+
+	created, err := s.CreateLedger(ledger)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrInvalidLedger):
+			wire.WriteError(w, http.StatusBadRequest, "Invalid Ledger Name")
+		case errors.Is(err, ErrLedgerExists):
+			wire.WriteError(w, http.StatusConflict, "Ledger Exists")
+		default:
+			wire.WriteError(w, http.StatusInternalServerError, "Internal Server Error")
+		}
+		return
+	}
+
+	wire.WriteData(w, http.StatusCreated, created)
+}
+
+func (s *Service) handleGetLedgerSetting(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	ledger, err := s.GetLedgerInfo(r.PathValue("name"))
+	if err != nil {
+		if errors.Is(err, ErrLedgerNotFound) {
+			wire.WriteError(w, http.StatusNotFound, "Ledger Not Found")
+		} else {
+			wire.WriteError(w, http.StatusInternalServerError, "Internal Server Error")
+		}
+		return
+	}
+
+	wire.WriteData(w, http.StatusOK, ledger)
+}
+
+func (s *Service) handlePutLedgerSetting(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	var req UpdateLedgerRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		wire.WriteError(w, http.StatusBadRequest, "Malformed JSON")
+		return
+	}
+
+	ledger, err := s.GetLedgerInfo(r.PathValue("name"))
+	if err == nil {
+		if req.DisplayName != nil {
+			ledger.DisplayName = *req.DisplayName
+		}
+		if req.Description != nil {
+			ledger.Description = *req.Description
+		}
+		if req.Public != nil {
+			ledger.Public = *req.Public
+		}
+		if req.Archived != nil {
+			ledger.Archived = *req.Archived
+		}
+		ledger, err = s.UpdateLedger(*ledger)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrLedgerNotFound):
+			wire.WriteError(w, http.StatusNotFound, "Ledger Not Found")
+		case errors.Is(err, ErrLedgerInUse):
+			wire.WriteError(w, http.StatusConflict, "Ledger Has Allocations")
+		default:
+			wire.WriteError(w, http.StatusInternalServerError, "Internal Server Error")
+		}
+		return
+	}
+
+	wire.WriteData(w, http.StatusOK, ledger)
+}
+
+func (s *Service) handleDeleteLedgerSetting(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	if err := s.DeleteLedger(r.PathValue("name")); err != nil {
+		switch {
+		case errors.Is(err, ErrLedgerNotFound):
+			wire.WriteError(w, http.StatusNotFound, "Ledger Not Found")
+		case errors.Is(err, ErrLedgerInUse):
+			wire.WriteError(w, http.StatusConflict, "Ledger In Use")
+		default:
+			wire.WriteError(w, http.StatusInternalServerError, "Internal Server Error")
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,188 @@
+package service_test
+
+import (
+	"net/http"
+	"testing"
+
+	"git.sr.ht/~jakintosh/coffer/internal/service"
+	"git.sr.ht/~jakintosh/coffer/internal/testutil"
+	"git.sr.ht/~jakintosh/coffer/pkg/wire"
+)
+
+func TestAPICreateLedger(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	router := env.Service.BuildRouter()
+	auth := testutil.MakeAuthHeader(t, env.Service)
+
+	// post ledger
+	url := "/settings/ledgers"
+	body := `
+	{
+		"name": "community",
+		"display_name": "Community Fund",
+		"description": "grants"
+	}`
+	result := wire.TestPost[service.Ledger](router, url, body, auth)
+
+	// verify result
+	result.ExpectStatus(t, http.StatusCreated)
+	ledger := result.ExpectOK(t)
+	if ledger.Name != "community" || ledger.DisplayName != "Community Fund" || !ledger.Public {
+		t.Errorf("unexpected ledger %+v", ledger)
+	}
+
+	// duplicate name
+	result = wire.TestPost[service.Ledger](router, url, body, auth)
+	result.ExpectStatus(t, http.StatusConflict)
+
+	// get ledger
+	getResult := wire.TestGet[service.Ledger](router, "/settings/ledgers/community", auth)
+	getResult.ExpectOK(t)
+
+	// list ledgers
+	listResult := wire.TestGet[[]service.Ledger](router, "/settings/ledgers", auth)
+	ledgers := listResult.ExpectOK(t)
+	if len(ledgers) != 2 {
+		t.Errorf("expected 2 ledgers, got %d", len(ledgers))
+	}
+}
+
+func TestAPICreateLedgerInvalidName(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	router := env.Service.BuildRouter()
+	auth := testutil.MakeAuthHeader(t, env.Service)
+
+	url := "/settings/ledgers"
+	body := `{ "name": "Has Spaces" }`
+	result := wire.TestPost[any](router, url, body, auth)
+
+	result.ExpectStatus(t, http.StatusBadRequest)
+}
+
+func TestAPIArchiveLedger(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	testutil.SeedLedgers(t, env.Service, "community")
+	router := env.Service.BuildRouter()
+	auth := testutil.MakeAuthHeader(t, env.Service)
+
+	// archive ledger, leaving other fields unchanged
+	url := "/settings/ledgers/community"
+	body := `{ "archived": true }`
+	result := wire.TestPut[service.Ledger](router, url, body, auth)
+
+	// verify result
+	ledger := result.ExpectOK(t)
+	if !ledger.Archived || !ledger.Public {
+		t.Errorf("unexpected ledger %+v", ledger)
+	}
+
+	// archived ledgers reject transactions
+	txBody := `
+	{
+		"date": "2025-01-01T12:00:00Z",
+		"label": "late",
+		"amount": 50
+	}`
+	txResult := wire.TestPost[any](router, "/ledger/community/transactions", txBody, auth)
+	txResult.ExpectStatus(t, http.StatusConflict)
+
+	// and allocations
+	allocBody := `[
+		{ "id": "g", "ledger": "general", "percentage": 50 },
+		{ "id": "c", "ledger": "community", "percentage": 50 }
+	]`
+	allocResult := wire.TestPut[any](router, "/settings/allocations", allocBody, auth)
+	allocResult.ExpectStatus(t, http.StatusBadRequest)
+}
+
+func TestAPIArchiveAllocatedLedger(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	router := env.Service.BuildRouter()
+	auth := testutil.MakeAuthHeader(t, env.Service)
+
+	url := "/settings/ledgers/general"
+	body := `{ "archived": true }`
+	result := wire.TestPut[any](router, url, body, auth)
+
+	result.ExpectStatus(t, http.StatusConflict)
+}
+
+func TestAPIDeleteLedger(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	testutil.SeedLedgers(t, env.Service, "community")
+	router := env.Service.BuildRouter()
+	auth := testutil.MakeAuthHeader(t, env.Service)
+
+	result := wire.TestDelete[any](router, "/settings/ledgers/community", auth)
+	result.ExpectStatus(t, http.StatusNoContent)
+
+	result = wire.TestDelete[any](router, "/settings/ledgers/community", auth)
+	result.ExpectStatus(t, http.StatusNotFound)
+
+	// ledgers with transactions cannot be deleted
+	testutil.SeedTransactionData(t, env.Service)
+	result = wire.TestDelete[any](router, "/settings/ledgers/general", auth)
+	result.ExpectStatus(t, http.StatusConflict)
+}
+
+func TestAPIPostTransactionUnknownLedger(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	router := env.Service.BuildRouter()
+	auth := testutil.MakeAuthHeader(t, env.Service)
+
+	url := "/ledger/genral/transactions"
+	body := `
+	{
+		"date": "2025-01-01T12:00:00Z",
+		"label": "typo",
+		"amount": 50
+	}`
+	result := wire.TestPost[any](router, url, body, auth)
+
+	result.ExpectStatus(t, http.StatusNotFound)
+}
+
+func TestAPILedgerSettingsUnauthorized(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	router := env.Service.BuildRouter()
+
+	result := wire.TestGet[any](router, "/settings/ledgers")
+
+	result.ExpectStatus(t, http.StatusUnauthorized)
+}
+
+func TestAPIPrivateLedgerHidden(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	router := env.Service.BuildRouter()
+	auth := testutil.MakeAuthHeader(t, env.Service)
+	if _, err := env.Service.CreateLedger(service.Ledger{Name: "reserve", Public: false}); err != nil {
+		t.Fatal(err)
+	}
+
+	urls := []string{
+		"/ledger/reserve",
+		"/ledger/reserve/transactions",
+		"/ledger/reserve/history?interval=month",
+		"/ledger/reserve/export?format=csv",
+	}
+	for _, url := range urls {
+		// hidden from anonymous and unauthorized requests
+		wire.TestGet[any](router, url).ExpectStatus(t, http.StatusNotFound)
+		bad := wire.TestHeader{Key: "Authorization", Value: "Bearer nope"}
+		wire.TestGet[any](router, url, bad).ExpectStatus(t, http.StatusNotFound)
+
+		// visible with an API key
+		wire.TestGet[any](router, url, auth).ExpectStatus(t, http.StatusOK)
+	}
+
+	// public ledgers stay open
+	wire.TestGet[any](router, "/ledger/general/transactions").ExpectStatus(t, http.StatusOK)
+}
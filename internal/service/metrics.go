@@ -11,6 +11,8 @@ type Metrics struct {
 	MRRCents              int     `json:"mrr_cents"`
 	AvgPledgeCents        int     `json:"avg_pledge_cents"`
 	PaymentSuccessRatePct float64 `json:"payment_success_rate_pct"`
+	DisputesOpen          int     `json:"disputes_open"`
+	DisputedCents         int     `json:"disputed_cents"`
 }
 
 type SubscriptionSummary struct {
@@ -35,6 +37,13 @@ func (s *Service) GetMetrics() (*Metrics, error) {
 		metrics.AvgPledgeCents = (sum.Total * 100) / sum.Count
 	}
 
+	disputes, err := s.store.GetDisputeSummary()
+	if err != nil {
+		return nil, DatabaseError{err}
+	}
+	metrics.DisputesOpen = disputes.Count
+	metrics.DisputedCents = disputes.Total
+
 	return metrics, nil
 }
 
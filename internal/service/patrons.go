@@ -20,18 +20,41 @@ func (s *Service) ListPatrons(
 ) (
 	[]Patron,
 	error,
+) {
+	patrons, _, err := s.QueryPatrons(nil, limit, offset)
+	return patrons, err
+}
+
+// QueryPatrons lists patrons, newest first, starting after cursor, if
+// given. The returned cursor points at the next page and is nil on the last.
+func (s *Service) QueryPatrons(
+	cursor *wire.Cursor,
+	limit int,
+	offset int,
+) (
+	[]Patron,
+	*wire.Cursor,
+	error,
 ) {
 	if limit <= 0 {
 		limit = 100
 	}
 	offset = max(offset, 0)
 
-	patrons, err := s.store.GetCustomers(limit, offset)
+	// fetch one extra row to detect a following page
+	patrons, err := s.store.GetCustomers(cursor, limit+1, offset)
 	if err != nil {
-		return nil, DatabaseError{err}
+		return nil, nil, DatabaseError{err}
 	}
 
-	return patrons, nil
+	var next *wire.Cursor
+	if len(patrons) > limit {
+		patrons = patrons[:limit]
+		last := patrons[limit-1]
+		next = &wire.Cursor{Key: last.CreatedAt.Unix(), ID: last.ID}
+	}
+
+	return patrons, next, nil
 }
 
 func (s *Service) buildPatronsRouter(
@@ -39,6 +62,7 @@ func (s *Service) buildPatronsRouter(
 	mw Middleware,
 ) {
 	mux.HandleFunc("GET /patrons", mw.Auth(s.handleListPatrons))
+	mux.HandleFunc("GET /patrons/{id}/subscriptions", mw.Auth(s.handleGetPatronSubscriptions))
 }
 
 func (s *Service) handleListPatrons(
@@ -51,10 +75,16 @@ func (s *Service) handleListPatrons(
 		return
 	}
 
-	patrons, err := s.ListPatrons(limit, offset)
+	cursor, malformedQueryErr := wire.ParseCursor(r)
+	if malformedQueryErr != nil {
+		wire.WriteError(w, http.StatusBadRequest, malformedQueryErr.Error())
+		return
+	}
+
+	patrons, next, err := s.QueryPatrons(cursor, limit, offset)
 	if err != nil {
 		wire.WriteError(w, http.StatusInternalServerError, "Internal Server Error")
 	} else {
-		wire.WriteData(w, http.StatusOK, patrons)
+		wire.WritePage(w, http.StatusOK, patrons, next)
 	}
 }
@@ -25,11 +25,11 @@ func TestAPIListPatrons(t *testing.T) {
 	if len(patrons) != 3 {
 		t.Fatalf("want 2 patrons, got %d", len(patrons))
 	}
-	if patrons[0].ID != "c2" {
-		t.Errorf("first patron should be c2, got %s", patrons[0].ID)
+	if patrons[0].ID != "c3" {
+		t.Errorf("first patron should be c3, got %s", patrons[0].ID)
 	}
-	if patrons[1].ID != "c3" {
-		t.Errorf("second patron should be c3, got %s", patrons[1].ID)
+	if patrons[1].ID != "c2" {
+		t.Errorf("second patron should be c2, got %s", patrons[1].ID)
 	}
 	if patrons[2].ID != "c1" {
 		t.Errorf("third patron should be c1, got %s", patrons[2].ID)
@@ -52,11 +52,11 @@ func TestAPIListPatronsPagination(t *testing.T) {
 	if len(patrons) != 2 {
 		t.Fatalf("want 2 patrons, got %d", len(patrons))
 	}
-	if patrons[0].ID != "c2" {
-		t.Errorf("first patron should be updated customer c2")
+	if patrons[0].ID != "c3" {
+		t.Errorf("first patron should be newest customer c3")
 	}
-	if patrons[1].ID != "c3" {
-		t.Errorf("second patron should be c3")
+	if patrons[1].ID != "c2" {
+		t.Errorf("second patron should be c2")
 	}
 }
 
@@ -85,3 +85,29 @@ func TestAPIListPatronsInvalidQuery(t *testing.T) {
 	// validate result
 	result.ExpectStatus(t, http.StatusBadRequest)
 }
+
+func TestAPIListPatronsCursor(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	router := env.Service.BuildRouter()
+	testutil.SeedCustomerData(t, env.Service)
+	auth := testutil.MakeAuthHeader(t, env.Service)
+
+	// get first page
+	result := wire.TestGet[[]service.Patron](router, "/patrons?limit=2", auth)
+	first := result.ExpectOK(t)
+	if len(first) != 2 || result.NextCursor == "" {
+		t.Fatalf("expected full first page with cursor, got %d and %q", len(first), result.NextCursor)
+	}
+
+	// get second page
+	url := "/patrons?limit=2&cursor=" + result.NextCursor
+	result = wire.TestGet[[]service.Patron](router, url, auth)
+	second := result.ExpectOK(t)
+	if len(second) != 1 || second[0].ID != "c1" {
+		t.Fatalf("unexpected second page %+v", second)
+	}
+	if result.NextCursor != "" {
+		t.Errorf("expected no cursor on last page, got %q", result.NextCursor)
+	}
+}
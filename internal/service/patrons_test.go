@@ -18,10 +18,41 @@ func TestListPatrons(t *testing.T) {
 	if len(patrons) != 2 {
 		t.Fatalf("want 2 patrons got %d", len(patrons))
 	}
-	if patrons[0].ID != "c2" {
-		t.Errorf("first patron should be updated customer c2")
+	if patrons[0].ID != "c3" {
+		t.Errorf("first patron should be newest customer c3")
 	}
-	if patrons[1].ID != "c3" {
-		t.Errorf("second patron should be c3")
+	if patrons[1].ID != "c2" {
+		t.Errorf("second patron should be c2")
+	}
+}
+
+func TestQueryPatronsUpdatedWhilePaging(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	testutil.SeedCustomerData(t, env.Service)
+
+	first, next, err := env.Service.QueryPatrons(nil, 2, 0)
+	if err != nil {
+		t.Fatalf("QueryPatrons: %v", err)
+	}
+	if len(first) != 2 || next == nil {
+		t.Fatalf("expected full first page with cursor, got %+v", first)
+	}
+
+	// an update between pages does not move a patron across the cursor
+	name := "Renamed"
+	if err := env.Service.AddCustomer("c1", testutil.MakeDateUnix(2025, 7, 1), &name); err != nil {
+		t.Fatal(err)
+	}
+	if err := env.Service.AddCustomer("c3", testutil.MakeDateUnix(2025, 7, 1)+40, &name); err != nil {
+		t.Fatal(err)
+	}
+
+	second, _, err := env.Service.QueryPatrons(next, 2, 0)
+	if err != nil {
+		t.Fatalf("QueryPatrons: %v", err)
+	}
+	if len(second) != 1 || second[0].ID != "c1" {
+		t.Errorf("unexpected second page %+v", second)
 	}
 }
@@ -0,0 +1,223 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"time"
+
+	"git.sr.ht/~jakintosh/coffer/pkg/wire"
+)
+
+// PaymentAllocation is a recorded payment with the net amount posted to
+// each ledger for it. Held is what disputes that were not won have moved
+// from those ledgers into the holding ledger.
+type PaymentAllocation struct {
+	Payment
+	Posted []Allocation `json:"posted"`
+	Held   int          `json:"held"`
+}
+
+// AllocationDiff compares what a ledger actually received from a set of
+// payments with what it would have received under proposed rules.
+type AllocationDiff struct {
+	Ledger     string `json:"ledger"`
+	Actual     int    `json:"actual"`
+	Proposed   int    `json:"proposed"`
+	Difference int    `json:"difference"`
+}
+
+// AllocationPreview is the result of previewing proposed allocation rules,
+// either on a single amount (Shares) or by replaying the payments between
+// Since and Until (Ledgers).
+type AllocationPreview struct {
+	Amount   int              `json:"amount"`
+	Shares   []Allocation     `json:"shares,omitempty"`
+	Since    *time.Time       `json:"since,omitempty"`
+	Until    *time.Time       `json:"until,omitempty"`
+	Payments int              `json:"payments"`
+	Ledgers  []AllocationDiff `json:"ledgers,omitempty"`
+}
+
+// validatePreviewRules normalizes rules the same way ScheduleAllocations
+// does, so a preview fails exactly when saving the rules would.
+func (s *Service) validatePreviewRules(
+	rules []AllocationRule,
+) (
+	[]AllocationRule,
+	error,
+) {
+	rules, err := normalizeRules(rules)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range rules {
+		if err := s.requireOpenLedger(r.LedgerName); err != nil {
+			return nil, err
+		}
+	}
+	return rules, nil
+}
+
+// PreviewAllocation splits a single amount with rules, exactly as
+// CreatePayment would.
+func (s *Service) PreviewAllocation(
+	rules []AllocationRule,
+	amount int,
+) (
+	*AllocationPreview,
+	error,
+) {
+	if amount <= 0 {
+		return nil, ErrInvalidAlloc
+	}
+	rules, err := s.validatePreviewRules(rules)
+	if err != nil {
+		return nil, err
+	}
+
+	shares := allocate(amount, rules)
+	if shares == nil {
+		shares = []Allocation{}
+	}
+	return &AllocationPreview{
+		Amount:   amount,
+		Shares:   shares,
+		Payments: 1,
+	}, nil
+}
+
+// SimulateAllocations replays every payment created between since and
+// until with rules, and compares each ledger's total with what was actually
+// posted for those payments.
+func (s *Service) SimulateAllocations(
+	rules []AllocationRule,
+	since time.Time,
+	until time.Time,
+) (
+	*AllocationPreview,
+	error,
+) {
+	if until.Before(since) {
+		return nil, ErrInvalidDate
+	}
+	rules, err := s.validatePreviewRules(rules)
+	if err != nil {
+		return nil, err
+	}
+
+	payments, err := s.store.GetPaymentAllocations(since.Unix(), until.Unix())
+	if err != nil {
+		return nil, DatabaseError{err}
+	}
+
+	preview := &AllocationPreview{
+		Since:    &since,
+		Until:    &until,
+		Payments: len(payments),
+	}
+	preview.Ledgers, preview.Amount = diffAllocations(payments, rules)
+
+	return preview, nil
+}
+
+// diffAllocations totals what each ledger was posted for payments and what
+// it would receive under rules, sorted by ledger. It also returns the total
+// allocated amount of the payments.
+func diffAllocations(
+	payments []PaymentAllocation,
+	rules []AllocationRule,
+) (
+	[]AllocationDiff,
+	int,
+) {
+	total := 0
+	totals := map[string]*AllocationDiff{}
+	diff := func(ledger string) *AllocationDiff {
+		if d, ok := totals[ledger]; ok {
+			return d
+		}
+		totals[ledger] = &AllocationDiff{Ledger: ledger}
+		return totals[ledger]
+	}
+	for _, p := range payments {
+		total += p.Allocated
+		for _, a := range p.Posted {
+			diff(a.Ledger).Actual += a.Amount
+		}
+		for _, a := range allocate(p.Allocated, rules) {
+			diff(a.Ledger).Proposed += a.Amount
+		}
+	}
+
+	diffs := make([]AllocationDiff, 0, len(totals))
+	for _, d := range totals {
+		d.Difference = d.Proposed - d.Actual
+		diffs = append(diffs, *d)
+	}
+	sort.Slice(diffs, func(i, j int) bool {
+		return diffs[i].Ledger < diffs[j].Ledger
+	})
+	return diffs, total
+}
+
+// PreviewAllocationsRequest previews rules on a single amount, or on the
+// payments between since and until when no amount is given.
+type PreviewAllocationsRequest struct {
+	Rules  []AllocationRule `json:"rules"`
+	Amount int              `json:"amount"`
+	Since  string           `json:"since"`
+	Until  string           `json:"until"`
+}
+
+func (s *Service) handlePostAllocationsPreview(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	var req PreviewAllocationsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		wire.WriteError(w, http.StatusBadRequest, "Malformed JSON")
+		return
+	}
+
+	var (
+		preview *AllocationPreview
+		err     error
+	)
+	if req.Amount != 0 {
+		preview, err = s.PreviewAllocation(req.Rules, req.Amount)
+	} else {
+		// validate dates as RFC3339, until defaults to now
+		var since, until time.Time
+		if since, err = time.Parse(time.RFC3339, req.Since); err != nil {
+			wire.WriteError(w, http.StatusBadRequest, "Invalid RFC3339 Date")
+			return
+		}
+		until = s.Clock()
+		if req.Until != "" {
+			if until, err = time.Parse(time.RFC3339, req.Until); err != nil {
+				wire.WriteError(w, http.StatusBadRequest, "Invalid RFC3339 Date")
+				return
+			}
+		}
+		preview, err = s.SimulateAllocations(req.Rules, since, until)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrInvalidAlloc):
+			wire.WriteError(w, http.StatusBadRequest, "Invalid Allocation Rules")
+		case errors.Is(err, ErrInvalidDate):
+			wire.WriteError(w, http.StatusBadRequest, "Invalid Date Range")
+		case errors.Is(err, ErrLedgerNotFound):
+			wire.WriteError(w, http.StatusBadRequest, "Unknown Ledger")
+		case errors.Is(err, ErrLedgerArchived):
+			wire.WriteError(w, http.StatusBadRequest, "Archived Ledger")
+		default:
+			wire.WriteError(w, http.StatusInternalServerError, "Internal Server Error")
+		}
+		return
+	}
+
+	wire.WriteData(w, http.StatusOK, preview)
+}
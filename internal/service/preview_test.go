@@ -0,0 +1,108 @@
+package service_test
+
+import (
+	"errors"
+	"testing"
+
+	"git.sr.ht/~jakintosh/coffer/internal/service"
+	"git.sr.ht/~jakintosh/coffer/internal/testutil"
+)
+
+var previewRules = []service.AllocationRule{
+	{
+		ID:         "g",
+		LedgerName: "general",
+		Percentage: 25,
+	},
+	{
+		ID:         "c",
+		LedgerName: "community",
+		Percentage: 75,
+	},
+}
+
+func TestPreviewAllocationMatchesPayment(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	testutil.SeedLedgers(t, env.Service, "community")
+	svc := env.Service
+
+	preview, err := svc.PreviewAllocation(previewRules, 777)
+	if err != nil {
+		t.Fatalf("PreviewAllocation: %v", err)
+	}
+
+	// the preview matches what CreatePayment posts
+	testutil.ScheduleAllocations(t, env, previewRules, testutil.MakeDate(2025, 1, 1))
+	if err := svc.CreatePayment("pi_1", testutil.MakeDateUnix(2025, 1, 2), "succeeded", "cus_1", 777, "usd"); err != nil {
+		t.Fatal(err)
+	}
+	for _, share := range preview.Shares {
+		tx, err := env.DB.GetTransaction("pi_1:" + share.Ledger)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tx.Amount != share.Amount {
+			t.Errorf("%s: preview %d, posted %d", share.Ledger, share.Amount, tx.Amount)
+		}
+	}
+	if len(preview.Shares) != 2 {
+		t.Errorf("expected 2 shares, got %+v", preview.Shares)
+	}
+}
+
+func TestPreviewAllocationInvalid(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	svc := env.Service
+
+	if _, err := svc.PreviewAllocation(previewRules[:1], 100); !errors.Is(err, service.ErrInvalidAlloc) {
+		t.Errorf("expected ErrInvalidAlloc, got %v", err)
+	}
+	if _, err := svc.PreviewAllocation(previewRules, 100); !errors.Is(err, service.ErrLedgerNotFound) {
+		t.Errorf("expected ErrLedgerNotFound, got %v", err)
+	}
+}
+
+func TestSimulateAllocations(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	testutil.SeedLedgers(t, env.Service, "community")
+	svc := env.Service
+
+	// two payments allocated entirely to general
+	if err := svc.CreatePayment("pi_1", testutil.MakeDateUnix(2025, 1, 2), "succeeded", "cus_1", 1000, "usd"); err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.CreatePayment("pi_2", testutil.MakeDateUnix(2025, 1, 3), "succeeded", "cus_1", 777, "usd"); err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.CreatePayment("pi_3", testutil.MakeDateUnix(2025, 3, 1), "succeeded", "cus_1", 5000, "usd"); err != nil {
+		t.Fatal(err)
+	}
+
+	preview, err := svc.SimulateAllocations(
+		previewRules,
+		testutil.MakeDate(2025, 1, 1),
+		testutil.MakeDate(2025, 2, 1),
+	)
+	if err != nil {
+		t.Fatalf("SimulateAllocations: %v", err)
+	}
+	if preview.Payments != 2 || preview.Amount != 1777 {
+		t.Fatalf("unexpected replay %+v", preview)
+	}
+
+	want := []service.AllocationDiff{
+		{Ledger: "community", Actual: 0, Proposed: 750 + 583, Difference: 1333},
+		{Ledger: "general", Actual: 1777, Proposed: 250 + 194, Difference: -1333},
+	}
+	if len(preview.Ledgers) != len(want) {
+		t.Fatalf("want %+v got %+v", want, preview.Ledgers)
+	}
+	for i := range want {
+		if preview.Ledgers[i] != want[i] {
+			t.Errorf("want %+v got %+v", want[i], preview.Ledgers[i])
+		}
+	}
+}
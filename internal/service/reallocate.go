@@ -0,0 +1,237 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"git.sr.ht/~jakintosh/coffer/pkg/wire"
+)
+
+// PaymentReallocation is the change to a single payment's ledger shares.
+// Amount is the allocated amount of the payment. Before is what is
+// currently posted for the payment, After is its split under the target
+// allocation set.
+type PaymentReallocation struct {
+	PaymentID string       `json:"payment_id"`
+	Date      time.Time    `json:"date"`
+	Amount    int          `json:"amount"`
+	Before    []Allocation `json:"before"`
+	After     []Allocation `json:"after"`
+}
+
+// Reallocation re-splits the payments created between Since and Until
+// with a target allocation set. Only payments whose shares change are
+// listed. Disputed lists the payments left as they are because a dispute
+// holds part of them. ID is zero for a dry run.
+type Reallocation struct {
+	ID            int64                 `json:"id"`
+	AllocationSet int64                 `json:"allocation_set"`
+	Since         time.Time             `json:"since"`
+	Until         time.Time             `json:"until"`
+	DryRun        bool                  `json:"dry_run"`
+	Payments      []PaymentReallocation `json:"payments"`
+	Disputed      []string              `json:"disputed"`
+	Ledgers       []AllocationDiff      `json:"ledgers"`
+}
+
+// PaymentReshare works out the new shares of a payment, given the payment
+// as it stands when its reallocation is posted.
+type PaymentReshare func(
+	payment *PaymentAllocation,
+) ([]Allocation, error)
+
+// ReallocatePayments recomputes the ledger shares of every payment created
+// between since and until with the allocation set setID. For each payment
+// whose shares change, its current entries are reversed and replacement
+// entries are posted, all dated now and written in a single database
+// transaction, which splits each payment again as it stands then. Payments
+// with held dispute funds are skipped. A dry run only reports the changes.
+func (s *Service) ReallocatePayments(
+	setID int64,
+	since time.Time,
+	until time.Time,
+	dryRun bool,
+) (
+	*Reallocation,
+	error,
+) {
+	if until.Before(since) {
+		return nil, ErrInvalidDate
+	}
+
+	set, err := s.store.GetAllocationSetByID(setID)
+	if errors.Is(err, ErrAllocNotFound) {
+		return nil, err
+	} else if err != nil {
+		return nil, DatabaseError{err}
+	}
+	if len(set.Rules) == 0 {
+		return nil, ErrInvalidAlloc
+	}
+	for _, r := range set.Rules {
+		if err := s.requireOpenLedger(r.LedgerName); err != nil {
+			return nil, err
+		}
+	}
+
+	payments, err := s.store.GetPaymentAllocations(since.Unix(), until.Unix())
+	if err != nil {
+		return nil, DatabaseError{err}
+	}
+
+	realloc := &Reallocation{
+		AllocationSet: set.ID,
+		Since:         since,
+		Until:         until,
+		DryRun:        dryRun,
+		Payments:      []PaymentReallocation{},
+		Disputed:      []string{},
+	}
+
+	// disputed payments keep their shares until the hold is released
+	var movable []PaymentAllocation
+	for _, p := range payments {
+		if p.Held > 0 {
+			realloc.Disputed = append(realloc.Disputed, p.ID)
+			continue
+		}
+		movable = append(movable, p)
+	}
+	realloc.Ledgers, _ = diffAllocations(movable, set.Rules)
+
+	reshare := reshareWith(set.Rules)
+	for _, p := range movable {
+		after, err := reshare(&p)
+		if err != nil {
+			return nil, err
+		}
+		if sameAllocations(p.Posted, after) {
+			continue
+		}
+		realloc.Payments = append(realloc.Payments, PaymentReallocation{
+			PaymentID: p.ID,
+			Date:      p.Created,
+			Amount:    p.Allocated,
+			Before:    p.Posted,
+			After:     after,
+		})
+	}
+
+	if dryRun || len(realloc.Payments) == 0 {
+		return realloc, nil
+	}
+
+	realloc.ID, err = s.store.InsertReallocation(
+		set.ID,
+		since.Unix(),
+		until.Unix(),
+		realloc.Payments,
+		reshare,
+		s.Clock().Unix(),
+	)
+	if errors.Is(err, ErrTxConflict) || errors.Is(err, ErrPaymentDisputed) {
+		return nil, err
+	} else if err != nil {
+		return nil, DatabaseError{err}
+	}
+
+	return realloc, nil
+}
+
+// reshareWith splits what is allocated to a payment with rules, unless a
+// dispute holds part of it
+func reshareWith(
+	rules []AllocationRule,
+) PaymentReshare {
+	return func(payment *PaymentAllocation) ([]Allocation, error) {
+		if payment.Held > 0 {
+			return nil, ErrPaymentDisputed
+		}
+		after := allocate(payment.Allocated, rules)
+		if after == nil {
+			after = []Allocation{}
+		}
+		return after, nil
+	}
+}
+
+// sameAllocations reports whether two sets of shares post the same net
+// amount to every ledger, ignoring order and empty shares.
+func sameAllocations(a, b []Allocation) bool {
+	net := map[string]int{}
+	for _, x := range a {
+		net[x.Ledger] += x.Amount
+	}
+	for _, x := range b {
+		net[x.Ledger] -= x.Amount
+	}
+	for _, amount := range net {
+		if amount != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+type ReallocateRequest struct {
+	AllocationSet int64  `json:"allocation_set"`
+	Since         string `json:"since"`
+	Until         string `json:"until"`
+	DryRun        bool   `json:"dry_run"`
+}
+
+func (s *Service) handlePostReallocate(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	var req ReallocateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		wire.WriteError(w, http.StatusBadRequest, "Malformed JSON")
+		return
+	}
+
+	// validate dates as RFC3339, until defaults to now
+	since, err := time.Parse(time.RFC3339, req.Since)
+	if err != nil {
+		wire.WriteError(w, http.StatusBadRequest, "Invalid RFC3339 Date")
+		return
+	}
+	until := s.Clock()
+	if req.Until != "" {
+		if until, err = time.Parse(time.RFC3339, req.Until); err != nil {
+			wire.WriteError(w, http.StatusBadRequest, "Invalid RFC3339 Date")
+			return
+		}
+	}
+
+	realloc, err := s.ReallocatePayments(req.AllocationSet, since, until, req.DryRun)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrAllocNotFound):
+			wire.WriteError(w, http.StatusBadRequest, "Unknown Allocation Set")
+		case errors.Is(err, ErrInvalidAlloc):
+			wire.WriteError(w, http.StatusBadRequest, "Invalid Allocation Rules")
+		case errors.Is(err, ErrInvalidDate):
+			wire.WriteError(w, http.StatusBadRequest, "Invalid Date Range")
+		case errors.Is(err, ErrLedgerNotFound):
+			wire.WriteError(w, http.StatusBadRequest, "Unknown Ledger")
+		case errors.Is(err, ErrLedgerArchived):
+			wire.WriteError(w, http.StatusBadRequest, "Archived Ledger")
+		case errors.Is(err, ErrTxConflict):
+			wire.WriteError(w, http.StatusConflict, "Transaction Conflict")
+		case errors.Is(err, ErrPaymentDisputed):
+			wire.WriteError(w, http.StatusConflict, "Payment Disputed")
+		default:
+			wire.WriteError(w, http.StatusInternalServerError, "Internal Server Error")
+		}
+		return
+	}
+
+	code := http.StatusOK
+	if realloc.ID != 0 {
+		code = http.StatusCreated
+	}
+	wire.WriteData(w, code, realloc)
+}
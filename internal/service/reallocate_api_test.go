@@ -0,0 +1,66 @@
+package service_test
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"git.sr.ht/~jakintosh/coffer/internal/service"
+	"git.sr.ht/~jakintosh/coffer/internal/testutil"
+	"git.sr.ht/~jakintosh/coffer/pkg/wire"
+)
+
+func TestAPIReallocate(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	setID := seedReallocation(t, env)
+	router := env.Service.BuildRouter()
+	auth := testutil.MakeAuthHeader(t, env.Service)
+
+	url := "/ledger/reallocate"
+	body := fmt.Sprintf(`{
+		"allocation_set": %d,
+		"since": "2025-03-01T00:00:00Z",
+		"until": "2025-04-01T00:00:00Z",
+		"dry_run": true
+	}`, setID)
+
+	// dry run shows the diff
+	result := wire.TestPost[service.Reallocation](router, url, body, auth)
+	realloc := result.ExpectOK(t)
+	if !realloc.DryRun || len(realloc.Payments) != 2 {
+		t.Fatalf("unexpected dry run %+v", realloc)
+	}
+
+	// commit
+	body = fmt.Sprintf(`{
+		"allocation_set": %d,
+		"since": "2025-03-01T00:00:00Z",
+		"until": "2025-04-01T00:00:00Z"
+	}`, setID)
+	result = wire.TestPost[service.Reallocation](router, url, body, auth)
+	result.ExpectStatus(t, http.StatusCreated)
+	if result.Data.ID == 0 {
+		t.Errorf("expected reallocation id, got %+v", result.Data)
+	}
+}
+
+func TestAPIReallocateBad(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	router := env.Service.BuildRouter()
+	auth := testutil.MakeAuthHeader(t, env.Service)
+	url := "/ledger/reallocate"
+
+	// missing auth
+	body := `{ "allocation_set": 1, "since": "2025-03-01T00:00:00Z" }`
+	wire.TestPost[any](router, url, body).ExpectStatus(t, http.StatusUnauthorized)
+
+	// unknown set
+	body = `{ "allocation_set": 99, "since": "2025-03-01T00:00:00Z" }`
+	wire.TestPost[any](router, url, body, auth).ExpectStatus(t, http.StatusBadRequest)
+
+	// bad date
+	body = `{ "allocation_set": 1, "since": "2025-03-01" }`
+	wire.TestPost[any](router, url, body, auth).ExpectStatus(t, http.StatusBadRequest)
+}
@@ -0,0 +1,157 @@
+package service_test
+
+import (
+	"errors"
+	"testing"
+
+	"git.sr.ht/~jakintosh/coffer/internal/service"
+	"git.sr.ht/~jakintosh/coffer/internal/testutil"
+)
+
+// seedReallocation posts two march payments to general, then saves a
+// corrected 50/50 split and returns its set ID.
+func seedReallocation(
+	t *testing.T,
+	env *testutil.TestEnv,
+) int64 {
+	t.Helper()
+	svc := env.Service
+
+	testutil.SeedLedgers(t, svc, "community")
+	if err := svc.CreatePayment("pi_1", testutil.MakeDateUnix(2025, 3, 2), "succeeded", "cus_1", 1000, "usd"); err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.CreatePayment("pi_2", testutil.MakeDateUnix(2025, 3, 3), "succeeded", "cus_1", 501, "usd"); err != nil {
+		t.Fatal(err)
+	}
+
+	rules := []service.AllocationRule{
+		{ID: "g", LedgerName: "general", Percentage: 50},
+		{ID: "c", LedgerName: "community", Percentage: 50},
+	}
+	testutil.ScheduleAllocations(t, env, rules, testutil.MakeDate(2025, 4, 1))
+	sets, err := svc.GetAllocationHistory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sets[0].ID
+}
+
+func TestReallocatePaymentsDryRun(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	svc := env.Service
+	setID := seedReallocation(t, env)
+
+	realloc, err := svc.ReallocatePayments(setID, testutil.MakeDate(2025, 3, 1), testutil.MakeDate(2025, 4, 1), true)
+	if err != nil {
+		t.Fatalf("ReallocatePayments: %v", err)
+	}
+	if realloc.ID != 0 || len(realloc.Payments) != 2 {
+		t.Fatalf("unexpected dry run %+v", realloc)
+	}
+	p := realloc.Payments[1]
+	if p.PaymentID != "pi_2" || len(p.After) != 2 || p.After[0].Amount != 251 || p.After[1].Amount != 250 {
+		t.Errorf("unexpected payment diff %+v", p)
+	}
+	if len(realloc.Ledgers) != 2 || realloc.Ledgers[0].Difference != 750 || realloc.Ledgers[1].Difference != -750 {
+		t.Errorf("unexpected ledger diff %+v", realloc.Ledgers)
+	}
+
+	// nothing was written
+	cTx, err := svc.GetTransactions("community", 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cTx) != 0 {
+		t.Errorf("dry run posted %d transactions", len(cTx))
+	}
+}
+
+func TestReallocatePayments(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	svc := env.Service
+	setID := seedReallocation(t, env)
+	since, until := testutil.MakeDate(2025, 3, 1), testutil.MakeDate(2025, 4, 1)
+	now := testutil.MakeDate(2025, 4, 15)
+	env.Clock.Set(now)
+
+	realloc, err := svc.ReallocatePayments(setID, since, until, false)
+	if err != nil {
+		t.Fatalf("ReallocatePayments: %v", err)
+	}
+	if realloc.ID == 0 || len(realloc.Payments) != 2 {
+		t.Fatalf("unexpected reallocation %+v", realloc)
+	}
+
+	// balances reflect the new split from when it was made, with the
+	// original entries reversed
+	for ledger, want := range map[string]int{"general": 751, "community": 750} {
+		snapshot, err := svc.GetSnapshot(ledger, since, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if snapshot.ClosingBalance != want {
+			t.Errorf("%s: want balance %d got %d", ledger, want, snapshot.ClosingBalance)
+		}
+	}
+	snapshot, err := svc.GetSnapshot("community", since, until)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snapshot.ClosingBalance != 0 {
+		t.Errorf("community: want reported balance 0 got %d", snapshot.ClosingBalance)
+	}
+	reversal, err := env.DB.GetTransaction("pi_1:general:reversal")
+	if err != nil {
+		t.Errorf("expected original entry to be reversed: %v", err)
+	} else if !reversal.Date.Equal(now) {
+		t.Errorf("want reversal dated %v got %v", now, reversal.Date)
+	}
+
+	// the payment records its new allocation set
+	payment, err := svc.GetPayment("pi_1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payment.AllocationSet != setID {
+		t.Errorf("want allocation set %d got %d", setID, payment.AllocationSet)
+	}
+
+	// reallocating again with the same set changes nothing
+	again, err := svc.ReallocatePayments(setID, since, until, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.ID != 0 || len(again.Payments) != 0 {
+		t.Errorf("expected no changes, got %+v", again)
+	}
+
+	// and payments can be moved back to the original set
+	back, err := svc.ReallocatePayments(1, since, until, false)
+	if err != nil {
+		t.Fatalf("ReallocatePayments back: %v", err)
+	}
+	if len(back.Payments) != 2 {
+		t.Fatalf("unexpected reallocation %+v", back)
+	}
+	snapshot, err = svc.GetSnapshot("community", since, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snapshot.ClosingBalance != 0 {
+		t.Errorf("community: want balance 0 got %d", snapshot.ClosingBalance)
+	}
+}
+
+func TestReallocatePaymentsUnknownSet(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	svc := env.Service
+
+	_, err := svc.ReallocatePayments(99, testutil.MakeDate(2025, 3, 1), testutil.MakeDate(2025, 4, 1), true)
+	if !errors.Is(err, service.ErrAllocNotFound) {
+		t.Errorf("expected ErrAllocNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,173 @@
+package service
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"git.sr.ht/~jakintosh/coffer/pkg/wire"
+)
+
+// BalanceTransaction is a Stripe balance transaction settled by a payout,
+// as recorded locally. Source is the object that moved the funds, such as
+// a charge or a refund, and Net is the amount left after Stripe's fee.
+type BalanceTransaction struct {
+	ID       string    `json:"id"`
+	Payout   string    `json:"payout"`
+	Created  time.Time `json:"created"`
+	Type     string    `json:"type"`
+	Source   string    `json:"source"`
+	Amount   int       `json:"amount"`
+	Fee      int       `json:"fee"`
+	Net      int       `json:"net"`
+	Currency string    `json:"currency"`
+}
+
+// PayoutPayment is a payment balance transaction settled by a payout,
+// matched to the local payment recorded with it. Payment is empty when no
+// payment was recorded, and Ledgered reports whether any share of the
+// payment is posted and not reversed.
+type PayoutPayment struct {
+	BalanceTransaction
+	Payment  string `json:"payment,omitempty"`
+	Ledgered bool   `json:"ledgered"`
+}
+
+// PayoutTotal compares a paid payout with the balance transactions stored
+// for it. Net is the total of those transactions, which matches Amount
+// when every transaction settled by the payout is known.
+type PayoutTotal struct {
+	ID           string    `json:"id"`
+	Created      time.Time `json:"created"`
+	Amount       int       `json:"amount"`
+	Currency     string    `json:"currency"`
+	Net          int       `json:"net"`
+	Transactions int       `json:"transactions"`
+}
+
+// Reconciliation checks the payouts created between Since and Until
+// against the payments and ledger entries recorded locally. Payouts and
+// Payments count what was checked; the lists hold what did not match.
+// UnverifiedPayouts have no stored balance transactions to check, which
+// is always the case for manual payouts.
+type Reconciliation struct {
+	Since             time.Time       `json:"since"`
+	Until             time.Time       `json:"until"`
+	Payouts           int             `json:"payouts"`
+	Payments          int             `json:"payments"`
+	MissingPayments   []PayoutPayment `json:"missing_payments"`
+	UnmatchedEntries  []Transaction   `json:"unmatched_entries"`
+	MismatchedPayouts []PayoutTotal   `json:"mismatched_payouts"`
+	UnverifiedPayouts []PayoutTotal   `json:"unverified_payouts"`
+}
+
+// Reconcile matches the payments settled by each paid payout created from
+// since up to, but not including, until with the payments in the ledger.
+// It lists payments Stripe paid out that have no ledger entries, patron
+// entries dated in the range whose payment was never recorded, and
+// payouts whose amount differs from the total of their stored balance
+// transactions. Balance transactions are only known for automatic payouts,
+// so payouts without any are listed as unverified instead.
+func (s *Service) Reconcile(
+	since time.Time,
+	until time.Time,
+) (
+	*Reconciliation,
+	error,
+) {
+	if !until.After(since) {
+		return nil, ErrInvalidRange
+	}
+
+	payouts, err := s.store.GetPayoutTotals(since.Unix(), until.Unix())
+	if err != nil {
+		return nil, DatabaseError{err}
+	}
+	payments, err := s.store.GetPayoutPayments(since.Unix(), until.Unix())
+	if err != nil {
+		return nil, DatabaseError{err}
+	}
+	entries, err := s.store.GetUnmatchedEntries(since.Unix(), until.Unix())
+	if err != nil {
+		return nil, DatabaseError{err}
+	}
+
+	report := &Reconciliation{
+		Since:             since,
+		Until:             until,
+		Payouts:           len(payouts),
+		Payments:          len(payments),
+		MissingPayments:   []PayoutPayment{},
+		UnmatchedEntries:  entries,
+		MismatchedPayouts: []PayoutTotal{},
+		UnverifiedPayouts: []PayoutTotal{},
+	}
+	for _, p := range payments {
+		if !p.Ledgered {
+			report.MissingPayments = append(report.MissingPayments, p)
+		}
+	}
+	for _, p := range payouts {
+		if p.Transactions == 0 {
+			report.UnverifiedPayouts = append(report.UnverifiedPayouts, p)
+		} else if p.Net != p.Amount {
+			report.MismatchedPayouts = append(report.MismatchedPayouts, p)
+		}
+	}
+
+	return report, nil
+}
+
+func (s *Service) buildReconciliationRouter(
+	mux *http.ServeMux,
+	mw Middleware,
+) {
+	mux.HandleFunc("GET /reconciliation", mw.Auth(s.handleGetReconciliation))
+}
+
+func (s *Service) handleGetReconciliation(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	sinceQ := r.URL.Query().Get("since")
+	untilQ := r.URL.Query().Get("until")
+
+	var (
+		err   error
+		since time.Time
+		until time.Time
+	)
+
+	if untilQ != "" {
+		if until, err = time.Parse("2006-01-02", untilQ); err != nil {
+			wire.WriteError(w, http.StatusBadRequest, "Malformed 'until' Query")
+			return
+		}
+	} else {
+		until = s.Clock()
+	}
+
+	if sinceQ != "" {
+		if since, err = time.Parse("2006-01-02", sinceQ); err != nil {
+			wire.WriteError(w, http.StatusBadRequest, "Malformed 'since' Query")
+			return
+		}
+	} else {
+		// default to the start of the month that until ends
+		u := until.Add(-time.Second).UTC()
+		since = time.Date(u.Year(), u.Month(), 1, 0, 0, 0, 0, time.UTC)
+	}
+
+	report, err := s.Reconcile(since, until)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrInvalidRange):
+			wire.WriteError(w, http.StatusBadRequest, "Invalid Reconciliation Range")
+		default:
+			wire.WriteError(w, http.StatusInternalServerError, "Internal Server Error")
+		}
+		return
+	}
+
+	wire.WriteData(w, http.StatusOK, report)
+}
@@ -0,0 +1,67 @@
+package service_test
+
+import (
+	"net/http"
+	"testing"
+
+	"git.sr.ht/~jakintosh/coffer/internal/service"
+	"git.sr.ht/~jakintosh/coffer/internal/testutil"
+	"git.sr.ht/~jakintosh/coffer/pkg/wire"
+)
+
+func TestAPIGetReconciliation(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	seedPayouts(t, env)
+	router := env.Service.BuildRouter()
+	auth := testutil.MakeAuthHeader(t, env.Service)
+
+	url := "/reconciliation?since=2025-01-01&until=2025-02-01"
+	result := wire.TestGet[service.Reconciliation](router, url, auth)
+
+	report := result.ExpectOK(t)
+	if report.Payouts != 2 || len(report.MissingPayments) != 2 ||
+		len(report.UnmatchedEntries) != 1 || len(report.MismatchedPayouts) != 1 {
+		t.Errorf("unexpected report %+v", report)
+	}
+}
+
+func TestAPIGetReconciliationDefaultSince(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	seedPayouts(t, env)
+	router := env.Service.BuildRouter()
+	auth := testutil.MakeAuthHeader(t, env.Service)
+
+	// defaults to the month that until ends
+	result := wire.TestGet[service.Reconciliation](router, "/reconciliation?until=2025-02-01", auth)
+
+	report := result.ExpectOK(t)
+	if !report.Since.Equal(testutil.MakeDate(2025, 1, 1)) || report.Payouts != 2 {
+		t.Errorf("unexpected report %+v", report)
+	}
+}
+
+func TestAPIGetReconciliationBadQuery(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	router := env.Service.BuildRouter()
+	auth := testutil.MakeAuthHeader(t, env.Service)
+
+	for _, url := range []string{
+		"/reconciliation?since=january",
+		"/reconciliation?since=2025-02-01&until=2025-01-01",
+	} {
+		result := wire.TestGet[any](router, url, auth)
+		result.ExpectStatus(t, http.StatusBadRequest)
+	}
+}
+
+func TestAPIGetReconciliationUnauthorized(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	router := env.Service.BuildRouter()
+
+	result := wire.TestGet[any](router, "/reconciliation")
+	result.ExpectStatus(t, http.StatusUnauthorized)
+}
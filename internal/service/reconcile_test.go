@@ -0,0 +1,170 @@
+package service_test
+
+import (
+	"errors"
+	"testing"
+
+	"git.sr.ht/~jakintosh/coffer/internal/service"
+	"git.sr.ht/~jakintosh/coffer/internal/testutil"
+)
+
+// seedPayouts records a payment and two automatic payouts for January 2025.
+// The first payout settles the payment and a charge that was never
+// recorded, and the second is missing a transaction. An entry for a payment
+// that was never recorded is also posted.
+func seedPayouts(
+	t *testing.T,
+	env *testutil.TestEnv,
+) {
+	t.Helper()
+
+	f := env.Stripe
+	addSettledIntent(f, "pi_1", testutil.MakeDateUnix(2025, 1, 2), 1000, 59)
+	env.Service.HandleStripeResource("payment", "pi_1")
+
+	payout := func(id string, amount int, created int64) map[string]any {
+		return map[string]any{
+			"id":        id,
+			"object":    "payout",
+			"created":   created,
+			"status":    "paid",
+			"automatic": true,
+			"amount":    amount,
+			"currency":  "usd",
+		}
+	}
+	f.AddObject("/v1/payouts/po_1", payout("po_1", 1426, testutil.MakeDateUnix(2025, 1, 10)))
+	f.AddObject("/v1/payouts/po_2", payout("po_2", 1000, testutil.MakeDateUnix(2025, 1, 20)))
+
+	// payout is not a real balance transaction field, but lets the fake
+	// filter the list by payout
+	txn := func(id, payout, kind, source string, amount, fee int) map[string]any {
+		return map[string]any{
+			"id":       id,
+			"object":   "balance_transaction",
+			"payout":   payout,
+			"created":  testutil.MakeDateUnix(2025, 1, 3),
+			"type":     kind,
+			"source":   source,
+			"amount":   amount,
+			"fee":      fee,
+			"net":      amount - fee,
+			"currency": "usd",
+		}
+	}
+	f.AddList("/v1/balance_transactions",
+		txn("txn_po_2", "po_2", "payout", "po_2", -1000, 0),
+		txn("txn_3", "po_2", "charge", "ch_3", 920, 20),
+		txn("txn_po_1", "po_1", "payout", "po_1", -1426, 0),
+		txn("txn_pi_1", "po_1", "charge", "ch_pi_1", 1000, 59),
+		txn("txn_2", "po_1", "charge", "ch_2", 500, 15),
+	)
+	env.Service.HandleStripeResource("payout", "po_1")
+	env.Service.HandleStripeResource("payout", "po_2")
+
+	if err := env.Service.AddTransaction(
+		"pi_lost:general",
+		"general",
+		700,
+		testutil.MakeDate(2025, 1, 5),
+		"patron",
+	); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReconcile(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	seedPayouts(t, env)
+
+	report, err := env.Service.Reconcile(
+		testutil.MakeDate(2025, 1, 1),
+		testutil.MakeDate(2025, 2, 1),
+	)
+	if err != nil {
+		t.Fatalf("Reconcile: %v", err)
+	}
+	if report.Payouts != 2 || report.Payments != 3 {
+		t.Errorf("unexpected counts %+v", report)
+	}
+
+	missing := report.MissingPayments
+	if len(missing) != 2 || missing[0].ID != "txn_2" || missing[1].ID != "txn_3" {
+		t.Fatalf("unexpected missing payments %+v", missing)
+	}
+	if missing[0].Payment != "" || missing[0].Payout != "po_1" || missing[0].Net != 485 {
+		t.Errorf("unexpected missing payment %+v", missing[0])
+	}
+
+	entries := report.UnmatchedEntries
+	if len(entries) != 1 || entries[0].ID != "pi_lost:general" {
+		t.Errorf("unexpected unmatched entries %+v", entries)
+	}
+
+	payouts := report.MismatchedPayouts
+	if len(payouts) != 1 || payouts[0].ID != "po_2" || payouts[0].Net != 900 || payouts[0].Transactions != 1 {
+		t.Errorf("unexpected mismatched payouts %+v", payouts)
+	}
+}
+
+func TestReconcileOutsideRange(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	seedPayouts(t, env)
+
+	// the end of the range is exclusive
+	report, err := env.Service.Reconcile(
+		testutil.MakeDate(2024, 12, 1),
+		testutil.MakeDate(2025, 1, 1),
+	)
+	if err != nil {
+		t.Fatalf("Reconcile: %v", err)
+	}
+	if report.Payouts != 0 || len(report.MissingPayments) != 0 ||
+		len(report.UnmatchedEntries) != 0 || len(report.MismatchedPayouts) != 0 ||
+		len(report.UnverifiedPayouts) != 0 {
+		t.Errorf("unexpected report %+v", report)
+	}
+}
+
+func TestReconcileInvalidRange(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+
+	date := testutil.MakeDate(2025, 1, 1)
+	_, err := env.Service.Reconcile(date, date)
+	if !errors.Is(err, service.ErrInvalidRange) {
+		t.Errorf("expected ErrInvalidRange, got %v", err)
+	}
+}
+
+func TestManualPayoutUnverified(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+
+	// listing a manual payout's transactions would fail
+	env.Stripe.AddObject("/v1/payouts/po_1", map[string]any{
+		"id":       "po_1",
+		"object":   "payout",
+		"created":  testutil.MakeDateUnix(2025, 1, 10),
+		"status":   "paid",
+		"amount":   1000,
+		"currency": "usd",
+	})
+	env.Service.HandleStripeResource("payout", "po_1")
+
+	report, err := env.Service.Reconcile(
+		testutil.MakeDate(2025, 1, 1),
+		testutil.MakeDate(2025, 2, 1),
+	)
+	if err != nil {
+		t.Fatalf("Reconcile: %v", err)
+	}
+	if len(report.MismatchedPayouts) != 0 {
+		t.Errorf("unexpected mismatched payouts %+v", report.MismatchedPayouts)
+	}
+	if len(report.UnverifiedPayouts) != 1 || report.UnverifiedPayouts[0].ID != "po_1" {
+		t.Errorf("unexpected unverified payouts %+v", report.UnverifiedPayouts)
+	}
+}
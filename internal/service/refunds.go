@@ -0,0 +1,142 @@
+package service
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+// Refund is a Stripe refund of a payment as recorded locally. Once a refund
+// succeeds it is posted: Allocated is the part of the payment's allocated
+// amount it took back from the ledgers.
+type Refund struct {
+	ID        string    `json:"id"`
+	Payment   string    `json:"payment"`
+	Created   time.Time `json:"created"`
+	Status    string    `json:"status"`
+	Amount    int       `json:"amount"`
+	Currency  string    `json:"currency"`
+	Allocated int       `json:"allocated"`
+	Posted    bool      `json:"posted"`
+}
+
+// RefundReversal works out the shares a refund takes back from a payment,
+// given the payment as it stands when the refund is posted, and sets the
+// refund's Allocated amount.
+type RefundReversal func(
+	payment *PaymentAllocation,
+	refund *Refund,
+) ([]Allocation, error)
+
+// CreateRefund records a refund of a payment. When the refund succeeds,
+// the payment's current ledger shares are reversed in proportion to the
+// amount refunded, so a full refund leaves every ledger where it was before
+// the payment. A refund is only posted once, and later events only update
+// its status, except that a posted refund that fails or is canceled has its
+// entries reversed and gives the payment back what it took. A refund of a payment that is still waiting on its fee is
+// not recorded, and ErrFeePending is returned so that it is retried once
+// the payment has been allocated. Refunds of a payment with held dispute
+// funds are rejected with ErrPaymentDisputed, since the shares they would
+// take back are partly in the holding ledger.
+func (s *Service) CreateRefund(
+	id string,
+	paymentID string,
+	created int64,
+	status string,
+	amount int64,
+	currency string,
+) error {
+	refund := Refund{
+		ID:       id,
+		Payment:  paymentID,
+		Created:  time.Unix(created, 0),
+		Status:   status,
+		Amount:   int(amount),
+		Currency: currency,
+		Posted:   status == "succeeded",
+	}
+
+	err := s.store.InsertRefund(refund, reverseRefund, s.Clock().Unix())
+	if errors.Is(err, ErrPaymentNotFound) ||
+		errors.Is(err, ErrInvalidRefund) ||
+		errors.Is(err, ErrFeePending) ||
+		errors.Is(err, ErrPaymentDisputed) ||
+		errors.Is(err, ErrTxConflict) {
+		return err
+	} else if err != nil {
+		return DatabaseError{err}
+	}
+
+	return nil
+}
+
+// reverseRefund takes back the same fraction of what is still allocated to
+// a payment as the refund is of what has not been refunded yet
+func reverseRefund(
+	payment *PaymentAllocation,
+	refund *Refund,
+) (
+	[]Allocation,
+	error,
+) {
+	if payment.Pending {
+		return nil, ErrFeePending
+	}
+	if payment.Held > 0 {
+		return nil, ErrPaymentDisputed
+	}
+	remaining := payment.Amount - payment.Refunded
+	if refund.Amount <= 0 || refund.Amount > remaining {
+		return nil, ErrInvalidRefund
+	}
+
+	refund.Allocated = payment.Allocated * refund.Amount / remaining
+	return prorate(refund.Allocated, payment.Posted), nil
+}
+
+// prorate splits amount across shares in proportion to their amounts, using
+// the largest remainder method like allocate. Shares that are not positive
+// get nothing, and empty results are dropped.
+func prorate(
+	amount int,
+	shares []Allocation,
+) []Allocation {
+	total := 0
+	for _, s := range shares {
+		if s.Amount > 0 {
+			total += s.Amount
+		}
+	}
+	if total == 0 {
+		return nil
+	}
+
+	type fraction struct{ index, rem int }
+	var fractions []fraction
+	split := make([]int, len(shares))
+	given := 0
+	for i, s := range shares {
+		if s.Amount > 0 {
+			exact := amount * s.Amount
+			split[i] = exact / total
+			given += split[i]
+			fractions = append(fractions, fraction{i, exact % total})
+		}
+	}
+
+	// hand out leftover cents by largest remainder, earlier shares first
+	sort.SliceStable(fractions, func(a, b int) bool {
+		return fractions[a].rem > fractions[b].rem
+	})
+	for i := 0; i < amount-given && i < len(fractions); i++ {
+		split[fractions[i].index]++
+	}
+
+	var prorated []Allocation
+	for i, s := range shares {
+		if split[i] != 0 {
+			prorated = append(prorated, Allocation{s.Ledger, split[i]})
+		}
+	}
+	return prorated
+}
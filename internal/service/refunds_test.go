@@ -0,0 +1,333 @@
+package service_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"git.sr.ht/~jakintosh/coffer/internal/service"
+	"git.sr.ht/~jakintosh/coffer/internal/testutil"
+)
+
+// seedRefundPayment splits a 1001 payment 60/40 between general and
+// community.
+func seedRefundPayment(
+	t *testing.T,
+	env *testutil.TestEnv,
+) {
+	t.Helper()
+	svc := env.Service
+
+	testutil.SeedLedgers(t, svc, "community")
+	rules := []service.AllocationRule{
+		{ID: "g", LedgerName: "general", Percentage: 60},
+		{ID: "c", LedgerName: "community", Percentage: 40},
+	}
+	testutil.ScheduleAllocations(t, env, rules, testutil.MakeDate(2025, 1, 1))
+	if err := svc.CreatePayment("pi_1", testutil.MakeDateUnix(2025, 3, 2), "succeeded", "cus_1", 1001, "usd"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// balance returns the closing balance of a ledger
+func balance(
+	t *testing.T,
+	svc *service.Service,
+	ledger string,
+) int {
+	t.Helper()
+
+	snapshot, err := svc.GetSnapshot(ledger, time.Unix(0, 0), testutil.MakeDate(2030, 1, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return snapshot.ClosingBalance
+}
+
+func TestCreateRefundFull(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	svc := env.Service
+	seedRefundPayment(t, env)
+
+	if err := svc.CreateRefund("re_1", "pi_1", testutil.MakeDateUnix(2025, 3, 5), "succeeded", 1001, "usd"); err != nil {
+		t.Fatalf("CreateRefund: %v", err)
+	}
+
+	// every share is reversed
+	if b := balance(t, svc, "general"); b != 0 {
+		t.Errorf("want general balance 0 got %d", b)
+	}
+	if b := balance(t, svc, "community"); b != 0 {
+		t.Errorf("want community balance 0 got %d", b)
+	}
+	payment, err := svc.GetPayment("pi_1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payment.Status != "refunded" || payment.Refunded != 1001 || payment.Allocated != 0 {
+		t.Errorf("unexpected payment %+v", payment)
+	}
+	tx, err := env.DB.GetTransaction("pi_1:general:re_1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tx.Amount != -601 || tx.Label != "refund" || !tx.Date.Equal(testutil.MakeDate(2025, 3, 5)) {
+		t.Errorf("unexpected refund entry %+v", tx)
+	}
+}
+
+func TestCreateRefundPartial(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	svc := env.Service
+	seedRefundPayment(t, env)
+
+	if err := svc.CreateRefund("re_1", "pi_1", testutil.MakeDateUnix(2025, 3, 5), "succeeded", 500, "usd"); err != nil {
+		t.Fatalf("CreateRefund: %v", err)
+	}
+
+	// shares are reversed in proportion
+	if b := balance(t, svc, "general"); b != 301 {
+		t.Errorf("want general balance 301 got %d", b)
+	}
+	if b := balance(t, svc, "community"); b != 200 {
+		t.Errorf("want community balance 200 got %d", b)
+	}
+	payment, err := svc.GetPayment("pi_1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payment.Status != "partially_refunded" || payment.Refunded != 500 || payment.Allocated != 501 {
+		t.Errorf("unexpected payment %+v", payment)
+	}
+
+	// refunding the rest leaves nothing behind
+	if err := svc.CreateRefund("re_2", "pi_1", testutil.MakeDateUnix(2025, 3, 6), "succeeded", 501, "usd"); err != nil {
+		t.Fatalf("CreateRefund: %v", err)
+	}
+	if b := balance(t, svc, "general") + balance(t, svc, "community"); b != 0 {
+		t.Errorf("want no balance left got %d", b)
+	}
+	payment, err = svc.GetPayment("pi_1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payment.Status != "refunded" {
+		t.Errorf("want status refunded got %s", payment.Status)
+	}
+
+	// nothing is left to refund
+	err = svc.CreateRefund("re_3", "pi_1", testutil.MakeDateUnix(2025, 3, 7), "succeeded", 1, "usd")
+	if !errors.Is(err, service.ErrInvalidRefund) {
+		t.Errorf("want ErrInvalidRefund got %v", err)
+	}
+}
+
+func TestCreateRefundPosting(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	svc := env.Service
+	seedRefundPayment(t, env)
+	date := testutil.MakeDateUnix(2025, 3, 5)
+
+	// pending refunds are recorded but not posted
+	if err := svc.CreateRefund("re_1", "pi_1", date, "pending", 1001, "usd"); err != nil {
+		t.Fatalf("CreateRefund: %v", err)
+	}
+	if b := balance(t, svc, "general"); b != 601 {
+		t.Errorf("want general balance 601 got %d", b)
+	}
+
+	// the refund is posted once it succeeds, and only once
+	for range 2 {
+		if err := svc.CreateRefund("re_1", "pi_1", date, "succeeded", 1001, "usd"); err != nil {
+			t.Fatalf("CreateRefund: %v", err)
+		}
+	}
+	if b := balance(t, svc, "general"); b != 0 {
+		t.Errorf("want general balance 0 got %d", b)
+	}
+	payment, err := svc.GetPayment("pi_1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payment.Refunded != 1001 {
+		t.Errorf("want refunded 1001 got %d", payment.Refunded)
+	}
+
+	// a later payment update keeps the refunded status
+	if err := svc.CreatePayment("pi_1", testutil.MakeDateUnix(2025, 3, 2), "succeeded", "cus_1", 1001, "usd"); err != nil {
+		t.Fatal(err)
+	}
+	payment, err = svc.GetPayment("pi_1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payment.Status != "refunded" {
+		t.Errorf("want status refunded got %s", payment.Status)
+	}
+}
+
+func TestCreateRefundFailed(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	svc := env.Service
+	seedRefundPayment(t, env)
+	date := testutil.MakeDateUnix(2025, 3, 5)
+
+	if err := svc.CreateRefund("re_1", "pi_1", date, "succeeded", 500, "usd"); err != nil {
+		t.Fatalf("CreateRefund: %v", err)
+	}
+
+	// a posted refund that fails is reversed when it fails, and only once
+	failed := testutil.MakeDate(2025, 4, 1)
+	env.Clock.Set(failed)
+	for range 2 {
+		if err := svc.CreateRefund("re_1", "pi_1", date, "failed", 500, "usd"); err != nil {
+			t.Fatalf("CreateRefund: %v", err)
+		}
+	}
+	if b := balance(t, svc, "general"); b != 601 {
+		t.Errorf("want general balance 601 got %d", b)
+	}
+	if b := balance(t, svc, "community"); b != 400 {
+		t.Errorf("want community balance 400 got %d", b)
+	}
+	tx, err := env.DB.GetTransaction("pi_1:general:re_1:reversal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tx.Amount != 300 || tx.Reverses != "pi_1:general:re_1" || !tx.Date.Equal(failed) {
+		t.Errorf("unexpected reversal %+v", tx)
+	}
+	payment, err := svc.GetPayment("pi_1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payment.Status != "succeeded" || payment.Refunded != 0 || payment.Allocated != 1001 {
+		t.Errorf("unexpected payment %+v", payment)
+	}
+	refund, err := env.DB.GetRefund("re_1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refund.Status != "failed" || refund.Posted || refund.Allocated != 0 {
+		t.Errorf("unexpected refund %+v", refund)
+	}
+
+	// the whole payment can still be refunded
+	if err := svc.CreateRefund("re_2", "pi_1", date, "succeeded", 1001, "usd"); err != nil {
+		t.Fatalf("CreateRefund: %v", err)
+	}
+	if b := balance(t, svc, "general") + balance(t, svc, "community"); b != 0 {
+		t.Errorf("want no balance left got %d", b)
+	}
+}
+
+func TestCreateRefundUnknownPayment(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+
+	err := env.Service.CreateRefund("re_1", "pi_missing", testutil.MakeDateUnix(2025, 3, 5), "succeeded", 100, "usd")
+	if !errors.Is(err, service.ErrPaymentNotFound) {
+		t.Errorf("want ErrPaymentNotFound got %v", err)
+	}
+}
+
+func TestCreateRefundPendingPayment(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	svc := env.Service
+	if err := svc.SetFeeSettings(service.FeeSettings{Mode: service.FeeModeNet}); err != nil {
+		t.Fatal(err)
+	}
+	ts := testutil.MakeDateUnix(2025, 3, 2)
+	err := svc.CreatePayment("pi_1", ts, "succeeded", "cus_1", 1000, "usd")
+	if !errors.Is(err, service.ErrFeePending) {
+		t.Fatalf("want ErrFeePending got %v", err)
+	}
+
+	// the refund waits until the payment has been allocated
+	date := testutil.MakeDateUnix(2025, 3, 5)
+	err = svc.CreateRefund("re_1", "pi_1", date, "succeeded", 1000, "usd")
+	if !errors.Is(err, service.ErrFeePending) {
+		t.Fatalf("want ErrFeePending got %v", err)
+	}
+	if _, err := env.DB.GetRefund("re_1"); !errors.Is(err, service.ErrRefundNotFound) {
+		t.Errorf("want ErrRefundNotFound got %v", err)
+	}
+
+	fee := &service.PaymentFee{Amount: 30, BalanceTransaction: "txn_1"}
+	if err := svc.CreatePaymentWithFee("pi_1", ts, "succeeded", "cus_1", 1000, "usd", "ch_pi_1", service.PaymentContext{}, fee); err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.CreateRefund("re_1", "pi_1", date, "succeeded", 1000, "usd"); err != nil {
+		t.Fatal(err)
+	}
+	if b := balance(t, svc, "general"); b != 0 {
+		t.Errorf("want general balance 0 got %d", b)
+	}
+}
+
+func TestReallocateRefundedPayment(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	svc := env.Service
+	seedRefundPayment(t, env)
+
+	if err := svc.CreateRefund("re_1", "pi_1", testutil.MakeDateUnix(2025, 3, 5), "succeeded", 500, "usd"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the rest of the payment is re-split evenly
+	rules := []service.AllocationRule{
+		{ID: "g", LedgerName: "general", Percentage: 50},
+		{ID: "c", LedgerName: "community", Percentage: 50},
+	}
+	testutil.ScheduleAllocations(t, env, rules, testutil.MakeDate(2025, 4, 1))
+	sets, err := svc.GetAllocationHistory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.ReallocatePayments(sets[0].ID, testutil.MakeDate(2025, 3, 1), testutil.MakeDate(2025, 4, 1), false); err != nil {
+		t.Fatalf("ReallocatePayments: %v", err)
+	}
+	if b := balance(t, svc, "general"); b != 251 {
+		t.Errorf("want general balance 251 got %d", b)
+	}
+	if b := balance(t, svc, "community"); b != 250 {
+		t.Errorf("want community balance 250 got %d", b)
+	}
+}
+
+func TestProcessChargeRefunds(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	svc := env.Service
+	seedRefundPayment(t, env)
+	stripe := env.Stripe
+	stripe.AddList("/v1/refunds", map[string]any{
+		"id":             "re_1",
+		"object":         "refund",
+		"amount":         1001,
+		"currency":       "usd",
+		"created":        testutil.MakeDateUnix(2025, 3, 5),
+		"status":         "succeeded",
+		"charge":         "ch_1",
+		"payment_intent": "pi_1",
+	})
+
+	svc.HandleStripeResource("refunds", "ch_1")
+
+	payment, err := svc.GetPayment("pi_1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payment.Status != "refunded" || payment.Refunded != 1001 {
+		t.Errorf("unexpected payment %+v", payment)
+	}
+	if b := balance(t, svc, "general"); b != 0 {
+		t.Errorf("want general balance 0 got %d", b)
+	}
+}
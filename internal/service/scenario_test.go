@@ -0,0 +1,112 @@
+package service_test
+
+import (
+	"testing"
+	"time"
+
+	"git.sr.ht/~jakintosh/coffer/internal/service"
+	"git.sr.ht/~jakintosh/coffer/internal/testutil"
+)
+
+// replayScenario serves a captured scenario from the fake Stripe API,
+// delivers its events to the webhook, and waits until every handled event
+// has been processed
+func replayScenario(
+	t *testing.T,
+	env *testutil.TestEnv,
+	name string,
+) {
+	t.Helper()
+
+	scenario := testutil.LoadScenario(t, name)
+	env.Stripe.AddScenario(scenario)
+	testutil.ReplayScenario(t, env.Service.BuildRouter(), scenario)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		pending, _, err := env.Service.QueryStripeEvents(service.EventPending, nil, 100, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(pending) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("events still pending %+v", pending)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	failed, _, err := env.Service.QueryStripeEvents(service.EventFailed, nil, 100, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(failed) > 0 {
+		t.Fatalf("events failed %+v", failed)
+	}
+}
+
+func TestScenarioPaymentSucceeded(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	replayScenario(t, env, "payment_succeeded")
+
+	payment, err := env.Service.GetPayment("pi_3SjunEIogVKKdox91cKzo1xi")
+	if err != nil {
+		t.Fatalf("failed to get payment: %v", err)
+	}
+	if payment.Status != "succeeded" || payment.Amount != 2000 || payment.Allocated != 2000 {
+		t.Errorf("unexpected payment %+v", payment)
+	}
+	if b := balance(t, env.Service, "general"); b != 2000 {
+		t.Errorf("want general balance 2000 got %d", b)
+	}
+}
+
+func TestScenarioCheckoutCompleted(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	replayScenario(t, env, "checkout_completed")
+
+	payment, err := env.Service.GetPayment("pi_3SjumNIogVKKdox92gnIhqEs")
+	if err != nil {
+		t.Fatalf("failed to get payment: %v", err)
+	}
+	if payment.Amount != 3000 {
+		t.Errorf("unexpected payment %+v", payment)
+	}
+	if b := balance(t, env.Service, "general"); b != 3000 {
+		t.Errorf("want general balance 3000 got %d", b)
+	}
+}
+
+func TestScenarioSubscriptionCreated(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	replayScenario(t, env, "subscription_created")
+
+	metrics, err := env.Service.GetMetrics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metrics.PatronsActive != 1 || metrics.MRRCents != 1500 {
+		t.Errorf("unexpected metrics %+v", metrics)
+	}
+	if b := balance(t, env.Service, "general"); b != 1500 {
+		t.Errorf("want general balance 1500 got %d", b)
+	}
+}
+
+func TestScenarioSubscriptionDeleted(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	replayScenario(t, env, "subscription_deleted")
+
+	metrics, err := env.Service.GetMetrics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metrics.PatronsActive != 0 {
+		t.Errorf("unexpected metrics %+v", metrics)
+	}
+}
@@ -0,0 +1,110 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Allocation set selector kinds. A selector is written "kind:value", for
+// example "amount:5000", "price:price_123", "product:prod_123" or
+// "metadata:tier=sponsor". The empty selector is the default set.
+const (
+	SelectorAmount   = "amount"
+	SelectorPrice    = "price"
+	SelectorProduct  = "product"
+	SelectorMetadata = "metadata"
+)
+
+// PaymentContext describes what a payment was for. It picks the allocation
+// set used to split the payment.
+type PaymentContext struct {
+	PriceID   string            `json:"price_id,omitempty"`
+	ProductID string            `json:"product_id,omitempty"`
+	Metadata  map[string]string `json:"metadata,omitempty"`
+}
+
+// validateSelector returns ErrInvalidSelector unless selector is empty or a
+// well-formed "kind:value" selector.
+func validateSelector(
+	selector string,
+) error {
+	if selector == "" {
+		return nil
+	}
+
+	kind, value, ok := strings.Cut(selector, ":")
+	if !ok || value == "" {
+		return ErrInvalidSelector
+	}
+	switch kind {
+	case SelectorAmount:
+		if amount, err := strconv.Atoi(value); err != nil || amount <= 0 {
+			return ErrInvalidSelector
+		}
+	case SelectorPrice, SelectorProduct:
+	case SelectorMetadata:
+		if key, _, ok := strings.Cut(value, "="); !ok || key == "" {
+			return ErrInvalidSelector
+		}
+	default:
+		return ErrInvalidSelector
+	}
+	return nil
+}
+
+// selectors lists the selectors that match a payment, most specific first:
+// checkout metadata, then price, product and amount, then the default set.
+func (c PaymentContext) selectors(
+	amount int64,
+) []string {
+	keys := make([]string, 0, len(c.Metadata))
+	for k := range c.Metadata {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var selectors []string
+	for _, k := range keys {
+		selectors = append(selectors, fmt.Sprintf("%s:%s=%s", SelectorMetadata, k, c.Metadata[k]))
+	}
+	if c.PriceID != "" {
+		selectors = append(selectors, SelectorPrice+":"+c.PriceID)
+	}
+	if c.ProductID != "" {
+		selectors = append(selectors, SelectorProduct+":"+c.ProductID)
+	}
+	if amount > 0 {
+		selectors = append(selectors, fmt.Sprintf("%s:%d", SelectorAmount, amount))
+	}
+	return append(selectors, "")
+}
+
+// SelectAllocationSet returns the allocation set for a payment of amount
+// created at t: the first matching selector with a non-empty set in effect.
+// An empty set clears a selector, so matching falls through to the next.
+func (s *Service) SelectAllocationSet(
+	pctx PaymentContext,
+	amount int64,
+	t time.Time,
+) (
+	*AllocationSet,
+	error,
+) {
+	for _, selector := range pctx.selectors(amount) {
+		set, err := s.GetAllocationSet(selector, t)
+		if errors.Is(err, ErrAllocNotFound) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if len(set.Rules) > 0 {
+			return set, nil
+		}
+	}
+
+	return nil, ErrAllocNotFound
+}
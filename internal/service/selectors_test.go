@@ -0,0 +1,59 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestValidateSelector(t *testing.T) {
+
+	valid := []string{
+		"",
+		"amount:500",
+		"price:price_123",
+		"product:prod_123",
+		"metadata:tier=sponsor",
+		"metadata:tier=",
+	}
+	for _, selector := range valid {
+		if err := validateSelector(selector); err != nil {
+			t.Errorf("expected %q to be valid, got %v", selector, err)
+		}
+	}
+
+	invalid := []string{
+		"default",
+		"amount:",
+		"amount:0",
+		"amount:5.00",
+		"price:",
+		"metadata:tier",
+		"metadata:=sponsor",
+		"tier:gold",
+	}
+	for _, selector := range invalid {
+		if err := validateSelector(selector); err != ErrInvalidSelector {
+			t.Errorf("expected %q to be invalid, got %v", selector, err)
+		}
+	}
+}
+
+func TestPaymentContextSelectors(t *testing.T) {
+
+	pctx := PaymentContext{
+		PriceID:   "price_1",
+		ProductID: "prod_1",
+		Metadata:  map[string]string{"tier": "sponsor", "campaign": "meetup"},
+	}
+	want := []string{
+		"metadata:campaign=meetup",
+		"metadata:tier=sponsor",
+		"price:price_1",
+		"product:prod_1",
+		"amount:5000",
+		"",
+	}
+	if got := pctx.selectors(5000); !reflect.DeepEqual(got, want) {
+		t.Errorf("want %q got %q", want, got)
+	}
+}
@@ -4,15 +4,50 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"sync"
 	"time"
 
 	"git.sr.ht/~jakintosh/coffer/pkg/cors"
 	"git.sr.ht/~jakintosh/coffer/pkg/keys"
+	"git.sr.ht/~jakintosh/coffer/pkg/wire"
 )
 
 var (
-	ErrInvalidAlloc = errors.New("invalid allocation percentages")
-	ErrInvalidDate  = errors.New("invalid date format")
+	ErrInvalidAlloc         = errors.New("invalid allocation rules")
+	ErrInvalidSelector      = errors.New("invalid allocation selector")
+	ErrInvalidFees          = errors.New("invalid fee settings")
+	ErrAllocNotFound        = errors.New("allocation set not found")
+	ErrInvalidDate          = errors.New("invalid date format")
+	ErrInvalidFilter        = errors.New("invalid transaction filter")
+	ErrInvalidFormat        = errors.New("invalid export format")
+	ErrInvalidImport        = errors.New("invalid import")
+	ErrInvalidInterval      = errors.New("invalid history interval")
+	ErrInvalidTransfer      = errors.New("invalid transfer")
+	ErrInvalidReversal      = errors.New("transaction cannot be reversed")
+	ErrInvalidLedger        = errors.New("invalid ledger name")
+	ErrLedgerNotFound       = errors.New("ledger not found")
+	ErrLedgerExists         = errors.New("ledger already exists")
+	ErrLedgerArchived       = errors.New("ledger is archived")
+	ErrLedgerInUse          = errors.New("ledger is in use")
+	ErrInvalidGoal          = errors.New("invalid goal")
+	ErrGoalNotFound         = errors.New("goal not found")
+	ErrGoalExists           = errors.New("goal already exists")
+	ErrTxNotFound           = errors.New("transaction not found")
+	ErrTxConflict           = errors.New("transaction conflicts with existing record")
+	ErrPaymentNotFound      = errors.New("payment not found")
+	ErrFeePending           = errors.New("payment fee not known yet")
+	ErrRefundNotFound       = errors.New("refund not found")
+	ErrInvalidRefund        = errors.New("refund exceeds payment")
+	ErrPaymentDisputed      = errors.New("payment is disputed")
+	ErrDisputeNotFound      = errors.New("dispute not found")
+	ErrEventNotFound        = errors.New("stripe event not found")
+	ErrEventIgnored         = errors.New("stripe event not handled")
+	ErrInvalidStatus        = errors.New("invalid event status")
+	ErrSyncRunning          = errors.New("stripe sync already running")
+	ErrInvalidRange         = errors.New("invalid reconciliation range")
+	ErrPatronNotFound       = errors.New("patron not found")
+	ErrSubscriptionNotFound = errors.New("subscription not found")
+	ErrInvoiceNotFound      = errors.New("invoice not found")
 
 	ErrNoStripeProcessor = errors.New("stripe processor not configured")
 )
@@ -25,30 +60,97 @@ func (e DatabaseError) Unwrap() error { return e.Err }
 // Store defines persistence for the coffer domain.
 type Store interface {
 	// Allocations
-	GetAllocations() ([]AllocationRule, error)
-	SetAllocations([]AllocationRule) error
+	GetAllocations(at int64) ([]AllocationRule, error)
+	GetAllocationSet(selector string, at int64) (*AllocationSet, error)
+	GetAllocationSetByID(id int64) (*AllocationSet, error)
+	GetAllocationHistory() ([]AllocationSet, error)
+	SetAllocations(selector string, rules []AllocationRule, effectiveFrom int64, created int64) error
+
+	// Disputes
+	GetDispute(id string) (*Dispute, error)
+	GetDisputes(all bool) ([]Dispute, error)
+	GetDisputeSummary() (*DisputeSummary, error)
+	InsertDispute(d Dispute, post DisputePosting, date int64) error
+
+	// Events
+	GetStripeEvent(id string) (*StripeEvent, error)
+	GetStripeEvents(status string, after *wire.Cursor, limit, offset int) ([]StripeEvent, error)
+	InsertStripeEvent(e StripeEvent) error
+	UpdateStripeEvent(e StripeEvent) error
+	GetPendingStripeEvents(resourceType, resourceID string) ([]StripeEvent, error)
+	ClaimStripeEvents(due, until int64) ([]StripeEvent, error)
+
+	// Fees
+	GetFeeSettings() (*FeeSettings, error)
+	SetFeeSettings(f FeeSettings) error
+
+	// Goals
+	GetGoal(id string) (*Goal, error)
+	GetGoals() ([]Goal, error)
+	InsertGoal(g Goal) error
+	UpdateGoal(g Goal) error
+	DeleteGoal(id string) error
+
+	// Invoices
+	GetInvoice(id string) (*Invoice, error)
+	InsertInvoice(inv Invoice) error
 
 	// Ledger
+	ExportTransactions(ledger string, since, until int64) ([]Transaction, *LedgerSnapshot, error)
+	GetLedgers() ([]LedgerSummary, error)
+	GetLedgerInfo(name string) (*Ledger, error)
+	GetLedgerInfos() ([]Ledger, error)
+	InsertLedger(l Ledger) error
+	UpdateLedger(l Ledger) error
+	DeleteLedger(name string) error
 	GetLedgerSnapshot(ledger string, since, until int64) (*LedgerSnapshot, error)
-	GetTransactions(ledger string, limit, offset int) ([]Transaction, error)
+	GetLedgerFlows(ledger, interval string, since, until int64) ([]HistoryBucket, error)
+	GetTransaction(id string) (*Transaction, error)
+	GetTransactions(ledger string, filter TransactionFilter, after *wire.Cursor, limit, offset int) ([]Transaction, error)
 	InsertTransaction(id string, ledger string, amount int, date int64, label string) error
+	InsertTransactions(txs []Transaction) error
+	InsertReallocation(setID int64, since, until int64, payments []PaymentReallocation, reshare PaymentReshare, created int64) (int64, error)
+	InsertReversal(id string, original string, date int64, label string) error
+	InsertTransfer(id string, from string, to string, amount int, date int64, label string) error
+	GetTransfer(id string) (*Transfer, error)
+	InsertTransferReversal(id string, date int64, label string) error
 
 	// Metrics
 	GetSubscriptionSummary() (*SubscriptionSummary, error)
 
 	// Patrons
-	GetCustomers(limit, offset int) ([]Patron, error)
+	GetCustomer(id string) (*Patron, error)
+	GetCustomers(after *wire.Cursor, limit, offset int) ([]Patron, error)
+	GetSubscription(id string) (*Subscription, error)
+	GetSubscriptionTimelines(customer string) ([]SubscriptionTimeline, error)
+
+	// Reconciliation
+	InsertBalanceTransactions(payout string, txs []BalanceTransaction) error
+	GetPayoutTotals(since, until int64) ([]PayoutTotal, error)
+	GetPayoutPayments(since, until int64) ([]PayoutPayment, error)
+	GetUnmatchedEntries(since, until int64) ([]Transaction, error)
 
 	// Stripe sync
+	GetStripeSync() (*StripeSync, error)
+	SetStripeSync(sync StripeSync) error
 	InsertCustomer(id string, created int64, publicName *string) error
-	InsertSubscription(id string, created int64, customer string, status string, amount int64, currency string) error
-	InsertPayment(id string, created int64, status string, customer string, amount int64, currency string) error
+	InsertSubscription(sub Subscription, changed int64, diff SubscriptionDiff) error
+	GetPayment(id string) (*Payment, error)
+	GetPaymentAllocation(id string) (*PaymentAllocation, error)
+	GetPaymentAllocations(since, until int64) ([]PaymentAllocation, error)
+	InsertPayment(p Payment, shares []Allocation, feeLedger string) error
 	InsertPayout(id string, created int64, status string, amount int64, currency string) error
+	GetRefund(id string) (*Refund, error)
+	InsertRefund(r Refund, reverse RefundReversal, date int64) error
 }
 
 type Middleware struct {
 	CORS func(http.HandlerFunc) http.HandlerFunc
 	Auth func(http.HandlerFunc) http.HandlerFunc
+
+	// Authorized reports whether a request is authenticated without
+	// rejecting it
+	Authorized func(*http.Request) bool
 }
 
 type Options struct {
@@ -59,6 +161,9 @@ type Options struct {
 	KeysOptions *keys.Options
 	CORSOptions *cors.Options
 
+	// Currency code of ledger amounts, defaults to "usd"
+	Currency string
+
 	// Optional dependencies
 	Clock                  func() time.Time
 	HealthCheck            func() error
@@ -71,8 +176,14 @@ type Service struct {
 	cors  *cors.Service
 
 	stripeProcessor *StripeProcessor
+	currency        string
 	clock           func() time.Time
 	healthCheck     func() error
+
+	done     chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+	syncMu   sync.Mutex
 }
 
 func New(opts Options) (*Service, error) {
@@ -96,6 +207,11 @@ func New(opts Options) (*Service, error) {
 		return nil, err
 	}
 
+	currency := opts.Currency
+	if currency == "" {
+		currency = "usd"
+	}
+
 	clock := opts.Clock
 	if clock == nil {
 		clock = time.Now
@@ -111,8 +227,10 @@ func New(opts Options) (*Service, error) {
 		keys:            keysSvc,
 		cors:            corsSvc,
 		stripeProcessor: stripeProcessor,
+		currency:        currency,
 		clock:           clock,
 		healthCheck:     opts.HealthCheck,
+		done:            make(chan struct{}),
 	}
 
 	return svc, nil
@@ -120,7 +238,7 @@ func New(opts Options) (*Service, error) {
 
 func (s *Service) consumeStripeEvents() {
 	for event := range s.stripeProcessor.Events {
-		s.HandleStripeResource(event.Type, event.ID)
+		s.processStripeResource(event)
 	}
 }
 
@@ -141,17 +259,21 @@ func (s *Service) KeysService() *keys.Service {
 
 func (s *Service) BuildRouter() http.Handler {
 	mw := Middleware{
-		CORS: s.cors.WithCORS,
-		Auth: s.keys.WithAuth,
+		CORS:       s.cors.WithCORS,
+		Auth:       s.keys.WithAuth,
+		Authorized: s.keys.Authorized,
 	}
 
 	mux := http.NewServeMux()
+	s.buildDisputesRouter(mux, mw)
+	s.buildGoalsRouter(mux, mw)
 	s.buildHealthRouter(mux)
 	s.buildLedgerRouter(mux, mw)
 	s.buildMetricsRouter(mux, mw)
 	s.buildPatronsRouter(mux, mw)
+	s.buildReconciliationRouter(mux, mw)
 	s.buildSettingsRouter(mux, mw)
-	s.buildStripeRouter(mux)
+	s.buildStripeRouter(mux, mw)
 	return mux
 }
 
@@ -159,6 +281,9 @@ func (s *Service) Start() {
 	if s.stripeProcessor != nil {
 		s.stripeProcessor.Start()
 		go s.consumeStripeEvents()
+
+		s.wg.Add(1)
+		go s.retryStripeEvents()
 	}
 }
 
@@ -170,6 +295,8 @@ func (s *Service) Serve(addr string) error {
 
 func (s *Service) Stop() {
 	if s.stripeProcessor != nil {
+		s.stopOnce.Do(func() { close(s.done) })
+		s.wg.Wait()
 		s.stripeProcessor.Stop()
 	}
 }
@@ -7,6 +7,8 @@ func (s *Service) buildSettingsRouter(
 	mw Middleware,
 ) {
 	s.buildAllocationsRouter(mux, mw)
+	s.buildFeesRouter(mux, mw)
+	s.buildLedgersRouter(mux, mw)
 	s.cors.Router(mux, "/settings", mw.Auth)
 	s.keys.Router(mux, "/settings", mw.Auth)
 }
@@ -1,8 +1,9 @@
 package service
 
 import (
+	"context"
 	"encoding/json"
-	"fmt"
+	"errors"
 	"io"
 	"log"
 	"net/http"
@@ -10,10 +11,6 @@ import (
 	"time"
 
 	stripe "github.com/stripe/stripe-go/v82"
-	"github.com/stripe/stripe-go/v82/checkout/session"
-	"github.com/stripe/stripe-go/v82/paymentintent"
-	"github.com/stripe/stripe-go/v82/payout"
-	"github.com/stripe/stripe-go/v82/subscription"
 	"github.com/stripe/stripe-go/v82/webhook"
 )
 
@@ -26,6 +23,9 @@ type StripeProcessor struct {
 	EndpointSecret string
 	TestMode       bool
 	DebounceWindow time.Duration
+	RetryInterval  time.Duration
+	MaxAttempts    int
+	Client         *stripe.Client
 	Events         chan ResourceEvent
 
 	requests chan ResourceEvent
@@ -35,18 +35,48 @@ type StripeProcessor struct {
 }
 
 type StripeProcessorOptions struct {
-	Key            string
+	// Client makes every Stripe API request. When nil, a client is built
+	// with Key that sends requests to BaseURL, or to Stripe if it is empty.
+	Client  *stripe.Client
+	Key     string
+	BaseURL string
+
 	EndpointSecret string
 	TestMode       bool
 	DebounceWindow time.Duration
+
+	// Retry policy for events that fail to process, defaults to
+	// DefaultRetryInterval and DefaultMaxAttempts
+	RetryInterval time.Duration
+	MaxAttempts   int
 }
 
 func NewStripeProcessor(opts StripeProcessorOptions) *StripeProcessor {
-	stripe.Key = opts.Key
+	client := opts.Client
+	if client == nil {
+		var backends *stripe.Backends
+		if opts.BaseURL != "" {
+			backends = stripe.NewBackendsWithConfig(&stripe.BackendConfig{
+				URL: stripe.String(opts.BaseURL),
+			})
+		}
+		client = stripe.NewClient(opts.Key, stripe.WithBackends(backends))
+	}
+	retryInterval := opts.RetryInterval
+	if retryInterval <= 0 {
+		retryInterval = DefaultRetryInterval
+	}
+	maxAttempts := opts.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = DefaultMaxAttempts
+	}
 	return &StripeProcessor{
 		EndpointSecret: opts.EndpointSecret,
 		TestMode:       opts.TestMode,
 		DebounceWindow: opts.DebounceWindow,
+		RetryInterval:  retryInterval,
+		MaxAttempts:    maxAttempts,
+		Client:         client,
 		Events:         make(chan ResourceEvent),
 		requests:       make(chan ResourceEvent, 8),
 		done:           make(chan struct{}),
@@ -92,20 +122,22 @@ func (p *StripeProcessor) ParseEvent(
 	return webhook.ConstructEvent(payload, sig, p.EndpointSecret)
 }
 
-func (p *StripeProcessor) processEvent(
+// stripeResource maps an event to the resource it refers to. Events of
+// types that are not handled map to no resource.
+func stripeResource(
 	event stripe.Event,
-) error {
-	if p == nil {
-		return ErrNoStripeProcessor
-	}
-	log.Printf("<-  event %s %s", event.ID, event.Type)
+) (
+	ResourceEvent,
+	bool,
+	error,
+) {
 	var req ResourceEvent
 	switch event.Type {
 	case "checkout.session.completed":
 		var s stripe.CheckoutSession
 		if err := json.Unmarshal(event.Data.Raw, &s); err != nil {
 			log.Printf("parse checkout.session event: %v", err)
-			return err
+			return req, false, err
 		}
 		req = ResourceEvent{"checkout", s.ID}
 
@@ -118,7 +150,7 @@ func (p *StripeProcessor) processEvent(
 		var s stripe.Subscription
 		if err := json.Unmarshal(event.Data.Raw, &s); err != nil {
 			log.Printf("parse subscription event: %v", err)
-			return err
+			return req, false, err
 		}
 		req = ResourceEvent{"subscription", s.ID}
 
@@ -126,21 +158,87 @@ func (p *StripeProcessor) processEvent(
 		var pmt stripe.PaymentIntent
 		if err := json.Unmarshal(event.Data.Raw, &pmt); err != nil {
 			log.Printf("parse payment event: %v", err)
-			return err
+			return req, false, err
 		}
 		req = ResourceEvent{"payment", pmt.ID}
 
+	case "charge.updated":
+		// the balance transaction, and with it the fee, is often added
+		// to a charge after its payment intent has succeeded
+		var ch stripe.Charge
+		if err := json.Unmarshal(event.Data.Raw, &ch); err != nil {
+			log.Printf("parse charge event: %v", err)
+			return req, false, err
+		}
+		if ch.PaymentIntent == nil {
+			return req, false, nil
+		}
+		req = ResourceEvent{"payment", ch.PaymentIntent.ID}
+
+	case "charge.refunded":
+		var ch stripe.Charge
+		if err := json.Unmarshal(event.Data.Raw, &ch); err != nil {
+			log.Printf("parse charge event: %v", err)
+			return req, false, err
+		}
+		req = ResourceEvent{"refunds", ch.ID}
+
+	case "refund.created",
+		"refund.updated",
+		"refund.failed",
+		"charge.refund.updated":
+		var r stripe.Refund
+		if err := json.Unmarshal(event.Data.Raw, &r); err != nil {
+			log.Printf("parse refund event: %v", err)
+			return req, false, err
+		}
+		req = ResourceEvent{"refund", r.ID}
+
+	case "charge.dispute.created",
+		"charge.dispute.updated",
+		"charge.dispute.funds_withdrawn",
+		"charge.dispute.funds_reinstated",
+		"charge.dispute.closed":
+		var d stripe.Dispute
+		if err := json.Unmarshal(event.Data.Raw, &d); err != nil {
+			log.Printf("parse dispute event: %v", err)
+			return req, false, err
+		}
+		req = ResourceEvent{"dispute", d.ID}
+
+	case "invoice.paid",
+		"invoice.payment_failed",
+		"invoice.voided",
+		"invoice.marked_uncollectible":
+		var inv stripe.Invoice
+		if err := json.Unmarshal(event.Data.Raw, &inv); err != nil {
+			log.Printf("parse invoice event: %v", err)
+			return req, false, err
+		}
+		req = ResourceEvent{"invoice", inv.ID}
+
 	case "payout.paid",
 		"payout.failed":
 		var pmt stripe.Payout
 		if err := json.Unmarshal(event.Data.Raw, &pmt); err != nil {
 			log.Printf("parse payout event: %v", err)
-			return err
+			return req, false, err
 		}
 		req = ResourceEvent{"payout", pmt.ID}
 
 	default:
-		return nil
+		return req, false, nil
+	}
+
+	return req, true, nil
+}
+
+// submit hands a resource to the debouncer
+func (p *StripeProcessor) submit(
+	req ResourceEvent,
+) error {
+	if p == nil {
+		return ErrNoStripeProcessor
 	}
 
 	select {
@@ -173,6 +271,17 @@ func (p *StripeProcessor) scheduleResourceUpdates() {
 	}
 }
 
+// stripeClient returns the client that makes Stripe API requests
+func (s *Service) stripeClient() (
+	*stripe.Client,
+	error,
+) {
+	if s.stripeProcessor == nil {
+		return nil, ErrNoStripeProcessor
+	}
+	return s.stripeProcessor.Client, nil
+}
+
 func (s *Service) ParseStripeEvent(
 	payload []byte,
 	sig string,
@@ -186,13 +295,49 @@ func (s *Service) ParseStripeEvent(
 	return s.stripeProcessor.ParseEvent(payload, sig)
 }
 
+// ProcessStripeEvent stores a received event and queues the resource it
+// refers to. Once stored, the event is processed even if the service
+// restarts before it is handled. Events of types that are not handled are
+// recorded as ignored.
 func (s *Service) ProcessStripeEvent(
 	event stripe.Event,
 ) error {
 	if s == nil || s.stripeProcessor == nil {
 		return ErrNoStripeProcessor
 	}
-	return s.stripeProcessor.processEvent(event)
+	log.Printf("<-  event %s %s", event.ID, event.Type)
+
+	req, ok, err := stripeResource(event)
+	if err != nil {
+		return err
+	}
+
+	now := s.Clock()
+	e := StripeEvent{
+		ID:           event.ID,
+		Type:         string(event.Type),
+		ResourceType: req.Type,
+		ResourceID:   req.ID,
+		Created:      time.Unix(event.Created, 0),
+		Received:     now,
+		Status:       EventPending,
+		NextAttempt:  now.Add(eventLease),
+	}
+	if !ok {
+		e.Status = EventIgnored
+		e.NextAttempt = now
+	}
+	if err := s.store.InsertStripeEvent(e); err != nil {
+		return DatabaseError{err}
+	}
+	if !ok {
+		return nil
+	}
+
+	if err := s.stripeProcessor.submit(req); err != nil {
+		log.Printf("Event %s queued for restart: %v", event.ID, err)
+	}
+	return nil
 }
 
 // HandleStripeResource is the callback target for the stripe processor
@@ -200,20 +345,34 @@ func (s *Service) HandleStripeResource(
 	eventType string,
 	resourceID string,
 ) {
-	var err error
+	if err := s.handleStripeResource(eventType, resourceID); err != nil {
+		log.Printf("Error processing %s %s: %v", eventType, resourceID, err)
+	}
+}
+
+func (s *Service) handleStripeResource(
+	eventType string,
+	resourceID string,
+) error {
 	switch eventType {
 	case "checkout":
-		err = s.processCheckoutSession(resourceID)
+		return s.processCheckoutSession(resourceID)
 	case "subscription":
-		err = s.processSubscription(resourceID)
+		return s.processSubscription(resourceID)
 	case "payment":
-		err = s.processPaymentIntent(resourceID)
+		return s.processPaymentIntent(resourceID)
+	case "refund":
+		return s.processRefund(resourceID)
+	case "refunds":
+		return s.processChargeRefunds(resourceID)
+	case "dispute":
+		return s.processDispute(resourceID)
+	case "invoice":
+		return s.processInvoice(resourceID)
 	case "payout":
-		err = s.processPayout(resourceID)
-	}
-	if err != nil {
-		log.Printf("Error processing %s %s: %v", eventType, resourceID, err)
+		return s.processPayout(resourceID)
 	}
+	return nil
 }
 
 // AddCustomer adds a customer to the database
@@ -232,7 +391,7 @@ func (s *Service) AddCustomer(
 	return nil
 }
 
-// AddSubscription adds a subscription to the database
+// AddSubscription records a subscription with no lifecycle details
 func (s *Service) AddSubscription(
 	id string,
 	created int64,
@@ -241,10 +400,27 @@ func (s *Service) AddSubscription(
 	amount int64,
 	currency string,
 ) error {
-	if err := s.store.InsertSubscription(
+	return s.RecordSubscription(Subscription{
+		ID:       id,
+		Customer: customer,
+		Created:  time.Unix(created, 0),
+		Status:   status,
+		Amount:   int(amount),
+		Currency: currency,
+	}, nil)
+}
+
+// AddPayout adds a payout to the database
+func (s *Service) AddPayout(
+	id string,
+	created int64,
+	status string,
+	amount int64,
+	currency string,
+) error {
+	if err := s.store.InsertPayout(
 		id,
 		created,
-		customer,
 		status,
 		amount,
 		currency,
@@ -254,83 +430,161 @@ func (s *Service) AddSubscription(
 	return nil
 }
 
-// AddPayout adds a payout to the database
-func (s *Service) AddPayout(
+// Payment is a Stripe payment as recorded locally. Fee is what Stripe kept
+// from the payment, and Allocated is the amount that was split across
+// ledgers with AllocationSet, less what refunds have taken back. Pending
+// payments are waiting on their fee and have not been allocated yet.
+// Refunded is the total amount refunded to the customer. Charge is the
+// charge that paid it, when known.
+type Payment struct {
+	ID                 string    `json:"id"`
+	Created            time.Time `json:"created"`
+	Status             string    `json:"status"`
+	Customer           string    `json:"customer"`
+	Amount             int       `json:"amount"`
+	Currency           string    `json:"currency"`
+	Fee                int       `json:"fee"`
+	BalanceTransaction string    `json:"balance_transaction,omitempty"`
+	Charge             string    `json:"charge,omitempty"`
+	Allocated          int       `json:"allocated"`
+	Pending            bool      `json:"pending,omitempty"`
+	AllocationSet      int64     `json:"allocation_set"`
+	Refunded           int       `json:"refunded"`
+}
+
+func (s *Service) GetPayment(
+	id string,
+) (
+	*Payment,
+	error,
+) {
+	payment, err := s.store.GetPayment(id)
+	if errors.Is(err, ErrPaymentNotFound) {
+		return nil, err
+	} else if err != nil {
+		return nil, DatabaseError{err}
+	}
+
+	return payment, nil
+}
+
+// CreatePayment records a payment and allocates it with the default rules
+func (s *Service) CreatePayment(
 	id string,
 	created int64,
 	status string,
+	customer string,
 	amount int64,
 	currency string,
 ) error {
-	if err := s.store.InsertPayout(
+	return s.CreatePaymentWithContext(
 		id,
 		created,
 		status,
+		customer,
 		amount,
 		currency,
-	); err != nil {
-		return DatabaseError{err}
-	}
-	return nil
+		PaymentContext{},
+	)
 }
 
-func (s *Service) CreatePayment(
+// CreatePaymentWithContext records a payment and allocates it with the
+// allocation set selected by its context and amount, recording which set
+// was used. The fee is treated as unknown.
+func (s *Service) CreatePaymentWithContext(
 	id string,
 	created int64,
 	status string,
 	customer string,
 	amount int64,
 	currency string,
+	pctx PaymentContext,
 ) error {
-	if err := s.store.InsertPayment(
+	return s.CreatePaymentWithFee(
 		id,
 		created,
 		status,
 		customer,
 		amount,
 		currency,
-	); err != nil {
-		return err
-	}
+		"",
+		pctx,
+		nil,
+	)
+}
 
-	rules, err := s.GetAllocations()
+// CreatePaymentWithFee records a payment, the charge that paid it and its
+// Stripe fee, and allocates it according to the fee settings. The payment and its allocation entries
+// are written together. Unless fees are ignored, a payment whose fee is not
+// known yet is recorded without being allocated and ErrFeePending is
+// returned, so that its event is retried until the fee is known.
+func (s *Service) CreatePaymentWithFee(
+	id string,
+	created int64,
+	status string,
+	customer string,
+	amount int64,
+	currency string,
+	charge string,
+	pctx PaymentContext,
+	fee *PaymentFee,
+) error {
+	fees, err := s.GetFeeSettings()
 	if err != nil {
 		return err
 	}
 
-	payment := int(amount)
-	allocated := 0
 	date := time.Unix(created, 0)
-
-	for i, r := range rules {
-
-		share := 0
-		if i == len(rules)-1 {
-			// if last rule, use remaining payment amount
-			share = payment - allocated
-		} else {
-			// otherwise, calculate share
-			share = int((amount * int64(r.Percentage)) / 100)
-			allocated += share
+	payment := Payment{
+		ID:       id,
+		Created:  date,
+		Status:   status,
+		Customer: customer,
+		Amount:   int(amount),
+		Currency: currency,
+		Charge:   charge,
+	}
+	if fee != nil {
+		payment.Fee = int(fee.Amount)
+		payment.BalanceTransaction = fee.BalanceTransaction
+	} else if fees.Mode != FeeModeGross {
+		payment.Pending = true
+		if err := s.store.InsertPayment(payment, nil, ""); err != nil {
+			return DatabaseError{err}
 		}
+		return ErrFeePending
+	}
 
-		// do not commit an empty transaction
-		if share == 0 {
-			continue
-		}
+	payment.Allocated = payment.Amount
+	if fees.Mode == FeeModeNet {
+		payment.Allocated = max(payment.Amount-payment.Fee, 0)
+	}
 
-		// create unique transaction id from payment id + ledger name
-		txID := fmt.Sprintf("%s:%s", id, r.LedgerName)
+	// allocate with the rules in effect when the payment was created
+	set, err := s.SelectAllocationSet(pctx, amount, date)
+	if errors.Is(err, ErrAllocNotFound) {
+		set = &AllocationSet{}
+	} else if err != nil {
+		return err
+	}
+	payment.AllocationSet = set.ID
 
-		if err := s.AddTransaction(
-			txID,
-			r.LedgerName,
-			share,
-			date,
-			"patron",
-		); err != nil {
-			return err
-		}
+	feeLedger := ""
+	if fees.Mode == FeeModeLedger {
+		feeLedger = fees.Ledger
+	}
+
+	// the ledgers were open when the set was saved, and a late payment
+	// is still split by it after they are archived
+	err = s.store.InsertPayment(
+		payment,
+		allocate(payment.Allocated, set.Rules),
+		feeLedger,
+	)
+	if errors.Is(err, ErrTxConflict) {
+		return err
+	} else if err != nil {
+		return DatabaseError{err}
 	}
 
 	return nil
@@ -340,8 +594,12 @@ func (s *Service) processCheckoutSession(
 	id string,
 ) error {
 	log.Printf(" -> session %s", id)
-	params := &stripe.CheckoutSessionParams{}
-	session, err := session.Get(id, params)
+	sc, err := s.stripeClient()
+	if err != nil {
+		return err
+	}
+	params := &stripe.CheckoutSessionRetrieveParams{}
+	session, err := sc.V1CheckoutSessions.Retrieve(context.Background(), id, params)
 	if err != nil {
 		if stripeErr, ok := err.(*stripe.Error); ok {
 			log.Printf("<-  session %s STRIPE ERROR: %v", id, stripeErr)
@@ -352,6 +610,16 @@ func (s *Service) processCheckoutSession(
 	}
 	log.Printf("<-  checkout session %s", id)
 
+	return s.recordCheckoutSession(session, s.Clock().Unix())
+}
+
+// recordCheckoutSession adds the customer of a checkout session with the
+// public name they entered, if any
+func (s *Service) recordCheckoutSession(
+	session *stripe.CheckoutSession,
+	created int64,
+) error {
+	id := session.ID
 	var publicName *string
 	for _, f := range session.CustomFields {
 		if f != nil && f.Key == "publicsignature" && f.Text != nil {
@@ -372,7 +640,7 @@ func (s *Service) processCheckoutSession(
 		return nil
 	}
 
-	if err = s.AddCustomer(custID, s.Clock().Unix(), publicName); err != nil {
+	if err := s.AddCustomer(custID, created, publicName); err != nil {
 		log.Printf("DB ERROR session %s: %v", id, err)
 		return err
 	}
@@ -384,8 +652,12 @@ func (s *Service) processSubscription(
 	id string,
 ) error {
 	log.Printf(" -> subscription %s", id)
-	params := &stripe.SubscriptionParams{}
-	subs, err := subscription.Get(id, params)
+	sc, err := s.stripeClient()
+	if err != nil {
+		return err
+	}
+	params := &stripe.SubscriptionRetrieveParams{}
+	subs, err := sc.V1Subscriptions.Retrieve(context.Background(), id, params)
 	if err != nil {
 		if stripeErr, ok := err.(*stripe.Error); ok {
 			log.Printf("<-  subscription %s STRIPE ERROR: %v", id, stripeErr)
@@ -396,22 +668,55 @@ func (s *Service) processSubscription(
 	}
 	log.Printf("<-  subscription %s", id)
 
-	amount := int64(0)
-	currency := ""
-	if len(subs.Items.Data) > 0 {
-		price := subs.Items.Data[0].Price
-		amount = price.UnitAmount
-		currency = string(price.Currency)
+	// the subscription is in the state of the latest event about it
+	var changed *time.Time
+	events, err := s.store.GetPendingStripeEvents("subscription", id)
+	if err != nil {
+		return DatabaseError{err}
+	}
+	for _, e := range events {
+		if changed == nil || e.Created.After(*changed) {
+			created := e.Created
+			changed = &created
+		}
 	}
 
-	if err = s.AddSubscription(
-		id,
-		subs.Created,
-		subs.Customer.ID,
-		string(subs.Status),
-		amount,
-		currency,
-	); err != nil {
+	return s.recordSubscription(subs, changed)
+}
+
+// recordSubscription records a subscription, dating any change to it at
+// changed, or when it is recorded if changed is nil
+func (s *Service) recordSubscription(
+	subs *stripe.Subscription,
+	changed *time.Time,
+) error {
+	id := subs.ID
+	sub := Subscription{
+		ID:                id,
+		Customer:          subs.Customer.ID,
+		Created:           time.Unix(subs.Created, 0),
+		Status:            string(subs.Status),
+		CancelAtPeriodEnd: subs.CancelAtPeriodEnd,
+		CancelAt:          unixTime(subs.CancelAt),
+		CanceledAt:        unixTime(subs.CanceledAt),
+		Paused:            subs.Status == stripe.SubscriptionStatusPaused,
+	}
+	if subs.PauseCollection != nil {
+		sub.Paused = true
+		sub.ResumesAt = unixTime(subs.PauseCollection.ResumesAt)
+	}
+
+	if subs.Items != nil && len(subs.Items.Data) > 0 {
+		item := subs.Items.Data[0]
+		sub.Amount = int(item.Price.UnitAmount)
+		sub.Currency = string(item.Price.Currency)
+		sub.CurrentPeriodEnd = unixTime(item.CurrentPeriodEnd)
+	}
+	if sub.CancelAt == nil && sub.CancelAtPeriodEnd {
+		sub.CancelAt = sub.CurrentPeriodEnd
+	}
+
+	if err := s.RecordSubscription(sub, changed); err != nil {
 		log.Printf("DB ERROR subscription %s: %v", id, err)
 		return err
 	}
@@ -423,8 +728,13 @@ func (s *Service) processPaymentIntent(
 	id string,
 ) error {
 	log.Printf(" -> payment intent %s", id)
-	params := &stripe.PaymentIntentParams{}
-	intent, err := paymentintent.Get(id, params)
+	sc, err := s.stripeClient()
+	if err != nil {
+		return err
+	}
+	params := &stripe.PaymentIntentRetrieveParams{}
+	params.AddExpand("latest_charge.balance_transaction")
+	intent, err := sc.V1PaymentIntents.Retrieve(context.Background(), id, params)
 	if err != nil {
 		if stripeErr, ok := err.(*stripe.Error); ok {
 			log.Printf("<-  payment intent %s STRIPE ERROR: %v", id, stripeErr)
@@ -435,20 +745,55 @@ func (s *Service) processPaymentIntent(
 	}
 	log.Printf("<-  payment intent %s", id)
 
+	return s.recordPaymentIntent(intent)
+}
+
+// recordPaymentIntent records a payment intent with its context and fee
+func (s *Service) recordPaymentIntent(
+	intent *stripe.PaymentIntent,
+) error {
+	id := intent.ID
 	cust := "N/A"
 	if intent.Customer != nil {
 		cust = intent.Customer.ID
 	}
 
-	err = s.CreatePayment(
+	pctx, err := s.paymentContext(intent)
+	if err != nil {
+		log.Printf("<-  payment intent %s checkout ERROR: %v", id, err)
+		return err
+	}
+
+	// the fee is known once the charge has a balance transaction
+	var (
+		charge string
+		fee    *PaymentFee
+	)
+	if c := intent.LatestCharge; c != nil {
+		charge = c.ID
+		if c.BalanceTransaction != nil {
+			fee = &PaymentFee{
+				Amount:             c.BalanceTransaction.Fee,
+				BalanceTransaction: c.BalanceTransaction.ID,
+			}
+		}
+	}
+
+	err = s.CreatePaymentWithFee(
 		id,
 		intent.Created,
 		string(intent.Status),
 		cust,
 		intent.Amount,
 		string(intent.Currency),
+		charge,
+		pctx,
+		fee,
 	)
-	if err != nil {
+	if errors.Is(err, ErrFeePending) {
+		log.Printf("[!] payment intent %s waiting on its fee", id)
+		return err
+	} else if err != nil {
 		log.Printf("DB ERROR payment intent %s: %v", id, err)
 		return err
 	}
@@ -456,12 +801,210 @@ func (s *Service) processPaymentIntent(
 	return nil
 }
 
+// paymentContext collects the metadata, price and product of a payment
+// from the payment intent and the checkout session that created it or the
+// invoice it paid, if any. Payment intent metadata takes precedence over
+// session metadata, which takes precedence over invoice metadata.
+func (s *Service) paymentContext(
+	intent *stripe.PaymentIntent,
+) (
+	PaymentContext,
+	error,
+) {
+	sc, err := s.stripeClient()
+	if err != nil {
+		return PaymentContext{}, err
+	}
+	pctx := PaymentContext{Metadata: map[string]string{}}
+
+	// renewals are paid through an invoice of their subscription
+	ipParams := &stripe.InvoicePaymentListParams{
+		Payment: &stripe.InvoicePaymentListPaymentParams{
+			Type:          stripe.String("payment_intent"),
+			PaymentIntent: stripe.String(intent.ID),
+		},
+	}
+	ipParams.AddExpand("data.invoice")
+	for ip, err := range sc.V1InvoicePayments.List(context.Background(), ipParams) {
+		if err != nil {
+			return PaymentContext{}, err
+		}
+		if ip.Invoice != nil {
+			pctx = invoiceContext(ip.Invoice)
+		}
+		break
+	}
+
+	params := &stripe.CheckoutSessionListParams{
+		PaymentIntent: stripe.String(intent.ID),
+	}
+	params.AddExpand("data.line_items")
+	for sess, err := range sc.V1CheckoutSessions.List(context.Background(), params) {
+		if err != nil {
+			return PaymentContext{}, err
+		}
+		for k, v := range sess.Metadata {
+			pctx.Metadata[k] = v
+		}
+		if sess.LineItems != nil && len(sess.LineItems.Data) > 0 {
+			if price := sess.LineItems.Data[0].Price; price != nil {
+				pctx.PriceID = price.ID
+				if price.Product != nil {
+					pctx.ProductID = price.Product.ID
+				}
+			}
+		}
+		break
+	}
+
+	for k, v := range intent.Metadata {
+		pctx.Metadata[k] = v
+	}
+	return pctx, nil
+}
+
+func (s *Service) processRefund(
+	id string,
+) error {
+	log.Printf(" -> refund %s", id)
+	sc, err := s.stripeClient()
+	if err != nil {
+		return err
+	}
+	params := &stripe.RefundRetrieveParams{}
+	r, err := sc.V1Refunds.Retrieve(context.Background(), id, params)
+	if err != nil {
+		if stripeErr, ok := err.(*stripe.Error); ok {
+			log.Printf("<-  refund %s STRIPE ERROR: %v", id, stripeErr)
+		} else {
+			log.Printf("<-  refund %s ERROR: %v", id, err)
+		}
+		return err
+	}
+	log.Printf("<-  refund %s", id)
+
+	return s.recordRefund(r)
+}
+
+// processChargeRefunds records every refund of a charge
+func (s *Service) processChargeRefunds(
+	chargeID string,
+) error {
+	log.Printf(" -> refunds of charge %s", chargeID)
+	sc, err := s.stripeClient()
+	if err != nil {
+		return err
+	}
+	params := &stripe.RefundListParams{
+		Charge: stripe.String(chargeID),
+	}
+	for r, err := range sc.V1Refunds.List(context.Background(), params) {
+		if err != nil {
+			log.Printf("<-  refunds of charge %s ERROR: %v", chargeID, err)
+			return err
+		}
+		if err := s.recordRefund(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Service) recordRefund(
+	r *stripe.Refund,
+) error {
+	if r.PaymentIntent == nil {
+		log.Printf("[!] refund %s missing payment intent", r.ID)
+		return nil
+	}
+
+	err := s.CreateRefund(
+		r.ID,
+		r.PaymentIntent.ID,
+		r.Created,
+		string(r.Status),
+		r.Amount,
+		string(r.Currency),
+	)
+	if errors.Is(err, ErrFeePending) {
+		log.Printf("[!] refund %s waiting on payment %s", r.ID, r.PaymentIntent.ID)
+		return err
+	} else if err != nil {
+		log.Printf("DB ERROR refund %s: %v", r.ID, err)
+		return err
+	}
+	log.Printf("OK refund %s", r.ID)
+	return nil
+}
+
+func (s *Service) processDispute(
+	id string,
+) error {
+	log.Printf(" -> dispute %s", id)
+	sc, err := s.stripeClient()
+	if err != nil {
+		return err
+	}
+	params := &stripe.DisputeRetrieveParams{}
+	params.AddExpand("charge")
+	d, err := sc.V1Disputes.Retrieve(context.Background(), id, params)
+	if err != nil {
+		if stripeErr, ok := err.(*stripe.Error); ok {
+			log.Printf("<-  dispute %s STRIPE ERROR: %v", id, stripeErr)
+		} else {
+			log.Printf("<-  dispute %s ERROR: %v", id, err)
+		}
+		return err
+	}
+	log.Printf("<-  dispute %s", id)
+
+	paymentID := ""
+	if d.PaymentIntent != nil {
+		paymentID = d.PaymentIntent.ID
+	} else if d.Charge != nil && d.Charge.PaymentIntent != nil {
+		paymentID = d.Charge.PaymentIntent.ID
+	}
+	if paymentID == "" {
+		log.Printf("[!] dispute %s missing payment intent", id)
+		return nil
+	}
+
+	// the fee is spread over the balance transactions of the dispute
+	var fee int64
+	for _, bt := range d.BalanceTransactions {
+		if bt != nil {
+			fee += bt.Fee
+		}
+	}
+
+	err = s.CreateDispute(
+		id,
+		paymentID,
+		d.Created,
+		string(d.Status),
+		string(d.Reason),
+		d.Amount,
+		fee,
+		string(d.Currency),
+	)
+	if err != nil {
+		log.Printf("DB ERROR dispute %s: %v", id, err)
+		return err
+	}
+	log.Printf("OK dispute %s", id)
+	return nil
+}
+
 func (s *Service) processPayout(
 	id string,
 ) error {
 	log.Printf(" -> payout %s", id)
-	params := &stripe.PayoutParams{}
-	p, err := payout.Get(id, params)
+	sc, err := s.stripeClient()
+	if err != nil {
+		return err
+	}
+	params := &stripe.PayoutRetrieveParams{}
+	p, err := sc.V1Payouts.Retrieve(context.Background(), id, params)
 	if err != nil {
 		if stripeErr, ok := err.(*stripe.Error); ok {
 			log.Printf("<-  payout %s STRIPE ERROR: %v", id, stripeErr)
@@ -472,14 +1015,85 @@ func (s *Service) processPayout(
 	}
 	log.Printf("<-  payout %s", id)
 
-	if err = s.AddPayout(id, p.Created, string(p.Status), p.Amount, string(p.Currency)); err != nil {
+	return s.recordPayout(p)
+}
+
+func (s *Service) recordPayout(
+	p *stripe.Payout,
+) error {
+	id := p.ID
+	if err := s.AddPayout(id, p.Created, string(p.Status), p.Amount, string(p.Currency)); err != nil {
 		log.Printf("DB ERROR payout %s: %v", id, err)
 		return err
 	}
+
+	// Stripe only lists what a payout settled for automatic payouts
+	if p.Automatic && p.Status == stripe.PayoutStatusPaid {
+		if err := s.recordPayoutTransactions(id); err != nil {
+			return err
+		}
+	}
 	log.Printf("OK payout %s", id)
 	return nil
 }
 
+// recordPayoutTransactions stores the balance transactions settled by a
+// payout, for reconciliation
+func (s *Service) recordPayoutTransactions(
+	id string,
+) error {
+	sc, err := s.stripeClient()
+	if err != nil {
+		return err
+	}
+	params := &stripe.BalanceTransactionListParams{
+		Payout: stripe.String(id),
+	}
+	var txs []BalanceTransaction
+	for bt, err := range sc.V1BalanceTransactions.List(context.Background(), params) {
+		if err != nil {
+			log.Printf("<-  payout %s transactions ERROR: %v", id, err)
+			return err
+		}
+		// the payout's own transaction moves the funds out
+		if bt.Type == stripe.BalanceTransactionTypePayout {
+			continue
+		}
+		source := ""
+		if bt.Source != nil {
+			source = bt.Source.ID
+		}
+		txs = append(txs, BalanceTransaction{
+			ID:       bt.ID,
+			Payout:   id,
+			Created:  time.Unix(bt.Created, 0),
+			Type:     string(bt.Type),
+			Source:   source,
+			Amount:   int(bt.Amount),
+			Fee:      int(bt.Fee),
+			Net:      int(bt.Net),
+			Currency: string(bt.Currency),
+		})
+	}
+
+	if err := s.store.InsertBalanceTransactions(id, txs); err != nil {
+		log.Printf("DB ERROR payout %s transactions: %v", id, err)
+		return DatabaseError{err}
+	}
+	return nil
+}
+
+// unixTime converts a Stripe timestamp, where zero means unset
+func unixTime(
+	t int64,
+) *time.Time {
+	if t == 0 {
+		return nil
+	}
+	u := time.Unix(t, 0)
+	return &u
+}
+
 // eventDebouncer coalesces rapid-fire events for the same resource.
 // When multiple events arrive within the window, only one fires.
 type eventDebouncer struct {
@@ -535,8 +1149,12 @@ func (d *eventDebouncer) stop() {
 
 func (s *Service) buildStripeRouter(
 	mux *http.ServeMux,
+	mw Middleware,
 ) {
 	mux.HandleFunc("POST /stripe/webhook", s.handleStripeWebhook)
+	mux.HandleFunc("GET /stripe/events", mw.Auth(s.handleListStripeEvents))
+	mux.HandleFunc("POST /stripe/events/{id}/retry", mw.Auth(s.handleRetryStripeEvent))
+	mux.HandleFunc("POST /stripe/sync", mw.Auth(s.handlePostStripeSync))
 }
 
 func (s *Service) handleStripeWebhook(
@@ -16,6 +16,7 @@ func TestAPIWebhookReplay_SubscriptionCreated(t *testing.T) {
 	router := env.Service.BuildRouter()
 
 	scenario := testutil.LoadScenario(t, "subscription_created")
+	env.Stripe.AddScenario(scenario)
 	t.Logf("Replaying %d events through webhook endpoint", len(scenario.Events))
 
 	// Replay all events through the HTTP webhook endpoint
@@ -34,6 +35,7 @@ func TestAPIWebhookReplay_SubscriptionCreated_Shuffled(t *testing.T) {
 
 	// Load with shuffled order
 	scenario := testutil.LoadScenarioShuffled(t, "subscription_created", 42)
+	env.Stripe.AddScenario(scenario)
 	t.Logf("Replaying %d events (shuffled) through webhook endpoint", len(scenario.Events))
 
 	testutil.ReplayScenario(t, router, scenario)
@@ -48,6 +50,7 @@ func TestAPIWebhook_RapidFireScenario(t *testing.T) {
 	router := env.Service.BuildRouter()
 
 	scenario := testutil.LoadScenario(t, "subscription_created")
+	env.Stripe.AddScenario(scenario)
 	t.Logf("Rapid-fire replaying %d events", len(scenario.Events))
 
 	// Replay with no delays (stress test the debouncer)
@@ -65,6 +68,7 @@ func TestAPIWebhook_DuplicateEventsInStream(t *testing.T) {
 	router := env.Service.BuildRouter()
 
 	scenario := testutil.LoadScenario(t, "subscription_created")
+	env.Stripe.AddScenario(scenario)
 
 	// Create a duplicated scenario (each event appears twice)
 	duplicated := &testutil.Scenario{
@@ -89,6 +93,7 @@ func TestAPIWebhookReplay_WithDelays(t *testing.T) {
 	router := env.Service.BuildRouter()
 
 	scenario := testutil.LoadScenario(t, "subscription_created")
+	env.Stripe.AddScenario(scenario)
 	t.Logf("Replaying %d events with 10ms delays", len(scenario.Events))
 
 	// Replay with realistic delays between events
@@ -105,6 +110,7 @@ func TestAPIWebhookReplay_CheckoutCompleted(t *testing.T) {
 	router := env.Service.BuildRouter()
 
 	scenario := testutil.LoadScenario(t, "checkout_completed")
+	env.Stripe.AddScenario(scenario)
 	t.Logf("Replaying %d events for checkout scenario", len(scenario.Events))
 
 	testutil.ReplayScenario(t, router, scenario)
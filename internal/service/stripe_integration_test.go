@@ -32,6 +32,7 @@ func TestScenario_SubscriptionCreated(t *testing.T) {
 	env := testutil.SetupTestEnv(t)
 
 	scenario := testutil.LoadScenario(t, "subscription_created")
+	env.Stripe.AddScenario(scenario)
 	t.Logf("Loaded %d events for scenario %s", len(scenario.Events), scenario.Name)
 
 	// Process all events
@@ -44,10 +45,8 @@ func TestScenario_SubscriptionCreated(t *testing.T) {
 	// Wait for debouncing to complete
 	testutil.WaitForDebounce(50 * time.Millisecond)
 
-	// Verify final state
-	// Note: Since events are processed through debouncer, actual processing
-	// happens via HandleStripeResource which requires Stripe API access.
-	// For pure integration tests, we verify events were accepted without error.
+	// Processing runs through the debouncer against the fake Stripe API,
+	// which serves the scenario objects; here we verify events were accepted.
 	t.Log("All events processed successfully")
 }
 
@@ -56,6 +55,7 @@ func TestScenario_SubscriptionUpdated(t *testing.T) {
 	env := testutil.SetupTestEnv(t)
 
 	scenario := testutil.LoadScenario(t, "subscription_updated")
+	env.Stripe.AddScenario(scenario)
 	t.Logf("Loaded %d events for scenario %s", len(scenario.Events), scenario.Name)
 
 	for i, event := range scenario.Events {
@@ -73,6 +73,7 @@ func TestScenario_CheckoutCompleted(t *testing.T) {
 	env := testutil.SetupTestEnv(t)
 
 	scenario := testutil.LoadScenario(t, "checkout_completed")
+	env.Stripe.AddScenario(scenario)
 	t.Logf("Loaded %d events for scenario %s", len(scenario.Events), scenario.Name)
 
 	for i, event := range scenario.Events {
@@ -93,6 +94,7 @@ func TestScenario_SubscriptionCreated_OutOfOrder(t *testing.T) {
 
 	// Load with deterministic shuffle for reproducibility
 	scenario := testutil.LoadScenarioShuffled(t, "subscription_created", 12345)
+	env.Stripe.AddScenario(scenario)
 	t.Logf("Loaded %d events (shuffled) for scenario %s", len(scenario.Events), scenario.Name)
 
 	for i, event := range scenario.Events {
@@ -110,6 +112,7 @@ func TestScenario_CheckoutCompleted_OutOfOrder(t *testing.T) {
 	env := testutil.SetupTestEnv(t)
 
 	scenario := testutil.LoadScenarioShuffled(t, "checkout_completed", 67890)
+	env.Stripe.AddScenario(scenario)
 	t.Logf("Loaded %d events (shuffled) for scenario %s", len(scenario.Events), scenario.Name)
 
 	for i, event := range scenario.Events {
@@ -129,6 +132,7 @@ func TestScenario_RapidFire(t *testing.T) {
 	env := testutil.SetupTestEnv(t)
 
 	scenario := testutil.LoadScenario(t, "subscription_created")
+	env.Stripe.AddScenario(scenario)
 
 	// Send all events as fast as possible (no delays)
 	for i, event := range scenario.Events {
@@ -147,6 +151,7 @@ func TestScenario_DuplicateEvents(t *testing.T) {
 	env := testutil.SetupTestEnv(t)
 
 	scenario := testutil.LoadScenario(t, "subscription_created")
+	env.Stripe.AddScenario(scenario)
 
 	// Process each event twice (simulating retries)
 	for i, event := range scenario.Events {
@@ -2,6 +2,7 @@ package service_test
 
 import (
 	"testing"
+	"time"
 
 	"git.sr.ht/~jakintosh/coffer/internal/service"
 	"git.sr.ht/~jakintosh/coffer/internal/testutil"
@@ -39,6 +40,7 @@ func TestCreatePaymentDefault(t *testing.T) {
 func TestCreatePaymentAllocated(t *testing.T) {
 
 	env := testutil.SetupTestEnv(t)
+	testutil.SeedLedgers(t, env.Service, "community")
 	svc := env.Service
 
 	rules := []service.AllocationRule{
@@ -53,9 +55,7 @@ func TestCreatePaymentAllocated(t *testing.T) {
 			Percentage: 75,
 		},
 	}
-	if err := svc.SetAllocations(rules); err != nil {
-		t.Fatalf("failed to set allocations: %v", err)
-	}
+	testutil.ScheduleAllocations(t, env, rules, testutil.MakeDate(2025, 1, 1))
 
 	amount := int64(777)
 	ts := testutil.MakeDateUnix(2025, 1, 1)
@@ -91,3 +91,213 @@ func TestCreatePaymentAllocated(t *testing.T) {
 		t.Errorf("sums do not match payment")
 	}
 }
+
+func TestCreatePaymentUsesRulesInEffect(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	testutil.SeedLedgers(t, env.Service, "community")
+	svc := env.Service
+
+	// a split scheduled for march does not apply to a february payment
+	rules := []service.AllocationRule{
+		{
+			ID:         "c",
+			LedgerName: "community",
+			Percentage: 100,
+		},
+	}
+	testutil.ScheduleAllocations(t, env, rules, testutil.MakeDate(2025, 3, 1))
+
+	feb := testutil.MakeDateUnix(2025, 2, 1)
+	if err := svc.CreatePayment("pi_feb", feb, "succeeded", "cus_1", 500, "usd"); err != nil {
+		t.Fatalf("CreatePayment: %v", err)
+	}
+	mar := testutil.MakeDateUnix(2025, 3, 2)
+	if err := svc.CreatePayment("pi_mar", mar, "succeeded", "cus_1", 300, "usd"); err != nil {
+		t.Fatalf("CreatePayment: %v", err)
+	}
+
+	gTx, err := svc.GetTransactions("general", 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cTx, err := svc.GetTransactions("community", 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(gTx) != 1 || gTx[0].Amount != 500 {
+		t.Errorf("expected february payment on general, got %+v", gTx)
+	}
+	if len(cTx) != 1 || cTx[0].Amount != 300 {
+		t.Errorf("expected march payment on community, got %+v", cTx)
+	}
+}
+
+func TestCreatePaymentFixedAllocation(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	testutil.SeedLedgers(t, env.Service, "community", "hosting")
+	svc := env.Service
+
+	// hosting takes a fixed 3.00 before the rest is split
+	rules := []service.AllocationRule{
+		{
+			ID:         "h",
+			LedgerName: "hosting",
+			Fixed:      300,
+		},
+		{
+			ID:          "g",
+			LedgerName:  "general",
+			BasisPoints: 3333,
+		},
+		{
+			ID:          "c",
+			LedgerName:  "community",
+			BasisPoints: 6667,
+		},
+	}
+	testutil.ScheduleAllocations(t, env, rules, testutil.MakeDate(2025, 1, 1))
+
+	ts := testutil.MakeDateUnix(2025, 1, 2)
+	if err := svc.CreatePayment("pi_fixed", ts, "succeeded", "cus_1", 1000, "usd"); err != nil {
+		t.Fatalf("CreatePayment: %v", err)
+	}
+
+	want := map[string]int{
+		"hosting":   300,
+		"general":   233,
+		"community": 467,
+	}
+	for ledger, amount := range want {
+		txs, err := svc.GetTransactions(ledger, 10, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(txs) != 1 || txs[0].Amount != amount {
+			t.Errorf("%s want %d got %+v", ledger, amount, txs)
+		}
+	}
+}
+
+func TestCreatePaymentSelectsAllocationSet(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	testutil.SeedLedgers(t, env.Service, "dev", "events")
+	svc := env.Service
+	jan := testutil.MakeDate(2025, 1, 1)
+
+	schedule := func(selector, ledger string) {
+		t.Helper()
+		rules := []service.AllocationRule{
+			{ID: ledger, LedgerName: ledger, Percentage: 100},
+		}
+		testutil.ScheduleAllocationSet(t, env, selector, rules, jan)
+	}
+	schedule("amount:5000", "dev")
+	schedule("product:prod_sponsor", "dev")
+	schedule("metadata:campaign=meetup", "events")
+
+	tests := []struct {
+		id     string
+		amount int64
+		pctx   service.PaymentContext
+		ledger string
+	}{
+		{"pi_small", 500, service.PaymentContext{}, "general"},
+		{"pi_amount", 5000, service.PaymentContext{}, "dev"},
+		{"pi_product", 2500, service.PaymentContext{ProductID: "prod_sponsor"}, "dev"},
+		{"pi_meta", 5000, service.PaymentContext{
+			ProductID: "prod_sponsor",
+			Metadata:  map[string]string{"campaign": "meetup"},
+		}, "events"},
+	}
+
+	ts := testutil.MakeDateUnix(2025, 1, 2)
+	for _, tt := range tests {
+		if err := svc.CreatePaymentWithContext(tt.id, ts, "succeeded", "cus_1", tt.amount, "usd", tt.pctx); err != nil {
+			t.Fatalf("CreatePayment %s: %v", tt.id, err)
+		}
+		tx, err := env.DB.GetTransaction(tt.id + ":" + tt.ledger)
+		if err != nil {
+			t.Errorf("%s: expected allocation to %s: %v", tt.id, tt.ledger, err)
+			continue
+		}
+		if tx.Amount != int(tt.amount) {
+			t.Errorf("%s: want %d got %d", tt.id, tt.amount, tx.Amount)
+		}
+
+		// the chosen set is recorded on the payment
+		payment, err := svc.GetPayment(tt.id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		set, err := svc.SelectAllocationSet(tt.pctx, tt.amount, time.Unix(ts, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if payment.AllocationSet == 0 || payment.AllocationSet != set.ID {
+			t.Errorf("%s: want allocation set %d got %d", tt.id, set.ID, payment.AllocationSet)
+		}
+	}
+}
+
+func TestCreatePaymentClearedSelectorFallsBack(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	testutil.SeedLedgers(t, env.Service, "dev")
+	svc := env.Service
+
+	rules := []service.AllocationRule{
+		{ID: "d", LedgerName: "dev", Percentage: 100},
+	}
+	testutil.ScheduleAllocationSet(t, env, "amount:5000", rules, testutil.MakeDate(2025, 1, 1))
+	testutil.ScheduleAllocationSet(t, env, "amount:5000", nil, testutil.MakeDate(2025, 2, 1))
+
+	ts := testutil.MakeDateUnix(2025, 2, 2)
+	if err := svc.CreatePayment("pi_1", ts, "succeeded", "cus_1", 5000, "usd"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := env.DB.GetTransaction("pi_1:general"); err != nil {
+		t.Errorf("expected fallback to default set: %v", err)
+	}
+}
+
+func TestCreatePaymentArchivedLedger(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	testutil.SeedLedgers(t, env.Service, "dev")
+	svc := env.Service
+
+	rules := []service.AllocationRule{
+		{ID: "d", LedgerName: "dev", Percentage: 100},
+	}
+	testutil.ScheduleAllocations(t, env, rules, testutil.MakeDate(2025, 1, 1))
+	rules = []service.AllocationRule{
+		{ID: "g", LedgerName: "general", Percentage: 100},
+	}
+	testutil.ScheduleAllocations(t, env, rules, testutil.MakeDate(2025, 2, 1))
+
+	// dev is only in a historical set, so it can be archived
+	ledger, err := svc.GetLedgerInfo("dev")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ledger.Archived = true
+	if _, err := svc.UpdateLedger(*ledger); err != nil {
+		t.Fatalf("failed to archive: %v", err)
+	}
+
+	// a late january payment is still split by the january set
+	ts := testutil.MakeDateUnix(2025, 1, 15)
+	if err := svc.CreatePayment("pi_1", ts, "succeeded", "cus_1", 500, "usd"); err != nil {
+		t.Fatalf("failed to create payment: %v", err)
+	}
+	tx, err := env.DB.GetTransaction("pi_1:dev")
+	if err != nil {
+		t.Fatalf("expected allocation to archived ledger: %v", err)
+	}
+	if tx.Amount != 500 {
+		t.Errorf("want 500 got %d", tx.Amount)
+	}
+}
@@ -0,0 +1,168 @@
+package service
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"git.sr.ht/~jakintosh/coffer/pkg/wire"
+)
+
+// Subscription is a Stripe subscription as recorded locally. Amount is the
+// price of its first item per billing period. CancelAt is when a scheduled
+// cancellation takes effect, including one at the end of the current
+// period, and Paused is set while payment collection is paused.
+type Subscription struct {
+	ID                string     `json:"id"`
+	Customer          string     `json:"customer"`
+	Created           time.Time  `json:"created"`
+	Status            string     `json:"status"`
+	Amount            int        `json:"amount"`
+	Currency          string     `json:"currency"`
+	CurrentPeriodEnd  *time.Time `json:"current_period_end,omitempty"`
+	CancelAtPeriodEnd bool       `json:"cancel_at_period_end"`
+	CancelAt          *time.Time `json:"cancel_at,omitempty"`
+	CanceledAt        *time.Time `json:"canceled_at,omitempty"`
+	Paused            bool       `json:"paused"`
+	ResumesAt         *time.Time `json:"resumes_at,omitempty"`
+}
+
+// SubscriptionChange is the state of a subscription from Date until its
+// next change
+type SubscriptionChange struct {
+	Date     time.Time  `json:"date"`
+	Status   string     `json:"status"`
+	Amount   int        `json:"amount"`
+	Currency string     `json:"currency"`
+	Paused   bool       `json:"paused"`
+	CancelAt *time.Time `json:"cancel_at,omitempty"`
+}
+
+// SubscriptionTimeline is a subscription with its changes, in the order
+// they were recorded, and its invoices, oldest first
+type SubscriptionTimeline struct {
+	Subscription
+	Changes  []SubscriptionChange `json:"changes"`
+	Invoices []Invoice            `json:"invoices"`
+}
+
+// change returns the state of the subscription as a change at date
+func (sub Subscription) change(
+	date time.Time,
+) SubscriptionChange {
+	return SubscriptionChange{
+		Date:     date,
+		Status:   sub.Status,
+		Amount:   sub.Amount,
+		Currency: sub.Currency,
+		Paused:   sub.Paused,
+		CancelAt: sub.CancelAt,
+	}
+}
+
+// sameState reports whether two changes leave a subscription in the same
+// state, whenever they happened
+func (c SubscriptionChange) sameState(
+	other SubscriptionChange,
+) bool {
+	sameCancel := (c.CancelAt == nil) == (other.CancelAt == nil)
+	if sameCancel && c.CancelAt != nil {
+		sameCancel = c.CancelAt.Equal(*other.CancelAt)
+	}
+	return sameCancel &&
+		c.Status == other.Status &&
+		c.Amount == other.Amount &&
+		c.Currency == other.Currency &&
+		c.Paused == other.Paused
+}
+
+// SubscriptionDiff works out the change a new state of a subscription adds
+// to its history, given the state last recorded, or nil, read in the
+// transaction that records it. It returns nil when nothing changed.
+type SubscriptionDiff func(
+	prev *Subscription,
+) *SubscriptionChange
+
+// RecordSubscription records the state of a subscription as of changed, or
+// as of now if changed is nil, unless a later state is already recorded,
+// and adds a change to its history when its status, amount, pause or
+// scheduled cancellation differs from what was recorded before. A
+// cancellation is dated when the subscription was canceled and the first
+// change when it was created; any other change is dated when the state was
+// seen.
+func (s *Service) RecordSubscription(
+	sub Subscription,
+	changed *time.Time,
+) error {
+	date := s.Clock()
+	if changed != nil {
+		date = *changed
+	}
+
+	diff := func(prev *Subscription) *SubscriptionChange {
+		if prev != nil && prev.change(prev.Created).sameState(sub.change(sub.Created)) {
+			return nil
+		}
+		date := date
+		switch {
+		case sub.Status == "canceled" && sub.CanceledAt != nil &&
+			(prev == nil || prev.Status != "canceled"):
+			date = *sub.CanceledAt
+		case prev == nil:
+			date = sub.Created
+		}
+		c := sub.change(date)
+		return &c
+	}
+
+	if err := s.store.InsertSubscription(sub, date.Unix(), diff); err != nil {
+		return DatabaseError{err}
+	}
+	return nil
+}
+
+// GetPatronSubscriptions returns the subscriptions of a patron with their
+// history, oldest first
+func (s *Service) GetPatronSubscriptions(
+	customer string,
+) (
+	[]SubscriptionTimeline,
+	error,
+) {
+	timelines, err := s.store.GetSubscriptionTimelines(customer)
+	if err != nil {
+		return nil, DatabaseError{err}
+	}
+
+	// a patron without subscriptions must still be known
+	if len(timelines) == 0 {
+		_, err := s.store.GetCustomer(customer)
+		if errors.Is(err, ErrPatronNotFound) {
+			return nil, err
+		} else if err != nil {
+			return nil, DatabaseError{err}
+		}
+	}
+
+	return timelines, nil
+}
+
+func (s *Service) handleGetPatronSubscriptions(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	id := r.PathValue("id")
+
+	timelines, err := s.GetPatronSubscriptions(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrPatronNotFound):
+			wire.WriteError(w, http.StatusNotFound, "Patron Not Found")
+		default:
+			wire.WriteError(w, http.StatusInternalServerError, "Internal Server Error")
+		}
+		return
+	}
+
+	wire.WriteData(w, http.StatusOK, timelines)
+}
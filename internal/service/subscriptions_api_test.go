@@ -0,0 +1,51 @@
+package service_test
+
+import (
+	"net/http"
+	"testing"
+
+	"git.sr.ht/~jakintosh/coffer/internal/service"
+	"git.sr.ht/~jakintosh/coffer/internal/testutil"
+	"git.sr.ht/~jakintosh/coffer/pkg/wire"
+)
+
+func TestAPIGetPatronSubscriptions(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	router := env.Service.BuildRouter()
+	auth := testutil.MakeAuthHeader(t, env.Service)
+
+	addSubscription(env.Stripe, "sub_1", "active", 500, nil)
+	env.Service.HandleStripeResource("subscription", "sub_1")
+	addSubscription(env.Stripe, "sub_1", "active", 800, nil)
+	env.Service.HandleStripeResource("subscription", "sub_1")
+
+	result := wire.TestGet[[]service.SubscriptionTimeline](router, "/patrons/cus_1/subscriptions", auth)
+
+	timelines := result.ExpectOK(t)
+	if len(timelines) != 1 || timelines[0].ID != "sub_1" || timelines[0].Amount != 800 {
+		t.Fatalf("unexpected timelines %+v", timelines)
+	}
+	if changes := timelines[0].Changes; len(changes) != 2 || changes[0].Amount != 500 {
+		t.Errorf("unexpected changes %+v", changes)
+	}
+}
+
+func TestAPIGetPatronSubscriptionsNotFound(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	router := env.Service.BuildRouter()
+	auth := testutil.MakeAuthHeader(t, env.Service)
+
+	result := wire.TestGet[any](router, "/patrons/cus_missing/subscriptions", auth)
+	result.ExpectStatus(t, http.StatusNotFound)
+}
+
+func TestAPIGetPatronSubscriptionsUnauthorized(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	router := env.Service.BuildRouter()
+
+	result := wire.TestGet[any](router, "/patrons/cus_1/subscriptions")
+	result.ExpectStatus(t, http.StatusUnauthorized)
+}
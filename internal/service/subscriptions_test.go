@@ -0,0 +1,221 @@
+package service_test
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"git.sr.ht/~jakintosh/coffer/internal/service"
+	"git.sr.ht/~jakintosh/coffer/internal/testutil"
+	stripe "github.com/stripe/stripe-go/v82"
+)
+
+// addSubscription serves a monthly subscription of cus_1 created at the
+// start of 2025, with one item of the given amount, and any extra fields
+// set at the top level
+func addSubscription(
+	stripe *testutil.FakeStripe,
+	id string,
+	status string,
+	amount int,
+	extra map[string]any,
+) {
+	sub := map[string]any{
+		"id":       id,
+		"object":   "subscription",
+		"created":  testutil.MakeDateUnix(2025, 1, 1),
+		"status":   status,
+		"customer": "cus_1",
+		"items": map[string]any{
+			"object": "list",
+			"data": []any{map[string]any{
+				"id":                 "si_" + id,
+				"object":             "subscription_item",
+				"quantity":           1,
+				"current_period_end": testutil.MakeDateUnix(2025, 2, 1),
+				"price": map[string]any{
+					"id":          "price_1",
+					"object":      "price",
+					"unit_amount": amount,
+					"currency":    "usd",
+				},
+			}},
+		},
+	}
+	for k, v := range extra {
+		sub[k] = v
+	}
+	stripe.AddObject("/v1/subscriptions/"+id, sub)
+}
+
+func getTimeline(
+	t *testing.T,
+	svc *service.Service,
+) service.SubscriptionTimeline {
+	t.Helper()
+
+	timelines, err := svc.GetPatronSubscriptions("cus_1")
+	if err != nil {
+		t.Fatalf("GetPatronSubscriptions: %v", err)
+	}
+	if len(timelines) != 1 {
+		t.Fatalf("expected 1 subscription, got %+v", timelines)
+	}
+	return timelines[0]
+}
+
+func TestSubscriptionLifecycle(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	f := env.Stripe
+	start := time.Now().Add(-time.Second)
+
+	addSubscription(f, "sub_1", "active", 500, nil)
+	env.Service.HandleStripeResource("subscription", "sub_1")
+
+	// recording the same state again adds no change
+	env.Service.HandleStripeResource("subscription", "sub_1")
+
+	// upgrade to a higher tier
+	addSubscription(f, "sub_1", "active", 1500, nil)
+	env.Service.HandleStripeResource("subscription", "sub_1")
+
+	// pause collection
+	resumes := testutil.MakeDateUnix(2025, 4, 1)
+	addSubscription(f, "sub_1", "active", 1500, map[string]any{
+		"pause_collection": map[string]any{"behavior": "void", "resumes_at": resumes},
+	})
+	env.Service.HandleStripeResource("subscription", "sub_1")
+
+	timeline := getTimeline(t, env.Service)
+	if !timeline.Paused || timeline.ResumesAt == nil || timeline.ResumesAt.Unix() != resumes {
+		t.Errorf("unexpected paused subscription %+v", timeline.Subscription)
+	}
+
+	// resume and cancel at the end of the period
+	addSubscription(f, "sub_1", "active", 1500, map[string]any{
+		"cancel_at_period_end": true,
+	})
+	env.Service.HandleStripeResource("subscription", "sub_1")
+
+	// canceled once the period ends
+	canceled := testutil.MakeDateUnix(2025, 2, 1)
+	addSubscription(f, "sub_1", "canceled", 1500, map[string]any{
+		"cancel_at_period_end": true,
+		"canceled_at":          canceled,
+	})
+	env.Service.HandleStripeResource("subscription", "sub_1")
+
+	timeline = getTimeline(t, env.Service)
+	sub := timeline.Subscription
+	if sub.Status != "canceled" || sub.Amount != 1500 || sub.Paused {
+		t.Errorf("unexpected subscription %+v", sub)
+	}
+	if sub.CanceledAt == nil || sub.CanceledAt.Unix() != canceled {
+		t.Errorf("unexpected canceled_at %v", sub.CanceledAt)
+	}
+	if sub.CurrentPeriodEnd == nil || sub.CancelAt == nil || !sub.CancelAt.Equal(*sub.CurrentPeriodEnd) {
+		t.Errorf("expected cancellation at period end, got %+v", sub)
+	}
+
+	changes := timeline.Changes
+	if len(changes) != 5 {
+		t.Fatalf("expected 5 changes, got %+v", changes)
+	}
+	first := changes[0]
+	if first.Status != "active" || first.Amount != 500 || !first.Date.Equal(testutil.MakeDate(2025, 1, 1)) {
+		t.Errorf("unexpected first change %+v", first)
+	}
+	if changes[1].Amount != 1500 || changes[1].Date.Before(start) {
+		t.Errorf("unexpected upgrade %+v", changes[1])
+	}
+	if !changes[2].Paused {
+		t.Errorf("unexpected pause %+v", changes[2])
+	}
+	if changes[3].Paused || changes[3].CancelAt == nil || changes[3].Status != "active" {
+		t.Errorf("unexpected scheduled cancellation %+v", changes[3])
+	}
+	last := changes[4]
+	if last.Status != "canceled" || last.Date.Unix() != canceled {
+		t.Errorf("unexpected cancellation %+v", last)
+	}
+}
+
+func TestGetPatronSubscriptionsNotFound(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+
+	_, err := env.Service.GetPatronSubscriptions("cus_missing")
+	if !errors.Is(err, service.ErrPatronNotFound) {
+		t.Errorf("expected ErrPatronNotFound, got %v", err)
+	}
+
+	// a known patron without subscriptions has an empty timeline
+	if err := env.Service.AddCustomer("cus_1", testutil.MakeDateUnix(2025, 1, 1), nil); err != nil {
+		t.Fatal(err)
+	}
+	timelines, err := env.Service.GetPatronSubscriptions("cus_1")
+	if err != nil || len(timelines) != 0 {
+		t.Errorf("expected empty timeline, got %+v, %v", timelines, err)
+	}
+}
+
+func TestSubscriptionChangeEventDate(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	env.Clock.Set(testutil.MakeDate(2025, 2, 1))
+	addSubscription(env.Stripe, "sub_1", "active", 500, nil)
+	env.Service.HandleStripeResource("subscription", "sub_1")
+	env.Clock.Set(time.Time{})
+
+	// the upgrade is dated by the event, not when it is processed
+	addSubscription(env.Stripe, "sub_1", "active", 1500, nil)
+	updated := testutil.MakeDate(2025, 3, 1)
+	event := stripe.Event{
+		ID:      "evt_1",
+		Type:    "customer.subscription.updated",
+		Created: updated.Unix(),
+		Data: &stripe.EventData{
+			Raw: json.RawMessage(`{"id":"sub_1","object":"subscription"}`),
+		},
+	}
+	if err := env.Service.ProcessStripeEvent(event); err != nil {
+		t.Fatalf("ProcessStripeEvent: %v", err)
+	}
+	waitForEvent(t, env, "evt_1", service.EventProcessed)
+
+	changes := getTimeline(t, env.Service).Changes
+	if len(changes) != 2 || changes[1].Amount != 1500 || !changes[1].Date.Equal(updated) {
+		t.Errorf("unexpected changes %+v", changes)
+	}
+}
+
+func TestSubscriptionStaleEvent(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	record := func(id string, amount int, created time.Time) {
+		addSubscription(env.Stripe, "sub_1", "active", amount, nil)
+		event := stripe.Event{
+			ID:      id,
+			Type:    "customer.subscription.updated",
+			Created: created.Unix(),
+			Data: &stripe.EventData{
+				Raw: json.RawMessage(`{"id":"sub_1","object":"subscription"}`),
+			},
+		}
+		if err := env.Service.ProcessStripeEvent(event); err != nil {
+			t.Fatalf("ProcessStripeEvent: %v", err)
+		}
+		waitForEvent(t, env, id, service.EventProcessed)
+	}
+	record("evt_2", 1500, testutil.MakeDate(2025, 3, 1))
+
+	// a state older than the one recorded is ignored
+	record("evt_1", 500, testutil.MakeDate(2025, 2, 1))
+
+	timeline := getTimeline(t, env.Service)
+	if timeline.Amount != 1500 || len(timeline.Changes) != 1 {
+		t.Errorf("unexpected timeline %+v", timeline)
+	}
+}
@@ -0,0 +1,341 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+
+	stripe "github.com/stripe/stripe-go/v82"
+
+	"git.sr.ht/~jakintosh/coffer/pkg/wire"
+)
+
+// stripeSyncResources are synced in this order. Customers are synced from
+// completed checkout sessions, which carry their public names.
+var stripeSyncResources = []string{
+	"customers",
+	"subscriptions",
+	"payments",
+	"payouts",
+}
+
+// StripeSync is the progress of syncing from the Stripe list APIs. While a
+// sync is running, or after it was interrupted, Resource is the resource
+// being synced and After is the last object of it that was recorded. Last
+// is when the last completed sync started.
+type StripeSync struct {
+	Since    time.Time
+	Started  time.Time
+	Resource string
+	After    string
+	Last     time.Time
+}
+
+// StripeSyncResult counts the objects recorded by a sync, which includes
+// objects that were already known.
+type StripeSyncResult struct {
+	Since         time.Time `json:"since"`
+	Resumed       bool      `json:"resumed"`
+	Customers     int       `json:"customers"`
+	Subscriptions int       `json:"subscriptions"`
+	Payments      int       `json:"payments"`
+	Payouts       int       `json:"payouts"`
+}
+
+// SyncStripe records every customer, subscription, succeeded payment and
+// payout created at or after since, through the same paths as webhooks,
+// so syncing again changes nothing. Without since, the sync starts where
+// the last completed sync started. A sync that stopped on an error resumes
+// after the last object it recorded when it is run again from the same
+// since.
+func (s *Service) SyncStripe(
+	since *time.Time,
+) (
+	*StripeSyncResult,
+	error,
+) {
+	if !s.syncMu.TryLock() {
+		return nil, ErrSyncRunning
+	}
+	defer s.syncMu.Unlock()
+
+	state, err := s.store.GetStripeSync()
+	if err != nil {
+		return nil, DatabaseError{err}
+	}
+
+	from := state.Last
+	if since != nil {
+		from = *since
+	} else if state.Resource != "" {
+		from = state.Since
+	}
+
+	result := &StripeSyncResult{Since: from}
+	start, after := 0, ""
+	if state.Resource != "" && state.Since.Equal(from) {
+		result.Resumed = true
+		start = max(slices.Index(stripeSyncResources, state.Resource), 0)
+		after = state.After
+	} else {
+		state = &StripeSync{
+			Since:   from,
+			Started: s.Clock(),
+			Last:    state.Last,
+		}
+	}
+
+	for _, resource := range stripeSyncResources[start:] {
+		state.Resource = resource
+		state.After = after
+		if err := s.store.SetStripeSync(*state); err != nil {
+			return nil, DatabaseError{err}
+		}
+
+		// record progress after every object so a failed sync can resume
+		progress := func(id string) error {
+			state.After = id
+			if err := s.store.SetStripeSync(*state); err != nil {
+				return DatabaseError{err}
+			}
+			return nil
+		}
+
+		var n int
+		switch resource {
+		case "customers":
+			n, err = s.syncCheckoutSessions(from.Unix(), after, progress)
+			result.Customers = n
+		case "subscriptions":
+			n, err = s.syncSubscriptions(from.Unix(), after, progress)
+			result.Subscriptions = n
+		case "payments":
+			n, err = s.syncPaymentIntents(from.Unix(), after, progress)
+			result.Payments = n
+		case "payouts":
+			n, err = s.syncPayouts(from.Unix(), after, progress)
+			result.Payouts = n
+		}
+		if err != nil {
+			log.Printf("Error syncing stripe %s: %v", resource, err)
+			return nil, err
+		}
+		after = ""
+	}
+
+	done := StripeSync{Since: from, Last: state.Started}
+	if err := s.store.SetStripeSync(done); err != nil {
+		return nil, DatabaseError{err}
+	}
+
+	return result, nil
+}
+
+func (s *Service) syncCheckoutSessions(
+	since int64,
+	after string,
+	progress func(id string) error,
+) (
+	int,
+	error,
+) {
+	sc, err := s.stripeClient()
+	if err != nil {
+		return 0, err
+	}
+	params := &stripe.CheckoutSessionListParams{
+		CreatedRange: &stripe.RangeQueryParams{GreaterThanOrEqual: since},
+		Status:       stripe.String(string(stripe.CheckoutSessionStatusComplete)),
+	}
+	if after != "" {
+		params.StartingAfter = stripe.String(after)
+	}
+
+	n := 0
+	for sess, err := range sc.V1CheckoutSessions.List(context.Background(), params) {
+		if err != nil {
+			return n, err
+		}
+		if err := s.recordCheckoutSession(sess, sess.Created); err != nil {
+			return n, err
+		}
+		if sess.Customer != nil {
+			n++
+		}
+		if err := progress(sess.ID); err != nil {
+			return n, err
+		}
+	}
+
+	return n, nil
+}
+
+func (s *Service) syncSubscriptions(
+	since int64,
+	after string,
+	progress func(id string) error,
+) (
+	int,
+	error,
+) {
+	sc, err := s.stripeClient()
+	if err != nil {
+		return 0, err
+	}
+	params := &stripe.SubscriptionListParams{
+		CreatedRange: &stripe.RangeQueryParams{GreaterThanOrEqual: since},
+		Status:       stripe.String("all"),
+	}
+	if after != "" {
+		params.StartingAfter = stripe.String(after)
+	}
+
+	n := 0
+	for subs, err := range sc.V1Subscriptions.List(context.Background(), params) {
+		if err != nil {
+			return n, err
+		}
+		if err := s.recordSubscription(subs, nil); err != nil {
+			return n, err
+		}
+		n++
+		if err := progress(subs.ID); err != nil {
+			return n, err
+		}
+	}
+
+	return n, nil
+}
+
+// syncPaymentIntents records the succeeded payment intents, which are the
+// ones webhooks record
+func (s *Service) syncPaymentIntents(
+	since int64,
+	after string,
+	progress func(id string) error,
+) (
+	int,
+	error,
+) {
+	sc, err := s.stripeClient()
+	if err != nil {
+		return 0, err
+	}
+	params := &stripe.PaymentIntentListParams{
+		CreatedRange: &stripe.RangeQueryParams{GreaterThanOrEqual: since},
+	}
+	params.AddExpand("data.latest_charge.balance_transaction")
+	if after != "" {
+		params.StartingAfter = stripe.String(after)
+	}
+
+	n := 0
+	for intent, err := range sc.V1PaymentIntents.List(context.Background(), params) {
+		if err != nil {
+			return n, err
+		}
+		if intent.Status == stripe.PaymentIntentStatusSucceeded {
+			// a payment waiting on its fee is queued like an event, to be
+			// allocated once the fee is known
+			err := s.recordPaymentIntent(intent)
+			if errors.Is(err, ErrFeePending) {
+				err = s.queueStripeResource(
+					ResourceEvent{"payment", intent.ID},
+					time.Unix(intent.Created, 0),
+					err,
+				)
+			}
+			if err != nil {
+				return n, err
+			}
+			n++
+		}
+		if err := progress(intent.ID); err != nil {
+			return n, err
+		}
+	}
+
+	return n, nil
+}
+
+func (s *Service) syncPayouts(
+	since int64,
+	after string,
+	progress func(id string) error,
+) (
+	int,
+	error,
+) {
+	sc, err := s.stripeClient()
+	if err != nil {
+		return 0, err
+	}
+	params := &stripe.PayoutListParams{
+		CreatedRange: &stripe.RangeQueryParams{GreaterThanOrEqual: since},
+	}
+	if after != "" {
+		params.StartingAfter = stripe.String(after)
+	}
+
+	n := 0
+	for p, err := range sc.V1Payouts.List(context.Background(), params) {
+		if err != nil {
+			return n, err
+		}
+		if err := s.recordPayout(p); err != nil {
+			return n, err
+		}
+		n++
+		if err := progress(p.ID); err != nil {
+			return n, err
+		}
+	}
+
+	return n, nil
+}
+
+type StripeSyncRequest struct {
+	Since string `json:"since"`
+}
+
+func (s *Service) handlePostStripeSync(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	var req StripeSyncRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		wire.WriteError(w, http.StatusBadRequest, "Malformed JSON")
+		return
+	}
+
+	// since defaults to the start of the last completed sync
+	var since *time.Time
+	if req.Since != "" {
+		t, err := time.Parse(time.RFC3339, req.Since)
+		if err != nil {
+			wire.WriteError(w, http.StatusBadRequest, "Invalid RFC3339 Date")
+			return
+		}
+		since = &t
+	}
+
+	result, err := s.SyncStripe(since)
+	if err != nil {
+		var stripeErr *stripe.Error
+		switch {
+		case errors.Is(err, ErrSyncRunning):
+			wire.WriteError(w, http.StatusConflict, "Sync In Progress")
+		case errors.As(err, &stripeErr):
+			wire.WriteError(w, http.StatusBadGateway, "Stripe Error")
+		default:
+			wire.WriteError(w, http.StatusInternalServerError, "Internal Server Error")
+		}
+		return
+	}
+
+	wire.WriteData(w, http.StatusOK, result)
+}
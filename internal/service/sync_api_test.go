@@ -0,0 +1,57 @@
+package service_test
+
+import (
+	"net/http"
+	"testing"
+
+	"git.sr.ht/~jakintosh/coffer/internal/service"
+	"git.sr.ht/~jakintosh/coffer/internal/testutil"
+	"git.sr.ht/~jakintosh/coffer/pkg/wire"
+)
+
+func TestAPIStripeSync(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	f := env.Stripe
+	seedStripeAccount(f)
+	router := env.Service.BuildRouter()
+	auth := testutil.MakeAuthHeader(t, env.Service)
+
+	body := `{"since": "2025-02-01T00:00:00Z"}`
+	result := wire.TestPost[service.StripeSyncResult](router, "/stripe/sync", body, auth)
+	sync := result.ExpectOK(t)
+	if sync.Customers != 1 || sync.Subscriptions != 1 || sync.Payments != 1 || sync.Payouts != 1 {
+		t.Errorf("unexpected result %+v", sync)
+	}
+}
+
+func TestAPIStripeSyncStripeError(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	f := env.Stripe
+	f.RemoveList("/v1/checkout/sessions")
+	router := env.Service.BuildRouter()
+	auth := testutil.MakeAuthHeader(t, env.Service)
+
+	result := wire.TestPost[service.StripeSyncResult](router, "/stripe/sync", `{}`, auth)
+	result.ExpectStatus(t, http.StatusBadGateway)
+}
+
+func TestAPIStripeSyncBadDate(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	router := env.Service.BuildRouter()
+	auth := testutil.MakeAuthHeader(t, env.Service)
+
+	result := wire.TestPost[service.StripeSyncResult](router, "/stripe/sync", `{"since": "yesterday"}`, auth)
+	result.ExpectStatus(t, http.StatusBadRequest)
+}
+
+func TestAPIStripeSyncUnauthorized(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	router := env.Service.BuildRouter()
+
+	result := wire.TestPost[service.StripeSyncResult](router, "/stripe/sync", `{}`)
+	result.ExpectStatus(t, http.StatusUnauthorized)
+}
@@ -0,0 +1,285 @@
+package service_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"git.sr.ht/~jakintosh/coffer/internal/service"
+	"git.sr.ht/~jakintosh/coffer/internal/testutil"
+	stripe "github.com/stripe/stripe-go/v82"
+)
+
+// seedStripeAccount serves two of everything the sync records, created in
+// january and march 2025, plus a payment intent that never succeeded
+func seedStripeAccount(
+	f *testutil.FakeStripe,
+) {
+	jan := testutil.MakeDateUnix(2025, 1, 10)
+	mar := testutil.MakeDateUnix(2025, 3, 10)
+
+	session := func(id, customer, name string, created int64) map[string]any {
+		return map[string]any{
+			"id":       id,
+			"object":   "checkout.session",
+			"created":  created,
+			"status":   "complete",
+			"customer": customer,
+			"custom_fields": []any{map[string]any{
+				"key":  "publicsignature",
+				"type": "text",
+				"text": map[string]any{"value": name},
+			}},
+		}
+	}
+	f.AddList("/v1/checkout/sessions",
+		session("cs_2", "cus_2", "Bea", mar),
+		session("cs_1", "cus_1", "Ada", jan),
+	)
+
+	subscription := func(id, customer string, amount int, created int64) map[string]any {
+		return map[string]any{
+			"id":       id,
+			"object":   "subscription",
+			"created":  created,
+			"status":   "active",
+			"customer": customer,
+			"items": map[string]any{
+				"object": "list",
+				"data": []any{map[string]any{
+					"id":     "si_" + id,
+					"object": "subscription_item",
+					"price": map[string]any{
+						"id":          "price_1",
+						"object":      "price",
+						"unit_amount": amount,
+						"currency":    "usd",
+					},
+				}},
+			},
+		}
+	}
+	f.AddList("/v1/subscriptions",
+		subscription("sub_2", "cus_2", 500, mar),
+		subscription("sub_1", "cus_1", 300, jan),
+	)
+
+	intent := func(id, status string, amount int, created int64) map[string]any {
+		return map[string]any{
+			"id":       id,
+			"object":   "payment_intent",
+			"amount":   amount,
+			"currency": "usd",
+			"created":  created,
+			"status":   status,
+			"customer": "cus_1",
+			"latest_charge": map[string]any{
+				"id":     "ch_" + id,
+				"object": "charge",
+				"balance_transaction": map[string]any{
+					"id":     "txn_" + id,
+					"object": "balance_transaction",
+					"amount": amount,
+					"fee":    30,
+				},
+			},
+		}
+	}
+	f.AddList("/v1/payment_intents",
+		intent("pi_3", "requires_payment_method", 900, mar+1),
+		intent("pi_2", "succeeded", 500, mar),
+		intent("pi_1", "succeeded", 300, jan),
+	)
+
+	payout := func(id string, amount int, created int64) map[string]any {
+		return map[string]any{
+			"id":       id,
+			"object":   "payout",
+			"created":  created,
+			"status":   "paid",
+			"amount":   amount,
+			"currency": "usd",
+		}
+	}
+	f.AddList("/v1/payouts",
+		payout("po_2", 470, mar),
+		payout("po_1", 270, jan),
+	)
+}
+
+func TestSyncStripe(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	f := env.Stripe
+	seedStripeAccount(f)
+
+	since := testutil.MakeDate(2025, 1, 1)
+	result, err := env.Service.SyncStripe(&since)
+	if err != nil {
+		t.Fatalf("SyncStripe: %v", err)
+	}
+	want := service.StripeSyncResult{Since: since, Customers: 2, Subscriptions: 2, Payments: 2, Payouts: 2}
+	if !result.Since.Equal(want.Since) || result.Resumed ||
+		result.Customers != 2 || result.Subscriptions != 2 || result.Payments != 2 || result.Payouts != 2 {
+		t.Errorf("want %+v got %+v", want, result)
+	}
+
+	patrons, err := env.Service.ListPatrons(10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(patrons) != 2 || patrons[0].Name != "Bea" || patrons[1].Name != "Ada" {
+		t.Errorf("unexpected patrons %+v", patrons)
+	}
+	payment, err := env.Service.GetPayment("pi_1")
+	if err != nil {
+		t.Fatalf("failed to get payment: %v", err)
+	}
+	if payment.Fee != 30 || payment.Allocated != 300 {
+		t.Errorf("unexpected payment %+v", payment)
+	}
+	if _, err := env.Service.GetPayment("pi_3"); !errors.Is(err, service.ErrPaymentNotFound) {
+		t.Errorf("want ErrPaymentNotFound for unpaid intent got %v", err)
+	}
+	if b := balance(t, env.Service, "general"); b != 800 {
+		t.Errorf("want general balance 800 got %d", b)
+	}
+
+	// syncing again records the same objects without posting twice
+	if _, err := env.Service.SyncStripe(&since); err != nil {
+		t.Fatalf("SyncStripe again: %v", err)
+	}
+	if b := balance(t, env.Service, "general"); b != 800 {
+		t.Errorf("want general balance 800 after resync got %d", b)
+	}
+}
+
+func TestSyncStripeSince(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	f := env.Stripe
+	seedStripeAccount(f)
+
+	since := testutil.MakeDate(2025, 2, 1)
+	result, err := env.Service.SyncStripe(&since)
+	if err != nil {
+		t.Fatalf("SyncStripe: %v", err)
+	}
+	if result.Customers != 1 || result.Subscriptions != 1 || result.Payments != 1 || result.Payouts != 1 {
+		t.Errorf("unexpected result %+v", result)
+	}
+	if _, err := env.Service.GetPayment("pi_1"); !errors.Is(err, service.ErrPaymentNotFound) {
+		t.Errorf("want ErrPaymentNotFound got %v", err)
+	}
+
+	// without since, a sync starts where the last completed one started
+	result, err = env.Service.SyncStripe(nil)
+	if err != nil {
+		t.Fatalf("SyncStripe: %v", err)
+	}
+	if result.Since.Before(time.Now().Add(-time.Minute)) || result.Payments != 0 {
+		t.Errorf("unexpected result %+v", result)
+	}
+}
+
+func TestSyncStripeResume(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	f := env.Stripe
+	seedStripeAccount(f)
+
+	// the sync fails once it reaches payouts
+	f.RemoveList("/v1/payouts")
+	since := testutil.MakeDate(2025, 1, 1)
+	_, err := env.Service.SyncStripe(&since)
+	var stripeErr *stripe.Error
+	if !errors.As(err, &stripeErr) {
+		t.Fatalf("want stripe error got %v", err)
+	}
+
+	seedStripeAccount(f)
+	result, err := env.Service.SyncStripe(nil)
+	if err != nil {
+		t.Fatalf("SyncStripe: %v", err)
+	}
+	if !result.Resumed || !result.Since.Equal(since) ||
+		result.Customers != 0 || result.Payments != 0 || result.Payouts != 2 {
+		t.Errorf("unexpected result %+v", result)
+	}
+}
+
+func TestSyncStripeResumeAfter(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	f := env.Stripe
+	seedStripeAccount(f)
+
+	// a previous sync stopped after recording pi_2
+	since := testutil.MakeDate(2025, 1, 1)
+	state := service.StripeSync{
+		Since:    since,
+		Started:  time.Now(),
+		Resource: "payments",
+		After:    "pi_2",
+	}
+	if err := env.DB.SetStripeSync(state); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := env.Service.SyncStripe(&since)
+	if err != nil {
+		t.Fatalf("SyncStripe: %v", err)
+	}
+	if !result.Resumed || result.Payments != 1 || result.Payouts != 2 {
+		t.Errorf("unexpected result %+v", result)
+	}
+	if _, err := env.Service.GetPayment("pi_1"); err != nil {
+		t.Errorf("failed to get payment: %v", err)
+	}
+	if _, err := env.Service.GetPayment("pi_2"); !errors.Is(err, service.ErrPaymentNotFound) {
+		t.Errorf("want ErrPaymentNotFound got %v", err)
+	}
+}
+
+func TestSyncStripeFeePending(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	if err := env.Service.SetFeeSettings(service.FeeSettings{Mode: service.FeeModeNet}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the listed charge has no balance transaction yet, but has one by the
+	// time the payment is retried
+	created := testutil.MakeDateUnix(2025, 1, 10)
+	env.Stripe.AddList("/v1/checkout/sessions")
+	env.Stripe.AddList("/v1/subscriptions")
+	env.Stripe.AddList("/v1/payouts")
+	env.Stripe.AddList("/v1/payment_intents", map[string]any{
+		"id":            "pi_1",
+		"object":        "payment_intent",
+		"amount":        1000,
+		"currency":      "usd",
+		"created":       created,
+		"status":        "succeeded",
+		"customer":      "cus_1",
+		"latest_charge": map[string]any{"id": "ch_pi_1", "object": "charge"},
+	})
+	addSettledIntent(env.Stripe, "pi_1", created, 1000, 30)
+
+	since := testutil.MakeDate(2025, 1, 1)
+	if _, err := env.Service.SyncStripe(&since); err != nil {
+		t.Fatalf("SyncStripe: %v", err)
+	}
+
+	e := waitForEvent(t, env, "sync:payment:pi_1", service.EventProcessed)
+	if e.Type != "sync" || e.ResourceID != "pi_1" || e.Attempts != 2 {
+		t.Errorf("unexpected event %+v", e)
+	}
+	payment, err := env.Service.GetPayment("pi_1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payment.Fee != 30 || payment.Allocated != 970 {
+		t.Errorf("unexpected payment %+v", payment)
+	}
+}
@@ -0,0 +1,228 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"git.sr.ht/~jakintosh/coffer/pkg/wire"
+	"github.com/google/uuid"
+)
+
+// Transfer moves funds between two ledgers. It is stored as a debit leg on
+// the source ledger and a credit leg on the destination ledger, both of
+// which carry the transfer ID.
+type Transfer struct {
+	ID     string    `json:"id"`
+	From   string    `json:"from"`
+	To     string    `json:"to"`
+	Amount int       `json:"amount"`
+	Date   time.Time `json:"date"`
+	Label  string    `json:"label"`
+}
+
+func (s *Service) Transfer(
+	id string,
+	from string,
+	to string,
+	amount int,
+	date time.Time,
+	label string,
+) (
+	*Transfer,
+	error,
+) {
+	if from == "" || to == "" || from == to {
+		return nil, ErrInvalidTransfer
+	}
+	if amount <= 0 {
+		return nil, ErrInvalidTransfer
+	}
+	for _, ledger := range []string{from, to} {
+		if err := s.requireOpenLedger(ledger); err != nil {
+			return nil, err
+		}
+	}
+	if id == "" {
+		id = uuid.NewString()
+	}
+
+	err := s.store.InsertTransfer(
+		id,
+		from,
+		to,
+		amount,
+		date.Unix(),
+		label,
+	)
+	if errors.Is(err, ErrTxConflict) {
+		return nil, err
+	} else if err != nil {
+		return nil, DatabaseError{err}
+	}
+
+	transfer := &Transfer{
+		ID:     id,
+		From:   from,
+		To:     to,
+		Amount: amount,
+		Date:   date,
+		Label:  label,
+	}
+	return transfer, nil
+}
+
+// ReverseTransfer reverses both legs of a transfer in a single database
+// transaction, returning the transfer that undoes it. A voided transfer is
+// reversed on its original date; otherwise the reversal is dated at date.
+// Neither ledger of the transfer may be archived.
+func (s *Service) ReverseTransfer(
+	id string,
+	date time.Time,
+	label string,
+	void bool,
+) (
+	*Transfer,
+	error,
+) {
+	orig, err := s.store.GetTransfer(id)
+	if errors.Is(err, ErrTxNotFound) {
+		return nil, err
+	} else if err != nil {
+		return nil, DatabaseError{err}
+	}
+
+	for _, ledger := range []string{orig.From, orig.To} {
+		if err := s.requireOpenLedger(ledger); err != nil {
+			return nil, err
+		}
+	}
+
+	if void {
+		date = orig.Date
+	}
+	if label == "" {
+		label = "reversal"
+	}
+
+	err = s.store.InsertTransferReversal(id, date.Unix(), label)
+	if errors.Is(err, ErrTxNotFound) || errors.Is(err, ErrTxConflict) {
+		return nil, err
+	} else if err != nil {
+		return nil, DatabaseError{err}
+	}
+
+	reversal := &Transfer{
+		ID:     fmt.Sprintf("%s:reversal", id),
+		From:   orig.To,
+		To:     orig.From,
+		Amount: orig.Amount,
+		Date:   date,
+		Label:  label,
+	}
+	return reversal, nil
+}
+
+type CreateTransferRequest struct {
+	ID     string `json:"id"`
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Date   string `json:"date"`
+	Amount int    `json:"amount"`
+	Label  string `json:"label"`
+}
+
+func (s *Service) buildTransfersRouter(
+	mux *http.ServeMux,
+	mw Middleware,
+) {
+	mux.HandleFunc("POST /ledger/transfers", mw.Auth(s.handlePostTransfer))
+	mux.HandleFunc("POST /ledger/transfers/{id}/reverse", mw.Auth(s.handlePostReverseTransfer))
+}
+
+func (s *Service) handlePostTransfer(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	// decode body
+	var req CreateTransferRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		wire.WriteError(w, http.StatusBadRequest, "Malformed JSON")
+		return
+	}
+
+	// validate date as RFC3339
+	date, err := time.Parse(time.RFC3339, req.Date)
+	if err != nil {
+		log.Printf("invalid RFC3339 date: %v", err)
+		wire.WriteError(w, http.StatusBadRequest, "Invalid RFC3339 Date")
+		return
+	}
+
+	transfer, err := s.Transfer(req.ID, req.From, req.To, req.Amount, date, req.Label)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrInvalidTransfer):
+			wire.WriteError(w, http.StatusBadRequest, "Invalid Transfer")
+		case errors.Is(err, ErrLedgerNotFound):
+			wire.WriteError(w, http.StatusNotFound, "Ledger Not Found")
+		case errors.Is(err, ErrLedgerArchived):
+			wire.WriteError(w, http.StatusConflict, "Ledger Archived")
+		case errors.Is(err, ErrTxConflict):
+			wire.WriteError(w, http.StatusConflict, "Transaction Conflict")
+		default:
+			wire.WriteError(w, http.StatusInternalServerError, "Internal Server Error")
+		}
+		return
+	}
+
+	wire.WriteData(w, http.StatusCreated, transfer)
+}
+
+func (s *Service) handlePostReverseTransfer(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	id := r.PathValue("id")
+
+	// decode optional body
+	var req ReverseTransactionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		wire.WriteError(w, http.StatusBadRequest, "Malformed JSON")
+		return
+	}
+
+	// validate date as RFC3339, defaulting to now
+	date := s.Clock()
+	if req.Date != "" {
+		var err error
+		if date, err = time.Parse(time.RFC3339, req.Date); err != nil {
+			log.Printf("invalid RFC3339 date: %v", err)
+			wire.WriteError(w, http.StatusBadRequest, "Invalid RFC3339 Date")
+			return
+		}
+	}
+
+	reversal, err := s.ReverseTransfer(id, date, req.Label, req.Void)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrTxNotFound):
+			wire.WriteError(w, http.StatusNotFound, "Transfer Not Found")
+		case errors.Is(err, ErrLedgerNotFound):
+			wire.WriteError(w, http.StatusNotFound, "Ledger Not Found")
+		case errors.Is(err, ErrLedgerArchived):
+			wire.WriteError(w, http.StatusConflict, "Ledger Archived")
+		case errors.Is(err, ErrTxConflict):
+			wire.WriteError(w, http.StatusConflict, "Transfer Already Reversed")
+		default:
+			wire.WriteError(w, http.StatusInternalServerError, "Internal Server Error")
+		}
+		return
+	}
+
+	wire.WriteData(w, http.StatusCreated, reversal)
+}
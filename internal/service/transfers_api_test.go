@@ -0,0 +1,106 @@
+package service_test
+
+import (
+	"net/http"
+	"testing"
+
+	"git.sr.ht/~jakintosh/coffer/internal/service"
+	"git.sr.ht/~jakintosh/coffer/internal/testutil"
+	"git.sr.ht/~jakintosh/coffer/pkg/wire"
+)
+
+func TestAPICreateTransfer(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	testutil.SeedLedgers(t, env.Service, "community")
+	router := env.Service.BuildRouter()
+
+	// post transfer
+	url := "/ledger/transfers"
+	body := `
+	{
+		"id": "tr1",
+		"from": "general",
+		"to": "community",
+		"date": "2025-01-01T12:00:00Z",
+		"label": "grant",
+		"amount": 50
+	}`
+	auth := testutil.MakeAuthHeader(t, env.Service)
+	result := wire.TestPost[service.Transfer](router, url, body, auth)
+
+	// verify result
+	result.ExpectStatus(t, http.StatusCreated)
+	transfer := result.ExpectOK(t)
+	if transfer.ID != "tr1" || transfer.Amount != 50 {
+		t.Errorf("unexpected transfer %+v", transfer)
+	}
+
+	// verify legs
+	listResult := wire.TestGet[[]service.Transaction](router, "/ledger/community/transactions")
+	txs := listResult.ExpectOK(t)
+	if len(txs) != 1 || txs[0].TransferID != "tr1" {
+		t.Fatalf("unexpected credit legs %+v", txs)
+	}
+}
+
+func TestAPICreateTransferSameLedger(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	router := env.Service.BuildRouter()
+
+	// post transfer
+	url := "/ledger/transfers"
+	body := `
+	{
+		"from": "general",
+		"to": "general",
+		"date": "2025-01-01T12:00:00Z",
+		"label": "x",
+		"amount": 50
+	}`
+	auth := testutil.MakeAuthHeader(t, env.Service)
+	result := wire.TestPost[any](router, url, body, auth)
+
+	// verify result
+	result.ExpectStatus(t, http.StatusBadRequest)
+}
+
+func TestAPICreateTransferUnauthorized(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	router := env.Service.BuildRouter()
+
+	url := "/ledger/transfers"
+	body := `{}`
+	result := wire.TestPost[any](router, url, body)
+
+	// verify result
+	result.ExpectStatus(t, http.StatusUnauthorized)
+}
+
+func TestAPIReverseTransfer(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	testutil.SeedLedgers(t, env.Service, "community")
+	router := env.Service.BuildRouter()
+	date := testutil.MakeDate(2025, 1, 1)
+	if _, err := env.Service.Transfer("tr1", "general", "community", 50, date, "grant"); err != nil {
+		t.Fatal(err)
+	}
+
+	// reverse transfer
+	url := "/ledger/transfers/tr1/reverse"
+	auth := testutil.MakeAuthHeader(t, env.Service)
+	result := wire.TestPost[service.Transfer](router, url, `{"label": "mistake"}`, auth)
+
+	// verify result
+	result.ExpectStatus(t, http.StatusCreated)
+	reversal := result.ExpectOK(t)
+	if reversal.ID != "tr1:reversal" || reversal.From != "community" || reversal.Amount != 50 {
+		t.Errorf("unexpected reversal %+v", reversal)
+	}
+
+	missing := wire.TestPost[any](router, "/ledger/transfers/missing/reverse", "", auth)
+	missing.ExpectStatus(t, http.StatusNotFound)
+}
@@ -0,0 +1,167 @@
+package service_test
+
+import (
+	"errors"
+	"testing"
+
+	"git.sr.ht/~jakintosh/coffer/internal/service"
+	"git.sr.ht/~jakintosh/coffer/internal/testutil"
+)
+
+func TestTransferSuccess(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	testutil.SeedLedgers(t, env.Service, "community")
+	svc := env.Service
+	testutil.SeedTransactionData(t, svc)
+
+	date := testutil.MakeDate(2025, 4, 1)
+	transfer, err := svc.Transfer("", "general", "community", 100, date, "grant")
+	if err != nil {
+		t.Fatalf("Transfer: %v", err)
+	}
+	if transfer.ID == "" {
+		t.Fatalf("expected generated transfer id")
+	}
+
+	// both legs should be linked to the transfer
+	general, err := svc.GetTransactions("general", 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(general) != 4 {
+		t.Fatalf("expected 4 general tx, got %d", len(general))
+	}
+	if general[0].Amount != -100 || general[0].TransferID != transfer.ID {
+		t.Errorf("unexpected debit leg %+v", general[0])
+	}
+
+	community, err := svc.GetTransactions("community", 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(community) != 1 {
+		t.Fatalf("expected 1 community tx, got %d", len(community))
+	}
+	if community[0].Amount != 100 || community[0].TransferID != transfer.ID {
+		t.Errorf("unexpected credit leg %+v", community[0])
+	}
+}
+
+func TestTransferInvalid(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	testutil.SeedLedgers(t, env.Service, "community")
+	svc := env.Service
+	date := testutil.MakeDate(2025, 4, 1)
+
+	cases := []struct {
+		from   string
+		to     string
+		amount int
+	}{
+		{"general", "general", 100},
+		{"", "community", 100},
+		{"general", "", 100},
+		{"general", "community", 0},
+		{"general", "community", -10},
+	}
+	for _, c := range cases {
+		_, err := svc.Transfer("", c.from, c.to, c.amount, date, "x")
+		if !errors.Is(err, service.ErrInvalidTransfer) {
+			t.Errorf("%+v: expected ErrInvalidTransfer, got %v", c, err)
+		}
+	}
+
+	// ensure nothing was written
+	txs, err := svc.GetTransactions("general", 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(txs) != 0 {
+		t.Fatalf("expected 0 transactions, got %d", len(txs))
+	}
+}
+
+func TestReverseTransfer(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	testutil.SeedLedgers(t, env.Service, "community")
+	svc := env.Service
+
+	date := testutil.MakeDate(2025, 4, 1)
+	if _, err := svc.Transfer("tr1", "general", "community", 100, date, "grant"); err != nil {
+		t.Fatalf("Transfer: %v", err)
+	}
+
+	// a leg cannot be reversed on its own
+	if _, err := svc.ReverseTransaction("general", "transfer:tr1:general", date, "", false); !errors.Is(err, service.ErrInvalidReversal) {
+		t.Fatalf("expected ErrInvalidReversal, got %v", err)
+	}
+
+	reversal, err := svc.ReverseTransfer("tr1", testutil.MakeDate(2025, 5, 1), "", true)
+	if err != nil {
+		t.Fatalf("ReverseTransfer: %v", err)
+	}
+	if reversal.From != "community" || reversal.To != "general" || reversal.Amount != 100 ||
+		!reversal.Date.Equal(date) || reversal.Label != "reversal" {
+		t.Errorf("unexpected reversal %+v", reversal)
+	}
+
+	// both legs are cancelled out
+	for _, ledger := range []string{"general", "community"} {
+		txs, err := svc.GetTransactions(ledger, 10, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sum := 0
+		for _, tx := range txs {
+			sum += tx.Amount
+		}
+		if len(txs) != 2 || sum != 0 {
+			t.Errorf("%s: expected leg and reversal, got %+v", ledger, txs)
+		}
+	}
+
+	// reversing again with a different date conflicts
+	if _, err := svc.ReverseTransfer("tr1", testutil.MakeDate(2025, 6, 1), "", false); !errors.Is(err, service.ErrTxConflict) {
+		t.Errorf("expected ErrTxConflict, got %v", err)
+	}
+	if _, err := svc.ReverseTransfer("missing", date, "", false); !errors.Is(err, service.ErrTxNotFound) {
+		t.Errorf("expected ErrTxNotFound, got %v", err)
+	}
+}
+
+func TestReverseArchivedLedger(t *testing.T) {
+
+	env := testutil.SetupTestEnv(t)
+	testutil.SeedLedgers(t, env.Service, "community")
+	svc := env.Service
+
+	date := testutil.MakeDate(2025, 4, 1)
+	if err := svc.AddTransaction("t1", "community", 100, date, "gift"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.Transfer("tr1", "general", "community", 100, date, "grant"); err != nil {
+		t.Fatal(err)
+	}
+	ledger, err := svc.GetLedgerInfo("community")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ledger.Archived = true
+	if _, err := svc.UpdateLedger(*ledger); err != nil {
+		t.Fatalf("failed to archive: %v", err)
+	}
+
+	// reversals post to the ledger like any other entry
+	if _, err := svc.ReverseTransaction("community", "t1", date, "", false); !errors.Is(err, service.ErrLedgerArchived) {
+		t.Errorf("expected ErrLedgerArchived, got %v", err)
+	}
+	if _, err := svc.ReverseTransfer("tr1", date, "", false); !errors.Is(err, service.ErrLedgerArchived) {
+		t.Errorf("expected ErrLedgerArchived, got %v", err)
+	}
+	if b := balance(t, svc, "community"); b != 200 {
+		t.Errorf("want community balance 200 got %d", b)
+	}
+}
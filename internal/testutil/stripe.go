@@ -0,0 +1,334 @@
+package testutil
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	stripe "github.com/stripe/stripe-go/v82"
+)
+
+// FakeStripe is a local stand-in for the Stripe API. Objects and lists are
+// served as JSON by request path, and anything else is a Stripe 404. Lists
+// are paged with limit and starting_after, and filtered by created[gte] and
+// by equality on any other parameter, which must name a field, nested with
+// brackets as in payment[payment_intent].
+// Expanded fields are replaced with the served object of the same ID, when
+// there is one.
+type FakeStripe struct {
+	Server *httptest.Server
+
+	mu      sync.Mutex
+	objects map[string]map[string]any
+	lists   map[string][]map[string]any
+	byID    map[string]map[string]any
+}
+
+// NewFakeStripe starts a fake Stripe API for the rest of the test. Checkout
+// session and invoice payment lookups find nothing unless some are added.
+func NewFakeStripe(t *testing.T) *FakeStripe {
+	t.Helper()
+
+	f := &FakeStripe{
+		objects: map[string]map[string]any{},
+		lists:   map[string][]map[string]any{},
+		byID:    map[string]map[string]any{},
+	}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serve))
+	f.AddList("/v1/checkout/sessions")
+	f.AddList("/v1/invoice_payments")
+
+	t.Cleanup(f.Server.Close)
+	return f
+}
+
+// Client returns a stripe client that sends every request to the fake
+func (f *FakeStripe) Client() *stripe.Client {
+	backends := stripe.NewBackendsWithConfig(&stripe.BackendConfig{
+		URL:               stripe.String(f.Server.URL),
+		HTTPClient:        f.Server.Client(),
+		MaxNetworkRetries: stripe.Int64(0),
+	})
+	return stripe.NewClient("sk_test_fake", stripe.WithBackends(backends))
+}
+
+// AddObject serves obj at path, for example "/v1/payment_intents/pi_123".
+func (f *FakeStripe) AddObject(
+	path string,
+	obj any,
+) {
+	m := toJSONMap(obj)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.objects[path] = m
+	if id, ok := m["id"].(string); ok {
+		f.byID[id] = m
+	}
+}
+
+// AddList serves objs as a Stripe list at path, in the given order, which
+// for Stripe is newest first.
+func (f *FakeStripe) AddList(
+	path string,
+	objs ...any,
+) {
+	data := make([]map[string]any, 0, len(objs))
+	for _, obj := range objs {
+		data = append(data, toJSONMap(obj))
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.lists[path] = data
+}
+
+// RemoveList stops serving the list at path
+func (f *FakeStripe) RemoveList(
+	path string,
+) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.lists, path)
+}
+
+// AddScenario serves the objects of a captured event stream as Stripe
+// would after the last event: each object is served at its resource path
+// and listed in its collection, newest first. An object's state comes from
+// its newest event, or from the first of its newest events, because
+// captures list simultaneous events newest first.
+func (f *FakeStripe) AddScenario(
+	scenario *Scenario,
+) {
+	type version struct {
+		created int64
+		obj     map[string]any
+	}
+	latest := map[string]version{}
+	var order []string
+	for _, event := range scenario.Events {
+		if event.Data == nil {
+			continue
+		}
+		var obj map[string]any
+		if err := json.Unmarshal(event.Data.Raw, &obj); err != nil {
+			continue
+		}
+		id, _ := obj["id"].(string)
+		if id == "" {
+			continue
+		}
+		v, seen := latest[id]
+		if !seen {
+			order = append(order, id)
+		}
+		if !seen || event.Created > v.created {
+			latest[id] = version{event.Created, obj}
+		}
+	}
+
+	collections := map[string][]map[string]any{}
+	for _, id := range order {
+		obj := latest[id].obj
+		kind, _ := obj["object"].(string)
+		path := "/v1/" + strings.ReplaceAll(kind, ".", "/") + "s"
+		f.AddObject(path+"/"+id, obj)
+		collections[path] = append(collections[path], obj)
+	}
+	for path, objs := range collections {
+		slices.SortStableFunc(objs, func(a, b map[string]any) int {
+			ca, _ := a["created"].(float64)
+			cb, _ := b["created"].(float64)
+			return int(cb - ca)
+		})
+		list := make([]any, len(objs))
+		for i, obj := range objs {
+			list[i] = obj
+		}
+		f.AddList(path, list...)
+	}
+}
+
+func (f *FakeStripe) serve(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	query := r.URL.Query()
+	var expand []string
+	for key, values := range query {
+		if strings.HasPrefix(key, "expand") {
+			expand = append(expand, values...)
+		}
+	}
+
+	f.mu.Lock()
+	var obj map[string]any
+	data, ok := f.lists[r.URL.Path]
+	if ok {
+		obj = listPage(r.URL.Path, data, query)
+	} else {
+		obj, ok = f.objects[r.URL.Path]
+	}
+	if ok {
+		obj = toJSONMap(obj)
+		for _, path := range expand {
+			f.expand(obj, strings.Split(path, "."))
+		}
+	}
+	f.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]any{
+			"error": map[string]any{
+				"type":    "invalid_request_error",
+				"code":    "resource_missing",
+				"message": "No such object: " + r.URL.Path,
+			},
+		})
+		return
+	}
+	json.NewEncoder(w).Encode(obj)
+}
+
+// expand replaces the ID at path in obj with a copy of the object it
+// names, following "data" into each object of a list
+func (f *FakeStripe) expand(
+	obj map[string]any,
+	path []string,
+) {
+	if len(path) == 0 {
+		return
+	}
+	if path[0] == "data" {
+		if data, ok := obj["data"].([]any); ok {
+			for _, item := range data {
+				if m, ok := item.(map[string]any); ok {
+					f.expand(m, path[1:])
+				}
+			}
+			return
+		}
+	}
+
+	field := obj[path[0]]
+	if id, ok := field.(string); ok {
+		if target, ok := f.byID[id]; ok {
+			field = toJSONMap(target)
+			obj[path[0]] = field
+		}
+	}
+	if m, ok := field.(map[string]any); ok {
+		f.expand(m, path[1:])
+	}
+}
+
+// toJSONMap copies obj as the client would decode it, so that filters and
+// expansion see JSON types
+func toJSONMap(
+	obj any,
+) map[string]any {
+	var m map[string]any
+	b, _ := json.Marshal(obj)
+	json.Unmarshal(b, &m)
+	return m
+}
+
+// listPage filters a list by the query and returns the requested page
+func listPage(
+	path string,
+	data []map[string]any,
+	query url.Values,
+) map[string]any {
+	matched := []map[string]any{}
+	for _, obj := range data {
+		if listMatches(obj, query) {
+			matched = append(matched, obj)
+		}
+	}
+
+	if after := query.Get("starting_after"); after != "" {
+		for i, obj := range matched {
+			if obj["id"] == after {
+				matched = matched[i+1:]
+				break
+			}
+		}
+	}
+
+	limit := 10
+	if l, err := strconv.Atoi(query.Get("limit")); err == nil && l > 0 {
+		limit = l
+	}
+	hasMore := len(matched) > limit
+	if hasMore {
+		matched = matched[:limit]
+	}
+
+	return map[string]any{
+		"object":   "list",
+		"data":     matched,
+		"has_more": hasMore,
+		"url":      path,
+	}
+}
+
+func listMatches(
+	obj map[string]any,
+	query url.Values,
+) bool {
+	for key, values := range query {
+		value := values[0]
+		switch {
+		case key == "created[gte]":
+			gte, _ := strconv.ParseFloat(value, 64)
+			if created, _ := obj["created"].(float64); created < gte {
+				return false
+			}
+		case key == "limit", key == "starting_after", strings.HasPrefix(key, "expand"):
+		case key == "status" && value == "all":
+		default:
+			field, ok := lookupField(obj, key)
+			if !ok {
+				return false
+			}
+			if id, ok := field.(map[string]any); ok {
+				field = id["id"]
+			}
+			if s, ok := field.(string); !ok || s != value {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// lookupField finds the field named by a list parameter, following
+// bracketed keys into nested objects
+func lookupField(
+	obj map[string]any,
+	key string,
+) (
+	any,
+	bool,
+) {
+	path := strings.Split(strings.ReplaceAll(key, "]", ""), "[")
+	var field any = obj
+	for _, name := range path {
+		m, ok := field.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		if field, ok = m[name]; !ok {
+			return nil, false
+		}
+	}
+	return field, true
+}
@@ -10,6 +10,7 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"sync"
 	"testing"
 	"time"
 
@@ -51,6 +52,34 @@ func MakeDate3339(
 type TestEnv struct {
 	DB      *database.DB
 	Service *service.Service
+	Stripe  *FakeStripe
+	Clock   *TestClock
+}
+
+// TestClock is the clock of a test service. It follows the real time until
+// it is set.
+type TestClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *TestClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.now.IsZero() {
+		return time.Now()
+	}
+	return c.now
+}
+
+// Set stops the clock at now, or lets it follow the real time again if now
+// is zero
+func (c *TestClock) Set(
+	now time.Time,
+) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = now
 }
 
 func SetupTestEnv(t *testing.T) *TestEnv {
@@ -61,14 +90,51 @@ func SetupTestEnv(t *testing.T) *TestEnv {
 		t.Fatalf("failed to open test database: %v", err)
 	}
 
+	t.Cleanup(func() {
+		db.Close()
+	})
+	fake := NewFakeStripe(t)
+	clock := &TestClock{}
+	svc := newTestService(t, db, fake, clock.Now)
+
+	return &TestEnv{
+		DB:      db,
+		Service: svc,
+		Stripe:  fake,
+		Clock:   clock,
+	}
+}
+
+// NewTestService starts a service backed by db that calls the fake Stripe
+// API, which is stopped when the test ends.
+func NewTestService(
+	t *testing.T,
+	db *database.DB,
+	fake *FakeStripe,
+) *service.Service {
+	t.Helper()
+	return newTestService(t, db, fake, nil)
+}
+
+func newTestService(
+	t *testing.T,
+	db *database.DB,
+	fake *FakeStripe,
+	clock func() time.Time,
+) *service.Service {
+	t.Helper()
+
 	svc, err := service.New(service.Options{
 		Store:       db,
+		Clock:       clock,
 		HealthCheck: db.HealthCheck,
 		StripeProcessorOptions: &service.StripeProcessorOptions{
-			Key:            "",
+			Client:         fake.Client(),
 			EndpointSecret: STRIPE_TEST_KEY,
 			TestMode:       true,
 			DebounceWindow: 50 * time.Millisecond,
+			RetryInterval:  50 * time.Millisecond,
+			MaxAttempts:    3,
 		},
 		KeysOptions: &keys.Options{
 			Store: db.KeysStore,
@@ -81,16 +147,9 @@ func SetupTestEnv(t *testing.T) *TestEnv {
 		t.Fatalf("failed to create service: %v", err)
 	}
 	svc.Start()
+	t.Cleanup(svc.Stop)
 
-	t.Cleanup(func() {
-		svc.Stop()
-		db.Close()
-	})
-
-	return &TestEnv{
-		DB:      db,
-		Service: svc,
-	}
+	return svc
 }
 
 func MakeAuthHeader(t *testing.T, svc *service.Service) wire.TestHeader {
@@ -145,6 +204,48 @@ func SeedSubscriberData(t *testing.T, svc *service.Service) {
 	}
 }
 
+// SeedLedgers registers public ledgers with the given names
+func SeedLedgers(
+	t *testing.T,
+	svc *service.Service,
+	names ...string,
+) {
+	t.Helper()
+	for _, name := range names {
+		if _, err := svc.CreateLedger(service.Ledger{Name: name, Public: true}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// ScheduleAllocations saves default rules effective from the given date,
+// with the test clock stopped at that date so it isn't rejected as past
+func ScheduleAllocations(
+	t *testing.T,
+	env *TestEnv,
+	rules []service.AllocationRule,
+	effectiveFrom time.Time,
+) {
+	t.Helper()
+	ScheduleAllocationSet(t, env, "", rules, effectiveFrom)
+}
+
+// ScheduleAllocationSet is ScheduleAllocations for a selector
+func ScheduleAllocationSet(
+	t *testing.T,
+	env *TestEnv,
+	selector string,
+	rules []service.AllocationRule,
+	effectiveFrom time.Time,
+) {
+	t.Helper()
+	env.Clock.Set(effectiveFrom)
+	defer env.Clock.Set(time.Time{})
+	if err := env.Service.ScheduleAllocationSet(selector, rules, effectiveFrom); err != nil {
+		t.Fatalf("failed to schedule %q: %v", selector, err)
+	}
+}
+
 func SeedTransactionData(
 	t *testing.T,
 	svc *service.Service,
@@ -50,10 +50,7 @@ func (s *Service) WithAuth(
 	next http.HandlerFunc,
 ) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		token := r.Header.Get("Authorization")
-		if strings.HasPrefix(strings.ToLower(token), "bearer ") {
-			token = strings.TrimSpace(token[7:])
-		}
+		token := bearerToken(r)
 		if token == "" {
 			wire.WriteError(w, http.StatusUnauthorized, "Missing Authorization")
 			return
@@ -68,3 +65,26 @@ func (s *Service) WithAuth(
 		next(w, r)
 	}
 }
+
+// Authorized reports whether a request carries a valid bearer token, for
+// routes that are public but show more to authenticated clients.
+func (s *Service) Authorized(
+	r *http.Request,
+) bool {
+	token := bearerToken(r)
+	if token == "" {
+		return false
+	}
+	ok, err := s.Verify(token)
+	return err == nil && ok
+}
+
+func bearerToken(
+	r *http.Request,
+) string {
+	token := r.Header.Get("Authorization")
+	if strings.HasPrefix(strings.ToLower(token), "bearer ") {
+		token = strings.TrimSpace(token[7:])
+	}
+	return token
+}
@@ -199,3 +199,29 @@ func TestAuth_ProtectsCustomRoutes(t *testing.T) {
 		t.Error("handler should not have been called")
 	}
 }
+
+func TestAuthorized(t *testing.T) {
+	svc := testService(t)
+
+	token, err := svc.Create()
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	cases := map[string]bool{
+		"":                false,
+		"Bearer ":         false,
+		"Bearer wrong":    false,
+		"Bearer " + token: true,
+		"bearer " + token: true,
+	}
+	for header, want := range cases {
+		req := httptest.NewRequest("GET", "/", nil)
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+		if got := svc.Authorized(req); got != want {
+			t.Errorf("Authorized(%q) = %v, want %v", header, got, want)
+		}
+	}
+}
@@ -5,13 +5,19 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"iter"
 	"net/http"
+	"net/url"
 	"strings"
 	"time"
 )
 
 var defaultHTTPClient = &http.Client{Timeout: 10 * time.Second}
 
+// downloadHTTPClient allows large bodies long enough to stream, since the
+// timeout also covers reading the body
+var downloadHTTPClient = &http.Client{Timeout: 10 * time.Minute}
+
 // Client holds API endpoint configuration.
 type Client struct {
 	BaseURL    string
@@ -21,6 +27,81 @@ type Client struct {
 
 // Do makes an API request and decodes the response into response.
 func (c Client) Do(method, path string, body []byte, response any) error {
+	_, err := c.do(method, path, body, response)
+	return err
+}
+
+// GetPage issues a GET request for the page at cursor and returns the
+// cursor of the following page, which is empty on the last page.
+func (c Client) GetPage(path string, cursor string, response any) (string, error) {
+	if cursor != "" {
+		u, err := url.Parse(path)
+		if err != nil {
+			return "", err
+		}
+		q := u.Query()
+		q.Set("cursor", cursor)
+		u.RawQuery = q.Encode()
+		path = u.String()
+	}
+	return c.do(http.MethodGet, path, nil, response)
+}
+
+// Download issues a GET request and copies the raw response body to dst.
+// Error responses are decoded from the standard envelope. Unless the client
+// has its own HTTPClient, downloads time out after ten minutes.
+func (c Client) Download(path string, dst io.Writer) error {
+	req, err := http.NewRequest(http.MethodGet, c.resolveURL(path), nil)
+	if err != nil {
+		return err
+	}
+	if c.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.APIKey)
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = downloadHTTPClient
+	}
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= http.StatusBadRequest {
+		data, err := io.ReadAll(res.Body)
+		if err != nil {
+			return err
+		}
+		apiErr, _ := decodeInto(data, nil)
+		if apiErr != nil {
+			return errors.New(apiErr.Message)
+		}
+		return fmt.Errorf("server returned %s", res.Status)
+	}
+
+	_, err = io.Copy(dst, res.Body)
+	return err
+}
+
+// Pages iterates every page of a cursor paginated GET endpoint, starting
+// from the first. Iteration stops after the last page or the first error.
+func Pages[T any](c Client, path string) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		cursor := ""
+		for {
+			var page T
+			next, err := c.GetPage(path, cursor, &page)
+			if !yield(page, err) || err != nil || next == "" {
+				return
+			}
+			cursor = next
+		}
+	}
+}
+
+func (c Client) do(method, path string, body []byte, response any) (string, error) {
 	url := c.resolveURL(path)
 
 	var bodyReader io.Reader
@@ -30,7 +111,7 @@ func (c Client) Do(method, path string, body []byte, response any) error {
 
 	req, err := http.NewRequest(method, url, bodyReader)
 	if err != nil {
-		return err
+		return "", err
 	}
 	if body != nil {
 		req.Header.Set("Content-Type", "application/json")
@@ -39,39 +120,34 @@ func (c Client) Do(method, path string, body []byte, response any) error {
 		req.Header.Set("Authorization", "Bearer "+c.APIKey)
 	}
 
-	httpClient := c.HTTPClient
-	if httpClient == nil {
-		httpClient = defaultHTTPClient
-	}
-
-	res, err := httpClient.Do(req)
+	res, err := c.httpClient().Do(req)
 	if err != nil {
-		return err
+		return "", err
 	}
 	defer res.Body.Close()
 
 	data, err := io.ReadAll(res.Body)
 	if err != nil {
-		return err
+		return "", err
 	}
 
 	if res.StatusCode >= http.StatusBadRequest {
 		apiErr, _ := decodeInto(data, nil)
 		if apiErr != nil {
-			return errors.New(apiErr.Message)
+			return "", errors.New(apiErr.Message)
 		}
-		return fmt.Errorf("server returned %s", res.Status)
+		return "", fmt.Errorf("server returned %s", res.Status)
 	}
 
 	if response == nil || len(data) == 0 {
-		return nil
+		return "", nil
 	}
 
-	apiErr, err := decodeInto(data, response)
+	next, apiErr, err := decodePage(data, response)
 	if apiErr != nil {
-		return errors.New(apiErr.Message)
+		return "", errors.New(apiErr.Message)
 	}
-	return err
+	return next, err
 }
 
 // Get issues a GET request.
@@ -94,6 +170,13 @@ func (c Client) Delete(path string, response any) error {
 	return c.Do(http.MethodDelete, path, nil, response)
 }
 
+func (c Client) httpClient() *http.Client {
+	if c.HTTPClient == nil {
+		return defaultHTTPClient
+	}
+	return c.HTTPClient
+}
+
 func (c Client) resolveURL(path string) string {
 	base := strings.TrimRight(c.BaseURL, "/")
 	if path == "" {
@@ -1,6 +1,7 @@
 package wire_test
 
 import (
+	"bytes"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -76,3 +77,91 @@ func TestClientDoEmptyData(t *testing.T) {
 		t.Fatalf("expected empty result got %q", result)
 	}
 }
+
+func TestPagesIteratesAllPages(t *testing.T) {
+	pages := map[string][]int{
+		"":   {1, 2},
+		"p2": {3, 4},
+		"p3": {5},
+	}
+	next := map[string]*wire.Cursor{
+		"":   {Key: 2, ID: "p2"},
+		"p2": {Key: 4, ID: "p3"},
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := ""
+		if cursor, err := wire.ParseCursor(r); err != nil {
+			wire.WriteError(w, http.StatusBadRequest, err.Error())
+			return
+		} else if cursor != nil {
+			key = cursor.ID
+		}
+		wire.WritePage(w, http.StatusOK, pages[key], next[key])
+	}))
+	defer server.Close()
+
+	client := wire.Client{BaseURL: server.URL}
+	var all []int
+	for page, err := range wire.Pages[[]int](client, "/items?limit=2") {
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		all = append(all, page...)
+	}
+	if len(all) != 5 || all[0] != 1 || all[4] != 5 {
+		t.Fatalf("unexpected items %v", all)
+	}
+}
+
+func TestPagesStopsOnError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		wire.WriteError(w, http.StatusInternalServerError, "boom")
+	}))
+	defer server.Close()
+
+	client := wire.Client{BaseURL: server.URL}
+	calls := 0
+	for _, err := range wire.Pages[[]int](client, "/items") {
+		calls++
+		if err == nil {
+			t.Fatalf("expected error")
+		}
+	}
+	if calls != 1 {
+		t.Fatalf("expected 1 call got %d", calls)
+	}
+}
+
+func TestClientDownload(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/csv")
+		w.Write([]byte("id,amount\nt1,1.00\n"))
+	}))
+	defer server.Close()
+
+	client := wire.Client{BaseURL: server.URL}
+	var buf bytes.Buffer
+	if err := client.Download("/export", &buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if buf.String() != "id,amount\nt1,1.00\n" {
+		t.Fatalf("unexpected body %q", buf.String())
+	}
+}
+
+func TestClientDownloadErrorEnvelope(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		wire.WriteError(w, http.StatusBadRequest, "bad")
+	}))
+	defer server.Close()
+
+	client := wire.Client{BaseURL: server.URL}
+	var buf bytes.Buffer
+	err := client.Download("/export", &buf)
+	if err == nil || err.Error() != "bad" {
+		t.Fatalf("expected error 'bad' got %v", err)
+	}
+	if buf.Len() != 0 {
+		t.Fatalf("expected nothing written, got %q", buf.String())
+	}
+}
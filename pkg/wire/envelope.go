@@ -3,9 +3,11 @@ package wire
 import "encoding/json"
 
 // Response defines the common API response envelope.
+// NextCursor is only set on paginated responses with more results.
 type Response struct {
-	Data  any    `json:"data,omitempty"`
-	Error *Error `json:"error,omitempty"`
+	Data       any    `json:"data,omitempty"`
+	Error      *Error `json:"error,omitempty"`
+	NextCursor string `json:"next_cursor,omitempty"`
 }
 
 // Error defines the common API error shape.
@@ -15,22 +17,29 @@ type Error struct {
 
 // rawResponse is used internally for two-phase JSON decoding.
 type rawResponse struct {
-	Data  json.RawMessage `json:"data"`
-	Error *Error          `json:"error"`
+	Data       json.RawMessage `json:"data"`
+	Error      *Error          `json:"error"`
+	NextCursor string          `json:"next_cursor"`
 }
 
 // decodeInto decodes a JSON envelope and unmarshals data into dest.
 // dest must be a pointer. Returns API error if present.
 func decodeInto(body []byte, dest any) (*Error, error) {
+	_, apiErr, err := decodePage(body, dest)
+	return apiErr, err
+}
+
+// decodePage is decodeInto that also returns the envelope's next cursor.
+func decodePage(body []byte, dest any) (string, *Error, error) {
 	var raw rawResponse
 	if err := json.Unmarshal(body, &raw); err != nil {
-		return nil, err
+		return "", nil, err
 	}
 	if raw.Error != nil && raw.Error.Message != "" {
-		return raw.Error, nil
+		return "", raw.Error, nil
 	}
 	if dest == nil || len(raw.Data) == 0 || string(raw.Data) == "null" {
-		return nil, nil
+		return raw.NextCursor, nil, nil
 	}
-	return nil, json.Unmarshal(raw.Data, dest)
+	return raw.NextCursor, nil, json.Unmarshal(raw.Data, dest)
 }
@@ -1,9 +1,11 @@
 package wire
 
 import (
+	"encoding/base64"
 	"encoding/json"
 	"net/http"
 	"strconv"
+	"strings"
 )
 
 // ErrMalformedQuery indicates a malformed query parameter.
@@ -26,6 +28,18 @@ func WriteData(w http.ResponseWriter, code int, data any) {
 	json.NewEncoder(w).Encode(Response{Data: data})
 }
 
+// WritePage writes a data response with the standard envelope, including
+// the cursor for the next page if there is one.
+func WritePage(w http.ResponseWriter, code int, data any, next *Cursor) {
+	res := Response{Data: data}
+	if next != nil {
+		res.NextCursor = next.Encode()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(res)
+}
+
 // WriteError writes an error response with the standard envelope.
 func WriteError(w http.ResponseWriter, code int, message string) {
 	w.Header().Set("Content-Type", "application/json")
@@ -58,3 +72,46 @@ func ParsePagination(r *http.Request) (int, int, *ErrMalformedQuery) {
 
 	return limit, offset, nil
 }
+
+// Cursor is a keyset pagination position: the sort key and id of the last
+// row of a page.
+type Cursor struct {
+	Key int64
+	ID  string
+}
+
+// Encode returns the cursor as an opaque URL-safe token.
+func (c Cursor) Encode() string {
+	raw := strconv.FormatInt(c.Key, 10) + ":" + c.ID
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// DecodeCursor parses a token produced by Cursor.Encode.
+func DecodeCursor(token string) (*Cursor, bool) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, false
+	}
+	keyStr, id, found := strings.Cut(string(raw), ":")
+	if !found || id == "" {
+		return nil, false
+	}
+	key, err := strconv.ParseInt(keyStr, 10, 64)
+	if err != nil {
+		return nil, false
+	}
+	return &Cursor{Key: key, ID: id}, true
+}
+
+// ParseCursor reads the optional cursor query.
+func ParseCursor(r *http.Request) (*Cursor, *ErrMalformedQuery) {
+	token := r.URL.Query().Get("cursor")
+	if token == "" {
+		return nil, nil
+	}
+	cursor, ok := DecodeCursor(token)
+	if !ok {
+		return nil, &ErrMalformedQuery{"cursor"}
+	}
+	return cursor, nil
+}
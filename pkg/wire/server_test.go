@@ -37,3 +37,35 @@ func TestParsePaginationInvalidOffset(t *testing.T) {
 		t.Fatalf("expected error for invalid offset")
 	}
 }
+
+func TestCursorRoundTrip(t *testing.T) {
+	cursor := wire.Cursor{Key: 1735689600, ID: "pi_123:general"}
+
+	decoded, ok := wire.DecodeCursor(cursor.Encode())
+	if !ok {
+		t.Fatalf("failed to decode cursor")
+	}
+	if *decoded != cursor {
+		t.Fatalf("expected %+v got %+v", cursor, *decoded)
+	}
+}
+
+func TestParseCursorAbsent(t *testing.T) {
+	req := httptest.NewRequest("GET", "http://test", nil)
+
+	cursor, err := wire.ParseCursor(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cursor != nil {
+		t.Fatalf("expected nil cursor got %+v", cursor)
+	}
+}
+
+func TestParseCursorInvalid(t *testing.T) {
+	req := httptest.NewRequest("GET", "http://test?cursor=!!!", nil)
+
+	if _, err := wire.ParseCursor(req); err == nil {
+		t.Fatalf("expected error for invalid cursor")
+	}
+}
@@ -15,11 +15,12 @@ type TestHeader struct {
 
 // TestResult captures the result of a test request with typed data.
 type TestResult[T any] struct {
-	Code    int
-	Data    T
-	Error   *Error
-	Headers http.Header
-	Raw     []byte
+	Code       int
+	Data       T
+	Error      *Error
+	NextCursor string
+	Headers    http.Header
+	Raw        []byte
 }
 
 // ExpectOK fails the test if status is not 2xx or if there's an API error.
@@ -99,7 +100,7 @@ func testRequest[T any](
 
 	if len(result.Raw) > 0 {
 		var data T
-		result.Error, _ = decodeInto(result.Raw, &data)
+		result.NextCursor, result.Error, _ = decodePage(result.Raw, &data)
 		result.Data = data
 	}
 